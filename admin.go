@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// adminRouter arma las rutas de /admin: introspección del pool y la cola,
+// cancelación por key, ajuste de concurrencia en caliente y operaciones de
+// runbook auditadas.
+func adminRouter(cfg *Config) chi.Router {
+	r := chi.NewRouter()
+	if cfg.Admin.Token != "" {
+		r.Use(requireAdminToken(cfg.Admin.Token))
+	}
+	r.Get("/jobs", handleAdminJobs)
+	r.Post("/jobs/{key}/cancel", handleAdminCancel)
+	r.Post("/jobs/requeue-stuck", handleAdminRequeueStuck)
+	r.Get("/queue", handleAdminQueue)
+	r.Get("/pool", handleAdminPool)
+	r.Put("/pool", handleAdminResizePool)
+	r.Get("/audit", handleAdminAudit)
+	r.Get("/usage", handleAdminUsage)
+	r.Get("/rollups", handleAdminRollups)
+	r.Get("/audit/operations", handleAdminOperations)
+	r.Post("/reload", handleAdminReload)
+	r.Post("/encryption/rewrap", handleAdminRewrap)
+	r.Get("/tenants/paused", handleAdminPausedTenants)
+	r.Post("/tenants/{id}/pause", handleAdminPauseTenant)
+	r.Post("/tenants/{id}/resume", handleAdminResumeTenant)
+	r.Post("/schedules/{id}/rotate-webhook-secret", handleAdminRotateWebhookSecret)
+	return r
+}
+
+// requireAdminToken exige "Authorization: Bearer <token>".
+func requireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeProblem(w, r, newProblem(CodeUnauthorized, "Se requiere el token de administración"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminJob es un job de la cola que procesa esta réplica.
+type AdminJob struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// AdminJobs lista el trabajo activo en esta réplica.
+type AdminJobs struct {
+	Tasks []ActiveTask `json:"tasks"`
+	Jobs  []AdminJob   `json:"jobs"`
+}
+
+// GET /admin/jobs -> ítems en cola o en proceso en el pool y jobs locales
+func handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	out := AdminJobs{Tasks: pool.active(), Jobs: []AdminJob{}}
+	runningMu.Lock()
+	for id, rj := range runningJobs {
+		out.Jobs = append(out.Jobs, AdminJob{ID: id, Key: rj.key})
+	}
+	runningMu.Unlock()
+	writeJSON(w, http.StatusOK, out)
+}
+
+// POST /admin/jobs/{key}/cancel -> cancela lo que esté procesando esa key
+func handleAdminCancel(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	// Primero los jobs, para que queden como cancelados y no como fallidos
+	jobs := localJobsByKey(key)
+	for _, id := range jobs {
+		cancelJob(context.WithoutCancel(r.Context()), id)
+	}
+	tasks := pool.cancelKey(key)
+	if tasks == 0 && len(jobs) == 0 {
+		writeProblem(w, r, newProblem(CodeNotFound, "No hay ítems activos con esa key"))
+		return
+	}
+	auditAdmin(r, "jobs.cancel", fmt.Sprintf("key=%s tasks=%d jobs=%d", key, tasks, len(jobs)))
+	writeJSON(w, http.StatusOK, map[string]any{"key": key, "cancelled_tasks": tasks, "cancelled_jobs": jobs})
+}
+
+// QueueStats es la profundidad del pool local y de la cola de jobs.
+type QueueStats struct {
+	Pool  map[string]int `json:"pool"`
+	Jobs  map[string]int `json:"jobs,omitempty"`
+	Error string         `json:"jobs_error,omitempty"`
+}
+
+// GET /admin/queue
+func handleAdminQueue(w http.ResponseWriter, r *http.Request) {
+	out := QueueStats{Pool: pool.depth()}
+	depth, err := jobQueue.Depth(r.Context())
+	if err != nil {
+		out.Error = err.Error()
+	} else {
+		out.Jobs = depth
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /admin/pool
+func handleAdminPool(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, pool.stats())
+}
+
+// PUT /admin/pool {"workers": n} -> ajusta la concurrencia sin reiniciar
+func handleAdminResizePool(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Workers int `json:"workers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Workers < 1 {
+		p := newProblem(CodeInvalidInput, "Se espera {workers} con un valor mayor a 0")
+		p.InvalidParams = []InvalidParam{{Name: "workers", Reason: "debe ser un entero mayor a 0"}}
+		writeProblem(w, r, p)
+		return
+	}
+	pool.resize(in.Workers)
+	resizeJobConsumers(in.Workers)
+	auditAdmin(r, "pool.resize", fmt.Sprintf("workers=%d", in.Workers))
+	writeJSON(w, http.StatusOK, pool.stats())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
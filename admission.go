@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Control de admisión: con la cola del pool por encima de
+// OCR_ADMISSION_MAX_QUEUE, o con una espera estimada mayor a
+// OCR_ADMISSION_MAX_WAIT, /ocr y /ocr/batch responden 429 QUEUE_FULL con
+// Retry-After en lugar de aceptar trabajo que va a vencer por el timeout de
+// la ruta. Los jobs asíncronos y /ocr con max_wait_ms se aceptan igual: no
+// dependen del timeout de la ruta.
+
+// Motivos del rechazo, para la métrica.
+const (
+	admissionQueueDepth = "queue_depth"
+	admissionQueueWait  = "queue_wait"
+)
+
+var admissionRejectedTotal = newCounterVec("ocr_admission_rejected_total", "Requests sincrónicas rechazadas por la cola del pool.", "reason")
+
+// admission es la configuración de OCR_ADMISSION_*; cero deshabilita cada
+// límite.
+var admission = newConfigValue(AdmissionConfig{})
+
+func setupAdmission(cfg AdmissionConfig) {
+	admission.store(cfg)
+}
+
+// admit verifica que la cola admita un request sincrónico con esa
+// prioridad; si no, responde 429 y devuelve false. Retry-After es lo que
+// tardaría la cola en bajar al límite al ritmo actual.
+func admit(w http.ResponseWriter, r *http.Request, priority string) bool {
+	queued, wait := pool.estimate(priorityIndex(priority))
+	limits := admission.load()
+	var reason, detail string
+	var retry time.Duration
+	switch {
+	case limits.MaxQueue > 0 && queued >= limits.MaxQueue:
+		reason = admissionQueueDepth
+		detail = fmt.Sprintf("La cola tiene %d ítems esperando, el máximo es %d", queued, limits.MaxQueue)
+		retry = pool.drainTime(queued - limits.MaxQueue + 1)
+	case limits.MaxWait > 0 && wait > limits.MaxWait:
+		reason = admissionQueueWait
+		detail = fmt.Sprintf("La espera estimada en la cola es de %s, el máximo es %s", wait.Round(time.Second), limits.MaxWait)
+		retry = wait - limits.MaxWait
+	default:
+		return true
+	}
+	admissionRejectedTotal.Inc(reason)
+	addLogAttrs(r.Context(), slog.String("admission", reason), slog.Int("queued", queued))
+	retryAfter(w, retry)
+	writeProblem(w, r, newProblem(CodeQueueFull, detail+"; reintentar más tarde o usar /ocr/jobs"))
+	return false
+}
+
+// batchPriority es la mayor prioridad de los ítems del batch, que van a la
+// cola como low si no la indican.
+func batchPriority(items []OCRRequest) string {
+	best := priorityIndex(priorityLow)
+	for _, item := range items {
+		if item.Priority != "" {
+			best = min(best, priorityIndex(item.Priority))
+		}
+	}
+	return priorities[best]
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Tipos de anotación.
+const (
+	annotationComment    = "comment"
+	annotationHighlight  = "highlight"
+	annotationCorrection = "correction"
+)
+
+// Annotation es un comentario, resaltado o corrección de campo que la UI de
+// revisión agrega sobre un resultado guardado.
+type Annotation struct {
+	ID        string       `json:"id"`
+	Type      string       `json:"type"`
+	Author    string       `json:"author,omitempty"`
+	Text      string       `json:"text,omitempty"`      // comment, highlight
+	Page      int          `json:"page,omitempty"`      // highlight
+	BBox      *BoundingBox `json:"bbox,omitempty"`      // highlight
+	Field     string       `json:"field,omitempty"`     // correction
+	Original  string       `json:"original,omitempty"`  // correction
+	Corrected string       `json:"corrected,omitempty"` // correction
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// BoundingBox es un rectángulo en coordenadas de página, en píxeles.
+type BoundingBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (a Annotation) validate() []InvalidParam {
+	var invalid []InvalidParam
+	switch a.Type {
+	case annotationComment:
+		if a.Text == "" {
+			invalid = append(invalid, InvalidParam{Name: "text", Reason: "requerido para comment"})
+		}
+	case annotationHighlight:
+		if a.Page < 1 {
+			invalid = append(invalid, InvalidParam{Name: "page", Reason: "requerido para highlight"})
+		}
+		if a.BBox == nil || a.BBox.Width <= 0 || a.BBox.Height <= 0 {
+			invalid = append(invalid, InvalidParam{Name: "bbox", Reason: "requerido para highlight, con width y height positivos"})
+		}
+	case annotationCorrection:
+		if a.Field == "" {
+			invalid = append(invalid, InvalidParam{Name: "field", Reason: "requerido para correction"})
+		}
+		if a.Corrected == "" {
+			invalid = append(invalid, InvalidParam{Name: "corrected", Reason: "requerido para correction"})
+		}
+	default:
+		invalid = append(invalid, InvalidParam{Name: "type", Reason: "debe ser comment, highlight o correction"})
+	}
+	return invalid
+}
+
+// GET /ocr/results/{key}/annotations
+func handleListAnnotations(w http.ResponseWriter, r *http.Request) {
+	res, ok, err := results.Get(tenantFrom(r.Context()), chi.URLParam(r, "key"))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+		return
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, "No hay un resultado guardado para esa key"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Annotation{"annotations": res.Annotations})
+}
+
+// POST /ocr/results/{key}/annotations -> 201 con la anotación creada
+func handleCreateAnnotation(w http.ResponseWriter, r *http.Request) {
+	var a Annotation
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&a); err != nil {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+		return
+	}
+	if invalid := a.validate(); len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "La anotación contiene campos inválidos")
+		p.InvalidParams = invalid
+		writeProblem(w, r, p)
+		return
+	}
+
+	a.ID = newID(8)
+	a.CreatedAt = time.Now().UTC()
+
+	err := results.Update(tenantFrom(r.Context()), chi.URLParam(r, "key"), func(res *StoredResult) error {
+		res.Annotations = append(res.Annotations, a)
+		return nil
+	})
+	if err != nil {
+		writeProblem(w, r, newProblem(errorCodeOf(err, CodeInternal), err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// DELETE /ocr/results/{key}/annotations/{id} -> 204
+func handleDeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	errNoAnnotation := &codedError{CodeNotFound, errors.New("anotación inexistente")}
+	err := results.Update(tenantFrom(r.Context()), chi.URLParam(r, "key"), func(res *StoredResult) error {
+		i := slices.IndexFunc(res.Annotations, func(a Annotation) bool { return a.ID == id })
+		if i < 0 {
+			return errNoAnnotation
+		}
+		res.Annotations = slices.Delete(res.Annotations, i, i+1)
+		return nil
+	})
+	if err != nil {
+		writeProblem(w, r, newProblem(errorCodeOf(err, CodeInternal), err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxOriginalBytes limita el tamaño de la imagen original que se descarga para archivar.
+const maxOriginalBytes = 50 << 20
+
+// archiveStore es nil cuando el archivado está deshabilitado.
+var archiveStore ObjectStore
+
+// archiveDownloadTTL es la validez de las URLs de /download.
+var archiveDownloadTTL time.Duration
+
+// ArchiveInfo contiene las URIs donde quedaron archivados original y resultado.
+type ArchiveInfo struct {
+	ImageURI  string `json:"image_uri"`
+	ResultURI string `json:"result_uri"`
+}
+
+// archiveResult guarda la imagen original y el resultado JSON bajo
+// {key}/{timestamp}/, dentro de tenants/{tenant}/ salvo para default. El
+// original se toma de source, el del documento procesado; sin él (al
+// reexportar) se descarga.
+func archiveResult(ctx context.Context, req OCRRequest, resp *APIResponse, source *documentSource) (*ArchiveInfo, error) {
+	if sc, ok := archiveStore.(spaceChecker); ok {
+		if err := sc.checkSpace(0); err != nil {
+			return nil, &codedError{CodeArchiveFailed, err}
+		}
+	}
+	if source == nil {
+		source = &documentSource{url: req.URL}
+	}
+	data, contentType, err := source.downloaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	base := safeSegment(req.Key) + "/" + time.Now().UTC().Format("20060102T150405.000Z")
+	if tenant := tenantFrom(ctx); tenant != defaultTenant {
+		base = "tenants/" + tenant + "/" + base
+	}
+	imageURI, err := putArchived(ctx, base+"/"+originalName(req.URL), contentType, data)
+	if err != nil {
+		return nil, &codedError{CodeArchiveFailed, fmt.Errorf("archivando original: %w", err)}
+	}
+
+	result, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	resultURI, err := putArchived(ctx, base+"/result.json", "application/json", result)
+	if err != nil {
+		return nil, &codedError{CodeArchiveFailed, fmt.Errorf("archivando resultado: %w", err)}
+	}
+	return &ArchiveInfo{ImageURI: imageURI, ResultURI: resultURI}, nil
+}
+
+// encryptedSuffix termina el nombre de los objetos archivados cifrados.
+const encryptedSuffix = ".enc"
+
+// putArchived guarda el objeto en el archivado. Con el cifrado en reposo
+// habilitado lo cifra con su key como id y le agrega encryptedSuffix.
+func putArchived(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	if encryption != nil {
+		sealed, err := encryption.seal(ctx, key, data)
+		if err != nil {
+			return "", err
+		}
+		key, contentType, data = key+encryptedSuffix, "application/octet-stream", sealed
+	}
+	return archiveStore.Put(ctx, key, contentType, data)
+}
+
+// fetchOriginal descarga el original, a través del caché en disco si está
+// habilitado.
+func fetchOriginal(ctx context.Context, rawURL string) ([]byte, string, error) {
+	if originals != nil {
+		return originals.fetch(ctx, rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return readOriginal(resp)
+}
+
+// readOriginal lee el original de la respuesta a memoria.
+func readOriginal(resp *http.Response) ([]byte, string, error) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOriginalBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxOriginalBytes {
+		return nil, "", fmt.Errorf("original supera %d bytes", maxOriginalBytes)
+	}
+	return data, originalType(resp, data), nil
+}
+
+// originalType es el Content-Type de la respuesta o, si no lo trae, el que
+// deduce net/http.
+func originalType(resp *http.Response, data []byte) string {
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(data)
+}
+
+// fetchError es el error de descarga del original: FETCH_FAILED, salvo que
+// ya tenga código (INSUFFICIENT_STORAGE). El motivo va solo al log: el
+// estado o el error de conexión dirían al cliente qué hay detrás de la URL.
+func fetchError(err error) error {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return err
+	}
+	slog.Warn("original not fetched", "error", err)
+	return &codedError{CodeFetchFailed, errors.New("no se pudo descargar el original de url")}
+}
+
+// safeSegment escapa la key del cliente para usarla como un único segmento de ruta.
+func safeSegment(key string) string {
+	seg := url.PathEscape(key)
+	if seg == "." || seg == ".." {
+		seg = strings.ReplaceAll(seg, ".", "%2E")
+	}
+	return seg
+}
+
+// originalName conserva el nombre de archivo de la URL cuando existe.
+func originalName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "." && name != "/" {
+			return "original-" + name
+		}
+	}
+	return "original"
+}
+
+// ResultDownload son las URLs firmadas para descargar lo archivado de un
+// resultado sin acceso al bucket; vencen en expires_at. Con el archivado
+// cifrado son rutas de /ocr/archive/{token}, que descifra en el servidor.
+type ResultDownload struct {
+	Key       string    `json:"key"`
+	Version   int       `json:"version,omitempty"`
+	ImageURL  string    `json:"image_url"`
+	ResultURL string    `json:"result_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GET /ocr/results/{key}/download?version= -> URLs firmadas de la imagen
+// original y del resultado archivados, válidas por OCR_ARCHIVE_DOWNLOAD_TTL
+func handleResultDownload(w http.ResponseWriter, r *http.Request) {
+	if archiveStore == nil {
+		writeProblem(w, r, newProblem(CodeConflict, "El archivado no está habilitado (OCR_ARCHIVE_URL)"))
+		return
+	}
+	res, ok := requestedResult(w, r)
+	if !ok {
+		return
+	}
+	archive := res.Result.Archive
+	if archive == nil {
+		writeProblem(w, r, newProblem(CodeNotFound, "El resultado no está archivado (ver export_error o POST /ocr/jobs/reexport)"))
+		return
+	}
+
+	// Las URLs del bucket darían el contenido cifrado: se firman en su
+	// lugar tokens para /ocr/archive/{token}
+	sign := archiveDownloadURL
+	if !strings.HasSuffix(archive.ImageURI, encryptedSuffix) {
+		signer, ok := archiveStore.(urlSigner)
+		if !ok {
+			writeProblem(w, r, newProblem(CodeConflict, "El archivado configurado no genera URLs firmadas: requiere s3:// o gs://"))
+			return
+		}
+		sign = signer.signedURL
+	}
+	now := time.Now().UTC()
+	out := ResultDownload{Key: res.Key, Version: res.Version, ExpiresAt: now.Add(archiveDownloadTTL).Truncate(time.Second)}
+	for _, u := range []struct {
+		uri string
+		dst *string
+	}{{archive.ImageURI, &out.ImageURL}, {archive.ResultURI, &out.ResultURL}} {
+		signed, err := sign(u.uri, archiveDownloadTTL, now)
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+			return
+		}
+		*u.dst = signed
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, out)
+}
+
+// archiveToken es lo que firma el token de /ocr/archive/{token}: el objeto
+// archivado y cuándo vence.
+type archiveToken struct {
+	URI     string `json:"uri"`
+	Expires int64  `json:"exp"`
+}
+
+// archiveTokenContext separa las firmas de los tokens de las de los
+// manifiestos de export, que usan la misma clave.
+const archiveTokenContext = "api-ocr archive download\n"
+
+// archiveDownloadURL es la ruta de /ocr/archive/{token} para descargar
+// descifrado el objeto de uri hasta now+ttl. El token es el JSON de
+// archiveToken y su firma Ed25519 con exportSigner, en base64url.
+func archiveDownloadURL(uri string, ttl time.Duration, now time.Time) (string, error) {
+	payload, err := json.Marshal(archiveToken{URI: uri, Expires: now.Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(exportSigner, append([]byte(archiveTokenContext), payload...))
+	return "/ocr/archive/" + base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parseArchiveToken verifica la firma y el vencimiento del token.
+func parseArchiveToken(token string, now time.Time) (archiveToken, error) {
+	var t archiveToken
+	p, s, ok := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if !ok || err != nil {
+		return t, errors.New("token mal formado")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || !ed25519.Verify(exportSigner.Public().(ed25519.PublicKey), append([]byte(archiveTokenContext), payload...), sig) {
+		return t, errors.New("firma inválida")
+	}
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return t, err
+	}
+	if now.Unix() >= t.Expires {
+		return t, errors.New("token vencido")
+	}
+	return t, nil
+}
+
+// GET /ocr/archive/{token} -> objeto archivado cifrado, descifrado en el
+// servidor. El token lo da /ocr/results/{key}/download y es la credencial:
+// la ruta no requiere auth, como las URLs prefirmadas del bucket.
+func handleArchiveDownload(w http.ResponseWriter, r *http.Request) {
+	t, err := parseArchiveToken(chi.URLParam(r, "token"), time.Now())
+	if err != nil || archiveStore == nil {
+		writeProblem(w, r, newProblem(CodeForbidden, "El enlace de descarga no es válido o ya venció: pedir uno nuevo a /ocr/results/{key}/download"))
+		return
+	}
+	key, err := archiveStore.Key(t.URI)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+		return
+	}
+	data, err := archiveStore.Get(r.Context(), t.URI)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInternal, fmt.Sprintf("Leyendo el archivado: %v", err)))
+		return
+	}
+	key = strings.TrimSuffix(key, encryptedSuffix)
+	plain, err := openRecord(r.Context(), key, data)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInternal, fmt.Sprintf("Descifrando %s: %v", key, err)))
+		return
+	}
+	name := path.Base(key)
+	contentType := "application/json"
+	if name != "result.json" {
+		if contentType = detectFormat(plain, ""); !slices.Contains(supportedContentTypes, contentType) {
+			contentType = "application/octet-stream"
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(plain)
+}
+
+// archiveRewrapper vuelve a cifrar con la clave maestra activa los objetos
+// archivados cifrados (.enc). Los archivados sin cifrar quedan como están:
+// cifrarlos cambiaría su URI, que está en los resultados guardados.
+type archiveRewrapper struct {
+	store ObjectStore
+}
+
+func (a archiveRewrapper) rewrap(ctx context.Context) (int, error) {
+	n := 0
+	err := a.store.Walk(ctx, func(key, uri string) error {
+		if !strings.HasSuffix(key, encryptedSuffix) {
+			return nil
+		}
+		data, err := a.store.Get(ctx, uri)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if !isSealed(data) || !needsRewrap(data) {
+			return nil
+		}
+		sealed, err := rewrapRecord(ctx, strings.TrimSuffix(key, encryptedSuffix), data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if _, err := a.store.Put(ctx, key, "application/octet-stream", sealed); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
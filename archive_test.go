@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// useSigner genera una clave de firma efímera hasta que termina el test.
+func useSigner(t *testing.T) {
+	t.Helper()
+	prev := exportSigner
+	if _, err := setupExportSigner(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { exportSigner = prev })
+}
+
+// archiveTokenOf saca el token de la ruta de archiveDownloadURL.
+func archiveTokenOf(t *testing.T, uri string, ttl time.Duration, now time.Time) string {
+	t.Helper()
+	u, err := archiveDownloadURL(uri, ttl, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, ok := strings.CutPrefix(u, "/ocr/archive/")
+	if !ok {
+		t.Fatalf("archiveDownloadURL = %q", u)
+	}
+	return token
+}
+
+func TestArchiveToken(t *testing.T) {
+	useSigner(t)
+	now := time.Unix(1_700_000_000, 0)
+	uri := "s3://ocr/default/a/original.png.enc"
+	token := archiveTokenOf(t, uri, time.Minute, now)
+
+	got, err := parseArchiveToken(token, now.Add(59*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.URI != uri {
+		t.Fatalf("URI = %q, se esperaba %q", got.URI, uri)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	other := archiveTokenOf(t, "s3://ocr/otro/b/result.json.enc", time.Hour, now)
+	otherPayload, _, _ := strings.Cut(other, ".")
+	// Un token firmado con otra clave
+	forged := func() string {
+		prev := exportSigner
+		defer func() { exportSigner = prev }()
+		_, exportSigner, _ = ed25519.GenerateKey(nil)
+		return archiveTokenOf(t, uri, time.Hour, now)
+	}()
+	for name, tc := range map[string]struct {
+		token string
+		now   time.Time
+		want  string
+	}{
+		"vencido":           {token, now.Add(time.Minute), "token vencido"},
+		"otra clave":        {forged, now, "firma inválida"},
+		"payload cambiado":  {otherPayload + "." + sig, now, "firma inválida"},
+		"firma truncada":    {payload + "." + sig[:len(sig)-4], now, "firma inválida"},
+		"sin firma":         {payload, now, "token mal formado"},
+		"payload no base64": {"!!." + sig, now, "token mal formado"},
+		"vacío":             {"", now, "token mal formado"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseArchiveToken(tc.token, tc.now); err == nil || err.Error() != tc.want {
+				t.Fatalf("parseArchiveToken = %v, se esperaba %q", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestArchiveRewrap archiva cifrado con una clave maestra, rota a otra y
+// descarga por /ocr/archive/{token} ya sin la vieja.
+func TestArchiveRewrap(t *testing.T) {
+	useSigner(t)
+	prev := archiveStore
+	archiveStore = &fileStore{dir: t.TempDir()}
+	t.Cleanup(func() { archiveStore = prev })
+	k1, k2 := testKey(t, "k1"), testKey(t, "k2")
+	ctx := context.Background()
+	plain := []byte(`{"full_text":"Pasaporte"}`)
+
+	useKeys(t, k1)
+	uri, err := putArchived(ctx, "default/a/result.json", "application/json", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Un archivado sin cifrar, de antes de habilitar el cifrado
+	if _, err := archiveStore.Put(ctx, "default/b/result.json", "application/json", plain); err != nil {
+		t.Fatal(err)
+	}
+
+	useKeys(t, k2, k1)
+	n, err := archiveRewrapper{archiveStore}.rewrap(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("rewrap = %d, se esperaba 1", n)
+	}
+	if n, err = (archiveRewrapper{archiveStore}).rewrap(ctx); err != nil || n != 0 {
+		t.Fatalf("segundo rewrap = %d, %v; se esperaba 0", n, err)
+	}
+
+	useKeys(t, k2)
+	r := chi.NewRouter()
+	r.Get("/ocr/archive/{token}", handleArchiveDownload)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	u, err := archiveDownloadURL(uri, time.Minute, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := get(u)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), plain) {
+		t.Fatalf("GET %s = %d %q, se esperaba 200 %q", u, rec.Code, rec.Body, plain)
+	}
+	if u, err = archiveDownloadURL(uri, -time.Second, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(u); rec.Code != http.StatusForbidden {
+		t.Fatalf("GET con el token vencido = %d, se esperaba 403", rec.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// pageNumberLine reconoce líneas que solo contienen la numeración de la
+// página: "3", "- 3 -", "Página 3 de 10", "Pág. 3/10", "Page 3 of 10".
+var pageNumberLine = regexp.MustCompile(`(?i)^\s*-?\s*((p[áa]g(ina)?|page)\.?\s*)?\d{1,4}(\s*(de|of|/)\s*\d{1,4})?\s*-?\s*$`)
+
+func isPageNumberLine(line string) bool {
+	return pageNumberLine.MatchString(line)
+}
+
+// watermarkWords son los textos de marca de agua habituales, en mayúsculas
+// y sin acentos ni espacios.
+var watermarkWords = map[string]bool{
+	"COPIA":         true,
+	"COPIANOVALIDA": true,
+	"CONFIDENCIAL":  true,
+	"BORRADOR":      true,
+	"MUESTRA":       true,
+	"ANULADO":       true,
+	"NOVALIDO":      true,
+	"COPY":          true,
+	"DRAFT":         true,
+	"SAMPLE":        true,
+	"VOID":          true,
+}
+
+// spacedCapitals reconoce letras mayúsculas separadas por espacios ("C O P I A"),
+// que es como el OCR suele leer una marca de agua diagonal.
+var spacedCapitals = regexp.MustCompile(`^\s*(\p{Lu}\s+){3,}\p{Lu}\s*$`)
+
+// ocrConfusions deshace las confusiones dígito/letra más comunes del OCR.
+var ocrConfusions = map[rune]rune{'0': 'O', '1': 'L', '5': 'S'}
+
+// isWatermarkLine indica si la línea es una marca de agua conocida o tiene
+// la forma típica de una marca de agua diagonal.
+func isWatermarkLine(line string) bool {
+	if spacedCapitals.MatchString(line) {
+		return true
+	}
+	var b strings.Builder
+	for _, r := range line {
+		if c, ok := ocrConfusions[r]; ok {
+			r = c
+		}
+		if unicode.IsLetter(r) {
+			b.WriteRune(unicode.ToUpper(stripAccent(r)))
+		}
+	}
+	return watermarkWords[b.String()]
+}
+
+func stripAccent(r rune) rune {
+	switch r {
+	case 'á', 'Á':
+		return 'a'
+	case 'é', 'É':
+		return 'e'
+	case 'í', 'Í':
+		return 'i'
+	case 'ó', 'Ó':
+		return 'o'
+	case 'ú', 'Ú', 'ü', 'Ü':
+		return 'u'
+	}
+	return r
+}
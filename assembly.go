@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+const defaultPageSeparator = "\n\n"
+
+// Valores de OCRRequest.HeadersFooters.
+const (
+	headersFootersKeep  = "keep"
+	headersFootersStrip = "strip" // equivale a remove: ["headers","footers"]
+)
+
+// Valores de OCRRequest.Remove.
+const (
+	removeHeaders     = "headers"
+	removeFooters     = "footers"
+	removePageNumbers = "page_numbers"
+	removeWatermarks  = "watermarks"
+)
+
+var removeOptions = []string{removeHeaders, removeFooters, removePageNumbers, removeWatermarks}
+
+// edgeLines es cuántas líneas al inicio y al final de cada página se
+// consideran candidatas a encabezado o pie.
+const edgeLines = 1
+
+// assembleText aplica las opciones de armado del request a las páginas
+// reconocidas: quita encabezados, pies, números de página y marcas de agua
+// según se pidió y devuelve las páginas resultantes junto con el texto unido
+// por el separador.
+func assembleText(pages []PageResult, req OCRRequest) ([]PageResult, string) {
+	out := make([]PageResult, len(pages))
+	copy(out, pages)
+
+	remove := req.removeSet()
+	if len(remove) > 0 {
+		headers, footers := repeatedEdgeLines(out)
+		if !remove[removeHeaders] {
+			headers = nil
+		}
+		if !remove[removeFooters] {
+			footers = nil
+		}
+		for i := range out {
+			if !out[i].Blank {
+				out[i].Text = cleanPage(out[i].Text, remove, headers, footers)
+			}
+		}
+	}
+	return out, joinPages(out, req.pageSeparator())
+}
+
+// removeSet combina Remove con el atajo HeadersFooters.
+func (req OCRRequest) removeSet() map[string]bool {
+	set := map[string]bool{}
+	for _, r := range req.Remove {
+		set[r] = true
+	}
+	if req.HeadersFooters == headersFootersStrip {
+		set[removeHeaders] = true
+		set[removeFooters] = true
+	}
+	return set
+}
+
+func (req OCRRequest) pageSeparator() string {
+	if req.PageSeparator != nil {
+		return *req.PageSeparator
+	}
+	return defaultPageSeparator
+}
+
+// joinPages une el texto de las páginas no vacías.
+func joinPages(pages []PageResult, sep string) string {
+	var texts []string
+	for _, p := range pages {
+		if !p.Blank {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, sep)
+}
+
+// repeatedEdgeLines detecta las líneas que se repiten al inicio (encabezados)
+// o al final (pies) de al menos dos páginas. Los dígitos se normalizan para
+// que "Página 1 de 3" y "Página 2 de 3" cuenten como la misma línea.
+func repeatedEdgeLines(pages []PageResult) (headers, footers map[string]bool) {
+	top := map[string]int{}
+	bottom := map[string]int{}
+	for _, p := range pages {
+		if p.Blank {
+			continue
+		}
+		lines := strings.Split(p.Text, "\n")
+		seenTop := map[string]bool{}
+		seenBottom := map[string]bool{}
+		for i, line := range lines {
+			key := normalizeEdgeLine(line)
+			if key == "" {
+				continue
+			}
+			if i < edgeLines && !seenTop[key] {
+				seenTop[key] = true
+				top[key]++
+			}
+			if i >= len(lines)-edgeLines && !seenBottom[key] {
+				seenBottom[key] = true
+				bottom[key]++
+			}
+		}
+	}
+
+	headers = map[string]bool{}
+	footers = map[string]bool{}
+	for k, n := range top {
+		if n >= 2 {
+			headers[k] = true
+		}
+	}
+	for k, n := range bottom {
+		if n >= 2 {
+			footers[k] = true
+		}
+	}
+	return headers, footers
+}
+
+// cleanPage quita de la página los encabezados y pies detectados y, según
+// remove, las líneas de número de página y de marca de agua.
+func cleanPage(text string, remove, headers, footers map[string]bool) string {
+	lines := strings.Split(text, "\n")
+	var kept []string
+	for i, line := range lines {
+		key := normalizeEdgeLine(line)
+		switch {
+		case i < edgeLines && headers[key]:
+		case i >= len(lines)-edgeLines && footers[key]:
+		case remove[removePageNumbers] && isPageNumberLine(line):
+		case remove[removeWatermarks] && isWatermarkLine(line):
+		default:
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+func normalizeEdgeLine(line string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return '#'
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimSpace(line))
+}
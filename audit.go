@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Auditoría de las operaciones de OCR: cada ítem que procesa el pool deja
+// una entrada con quién lo pidió (tenant y API key), qué (key, hash de la
+// URL y opciones), cuándo, cómo terminó y con qué motor. Es append-only: las
+// entradas no se editan ni se borran, solo vencen tras auditRetention. Las
+// acciones de /admin tienen su propio log en runbook.go.
+
+// auditRetention es cuánto se conservan las entradas; también es el rango
+// máximo de una consulta.
+const auditRetention = 365 * 24 * time.Hour
+
+const redisAuditPrefix = "ocr:audit:"
+
+// Estados de OCROperation.Status.
+const (
+	auditCompleted = "completed"
+	auditFailed    = "failed"
+)
+
+var auditStatuses = []string{auditCompleted, auditFailed}
+
+// OCROperation es un ítem procesado. La URL no se guarda, que puede llevar
+// credenciales firmadas, solo su SHA-256 para cotejarla.
+type OCROperation struct {
+	At        time.Time `json:"at"`
+	Tenant    string    `json:"tenant"`
+	APIKeyID  string    `json:"api_key_id,omitempty"` // ver apiKeyID
+	RequestID string    `json:"request_id,omitempty"`
+	Key       string    `json:"key"`
+	URLSHA256 string    `json:"url_sha256"`
+	// Options son los campos del request además de key y url.
+	Options    map[string]any `json:"options,omitempty"`
+	Status     string         `json:"status"`
+	StatusCode int            `json:"status_code"`
+	ErrorCode  ErrorCode      `json:"error_code,omitempty"`
+	Engine     string         `json:"engine"`
+	DurationMs int64          `json:"duration_ms"` // desde que entró al pool
+}
+
+// OperationStore guarda las entradas por día (UTC), compartidas entre
+// réplicas cuando el backend lo permite.
+type OperationStore interface {
+	Append(ctx context.Context, e OCROperation) error
+	// Days devuelve las entradas de esos días, de todos los tenants.
+	Days(ctx context.Context, days []string) ([]OCROperation, error)
+}
+
+var operationStore OperationStore
+
+// apiKeyID identifica una API key en la auditoría sin guardarla: los
+// primeros 16 hex de su SHA-256.
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+type apiKeyIDKey struct{}
+
+func withAPIKeyID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, apiKeyIDKey{}, id)
+}
+
+// apiKeyIDFrom devuelve la API key de la request, vacío si se identificó
+// con X-Tenant-ID o es interna (schedules, demo).
+func apiKeyIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(apiKeyIDKey{}).(string)
+	return id
+}
+
+// auditOptions son los campos de req que no son key ni url.
+func auditOptions(req OCRRequest) map[string]any {
+	req.Key, req.URL = "", ""
+	data, _ := json.Marshal(req)
+	var opts map[string]any
+	json.Unmarshal(data, &opts)
+	delete(opts, "key")
+	delete(opts, "url")
+	if len(opts) == 0 {
+		return nil
+	}
+	return opts
+}
+
+// recordOperation registra un ítem procesado por el pool. El motor es el
+// que produjo el resultado o, si falló, el pedido, como en recordRollup.
+func recordOperation(ctx context.Context, req OCRRequest, resp *APIResponse, latency time.Duration) {
+	sum := sha256.Sum256([]byte(req.URL))
+	e := OCROperation{
+		At:         time.Now().UTC(),
+		Tenant:     tenantFrom(ctx),
+		APIKeyID:   apiKeyIDFrom(ctx),
+		RequestID:  requestIDFrom(ctx),
+		Key:        req.Key,
+		URLSHA256:  hex.EncodeToString(sum[:]),
+		Options:    auditOptions(req),
+		Status:     auditCompleted,
+		Engine:     req.Engine,
+		DurationMs: latency.Milliseconds(),
+	}
+	if resp != nil {
+		e.StatusCode, e.ErrorCode = resp.StatusCode, resp.ErrorCode
+	}
+	if resp == nil || resp.ErrorCode != "" {
+		e.Status = auditFailed
+	} else {
+		e.Engine = resp.Engine
+	}
+	if e.Engine == "" {
+		e.Engine = primaryEngine().Name()
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := operationStore.Append(sctx, e); err != nil {
+		slog.Error("audit entry not recorded", "tenant", e.Tenant, "key", e.Key, "error", err)
+	}
+}
+
+// memoryOperationStore guarda las entradas en memoria y descarta los días
+// vencidos.
+type memoryOperationStore struct {
+	mu   sync.Mutex
+	days map[string][]OCROperation
+}
+
+func newMemoryOperationStore() *memoryOperationStore {
+	return &memoryOperationStore{days: map[string][]OCROperation{}}
+}
+
+func (s *memoryOperationStore) Append(_ context.Context, e OCROperation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := usageDay(e.At)
+	if s.days[day] == nil {
+		oldest := usageDay(time.Now().Add(-auditRetention))
+		for d := range s.days {
+			if d < oldest {
+				delete(s.days, d)
+			}
+		}
+	}
+	s.days[day] = append(s.days[day], e)
+	return nil
+}
+
+func (s *memoryOperationStore) Days(_ context.Context, days []string) ([]OCROperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []OCROperation
+	for _, day := range days {
+		out = append(out, s.days[day]...)
+	}
+	return out, nil
+}
+
+// redisOperationStore guarda una lista por día con las entradas en JSON.
+type redisOperationStore struct {
+	rdb *redis.Client
+}
+
+func (s *redisOperationStore) Append(ctx context.Context, e OCROperation) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	key := redisAuditPrefix + usageDay(e.At)
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		pipe.Expire(ctx, key, auditRetention)
+		return nil
+	})
+	return err
+}
+
+func (s *redisOperationStore) Days(ctx context.Context, days []string) ([]OCROperation, error) {
+	var out []OCROperation
+	for _, day := range days {
+		values, err := s.rdb.LRange(ctx, redisAuditPrefix+day, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			var e OCROperation
+			if json.Unmarshal([]byte(v), &e) == nil {
+				out = append(out, e)
+			}
+		}
+	}
+	return out, nil
+}
+
+// OperationPage es la respuesta JSON de /audit.
+type OperationPage struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Total      int            `json:"total"`
+	Offset     int            `json:"offset"`
+	Limit      int            `json:"limit"`
+	NextOffset *int           `json:"next_offset,omitempty"`
+	Entries    []OCROperation `json:"entries"`
+}
+
+var auditCSVHeader = []string{
+	"at", "tenant", "api_key_id", "request_id", "key", "url_sha256", "options",
+	"status", "status_code", "error_code", "engine", "duration_ms",
+}
+
+// writeOperations responde las entradas de los días pedidos que cumplen los
+// filtros, del tenant si no es vacío. En JSON pagina con offset y limit; en
+// CSV (?format=csv o Accept: text/csv) exporta todas.
+func writeOperations(w http.ResponseWriter, r *http.Request, tenant string) {
+	q := r.URL.Query()
+	days, problem := dayRange(r, auditRetention)
+	if problem != nil {
+		writeProblem(w, r, *problem)
+		return
+	}
+	var invalid []InvalidParam
+	status := q.Get("status")
+	if status != "" && !slices.Contains(auditStatuses, status) {
+		invalid = append(invalid, InvalidParam{Name: "status", Reason: "debe ser uno de: " + strings.Join(auditStatuses, ", ")})
+	}
+	format := q.Get("format")
+	switch {
+	case format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv"):
+		format = "csv"
+	case format == "":
+		format = "json"
+	case format != "json" && format != "csv":
+		invalid = append(invalid, InvalidParam{Name: "format", Reason: "debe ser json o csv"})
+	}
+	offset, limit := 0, defaultResultsLimit
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			invalid = append(invalid, InvalidParam{Name: "offset", Reason: "debe ser un entero mayor o igual a 0"})
+		}
+		offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxResultsLimit {
+			invalid = append(invalid, InvalidParam{Name: "limit", Reason: fmt.Sprintf("debe ser un entero entre 1 y %d", maxResultsLimit)})
+		}
+		limit = n
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "Parámetros inválidos")
+		p.InvalidParams = invalid
+		writeProblem(w, r, p)
+		return
+	}
+
+	all, err := operationStore.Days(r.Context(), days)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	entries := []OCROperation{}
+	for _, e := range all {
+		switch {
+		case tenant != "" && e.Tenant != tenant,
+			q.Get("key") != "" && e.Key != q.Get("key"),
+			q.Get("api_key_id") != "" && e.APIKeyID != q.Get("api_key_id"),
+			q.Get("engine") != "" && e.Engine != q.Get("engine"),
+			status != "" && e.Status != status:
+			continue
+		}
+		entries = append(entries, e)
+	}
+	slices.SortStableFunc(entries, func(a, b OCROperation) int { return a.At.Compare(b.At) })
+
+	from, to := days[0], days[len(days)-1]
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s-%s.csv"`, from, to))
+		cw := csv.NewWriter(w)
+		cw.Write(auditCSVHeader)
+		for _, e := range entries {
+			opts := ""
+			if e.Options != nil {
+				data, _ := json.Marshal(e.Options)
+				opts = string(data)
+			}
+			cw.Write([]string{
+				e.At.Format(time.RFC3339Nano), e.Tenant, e.APIKeyID, e.RequestID, e.Key, e.URLSHA256, opts,
+				e.Status, strconv.Itoa(e.StatusCode), string(e.ErrorCode), e.Engine, strconv.FormatInt(e.DurationMs, 10),
+			})
+		}
+		cw.Flush()
+		return
+	}
+
+	out := OperationPage{From: from, To: to, Total: len(entries), Offset: offset, Limit: limit, Entries: []OCROperation{}}
+	if offset < len(entries) {
+		end := min(offset+limit, len(entries))
+		out.Entries = entries[offset:end]
+		if end < len(entries) {
+			out.NextOffset = &end
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /audit -> entradas de auditoría del tenant de la request
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	writeOperations(w, r, tenantFrom(r.Context()))
+}
+
+// GET /admin/audit/operations -> entradas de auditoría de todos los
+// tenants; ?tenant= filtra
+func handleAdminOperations(w http.ResponseWriter, r *http.Request) {
+	writeOperations(w, r, r.URL.Query().Get("tenant"))
+}
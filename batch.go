@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchEngine es un motor cuyo backend acepta varias páginas por llamada
+// (APIs cloud con requests multi-imagen). MaxBatch es el límite del
+// proveedor.
+type BatchEngine interface {
+	OCREngine
+	MaxBatch() int
+	RecognizeBatch(ctx context.Context, pages []Page) ([]Recognition, error)
+}
+
+var (
+	engineBatchesTotal      = newCounterVec("ocr_engine_batches_total", "Llamadas batch a motores OCR.", "engine")
+	engineBatchedPagesTotal = newCounterVec("ocr_engine_batched_pages_total", "Páginas enviadas en llamadas batch.", "engine")
+)
+
+// mockBatchEngine simula un motor cloud: cada llamada paga callOverhead
+// (red, autenticación, cola del proveedor) más perPage por página, así que
+// agrupar páginas abarata el costo por página.
+type mockBatchEngine struct {
+	mockEngine
+	callOverhead time.Duration
+	perPage      time.Duration
+	maxBatch     int
+}
+
+func (e *mockBatchEngine) MaxBatch() int { return e.maxBatch }
+
+func (e *mockBatchEngine) Recognize(ctx context.Context, p Page) (Recognition, error) {
+	recs, err := e.RecognizeBatch(ctx, []Page{p})
+	if err != nil {
+		return Recognition{}, err
+	}
+	return recs[0], nil
+}
+
+func (e *mockBatchEngine) RecognizeBatch(ctx context.Context, pages []Page) ([]Recognition, error) {
+	if len(pages) > e.maxBatch {
+		return nil, fmt.Errorf("%s: el batch de %d páginas supera el máximo de %d", e.name, len(pages), e.maxBatch)
+	}
+	latency := e.callOverhead + time.Duration(len(pages))*e.perPage
+	mock := mockRand.load()
+	if mock.deterministic && mock.latency > 0 {
+		latency = mock.latency
+	}
+	select {
+	case <-time.After(latency):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// La falla es de la llamada; en modo determinístico depende de la
+	// primera página
+	if mock.source(e.name, "batch", pages[0].seed).Float64() < mock.failureRate {
+		return nil, fmt.Errorf("%s: %w", e.name, errEngineUnavailable)
+	}
+
+	recs := make([]Recognition, len(pages))
+	for i, p := range pages {
+		recs[i] = e.recognizePage(mock.source(e.name, p.seed), p)
+	}
+	return recs, nil
+}
+
+// batchCall es una página esperando su resultado dentro de un batch.
+type batchCall struct {
+	page Page
+	done chan batchResult
+}
+
+type batchResult struct {
+	rec Recognition
+	err error
+}
+
+// engineBatcher agrupa las páginas que llegan dentro de window (de
+// cualquier request) en una sola llamada RecognizeBatch de hasta maxItems
+// páginas y reparte los resultados. Un error del batch se devuelve a todas
+// sus páginas; los reintentos de resilientEngine las vuelven a encolar.
+type engineBatcher struct {
+	BatchEngine
+	window   time.Duration
+	maxItems int
+
+	mu      sync.Mutex
+	pending []*batchCall
+	timer   *time.Timer
+}
+
+func newEngineBatcher(e BatchEngine, window time.Duration, maxItems int) *engineBatcher {
+	return &engineBatcher{BatchEngine: e, window: window, maxItems: min(maxItems, e.MaxBatch())}
+}
+
+func (b *engineBatcher) Recognize(ctx context.Context, p Page) (Recognition, error) {
+	call := &batchCall{page: p, done: make(chan batchResult, 1)}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, call)
+	switch {
+	case len(b.pending) >= b.maxItems:
+		b.flushLocked()
+	case len(b.pending) == 1:
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	b.mu.Unlock()
+
+	select {
+	case res := <-call.done:
+		return res.rec, res.err
+	case <-ctx.Done():
+		// El batch sigue para las demás páginas; este resultado se descarta.
+		return Recognition{}, ctx.Err()
+	}
+}
+
+// Ping delega en el motor, si lo soporta, para el chequeo de readiness.
+func (b *engineBatcher) Ping(ctx context.Context) error {
+	if p, ok := b.BatchEngine.(pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (b *engineBatcher) flush() {
+	b.mu.Lock()
+	b.flushLocked()
+	b.mu.Unlock()
+}
+
+// flushLocked envía las páginas pendientes como un batch. Se llama con mu
+// tomado.
+func (b *engineBatcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	calls := b.pending
+	b.pending = nil
+	go b.send(calls)
+}
+
+// send hace la llamada batch. No depende del contexto de ningún request:
+// que uno se cancele no debe cortar las páginas de los demás.
+func (b *engineBatcher) send(calls []*batchCall) {
+	pages := make([]Page, len(calls))
+	for i, c := range calls {
+		pages[i] = c.page
+	}
+	name := b.Name()
+	engineBatchesTotal.Inc(name)
+	engineBatchedPagesTotal.Add(float64(len(pages)), name)
+
+	recs, err := b.RecognizeBatch(context.Background(), pages)
+	if err == nil && len(recs) != len(calls) {
+		err = fmt.Errorf("%s: el batch devolvió %d resultados para %d páginas", name, len(recs), len(calls))
+	}
+	for i, c := range calls {
+		if err != nil {
+			c.done <- batchResult{err: err}
+			continue
+		}
+		c.done <- batchResult{rec: recs[i]}
+	}
+}
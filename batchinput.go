@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strings"
+)
+
+// Formatos alternativos del body de un batch, además del envelope JSON
+// {items: [...]}. Se eligen por Content-Type.
+var (
+	csvMediaTypes   = []string{"text/csv", "application/csv"}
+	jsonlMediaTypes = []string{mediaTypeNDJSON, "application/jsonl", "application/x-jsonl"}
+)
+
+// errTabularNotBatch indica un body CSV o JSONL en un endpoint de un solo ítem.
+var errTabularNotBatch = errors.New("CSV y JSONL solo se aceptan en /ocr/batch y /ocr/batches")
+
+// batchEnvelope convierte un body CSV o JSONL al envelope JSON del batch,
+// para que presets y validaciones lo traten igual. Con otro Content-Type
+// devuelve el body sin cambios.
+func batchEnvelope(contentType string, body []byte, batch bool) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	parse := csvItems
+	switch {
+	case slices.Contains(csvMediaTypes, mediaType):
+	case slices.Contains(jsonlMediaTypes, mediaType):
+		parse = jsonlItems
+	default:
+		return body, nil
+	}
+	if !batch {
+		return nil, errTabularNotBatch
+	}
+	items, err := parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+	return json.Marshal(map[string]any{"items": items})
+}
+
+// csvItems lee filas key,url. Una primera fila "key,url" se toma como
+// encabezado; el BOM que agregan algunos exportadores se descarta.
+func csvItems(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimPrefix(body, []byte("\ufeff"))
+	cr := csv.NewReader(bytes.NewReader(body))
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	var items []json.RawMessage
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CSV inválido: %w", err)
+		}
+		key, url := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+		if line == 1 && strings.EqualFold(key, "key") && strings.EqualFold(url, "url") {
+			continue
+		}
+		item, err := json.Marshal(OCRRequest{Key: key, URL: url})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// jsonlItems lee un objeto JSON por línea con los mismos campos que cada
+// ítem del envelope. Las líneas vacías se ignoran.
+func jsonlItems(body []byte) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	for i, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var item map[string]json.RawMessage
+		if err := json.Unmarshal(line, &item); err != nil {
+			return nil, fmt.Errorf("JSONL inválido: línea %d: %w", i+1, err)
+		}
+		items = append(items, json.RawMessage(line))
+	}
+	return items, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// BatchRetrySummary informa los jobs del batch que volvieron a la cola.
+type BatchRetrySummary struct {
+	BatchID string     `json:"batch_id"`
+	Retried int        `json:"retried"`
+	Jobs    []JobState `json:"jobs"`
+}
+
+// POST /ocr/batches/{id}/retry?error_code= -> vuelve a encolar los jobs
+// fallidos del batch (timeouts incluidos), con el mismo id, así sus
+// resultados quedan en el batch; error_code (lista separada por comas, p.
+// ej. ENGINE_TIMEOUT) reintenta solo los que fallaron con esos códigos
+func handleRetryBatch(w http.ResponseWriter, r *http.Request) {
+	var codes []string
+	if v := r.URL.Query().Get("error_code"); v != "" {
+		codes = splitList(v)
+		for i, c := range codes {
+			c = strings.ToUpper(c)
+			codes[i] = c
+			if !slices.ContainsFunc(errorCatalog, func(d ErrorDefinition) bool { return string(d.Code) == c }) {
+				p := newProblem(CodeInvalidInput, "Parámetros inválidos")
+				p.InvalidParams = []InvalidParam{{Name: "error_code", Reason: fmt.Sprintf("código %q inexistente (ver GET /problems)", c)}}
+				writeProblem(w, r, p)
+				return
+			}
+		}
+	}
+
+	id, jobs, ok := batchJobs(w, r)
+	if !ok {
+		return
+	}
+	failed := slices.DeleteFunc(jobs, func(j Job) bool { return !retryable(j, codes) })
+	if err := checkQuota(r.Context(), len(failed)); err != nil {
+		writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	out := BatchRetrySummary{BatchID: id, Jobs: []JobState{}}
+	for _, job := range failed {
+		job, retried, err := retryJob(ctx, job.ID, codes)
+		if err != nil {
+			writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+			return
+		}
+		if !retried {
+			continue
+		}
+		out.Retried++
+		out.Jobs = append(out.Jobs, JobState{ID: job.ID, Key: job.Item.Key, Status: job.Status})
+		for _, d := range job.Duplicates {
+			out.Jobs = append(out.Jobs, JobState{ID: job.ID, Key: d.Key, Status: job.Status, Deduplicated: true})
+		}
+	}
+	addLogAttrs(r.Context(), slog.String("batch_id", id), slog.Int("retried", out.Retried))
+	w.Header().Set("Location", "/ocr/batches/"+id)
+	writeJSON(w, http.StatusAccepted, out)
+}
+
+// retryable indica si el job falló, con alguno de codes si no está vacío.
+// Los cancelados no se reintentan: los canceló el cliente.
+func retryable(j Job, codes []string) bool {
+	if j.Status != jobFailed {
+		return false
+	}
+	return len(codes) == 0 || (j.Result != nil && slices.Contains(codes, string(j.Result.ErrorCode)))
+}
+
+// retryJob vuelve el job a queued y lo encola de nuevo, si sigue fallido al
+// momento de actualizarlo. El resultado anterior queda en el historial.
+func retryJob(ctx context.Context, id string, codes []string) (Job, bool, error) {
+	var msg QueueMessage
+	retried := false
+	job, err := jobStore.Update(ctx, id, func(j *Job) error {
+		if !retryable(*j, codes) {
+			return nil
+		}
+		reason := "reintento del batch tras " + string(j.Result.ErrorCode)
+		j.Result = nil
+		j.ScheduledFor, j.CompleteBy = nil, nil
+		msg = j.schedule(time.Now().UTC())
+		if deferred := j.deferredReason(); deferred != "" {
+			reason += "; " + deferred
+		}
+		j.setStatus(jobQueued, reason)
+		retried = true
+		return nil
+	})
+	if err != nil || !retried {
+		return job, false, err
+	}
+	if err := enqueueJob(ctx, job, msg); err != nil {
+		return job, false, err
+	}
+	return job, true, nil
+}
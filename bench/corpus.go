@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Corpus sintético: documentos doc-N.jpg (una página) y doc-N.pdf (varias)
+// generados a partir de N, siempre iguales. El motor mock arma el texto a
+// partir de la URL; el contenido se sirve igual para el archivado y para
+// los motores reales.
+
+// corpus genera las URLs de los documentos de base.
+type corpus struct {
+	base string
+	docs int
+}
+
+// url devuelve la URL de un documento al azar, .pdf o .jpg.
+func (c corpus) url(r *rand.Rand, pdf bool) string {
+	ext := "jpg"
+	if pdf {
+		ext = "pdf"
+	}
+	return fmt.Sprintf("%s/doc-%04d.%s", c.base, r.IntN(c.docs), ext)
+}
+
+// serveCorpus levanta el servidor del corpus en addr y devuelve su URL base.
+func serveCorpus(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go http.Serve(ln, http.HandlerFunc(handleCorpus))
+	return "http://" + ln.Addr().String(), nil
+}
+
+// GET /doc-N.jpg y /doc-N.pdf
+func handleCorpus(w http.ResponseWriter, r *http.Request) {
+	name, ext, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/doc-"), ".")
+	n, err := strconv.Atoi(name)
+	if !ok || err != nil || n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	switch ext {
+	case "jpg":
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(syntheticJPEG(n))
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(syntheticPDF(n))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// syntheticJPEG es una página A4 a 36 dpi con renglones grises, como un
+// escaneo de texto.
+func syntheticJPEG(n int) []byte {
+	r := rand.New(rand.NewPCG(uint64(n), 1))
+	img := image.NewGray(image.Rect(0, 0, 298, 421))
+	for y := range 421 {
+		for x := range 298 {
+			v := uint8(235 + r.IntN(20))
+			if y%14 < 4 && x > 24 && x < 24+r.IntN(250) {
+				v = uint8(40 + r.IntN(60))
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 70})
+	return buf.Bytes()
+}
+
+// syntheticPDF es un PDF válido de 1 a 4 páginas con una línea de texto
+// cada una.
+func syntheticPDF(n int) []byte {
+	pages := n%4 + 1
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, pages)
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pages))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+	for i := range pages {
+		text := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (Documento %d - Pagina %d de %d) Tj ET", n, i+1, pages)
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(text), text))
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,326 @@
+// bench es el generador de carga de la API: repite una mezcla de requests
+// /ocr de una página, /ocr con PDFs y /ocr/batch contra un servidor durante
+// un tiempo y reporta latencias (p50 a p99) y throughput por escenario, para
+// validar los cambios del pool y la cola antes de llevarlos a producción.
+//
+//	go run ./bench --target http://localhost:8080 --duration 1m --concurrency 32 --mix single=70,pdf=10,batch=20
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Escenarios de la mezcla.
+const (
+	scenarioSingle = "single" // /ocr con una imagen
+	scenarioPDF    = "pdf"    // /ocr con un PDF de varias páginas
+	scenarioBatch  = "batch"  // /ocr/batch con --batch-size imágenes
+)
+
+var scenarios = []string{scenarioSingle, scenarioPDF, scenarioBatch}
+
+type options struct {
+	target      string
+	apiKey      string
+	duration    time.Duration
+	concurrency int
+	rate        float64
+	mix         string
+	batchSize   int
+	docs        int
+	corpusURL   string
+	corpusAddr  string
+	timeout     time.Duration
+	retryAfter  bool
+	jsonOut     bool
+}
+
+func main() {
+	if err := command().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func command() *cobra.Command {
+	var opts options
+	cmd := &cobra.Command{
+		Use:          "bench",
+		Short:        "Generador de carga de la API de OCR",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), &opts)
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&opts.target, "target", "http://localhost:8080", "URL base del servidor")
+	f.StringVar(&opts.apiKey, "api-key", os.Getenv("OCR_API_KEY"), "X-API-Key de las requests (default: OCR_API_KEY)")
+	f.DurationVar(&opts.duration, "duration", 30*time.Second, "duración de la corrida")
+	f.IntVar(&opts.concurrency, "concurrency", 8, "requests en vuelo como máximo")
+	f.Float64Var(&opts.rate, "rate", 0, "requests por segundo a enviar (0 = cada worker envía apenas recibe la respuesta)")
+	f.StringVar(&opts.mix, "mix", "single=70,pdf=10,batch=20", "peso de cada escenario: single, pdf, batch")
+	f.IntVar(&opts.batchSize, "batch-size", 10, "ítems de cada /ocr/batch")
+	f.IntVar(&opts.docs, "docs", 500, "documentos distintos del corpus sintético")
+	f.StringVar(&opts.corpusURL, "corpus-url", "", "URL base de un corpus ya servido (default: lo sirve bench en --corpus-addr)")
+	f.StringVar(&opts.corpusAddr, "corpus-addr", "127.0.0.1:0", "dirección donde servir el corpus sintético; el servidor tiene que alcanzarla")
+	f.DurationVar(&opts.timeout, "timeout", 30*time.Second, "timeout de cada request")
+	f.BoolVar(&opts.retryAfter, "respect-retry-after", true, "tras un 429 el worker espera el Retry-After")
+	f.BoolVar(&opts.jsonOut, "json", false, "escribir el reporte en JSON")
+	return cmd
+}
+
+// parseMix interpreta --mix como pesos por escenario.
+func parseMix(raw string) (map[string]int, int, error) {
+	weights := map[string]int{}
+	total := 0
+	for part := range strings.SplitSeq(raw, ",") {
+		name, w, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(w)
+		if !ok || err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("--mix: %q debe ser escenario=peso", part)
+		}
+		if !slices.Contains(scenarios, name) {
+			return nil, 0, fmt.Errorf("--mix: escenario %q inexistente (%s)", name, strings.Join(scenarios, ", "))
+		}
+		weights[name] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, 0, errors.New("--mix: algún escenario tiene que tener peso")
+	}
+	return weights, total, nil
+}
+
+func run(ctx context.Context, opts *options) error {
+	weights, total, err := parseMix(opts.mix)
+	if err != nil {
+		return err
+	}
+	if opts.concurrency < 1 || opts.batchSize < 1 || opts.docs < 1 || opts.rate < 0 {
+		return errors.New("--concurrency, --batch-size y --docs deben ser mayores a 0, y --rate no negativo")
+	}
+	base := strings.TrimRight(opts.corpusURL, "/")
+	if base == "" {
+		if base, err = serveCorpus(opts.corpusAddr); err != nil {
+			return fmt.Errorf("sirviendo el corpus: %w", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	g := &generator{
+		opts:    opts,
+		target:  strings.TrimRight(opts.target, "/"),
+		corpus:  corpus{base: base, docs: opts.docs},
+		weights: weights,
+		total:   total,
+		run:     strconv.FormatInt(time.Now().Unix(), 36),
+		client:  &http.Client{Timeout: opts.timeout, Transport: &http.Transport{MaxIdleConnsPerHost: opts.concurrency}},
+		rec:     &recorder{},
+	}
+	fmt.Fprintf(os.Stderr, "bench: %s, corpus en %s, %s\n", g.target, base, opts.duration)
+	start := time.Now()
+	if opts.rate > 0 {
+		g.openLoop(ctx)
+	} else {
+		g.closedLoop(ctx)
+	}
+	rep := g.rec.report(opts, time.Since(start))
+	if opts.jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	rep.write(os.Stdout)
+	return nil
+}
+
+// generator envía las requests de la mezcla.
+type generator struct {
+	opts    *options
+	target  string
+	corpus  corpus
+	weights map[string]int
+	total   int
+	run     string // prefijo de las keys, distinto en cada corrida
+	seq     atomic.Int64
+	client  *http.Client
+	rec     *recorder
+}
+
+// closedLoop: cada worker envía la próxima request apenas recibe la
+// respuesta, así que la carga se adapta a la latencia del servidor.
+func (g *generator) closedLoop(ctx context.Context) {
+	var wg sync.WaitGroup
+	for w := range g.opts.concurrency {
+		wg.Go(func() {
+			r := rand.New(rand.NewPCG(uint64(w), uint64(time.Now().UnixNano())))
+			for ctx.Err() == nil {
+				g.wait(ctx, g.send(ctx, r))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// openLoop envía --rate requests por segundo sin importar la latencia, con
+// hasta --concurrency en vuelo; las que no tienen worker libre se cuentan
+// como skipped en lugar de atrasar a las siguientes.
+func (g *generator) openLoop(ctx context.Context) {
+	slots := make(chan struct{}, g.opts.concurrency)
+	tick := time.NewTicker(time.Duration(float64(time.Second) / g.opts.rate))
+	defer tick.Stop()
+	var wg sync.WaitGroup
+	r := rand.New(rand.NewPCG(0, uint64(time.Now().UnixNano())))
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-tick.C:
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			g.rec.skip()
+			continue
+		}
+		seed := r.Uint64()
+		wg.Go(func() {
+			defer func() { <-slots }()
+			g.send(ctx, rand.New(rand.NewPCG(seed, 0)))
+		})
+	}
+}
+
+// wait espera el Retry-After de un 429 antes de la próxima request.
+func (g *generator) wait(ctx context.Context, retry time.Duration) {
+	if retry <= 0 || !g.opts.retryAfter {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(retry):
+	}
+}
+
+// pick elige un escenario según los pesos.
+func (g *generator) pick(r *rand.Rand) string {
+	n := r.IntN(g.total)
+	for _, name := range scenarios {
+		if n < g.weights[name] {
+			return name
+		}
+		n -= g.weights[name]
+	}
+	return scenarioSingle
+}
+
+func (g *generator) key() string {
+	return fmt.Sprintf("bench-%s-%d", g.run, g.seq.Add(1))
+}
+
+// send envía una request del escenario elegido y la registra. Devuelve el
+// Retry-After si la respuesta fue 429.
+func (g *generator) send(ctx context.Context, r *rand.Rand) time.Duration {
+	scenario := g.pick(r)
+	path, docs := "/ocr", 1
+	var body any
+	switch scenario {
+	case scenarioSingle, scenarioPDF:
+		body = map[string]string{"key": g.key(), "url": g.corpus.url(r, scenario == scenarioPDF)}
+	case scenarioBatch:
+		items := make([]map[string]string, g.opts.batchSize)
+		for i := range items {
+			items[i] = map[string]string{"key": g.key(), "url": g.corpus.url(r, false)}
+		}
+		path, docs, body = "/ocr/batch", len(items), map[string]any{"items": items}
+	}
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.target+path, bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.opts.apiKey != "" {
+		req.Header.Set("X-API-Key", g.opts.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := g.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			// Las cortadas por el fin de la corrida no cuentan
+			g.rec.add(sample{scenario: scenario, latency: time.Since(start), outcome: "transport_error"})
+		}
+		return 0
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	latency := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		return 0
+	}
+	s := sample{scenario: scenario, latency: latency, docs: docs, outcome: "ok"}
+	if resp.StatusCode != http.StatusOK {
+		s.outcome = problemCode(resp.StatusCode, out)
+	} else if scenario == scenarioBatch {
+		s.failed = failedItems(out)
+	}
+	g.rec.add(s)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(max(secs, 1)) * time.Second
+	}
+	return 0
+}
+
+// problemCode es el code del problem de la respuesta o, si no lo tiene, el
+// status.
+func problemCode(status int, body []byte) string {
+	var p struct {
+		Code string `json:"code"`
+	}
+	if json.Unmarshal(body, &p) == nil && p.Code != "" {
+		return p.Code
+	}
+	return strconv.Itoa(status)
+}
+
+// failedItems cuenta los ítems con error de la respuesta de /ocr/batch.
+func failedItems(body []byte) int {
+	var out struct {
+		Results []struct {
+			ErrorCode string `json:"error_code"`
+		} `json:"results"`
+	}
+	json.Unmarshal(body, &out)
+	n := 0
+	for _, r := range out.Results {
+		if r.ErrorCode != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// sample es el resultado de una request: outcome es "ok", el código de
+// error de la API (QUEUE_FULL, ENGINE_TIMEOUT...) o el status HTTP.
+type sample struct {
+	scenario string
+	latency  time.Duration
+	docs     int
+	failed   int // ítems fallidos de un batch que respondió 200
+	outcome  string
+}
+
+// recorder junta las muestras de los workers.
+type recorder struct {
+	mu      sync.Mutex
+	samples []sample
+	skipped int // requests que no salieron por falta de workers (con --rate)
+}
+
+func (rec *recorder) add(s sample) {
+	rec.mu.Lock()
+	rec.samples = append(rec.samples, s)
+	rec.mu.Unlock()
+}
+
+func (rec *recorder) skip() {
+	rec.mu.Lock()
+	rec.skipped++
+	rec.mu.Unlock()
+}
+
+// Stats resume las requests de un escenario, o de todos.
+type Stats struct {
+	Scenario    string         `json:"scenario"`
+	Requests    int            `json:"requests"`
+	OK          int            `json:"ok"`
+	Outcomes    map[string]int `json:"outcomes,omitempty"` // las que no son ok
+	FailedItems int            `json:"failed_items,omitempty"`
+	Docs        int            `json:"docs"`
+	RPS         float64        `json:"requests_per_second"`
+	DocsPerSec  float64        `json:"docs_per_second"`
+	P50         Millis         `json:"p50_ms"`
+	P90         Millis         `json:"p90_ms"`
+	P95         Millis         `json:"p95_ms"`
+	P99         Millis         `json:"p99_ms"`
+	Max         Millis         `json:"max_ms"`
+}
+
+// Millis es una duración que se muestra en milisegundos.
+type Millis time.Duration
+
+func (m Millis) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.ms())
+}
+
+func (m Millis) ms() float64 {
+	return float64(time.Duration(m).Microseconds()) / 1000
+}
+
+// Report es la salida de una corrida.
+type Report struct {
+	Target    string  `json:"target"`
+	Duration  string  `json:"duration"`
+	Workers   int     `json:"concurrency"`
+	Rate      float64 `json:"rate,omitempty"`
+	Skipped   int     `json:"skipped,omitempty"`
+	Scenarios []Stats `json:"scenarios"`
+	Total     Stats   `json:"total"`
+}
+
+// summarize arma las estadísticas de las muestras; las latencias son de
+// las requests que respondieron, ok o no.
+func summarize(name string, samples []sample, elapsed time.Duration) Stats {
+	st := Stats{Scenario: name, Requests: len(samples), Outcomes: map[string]int{}}
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		if s.outcome == "ok" {
+			st.OK++
+			st.Docs += s.docs
+		} else {
+			st.Outcomes[s.outcome]++
+		}
+		st.FailedItems += s.failed
+		latencies = append(latencies, s.latency)
+	}
+	slices.Sort(latencies)
+	pct := func(p float64) Millis {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return Millis(latencies[min(len(latencies)-1, int(p*float64(len(latencies))))])
+	}
+	st.P50, st.P90, st.P95, st.P99 = pct(0.50), pct(0.90), pct(0.95), pct(0.99)
+	if len(latencies) > 0 {
+		st.Max = Millis(latencies[len(latencies)-1])
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		st.RPS = float64(st.Requests) / secs
+		st.DocsPerSec = float64(st.Docs) / secs
+	}
+	return st
+}
+
+func (rec *recorder) report(opts *options, elapsed time.Duration) Report {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	out := Report{Target: opts.target, Duration: elapsed.Round(time.Millisecond).String(), Workers: opts.concurrency, Rate: opts.rate, Skipped: rec.skipped}
+	byScenario := map[string][]sample{}
+	for _, s := range rec.samples {
+		byScenario[s.scenario] = append(byScenario[s.scenario], s)
+	}
+	for _, name := range slices.Sorted(maps.Keys(byScenario)) {
+		out.Scenarios = append(out.Scenarios, summarize(name, byScenario[name], elapsed))
+	}
+	out.Total = summarize("total", rec.samples, elapsed)
+	return out
+}
+
+// write escribe el reporte como tabla.
+func (rep Report) write(w io.Writer) {
+	fmt.Fprintf(w, "%s durante %s, %d workers", rep.Target, rep.Duration, rep.Workers)
+	if rep.Rate > 0 {
+		fmt.Fprintf(w, ", %.1f req/s (%d sin enviar por falta de workers)", rep.Rate, rep.Skipped)
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "escenario\trequests\tok\treq/s\tdocs/s\tp50 ms\tp90 ms\tp95 ms\tp99 ms\tmax ms\t")
+	for _, st := range append(rep.Scenarios, rep.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			st.Scenario, st.Requests, st.OK, st.RPS, st.DocsPerSec, st.P50.ms(), st.P90.ms(), st.P95.ms(), st.P99.ms(), st.Max.ms())
+	}
+	tw.Flush()
+	for _, st := range append(rep.Scenarios, rep.Total) {
+		if len(st.Outcomes) == 0 && st.FailedItems == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:", st.Scenario)
+		for _, o := range slices.Sorted(maps.Keys(st.Outcomes)) {
+			fmt.Fprintf(w, " %s=%d", o, st.Outcomes[o])
+		}
+		if st.FailedItems > 0 {
+			fmt.Fprintf(w, " ítems fallidos=%d", st.FailedItems)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// languageCodes traduce los códigos ISO 639-2 (los de Tesseract, que son
+// los que se suelen tener a mano) a los ISO 639-1 de la API.
+var languageCodes = map[string]string{
+	"spa": "es", "eng": "en", "por": "pt", "fra": "fr", "fre": "fr",
+	"deu": "de", "ger": "de", "ita": "it", "cat": "ca", "glg": "gl",
+}
+
+// rootCommand arma la CLI. Sin subcomando levanta el servidor, como antes
+// de que existiera la CLI.
+func rootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:          "api-ocr",
+		Short:        "API de OCR y herramientas para procesar archivos locales",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		Run:          func(*cobra.Command, []string) { serve(setup()) },
+	}
+	root.AddCommand(
+		&cobra.Command{
+			Use:   "serve",
+			Short: "Levanta la API HTTP",
+			Args:  cobra.NoArgs,
+			Run:   func(*cobra.Command, []string) { serve(setup()) },
+		},
+		runCommand(),
+		batchCommand(),
+		decryptCommand(),
+	)
+	return root
+}
+
+// cliOptions son las opciones de OCR comunes a run y batch.
+type cliOptions struct {
+	lang     string
+	engine   string
+	preset   string
+	template string
+}
+
+func (o *cliOptions) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.lang, "lang", "", "idioma del documento, ISO 639-1 (es) o 639-2 (spa)")
+	cmd.Flags().StringVar(&o.engine, "engine", "", "motor de OCR (por defecto el de OCR_ENGINE)")
+	cmd.Flags().StringVar(&o.preset, "preset", "", "preset de OCR_PRESETS_FILE")
+	cmd.Flags().StringVar(&o.template, "template", "", "plantilla de OCR_TEMPLATES_FILE")
+}
+
+// apply completa el ítem con las opciones que no trae.
+func (o *cliOptions) apply(item map[string]any) {
+	for field, v := range map[string]string{"language": o.language(), "engine": o.engine, "preset": o.preset, "template": o.template} {
+		if _, ok := item[field]; !ok && v != "" {
+			item[field] = v
+		}
+	}
+}
+
+func (o *cliOptions) language() string {
+	if code, ok := languageCodes[strings.ToLower(o.lang)]; ok {
+		return code
+	}
+	return strings.ToLower(o.lang)
+}
+
+// setupCLI arma el pipeline como setup, con los logs en stderr (stdout
+// queda para los resultados) y con acceso a archivos locales por file://.
+func setupCLI() (*Config, context.Context, context.CancelFunc) {
+	logOutput = os.Stderr
+	cfg := setup()
+	cfg.Limits.AllowedSchemes = append(cfg.Limits.AllowedSchemes, "file")
+	http.DefaultTransport.(*http.Transport).RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	// Los resultados no se archivan ni comparten la cola del servidor
+	if err := setupQueue(context.Background(), QueueConfig{}); err != nil {
+		fatal("queue setup failed", err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	return cfg, ctx, cancel
+}
+
+// localURL convierte una ruta local en una URL file:// absoluta; las
+// relativas se toman desde dir. Las URLs http(s) quedan como están. Si el
+// archivo no se puede leer devuelve igual la URL, junto con el error.
+func localURL(raw, dir string) (string, error) {
+	if u, err := url.Parse(raw); err == nil && len(u.Scheme) > 1 {
+		return raw, nil
+	}
+	p := raw
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return raw, err
+	}
+	u := (&url.URL{Scheme: "file", Path: filepath.ToSlash(p)}).String()
+	info, err := os.Stat(p)
+	if err != nil {
+		return u, fmt.Errorf("no se puede leer %s: %w", raw, err)
+	}
+	if info.IsDir() {
+		return u, fmt.Errorf("%s es un directorio", raw)
+	}
+	return u, nil
+}
+
+// runItem procesa un ítem con el pool, con el mismo límite de tiempo que
+// una request a la API.
+func runItem(ctx context.Context, cfg *Config, req OCRRequest) *APIResponse {
+	ctx, cancel := withTimeoutLimit(withStageTracker(ctx), limitRoute, cfg.RouteTimeout)
+	defer cancel()
+	if req.Priority == "" {
+		req.Priority = priorityNormal
+	}
+	resp, _ := pool.run(ctx, req)
+	return resp
+}
+
+func runCommand() *cobra.Command {
+	var opts cliOptions
+	var file, key, format string
+	cmd := &cobra.Command{
+		Use:   "run --file doc.pdf",
+		Short: "Procesa un archivo local y escribe el resultado en stdout",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !slices.Contains(outputFormats, format) {
+				return fmt.Errorf("--format debe ser uno de: %s", strings.Join(outputFormats, ", "))
+			}
+			cfg, ctx, cancel := setupCLI()
+			defer cancel()
+
+			u, err := localURL(file, ".")
+			if err != nil {
+				return err
+			}
+			if key == "" {
+				key = filepath.Base(file)
+			}
+			item := map[string]any{"key": key, "url": u}
+			opts.apply(item)
+			if needsPages(format) {
+				item["include_pages"] = true
+			}
+			body, _ := json.Marshal(item)
+			body, invalid, err := presets.load().resolvePresets(body)
+			if err != nil {
+				return err
+			}
+			var req OCRRequest
+			if err := json.Unmarshal(body, &req); err != nil {
+				return err
+			}
+			if invalid = append(invalid, req.validate("", cfg.Limits)...); len(invalid) > 0 {
+				return invalidParamsError(invalid)
+			}
+
+			resp := runItem(ctx, cfg, req)
+			if resp.ErrorCode != "" && format != formatJSON {
+				return fmt.Errorf("%s: %s", resp.ErrorCode, resp.Err)
+			}
+			os.Stdout.Write(renderResult(format, resp))
+			if resp.ErrorCode != "" {
+				return fmt.Errorf("%s: %s", resp.ErrorCode, resp.Err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "archivo a procesar (o una URL http/https)")
+	cmd.Flags().StringVar(&key, "key", "", "key del resultado (por defecto el nombre del archivo)")
+	cmd.Flags().StringVar(&format, "format", formatJSON, "formato de salida: "+strings.Join(outputFormats, ", "))
+	opts.register(cmd)
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+func batchCommand() *cobra.Command {
+	var opts cliOptions
+	var manifest, format string
+	cmd := &cobra.Command{
+		Use:   "batch --manifest list.csv",
+		Short: "Procesa los archivos de un manifiesto y escribe un resultado JSON por línea",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if format != "" && !slices.Contains([]string{"csv", "jsonl", "json"}, format) {
+				return errors.New("--format debe ser csv, jsonl o json")
+			}
+			cfg, ctx, cancel := setupCLI()
+			defer cancel()
+
+			data, err := os.ReadFile(manifest)
+			if err != nil {
+				return err
+			}
+			in, invalid, err := parseManifest(Schedule{ManifestURL: manifest, Format: format, Preset: opts.preset}, data, "")
+			if err != nil {
+				return err
+			}
+			dir := filepath.Dir(manifest)
+			for i := range in.Items {
+				item := &in.Items[i]
+				if item.URL != "" {
+					if item.URL, err = localURL(item.URL, dir); err != nil {
+						invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("items[%d].url", i), Reason: err.Error()})
+					}
+				}
+				if item.Language == "" {
+					item.Language = opts.language()
+				}
+				if item.Engine == "" {
+					item.Engine = opts.engine
+				}
+				if item.Template == "" {
+					item.Template = opts.template
+				}
+			}
+			if err := checkManifest(in, invalid, cfg.Limits); err != nil {
+				return err
+			}
+
+			// Un resultado por línea a medida que terminan, como /ocr/batch
+			// con Accept: application/x-ndjson
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			failed := 0
+			enc := json.NewEncoder(os.Stdout)
+			for _, item := range in.Items {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp := runItem(ctx, cfg, item)
+					mu.Lock()
+					defer mu.Unlock()
+					if resp.ErrorCode != "" {
+						failed++
+					}
+					enc.Encode(resp)
+				}()
+			}
+			wg.Wait()
+			if failed > 0 {
+				return fmt.Errorf("%d de %d ítems con error", failed, len(in.Items))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&manifest, "manifest", "", "manifiesto CSV (key,url), JSONL o JSON; las rutas relativas se toman desde su directorio")
+	cmd.Flags().StringVar(&format, "format", "", "formato del manifiesto: csv, jsonl o json (por defecto según la extensión)")
+	opts.register(cmd)
+	cmd.MarkFlagRequired("manifest")
+	return cmd
+}
+
+// invalidParamsError resume los parámetros inválidos en un error.
+func invalidParamsError(invalid []InvalidParam) error {
+	reasons := make([]string, 0, len(invalid))
+	for _, p := range invalid {
+		reasons = append(reasons, p.Name+": "+p.Reason)
+	}
+	return errors.New("request inválida: " + strings.Join(reasons, "; "))
+}
+
+// decryptCommand descifra un objeto archivado con el cifrado en reposo
+// habilitado (los .enc), con las claves de OCR_ENCRYPTION_*.
+func decryptCommand() *cobra.Command {
+	var file, output string
+	cmd := &cobra.Command{
+		Use:   "decrypt --file result.json.enc",
+		Short: "Descifra un objeto archivado cifrado y lo escribe en stdout",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := setupEncryption(cfg.Encryption); err != nil {
+				return err
+			}
+			if encryption == nil {
+				return errors.New("el cifrado en reposo no está configurado (OCR_ENCRYPTION_KEYS u OCR_ENCRYPTION_KMS_KEY_ID)")
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if !isSealed(data) {
+				return fmt.Errorf("%s no está cifrado", file)
+			}
+			plain, err := encryption.open(cmd.Context(), "", data)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = os.Stdout.Write(plain)
+				return err
+			}
+			return os.WriteFile(output, plain, 0o600)
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "objeto cifrado descargado del archivado")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "archivo donde escribir el contenido (por defecto stdout)")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
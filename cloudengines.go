@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Motores OCR de nube: Google Cloud Vision, AWS Textract y Azure Document
+// Intelligence detrás de OCREngine. Se registran cuando tienen credenciales
+// y normalizan la respuesta al formato común: texto por líneas y confianza
+// de 0 a 1. Reconocen el original del documento (la página pedida en los
+// PDF/TIFF), no el texto simulado.
+
+const (
+	engineGoogleVision = "google-vision"
+	engineAWSTextract  = "aws-textract"
+	engineAzureDI      = "azure-document-intelligence"
+)
+
+// cloudHTTPTimeout acota cada llamada HTTP a un proveedor; OCR_ENGINE_TIMEOUT
+// acota además la llamada completa.
+const cloudHTTPTimeout = 60 * time.Second
+
+// registerCloudEngines agrega a engines los proveedores configurados.
+func registerCloudEngines(cfg CloudEnginesConfig) {
+	client := &http.Client{Timeout: cloudHTTPTimeout}
+	if cfg.VisionAPIKey != "" {
+		engines[engineGoogleVision] = &visionEngine{
+			endpoint: strings.TrimRight(cfg.VisionEndpoint, "/"),
+			apiKey:   cfg.VisionAPIKey,
+			client:   client,
+		}
+	}
+	if cfg.TextractAccessKey != "" && cfg.TextractSecretKey != "" {
+		endpoint := cfg.TextractEndpoint
+		if endpoint == "" {
+			endpoint = "https://textract." + cfg.TextractRegion + ".amazonaws.com"
+		}
+		engines[engineAWSTextract] = &textractEngine{
+			endpoint: strings.TrimRight(endpoint, "/"),
+			signer: awsSigner{region: cfg.TextractRegion, service: "textract",
+				accessKey: cfg.TextractAccessKey, secretKey: cfg.TextractSecretKey},
+			client: client,
+		}
+	}
+	if cfg.AzureEndpoint != "" && cfg.AzureKey != "" {
+		engines[engineAzureDI] = &azureEngine{
+			endpoint: strings.TrimRight(cfg.AzureEndpoint, "/"),
+			key:      cfg.AzureKey,
+			model:    cfg.AzureModel,
+			client:   client,
+		}
+	}
+}
+
+// providerError es un error devuelto por un proveedor de nube. Los 429 y
+// 5xx son caídas transitorias que resilientEngine reintenta.
+type providerError struct {
+	engine string
+	status int
+	msg    string
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("%s: %d: %s", e.engine, e.status, e.msg)
+}
+
+func (e *providerError) Is(target error) bool {
+	return target == errEngineUnavailable && (e.status == http.StatusTooManyRequests || e.status >= 500)
+}
+
+// cloudDo envía el request y decodifica la respuesta JSON en out. errMsg
+// extrae el mensaje del cuerpo de error de cada proveedor.
+func cloudDo(engine string, client *http.Client, req *http.Request, out any, errMsg func([]byte) string) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		// Sin respuesta del proveedor: se trata como caída transitoria
+		return nil, fmt.Errorf("%s: %w: %v", engine, errEngineUnavailable, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", engine, errEngineUnavailable, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg := errMsg(body)
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, &providerError{engine: engine, status: resp.StatusCode, msg: msg}
+	}
+	if out != nil && len(body) > 0 {
+		if err := json.Unmarshal(body, out); err != nil {
+			return nil, fmt.Errorf("%s: respuesta inválida: %w", engine, err)
+		}
+	}
+	return resp, nil
+}
+
+// roundConfidence deja la confianza con tres decimales, como los mocks.
+func roundConfidence(c float64) float64 {
+	return math.Round(c*1000) / 1000
+}
+
+// visionEngine usa DOCUMENT_TEXT_DETECTION de Google Cloud Vision:
+// images:annotate para imágenes y files:annotate para PDF/TIFF.
+type visionEngine struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (e *visionEngine) Name() string { return engineGoogleVision }
+
+func (e *visionEngine) Version() string { return "v1" }
+
+type visionAnnotation struct {
+	FullTextAnnotation *struct {
+		Text  string `json:"text"`
+		Pages []struct {
+			Confidence float64 `json:"confidence"`
+		} `json:"pages"`
+	} `json:"fullTextAnnotation"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *visionEngine) Recognize(ctx context.Context, p Page) (Recognition, error) {
+	data, contentType, err := p.source.original(ctx)
+	if err != nil {
+		return Recognition{}, err
+	}
+	feature := []map[string]string{{"type": "DOCUMENT_TEXT_DETECTION"}}
+	content := base64.StdEncoding.EncodeToString(data)
+
+	method := "images:annotate"
+	request := map[string]any{"image": map[string]string{"content": content}, "features": feature}
+	if p.source.multiPage {
+		method = "files:annotate"
+		request = map[string]any{
+			"inputConfig": map[string]string{"content": content, "mimeType": contentType},
+			"features":    feature,
+			"pages":       []int{p.Number},
+		}
+	}
+	body, _ := json.Marshal(map[string]any{"requests": []any{request}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		e.endpoint+"/v1/"+method+"?key="+url.QueryEscape(e.apiKey), bytes.NewReader(body))
+	if err != nil {
+		return Recognition{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// files:annotate anida una respuesta por página dentro de cada archivo
+	var out struct {
+		Responses []struct {
+			visionAnnotation
+			Responses []visionAnnotation `json:"responses"`
+		} `json:"responses"`
+	}
+	if _, err := cloudDo(e.Name(), e.client, req, &out, googleErrorMessage); err != nil {
+		return Recognition{}, err
+	}
+	if len(out.Responses) == 0 {
+		return Recognition{}, fmt.Errorf("%s: respuesta sin resultados", e.Name())
+	}
+	ann := out.Responses[0].visionAnnotation
+	if p.source.multiPage {
+		if len(out.Responses[0].Responses) == 0 {
+			return Recognition{}, fmt.Errorf("%s: el documento no tiene la página %d", e.Name(), p.Number)
+		}
+		ann = out.Responses[0].Responses[0]
+	}
+	if ann.Error != nil {
+		return Recognition{}, &providerError{engine: e.Name(), status: googleRPCStatus(ann.Error.Code), msg: ann.Error.Message}
+	}
+	if ann.FullTextAnnotation == nil {
+		// Página sin texto
+		return Recognition{}, nil
+	}
+	rec := Recognition{Text: strings.TrimRight(ann.FullTextAnnotation.Text, "\n")}
+	if pages := ann.FullTextAnnotation.Pages; len(pages) > 0 {
+		rec.Confidence = roundConfidence(pages[0].Confidence)
+	}
+	return rec, nil
+}
+
+func googleErrorMessage(body []byte) string {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &e)
+	return e.Error.Message
+}
+
+// googleRPCStatus traduce el código gRPC de un error por imagen a su status
+// HTTP, para distinguir los transitorios.
+func googleRPCStatus(code int) int {
+	switch code {
+	case 4: // DEADLINE_EXCEEDED
+		return http.StatusGatewayTimeout
+	case 8: // RESOURCE_EXHAUSTED
+		return http.StatusTooManyRequests
+	case 13, 14: // INTERNAL, UNAVAILABLE
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// textractEngine usa DetectDocumentText de AWS Textract, que en modo
+// síncrono procesa imágenes y PDF/TIFF de una página.
+type textractEngine struct {
+	endpoint string
+	signer   awsSigner
+	client   *http.Client
+}
+
+func (e *textractEngine) Name() string { return engineAWSTextract }
+
+func (e *textractEngine) Version() string { return "2018-06-27" }
+
+// Languages son los idiomas que DetectDocumentText reconoce.
+func (e *textractEngine) Languages() []string { return []string{"de", "en", "es", "fr", "it", "pt"} }
+
+func (e *textractEngine) Recognize(ctx context.Context, p Page) (Recognition, error) {
+	if p.source.multiPage && p.Number > 1 {
+		return Recognition{}, fmt.Errorf("%s: DetectDocumentText solo procesa documentos de una página", e.Name())
+	}
+	data, _, err := p.source.original(ctx)
+	if err != nil {
+		return Recognition{}, err
+	}
+	body, _ := json.Marshal(map[string]any{"Document": map[string][]byte{"Bytes": data}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return Recognition{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Textract.DetectDocumentText")
+	e.signer.sign(req, sha256Hex(body), time.Now().UTC())
+
+	var out struct {
+		Blocks []struct {
+			BlockType  string  `json:"BlockType"`
+			Text       string  `json:"Text"`
+			Confidence float64 `json:"Confidence"`
+		} `json:"Blocks"`
+	}
+	if _, err := cloudDo(e.Name(), e.client, req, &out, textractErrorMessage); err != nil {
+		return Recognition{}, textractThrottled(err)
+	}
+	var lines []string
+	var sum float64
+	for _, b := range out.Blocks {
+		if b.BlockType == "LINE" {
+			lines = append(lines, b.Text)
+			sum += b.Confidence
+		}
+	}
+	rec := Recognition{Text: strings.Join(lines, "\n")}
+	if len(lines) > 0 {
+		rec.Confidence = roundConfidence(sum / float64(len(lines)) / 100)
+	}
+	return rec, nil
+}
+
+func textractErrorMessage(body []byte) string {
+	var e struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &e)
+	if e.Type == "" {
+		return e.Message
+	}
+	return e.Type + ": " + e.Message
+}
+
+// textractThrottled marca como transitorios los rechazos por capacidad, que
+// Textract devuelve con status 400.
+func textractThrottled(err error) error {
+	var pe *providerError
+	if errors.As(err, &pe) && pe.status == http.StatusBadRequest &&
+		(strings.Contains(pe.msg, "ThrottlingException") || strings.Contains(pe.msg, "ProvisionedThroughputExceededException")) {
+		pe.status = http.StatusTooManyRequests
+	}
+	return err
+}
+
+// azureEngine usa Azure AI Document Intelligence (por defecto el modelo
+// prebuilt-read): inicia el análisis y consulta el resultado hasta que
+// termina.
+type azureEngine struct {
+	endpoint string
+	key      string
+	model    string
+	client   *http.Client
+}
+
+const azureAPIVersion = "2024-11-30"
+
+func (e *azureEngine) Name() string { return engineAzureDI }
+
+func (e *azureEngine) Version() string { return azureAPIVersion }
+
+func (e *azureEngine) Recognize(ctx context.Context, p Page) (Recognition, error) {
+	data, _, err := p.source.original(ctx)
+	if err != nil {
+		return Recognition{}, err
+	}
+	query := url.Values{"api-version": {azureAPIVersion}}
+	if p.source.multiPage {
+		query.Set("pages", strconv.Itoa(p.Number))
+	}
+	body, _ := json.Marshal(map[string][]byte{"base64Source": data})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		e.endpoint+"/documentintelligence/documentModels/"+url.PathEscape(e.model)+":analyze?"+query.Encode(),
+		bytes.NewReader(body))
+	if err != nil {
+		return Recognition{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ocp-Apim-Subscription-Key", e.key)
+	resp, err := cloudDo(e.Name(), e.client, req, nil, azureErrorMessage)
+	if err != nil {
+		return Recognition{}, err
+	}
+	operation := resp.Header.Get("Operation-Location")
+	if operation == "" {
+		return Recognition{}, fmt.Errorf("%s: respuesta sin Operation-Location", e.Name())
+	}
+
+	for {
+		wait := time.Second
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			wait = time.Duration(s) * time.Second
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return Recognition{}, ctx.Err()
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, operation, nil)
+		if err != nil {
+			return Recognition{}, err
+		}
+		req.Header.Set("Ocp-Apim-Subscription-Key", e.key)
+		var out struct {
+			Status string `json:"status"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+			AnalyzeResult struct {
+				Content string `json:"content"`
+				Pages   []struct {
+					Words []struct {
+						Confidence float64 `json:"confidence"`
+					} `json:"words"`
+				} `json:"pages"`
+			} `json:"analyzeResult"`
+		}
+		if resp, err = cloudDo(e.Name(), e.client, req, &out, azureErrorMessage); err != nil {
+			return Recognition{}, err
+		}
+		switch out.Status {
+		case "succeeded":
+			rec := Recognition{Text: out.AnalyzeResult.Content}
+			var sum float64
+			var n int
+			for _, page := range out.AnalyzeResult.Pages {
+				for _, w := range page.Words {
+					sum += w.Confidence
+					n++
+				}
+			}
+			if n > 0 {
+				rec.Confidence = roundConfidence(sum / float64(n))
+			}
+			return rec, nil
+		case "failed", "canceled":
+			msg := out.Status
+			if out.Error != nil {
+				msg = out.Error.Message
+			}
+			return Recognition{}, fmt.Errorf("%s: análisis %s", e.Name(), msg)
+		}
+	}
+}
+
+func azureErrorMessage(body []byte) string {
+	var e struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &e)
+	if e.Error.Code == "" {
+		return e.Error.Message
+	}
+	return e.Error.Code + ": " + e.Error.Message
+}
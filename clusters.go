@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Agrupamiento de resultados por similitud de texto, para detectar campañas
+// de documentos falsos fabricados en serie: la misma plantilla con otros
+// datos. Cada texto se reduce a sus shingles de tres palabras, con los
+// números normalizados, y se resume en una firma MinHash; LSH propone los
+// pares candidatos y la firma estima su similitud de Jaccard. Los pares que
+// superan el umbral se unen en clusters.
+
+const (
+	minhashSize  = 128
+	lshBands     = 32 // de minhashSize/lshBands filas cada uno
+	shingleWords = 3
+
+	defaultClusterThreshold = 0.8
+	// minClusterThreshold es el menor umbral que LSH detecta con buena
+	// probabilidad con 32 bandas de 4 filas.
+	minClusterThreshold = 0.5
+	maxClusterKeys      = 100
+	clusterExcerptRunes = 200
+)
+
+// minhashSeeds son las semillas de cada función de la firma; fijas, para
+// que la misma consulta dé siempre los mismos clusters.
+var minhashSeeds = func() [minhashSize]uint64 {
+	var seeds [minhashSize]uint64
+	x := uint64(0x5eed)
+	for i := range seeds {
+		x = splitmix64(x)
+		seeds[i] = x
+	}
+	return seeds
+}()
+
+// ResultCluster es un grupo de documentos parecidos. Representative es la key
+// del primero procesado y Similarity el promedio de la similitud estimada de
+// los pares que unieron el cluster.
+type ResultCluster struct {
+	Size           int            `json:"size"`
+	Similarity     float64        `json:"similarity"`
+	Representative string         `json:"representative"`
+	Excerpt        string         `json:"excerpt"`
+	DocumentTypes  map[string]int `json:"document_types,omitempty"`
+	FirstSeen      time.Time      `json:"first_seen"`
+	LastSeen       time.Time      `json:"last_seen"`
+	// Keys son las primeras 100 keys del cluster, en orden de proceso.
+	Keys          []string `json:"keys"`
+	KeysTruncated bool     `json:"keys_truncated,omitempty"`
+}
+
+// ClusterReport es la respuesta de /ocr/clusters.
+type ClusterReport struct {
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Threshold float64         `json:"threshold"`
+	MinSize   int             `json:"min_size"`
+	Documents int             `json:"documents"`
+	Clusters  []ResultCluster `json:"clusters"`
+}
+
+// shingles devuelve los hashes de las secuencias de tres palabras del texto.
+// Las palabras con dígitos se reemplazan por "#", así dos documentos que
+// solo cambian números, fechas o ids comparten todos sus shingles.
+func shingles(text string) map[uint64]bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	for i, w := range words {
+		if strings.ContainsFunc(w, unicode.IsDigit) {
+			words[i] = "#"
+		}
+	}
+	n := max(len(words)-shingleWords+1, min(len(words), 1))
+	out := make(map[uint64]bool, n)
+	for i := 0; i < n; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:min(i+shingleWords, len(words))], " ")))
+		out[h.Sum64()] = true
+	}
+	return out
+}
+
+// minhash es la firma del conjunto de shingles: el mínimo de cada función.
+func minhash(set map[uint64]bool) [minhashSize]uint64 {
+	var sig [minhashSize]uint64
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for x := range set {
+		for i, seed := range minhashSeeds {
+			sig[i] = min(sig[i], splitmix64(x^seed))
+		}
+	}
+	return sig
+}
+
+// similarity estima la similitud de Jaccard como la fracción de funciones en
+// que coinciden las firmas.
+func similarity(a, b *[minhashSize]uint64) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / minhashSize
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// clusterResults agrupa los resultados con texto. Dentro de cada bucket de
+// LSH cada documento se compara con el primero del bucket, así un lote de
+// miles de copias no cuesta comparaciones cuadráticas.
+func clusterResults(res []StoredResult, threshold float64, minSize int) []ResultCluster {
+	var docs []int
+	var sigs [][minhashSize]uint64
+	for i, r := range res {
+		if r.Result.ErrorCode != "" {
+			continue
+		}
+		set := shingles(r.Result.Body)
+		if len(set) == 0 {
+			continue
+		}
+		docs = append(docs, i)
+		sigs = append(sigs, minhash(set))
+	}
+
+	parent := make([]int, len(docs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	type edge struct {
+		a, b int
+		sim  float64
+	}
+	seen := map[[2]int]bool{}
+	var edges []edge
+	rows := minhashSize / lshBands
+	for band := 0; band < lshBands; band++ {
+		buckets := map[uint64][]int{}
+		for d := range sigs {
+			h := fnv.New64a()
+			for _, v := range sigs[d][band*rows : (band+1)*rows] {
+				h.Write(binary.LittleEndian.AppendUint64(nil, v))
+			}
+			k := h.Sum64()
+			buckets[k] = append(buckets[k], d)
+		}
+		for _, bucket := range buckets {
+			for _, d := range bucket[1:] {
+				pair := [2]int{bucket[0], d}
+				if seen[pair] {
+					continue
+				}
+				seen[pair] = true
+				if sim := similarity(&sigs[pair[0]], &sigs[d]); sim >= threshold {
+					edges = append(edges, edge{pair[0], d, sim})
+					parent[find(pair[0])] = find(d)
+				}
+			}
+		}
+	}
+	edgeSum := map[int]float64{}
+	edgeCount := map[int]int{}
+	for _, e := range edges {
+		root := find(e.a)
+		edgeSum[root] += e.sim
+		edgeCount[root]++
+	}
+
+	members := map[int][]int{}
+	for d := range docs {
+		root := find(d)
+		members[root] = append(members[root], d)
+	}
+	var out []ResultCluster
+	for root, ms := range members {
+		if len(ms) < minSize {
+			continue
+		}
+		first := res[docs[ms[0]]]
+		c := ResultCluster{
+			Size:           len(ms),
+			Similarity:     math.Round(edgeSum[root]/float64(edgeCount[root])*1000) / 1000,
+			Representative: first.Key,
+			Excerpt:        excerpt(first.Result.Body),
+			FirstSeen:      first.CreatedAt,
+			LastSeen:       res[docs[ms[len(ms)-1]]].CreatedAt,
+			Keys:           []string{},
+		}
+		for _, d := range ms {
+			r := res[docs[d]]
+			if len(c.Keys) < maxClusterKeys {
+				c.Keys = append(c.Keys, r.Key)
+			} else {
+				c.KeysTruncated = true
+			}
+			for _, doc := range r.Result.Documents {
+				if c.DocumentTypes == nil {
+					c.DocumentTypes = map[string]int{}
+				}
+				c.DocumentTypes[doc.DocumentType]++
+			}
+		}
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b ResultCluster) int {
+		if a.Size != b.Size {
+			return b.Size - a.Size
+		}
+		return a.FirstSeen.Compare(b.FirstSeen)
+	})
+	return out
+}
+
+// excerpt es el comienzo del texto, con los espacios colapsados.
+func excerpt(text string) string {
+	s := []rune(strings.Join(strings.Fields(text), " "))
+	if len(s) > clusterExcerptRunes {
+		return string(s[:clusterExcerptRunes]) + "…"
+	}
+	return string(s)
+}
+
+// GET /ocr/clusters?from=&to=&threshold=0.8&min_size=2 -> clusters de
+// documentos parecidos del tenant entre esas fechas (por defecto los
+// últimos 30 días), del más grande al más chico
+func handleResultClusters(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	threshold, minSize := defaultClusterThreshold, 2
+	var invalid []InvalidParam
+	if v := q.Get("threshold"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < minClusterThreshold || f > 1 {
+			invalid = append(invalid, InvalidParam{Name: "threshold", Reason: "debe ser un número entre 0.5 y 1"})
+		}
+		threshold = f
+	}
+	if v := q.Get("min_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			invalid = append(invalid, InvalidParam{Name: "min_size", Reason: "debe ser un entero mayor o igual a 2"})
+		}
+		minSize = n
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "Parámetros de agrupamiento inválidos")
+		p.InvalidParams = invalid
+		writeProblem(w, r, p)
+		return
+	}
+	days, problem := usageDays(r)
+	if problem != nil {
+		writeProblem(w, r, *problem)
+		return
+	}
+	from, _ := time.Parse(time.DateOnly, days[0])
+	to, _ := time.Parse(time.DateOnly, days[len(days)-1])
+	res, err := results.List(tenantFrom(r.Context()), from, to.AddDate(0, 0, 1))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+		return
+	}
+	out := ClusterReport{
+		From:      days[0],
+		To:        days[len(days)-1],
+		Threshold: threshold,
+		MinSize:   minSize,
+		Documents: len(res),
+		Clusters:  clusterResults(res, threshold, minSize),
+	}
+	if out.Clusters == nil {
+		out.Clusters = []ResultCluster{}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Targets de un alias: el endpoint propio al que se traduce.
+const (
+	compatTargetOCR   = "ocr"
+	compatTargetBatch = "batch"
+)
+
+// FieldMapping transforma un objeto JSON. Los nombres son paths con puntos,
+// así que un rename también sirve para anidar o desanidar campos
+// ("document.image_url" -> "url", "full_text" -> "result.text").
+type FieldMapping struct {
+	Rename map[string]string `json:"rename,omitempty"` // origen -> destino
+	Omit   []string          `json:"omit,omitempty"`   // se quitan antes del rename
+}
+
+// CompatAlias es una ruta con el formato de payload de un consumidor que
+// migra desde la API anterior. Request mapea del formato legado al propio y
+// Response del propio al legado, para el objeto o para cada ítem del batch.
+type CompatAlias struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Target   string       `json:"target"`            // ocr | batch
+	Items    string       `json:"items,omitempty"`   // nombre legado del array de ítems
+	Results  string       `json:"results,omitempty"` // nombre legado del array de resultados
+	Request  FieldMapping `json:"request"`
+	Response FieldMapping `json:"response"`
+}
+
+// loadCompatAliases lee OCR_COMPAT_FILE: {"aliases": [...]}.
+func loadCompatAliases(path string) ([]CompatAlias, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Aliases []CompatAlias `json:"aliases"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for _, a := range file.Aliases {
+		switch {
+		case !strings.HasPrefix(a.Path, "/"):
+			return nil, fmt.Errorf("%s: alias %q: path debe empezar con /", path, a.Name)
+		case a.Target != compatTargetOCR && a.Target != compatTargetBatch:
+			return nil, fmt.Errorf("%s: alias %q: target debe ser ocr o batch", path, a.Name)
+		case seen[a.Path]:
+			return nil, fmt.Errorf("%s: path %s repetido", path, a.Path)
+		}
+		seen[a.Path] = true
+	}
+	return file.Aliases, nil
+}
+
+// mountCompatAliases registra cada alias como POST sobre su path,
+// traduciendo hacia el handler del target.
+func mountCompatAliases(r chi.Router, aliases []CompatAlias, targets map[string]http.Handler) {
+	for _, a := range aliases {
+		r.Post(a.Path, a.handler(targets[a.Target]))
+	}
+}
+
+func (a CompatAlias) handler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+			return
+		}
+		body, err := json.Marshal(a.translateRequest(in))
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+
+		rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// Los errores siguen siendo problem+json; solo se traducen los
+		// nombres de los campos inválidos
+		out := rec.body.Bytes()
+		contentType := rec.header.Get("Content-Type")
+		switch {
+		case rec.status < 400 && strings.HasPrefix(contentType, "application/json"):
+			var resp map[string]any
+			if err := json.Unmarshal(out, &resp); err == nil {
+				if translated, err := json.Marshal(a.translateResponse(resp)); err == nil {
+					out = append(translated, '\n')
+				}
+			}
+		case strings.HasPrefix(contentType, "application/problem+json"):
+			var p Problem
+			if err := json.Unmarshal(out, &p); err == nil && len(p.InvalidParams) > 0 {
+				for i := range p.InvalidParams {
+					p.InvalidParams[i].Name = a.legacyParam(p.InvalidParams[i].Name)
+				}
+				if translated, err := json.Marshal(p); err == nil {
+					out = append(translated, '\n')
+				}
+			}
+		}
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rec.status)
+		w.Write(out)
+	}
+}
+
+func (a CompatAlias) translateRequest(in map[string]any) map[string]any {
+	if a.Target == compatTargetOCR {
+		return a.Request.apply(in)
+	}
+	itemsField := a.Items
+	if itemsField == "" {
+		itemsField = "items"
+	}
+	items, _ := getPath(in, itemsField).([]any)
+	out := map[string]any{}
+	if preset, ok := in["preset"]; ok {
+		out["preset"] = preset
+	}
+	mapped := make([]any, 0, len(items))
+	for _, item := range items {
+		if obj, ok := item.(map[string]any); ok {
+			mapped = append(mapped, a.Request.apply(obj))
+		} else {
+			mapped = append(mapped, item)
+		}
+	}
+	out["items"] = mapped
+	return out
+}
+
+func (a CompatAlias) translateResponse(resp map[string]any) map[string]any {
+	if a.Target == compatTargetOCR {
+		return a.Response.apply(resp)
+	}
+	results, _ := resp["results"].([]any)
+	for i, item := range results {
+		if obj, ok := item.(map[string]any); ok {
+			results[i] = a.Response.apply(obj)
+		}
+	}
+	resultsField := a.Results
+	if resultsField == "" {
+		resultsField = "results"
+	}
+	out := map[string]any{}
+	setPath(out, resultsField, results)
+	return out
+}
+
+// legacyParam traduce el nombre de un campo inválido ("items[2].url") al
+// formato del consumidor ("documents[2].document.image_url").
+func (a CompatAlias) legacyParam(name string) string {
+	prefix := ""
+	if rest, ok := strings.CutPrefix(name, "items["); ok && a.Target == compatTargetBatch {
+		idx, field, _ := strings.Cut(rest, "].")
+		items := a.Items
+		if items == "" {
+			items = "items"
+		}
+		prefix, name = items+"["+idx+"].", field
+	}
+	for from, to := range a.Request.Rename {
+		if to == name {
+			return prefix + from
+		}
+	}
+	return prefix + name
+}
+
+// apply devuelve obj con los campos omitidos y renombrados.
+func (m FieldMapping) apply(obj map[string]any) map[string]any {
+	for _, field := range m.Omit {
+		deletePath(obj, field)
+	}
+	// Primero se extraen todos los valores para que un rename no pise a otro
+	values := map[string]any{}
+	for from := range m.Rename {
+		if v := getPath(obj, from); v != nil {
+			values[from] = v
+			deletePath(obj, from)
+		}
+	}
+	for from, to := range m.Rename {
+		if v, ok := values[from]; ok {
+			setPath(obj, to, v)
+		}
+	}
+	return obj
+}
+
+func getPath(obj map[string]any, path string) any {
+	parts := strings.Split(path, ".")
+	var cur any = obj
+	for _, p := range parts {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[p]
+	}
+	return cur
+}
+
+func setPath(obj map[string]any, path string, v any) {
+	parts := strings.Split(path, ".")
+	cur := obj
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			cur[p] = next
+		}
+		cur = next
+	}
+	cur[parts[len(parts)-1]] = v
+}
+
+func deletePath(obj map[string]any, path string) {
+	parts := strings.Split(path, ".")
+	cur := obj
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]any)
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, parts[len(parts)-1])
+}
+
+// bufferedResponse guarda la respuesta del handler para poder traducirla.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
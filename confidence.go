@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rechazo por confianza: un tenant con min_confidence no recibe resultados
+// de menor confianza (imágenes ilegibles, fotos de otra cosa), así no llegan
+// a sus sistemas. El ítem falla con REJECTED_LOW_CONFIDENCE y, si el
+// cliente pidió include_rejected_text, trae igual el resultado.
+
+var rejectedLowConfidenceTotal = newCounterVec("ocr_rejected_low_confidence_total", "Resultados rechazados por confianza menor al mínimo del tenant.", "tenant")
+
+// RejectionInfo es la confianza obtenida y el mínimo del tenant.
+type RejectionInfo struct {
+	Confidence    float64 `json:"confidence"`
+	MinConfidence float64 `json:"min_confidence"`
+}
+
+// rejectLowConfidence devuelve la respuesta de rechazo si la confianza de
+// resp no alcanza el mínimo del tenant, o nil si no hay que rechazarla.
+func rejectLowConfidence(ctx context.Context, req OCRRequest, resp *APIResponse) *APIResponse {
+	tenant := tenantFrom(ctx)
+	min := tenants.load().get(tenant).MinConfidence
+	if min == 0 || resp.Confidence >= min {
+		return nil
+	}
+	rejectedLowConfidenceTotal.Inc(tenant)
+	info := &RejectionInfo{Confidence: resp.Confidence, MinConfidence: min}
+	detail := fmt.Sprintf("Confianza %.3f menor al mínimo %.3f del tenant", resp.Confidence, min)
+	traceEvent(ctx, TraceEvent{Stage: "rejected", Detail: detail})
+	out := errorResponse(req.Key, CodeLowConfidence, detail)
+	if req.IncludeRejectedText {
+		rejected := *resp
+		rejected.StatusCode, rejected.Err, rejected.ErrorCode = out.StatusCode, out.Err, out.ErrorCode
+		out = &rejected
+	}
+	out.Confidence, out.Engine, out.Rejection = resp.Confidence, resp.Engine, info
+	applyTruncation(out, req.MaxTextBytes)
+	return out
+}
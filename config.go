@@ -0,0 +1,709 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config agrupa la configuración del servicio, leída de variables de entorno.
+type Config struct {
+	Port     string
+	LogLevel slog.Level
+	Limits   LimitsConfig
+	Engine   EngineConfig
+	Archive  ArchiveConfig
+	Temp     TempConfig
+	Fetch    FetchConfig
+	Quality  QualityConfig
+
+	Encryption EncryptionConfig
+
+	Admission AdmissionConfig
+
+	ContinuationTTL time.Duration
+	RouteTimeout    time.Duration
+	// ConfigWatchInterval es cada cuánto se revisa si cambiaron los archivos
+	// de configuración; cero solo recarga con SIGHUP o /admin/reload.
+	ConfigWatchInterval time.Duration
+
+	Workers        int
+	PriorityAging  time.Duration
+	ResultStoreMax int
+
+	Mode    string // serveFull o serveReader
+	Queue   QueueConfig
+	Economy EconomyConfig
+	Demo    DemoConfig
+	Startup StartupConfig
+
+	WebSocket WebSocketConfig
+
+	ExportSigningKey string // seed Ed25519 en base64; vacío = clave efímera
+
+	Admin AdminConfig
+
+	ErrorTracking ErrorTrackingConfig
+
+	TLS    TLSConfig
+	Server ServerConfig
+
+	PresetsFile   string
+	TemplatesFile string
+	PIIFile       string
+	CompatFile    string
+	TenantsFile   string
+	// SignatureRootsFile reemplaza las raíces del sistema al verificar
+	// firmas de PDF.
+	SignatureRootsFile string
+	// MiddlewareFile configura el stack de middleware de cada grupo de rutas.
+	MiddlewareFile string
+	// EnvFile son variables de entorno que se vuelven a leer al recargar;
+	// ver envfile.go.
+	EnvFile string
+	// ContractValidation es requests, all u off; ver contract.go.
+	ContractValidation string
+	// Region es la región de procesamiento que informa el bloque meta.
+	Region string
+}
+
+// AdminConfig expone /admin en un puerto propio (AdminPort) o en el puerto
+// principal protegido con Token. Sin ninguno de los dos /admin no se expone.
+type AdminConfig struct {
+	Port  string
+	Token string
+}
+
+// TLSConfig habilita TLS con CertFile y KeyFile o con certificados de
+// Let's Encrypt para AutocertDomains. Con ClientCAFile se verifican
+// certificados de cliente firmados por esas CAs (mTLS); ClientAuth es
+// "require" u "optional".
+type TLSConfig struct {
+	CertFile         string
+	KeyFile          string
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+	ClientCAFile     string
+	ClientAuth       string
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || len(c.AutocertDomains) > 0
+}
+
+// ServerConfig son los límites de las conexiones HTTP, en el puerto
+// principal y en el de administración. Un timeout cero no limita.
+// UnixSocket atiende la API también en ese socket, con permisos
+// UnixSocketMode.
+type ServerConfig struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	KeepAlives        bool
+	TCPKeepAlive      time.Duration // período de los keep-alive TCP
+	UnixSocket        string
+	UnixSocketMode    os.FileMode
+}
+
+// ErrorTrackingConfig configura el envío de panics y errores inesperados a
+// un servicio compatible con Sentry. DSN vacío lo deshabilita.
+type ErrorTrackingConfig struct {
+	DSN         string
+	Environment string
+}
+
+// QueueConfig configura la cola de jobs. URL vacía usa una cola en memoria,
+// válida solo para una réplica. Con Redis también se guardan ahí los
+// resultados, hasta ResultStoreMax por tenant.
+type QueueConfig struct {
+	URL               string // redis://host:6379/0
+	VisibilityTimeout time.Duration
+	JobTimeout        time.Duration
+	JobTTL            time.Duration
+	ResultStoreMax    int
+	ReadOnly          bool // no crea los consumer groups: la URL puede ser una réplica
+}
+
+// WebSocketConfig limita las sesiones de /ocr/ws: requests en curso por
+// conexión y tiempo sin mensajes ni requests en curso antes de cerrarla.
+type WebSocketConfig struct {
+	MaxInFlight int
+	IdleTimeout time.Duration
+}
+
+// StartupConfig acota la espera de las dependencias al arrancar: Timeout
+// en total y MaxBackoff entre reintentos.
+type StartupConfig struct {
+	Timeout    time.Duration
+	MaxBackoff time.Duration
+}
+
+// EconomyConfig define las ventanas off-peak en que se procesan los jobs
+// economy y el tiempo máximo hasta que terminan.
+type EconomyConfig struct {
+	Windows  string // "22:00-06:00,12:00-14:00"
+	Timezone string
+	MaxDelay time.Duration
+}
+
+// DemoConfig habilita /demo/ocr, un endpoint público sin autenticación
+// para probar el servicio, limitado a RatePerMinute requests por IP y a
+// DailyLimit documentos por día entre todas las réplicas.
+type DemoConfig struct {
+	Enabled       bool
+	Engine        string
+	RatePerMinute int
+	DailyLimit    int
+	MaxTextBytes  int
+	// TrustForwardedFor toma la IP del cliente del último X-Forwarded-For,
+	// el que agrega el proxy; solo sirve detrás de uno.
+	TrustForwardedFor bool
+}
+
+// EngineConfig selecciona los motores OCR y el umbral de fallback por página.
+type EngineConfig struct {
+	Primary               string
+	Fallbacks             []string // en orden; vacío deshabilita el fallback
+	FallbackMinConfidence float64
+	MockFailureRate       float64       // fracción de llamadas en que fallan los motores mock
+	MockDeterministic     bool          // mismo key y url, mismo resultado de los mocks
+	MockSeed              uint64        // semilla del modo determinístico
+	MockLatency           time.Duration // latencia fija del modo determinístico; 0 = la mínima de cada motor
+	BatchWindow           time.Duration // espera para agrupar páginas en motores con API batch
+	BatchMaxItems         int           // páginas por llamada batch (tope: límite del proveedor); 1 deshabilita
+	Timeout               time.Duration // por llamada a un motor, reintentos incluidos
+	Resilience            ResilienceConfig
+	Cloud                 CloudEnginesConfig
+}
+
+// CloudEnginesConfig tiene las credenciales de los motores de nube; cada
+// uno se registra solo si las tiene.
+type CloudEnginesConfig struct {
+	VisionAPIKey      string
+	VisionEndpoint    string
+	TextractRegion    string
+	TextractAccessKey string
+	TextractSecretKey string
+	TextractEndpoint  string // vacío = https://textract.{región}.amazonaws.com
+	AzureEndpoint     string
+	AzureKey          string
+	AzureModel        string
+}
+
+// ResilienceConfig configura reintentos y circuit breaker de los motores.
+type ResilienceConfig struct {
+	Retries         int
+	RetryBaseDelay  time.Duration
+	RetryMaxDelay   time.Duration
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// QualityConfig define la calidad de imagen mínima para hacer OCR: Score
+// (0-1) y DPI; cero no exige mínimo. Action es reject o warn.
+type QualityConfig struct {
+	MinScore float64
+	MinDPI   int
+	Action   string
+}
+
+// TempConfig configura el caché en disco de los originales descargados.
+type TempConfig struct {
+	Dir          string
+	MaxBytes     int64 // cero deshabilita el caché: los originales quedan en memoria
+	MinFreeBytes int64 // espacio libre que siempre se deja en el disco; cero no lo limita
+}
+
+// FetchConfig configura la descarga de las URLs de los clientes; ver
+// fetch.go.
+type FetchConfig struct {
+	Timeout time.Duration
+	// AllowedNetworks se permiten aunque sean privadas, loopback o
+	// link-local.
+	AllowedNetworks []netip.Prefix
+}
+
+// AdmissionConfig configura el control de admisión de las requests
+// sincrónicas; cero deshabilita cada límite.
+type AdmissionConfig struct {
+	MaxQueue int           // ítems esperando en la cola del pool
+	MaxWait  time.Duration // espera estimada en la cola
+}
+
+// LimitsConfig define los límites de entrada que aplica validateInput.
+type LimitsConfig struct {
+	MaxBodyBytes  int64
+	MaxBatchItems int
+	MaxURLLength  int
+	// MaxWordlistEntries limita cada wordlist de un tenant.
+	MaxWordlistEntries int
+	AllowedSchemes     []string
+	// MaxImagePixels limita el ancho por alto de las imágenes que se
+	// decodifican para convertirlas a PNG.
+	MaxImagePixels int64
+}
+
+// ArchiveConfig configura el archivado de originales y resultados.
+// URL vacía deshabilita el archivado.
+type ArchiveConfig struct {
+	URL       string // s3://bucket/prefijo, gs://bucket/prefijo o file:///ruta
+	Endpoint  string // endpoint S3 compatible (opcional)
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	// MinFreeBytes es el espacio libre que file:// deja siempre en el
+	// disco; cero no lo limita.
+	MinFreeBytes int64
+	// DownloadTTL es la validez de las URLs firmadas de /download.
+	DownloadTTL time.Duration
+}
+
+// EncryptionConfig configura el cifrado en reposo. Sin Keys ni KMSKeyID no
+// se cifra.
+type EncryptionConfig struct {
+	Keys         string // id:clave en base64,...; la primera cifra, las demás solo descifran
+	KMSKeyID     string // clave de AWS KMS; si está, cifra ella y las locales solo descifran
+	KMSRegion    string
+	KMSAccessKey string
+	KMSSecretKey string
+	KMSEndpoint  string // vacío = https://kms.{región}.amazonaws.com
+	// DataKeyTTL es cuánto se reusa una clave de datos; cero genera una
+	// por registro.
+	DataKeyTTL time.Duration
+}
+
+// maxDownloadTTL es la validez máxima de una URL prefirmada con SigV4.
+const maxDownloadTTL = 7 * 24 * time.Hour
+
+func loadConfig() (*Config, error) {
+	var err error
+	cfg := &Config{
+		Port: envOr("PORT", "8080"),
+		Limits: LimitsConfig{
+			AllowedSchemes: splitList(envOr("OCR_ALLOWED_URL_SCHEMES", "http,https")),
+		},
+		Engine: EngineConfig{
+			Primary:   envOr("OCR_ENGINE", "mock"),
+			Fallbacks: []string{"mock-accurate"},
+			Cloud: CloudEnginesConfig{
+				VisionAPIKey:      os.Getenv("OCR_VISION_API_KEY"),
+				VisionEndpoint:    envOr("OCR_VISION_ENDPOINT", "https://vision.googleapis.com"),
+				TextractRegion:    envOr("OCR_TEXTRACT_REGION", envOr("AWS_REGION", "us-east-1")),
+				TextractAccessKey: envOr("OCR_TEXTRACT_ACCESS_KEY", os.Getenv("AWS_ACCESS_KEY_ID")),
+				TextractSecretKey: envOr("OCR_TEXTRACT_SECRET_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+				TextractEndpoint:  os.Getenv("OCR_TEXTRACT_ENDPOINT"),
+				AzureEndpoint:     os.Getenv("OCR_AZURE_DI_ENDPOINT"),
+				AzureKey:          os.Getenv("OCR_AZURE_DI_KEY"),
+				AzureModel:        envOr("OCR_AZURE_DI_MODEL", "prebuilt-read"),
+			},
+		},
+		Archive: ArchiveConfig{
+			URL:       os.Getenv("OCR_ARCHIVE_URL"),
+			Endpoint:  os.Getenv("OCR_ARCHIVE_ENDPOINT"),
+			Region:    envOr("OCR_ARCHIVE_REGION", envOr("AWS_REGION", "us-east-1")),
+			AccessKey: envOr("OCR_ARCHIVE_ACCESS_KEY", os.Getenv("AWS_ACCESS_KEY_ID")),
+			SecretKey: envOr("OCR_ARCHIVE_SECRET_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		},
+		Temp: TempConfig{
+			Dir: envOr("OCR_TEMP_DIR", filepath.Join(os.TempDir(), "api-ocr")),
+		},
+		Encryption: EncryptionConfig{
+			Keys:         os.Getenv("OCR_ENCRYPTION_KEYS"),
+			KMSKeyID:     os.Getenv("OCR_ENCRYPTION_KMS_KEY_ID"),
+			KMSRegion:    envOr("OCR_ENCRYPTION_KMS_REGION", envOr("AWS_REGION", "us-east-1")),
+			KMSAccessKey: envOr("OCR_ENCRYPTION_KMS_ACCESS_KEY", os.Getenv("AWS_ACCESS_KEY_ID")),
+			KMSSecretKey: envOr("OCR_ENCRYPTION_KMS_SECRET_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			KMSEndpoint:  os.Getenv("OCR_ENCRYPTION_KMS_ENDPOINT"),
+		},
+	}
+
+	if cfg.Limits.MaxBodyBytes, err = envInt64("OCR_MAX_BODY_BYTES", 1<<20); err != nil {
+		return nil, err
+	}
+	if cfg.Archive.MinFreeBytes, err = envNonNegativeInt64("OCR_ARCHIVE_MIN_FREE_BYTES", 512<<20); err != nil {
+		return nil, err
+	}
+	if cfg.Temp.MaxBytes, err = envNonNegativeInt64("OCR_TEMP_MAX_BYTES", 1<<30); err != nil {
+		return nil, err
+	}
+	if cfg.Temp.MaxBytes != 0 && cfg.Temp.MaxBytes < maxOriginalBytes {
+		return nil, fmt.Errorf("OCR_TEMP_MAX_BYTES debe ser 0 o al menos %d, el tamaño máximo de un original", maxOriginalBytes)
+	}
+	if cfg.Temp.MinFreeBytes, err = envNonNegativeInt64("OCR_TEMP_MIN_FREE_BYTES", 512<<20); err != nil {
+		return nil, err
+	}
+	if cfg.Fetch.Timeout, err = envDuration("OCR_FETCH_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Fetch.AllowedNetworks, err = parseNetworks("OCR_FETCH_ALLOWED_NETWORKS", os.Getenv("OCR_FETCH_ALLOWED_NETWORKS")); err != nil {
+		return nil, err
+	}
+	if cfg.Archive.DownloadTTL, err = envDuration("OCR_ARCHIVE_DOWNLOAD_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Archive.DownloadTTL < time.Second || cfg.Archive.DownloadTTL > maxDownloadTTL {
+		return nil, fmt.Errorf("OCR_ARCHIVE_DOWNLOAD_TTL debe estar entre 1s y 7 días (168h)")
+	}
+	if cfg.Encryption.DataKeyTTL, err = envOptionalDuration("OCR_ENCRYPTION_DATA_KEY_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Limits.MaxBatchItems, err = envInt("OCR_MAX_BATCH_ITEMS", 1000); err != nil {
+		return nil, err
+	}
+	if cfg.Limits.MaxURLLength, err = envInt("OCR_MAX_URL_LENGTH", 2048); err != nil {
+		return nil, err
+	}
+	if cfg.Limits.MaxWordlistEntries, err = envInt("OCR_WORDLIST_MAX_ENTRIES", 10000); err != nil {
+		return nil, err
+	}
+	if cfg.Limits.MaxImagePixels, err = envInt64("OCR_MAX_IMAGE_PIXELS", 64_000_000); err != nil {
+		return nil, err
+	}
+	// OCR_FALLBACK_ENGINE es la forma anterior, con un solo motor
+	if v, ok := os.LookupEnv("OCR_FALLBACK_ENGINES"); ok {
+		cfg.Engine.Fallbacks = splitList(v)
+	} else if v, ok := os.LookupEnv("OCR_FALLBACK_ENGINE"); ok {
+		cfg.Engine.Fallbacks = splitList(v)
+	}
+	if cfg.Engine.FallbackMinConfidence, err = envFloat("OCR_FALLBACK_MIN_CONFIDENCE", 0.8); err != nil {
+		return nil, err
+	}
+	if cfg.Engine.MockFailureRate, err = envFloat("OCR_MOCK_FAILURE_RATE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Engine.MockDeterministic, err = envBool("OCR_MOCK_DETERMINISTIC", false); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("OCR_MOCK_SEED"); v != "" {
+		if cfg.Engine.MockSeed, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("OCR_MOCK_SEED debe ser un entero mayor o igual a 0, se recibió %q", v)
+		}
+	}
+	if cfg.Engine.MockLatency, err = envDuration("OCR_MOCK_LATENCY", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Engine.BatchWindow, err = envDuration("OCR_ENGINE_BATCH_WINDOW", 50*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if cfg.Engine.BatchMaxItems, err = envInt("OCR_ENGINE_BATCH_MAX_ITEMS", 16); err != nil {
+		return nil, err
+	}
+
+	if cfg.Quality.MinScore, err = envFloat("OCR_IMAGE_MIN_QUALITY", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Quality.MinDPI, err = envNonNegativeInt("OCR_IMAGE_MIN_DPI", 0); err != nil {
+		return nil, err
+	}
+	cfg.Quality.Action = envOr("OCR_IMAGE_QUALITY_ACTION", qualityReject)
+	if !slices.Contains(qualityActions, cfg.Quality.Action) {
+		return nil, fmt.Errorf("OCR_IMAGE_QUALITY_ACTION debe ser uno de: %s", strings.Join(qualityActions, ", "))
+	}
+
+	if cfg.ContinuationTTL, err = envDuration("OCR_CONTINUATION_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.RouteTimeout, err = envDuration("OCR_ROUTE_TIMEOUT", 15*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ConfigWatchInterval, err = envOptionalDuration("OCR_CONFIG_WATCH_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Admission.MaxQueue, err = envNonNegativeInt("OCR_ADMISSION_MAX_QUEUE", 0); err != nil {
+		return nil, err
+	}
+	// Por default se rechaza lo que vencería igual por el timeout de la ruta
+	if cfg.Admission.MaxWait, err = envOptionalDuration("OCR_ADMISSION_MAX_WAIT", cfg.RouteTimeout); err != nil {
+		return nil, err
+	}
+	if cfg.WebSocket.MaxInFlight, err = envInt("OCR_WS_MAX_IN_FLIGHT", 4); err != nil {
+		return nil, err
+	}
+	if cfg.WebSocket.IdleTimeout, err = envDuration("OCR_WS_IDLE_TIMEOUT", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Startup.Timeout, err = envDuration("OCR_STARTUP_TIMEOUT", 2*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Startup.MaxBackoff, err = envDuration("OCR_STARTUP_MAX_BACKOFF", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Engine.Timeout, err = envDuration("OCR_ENGINE_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+
+	if cfg.Workers, err = envInt("OCR_WORKERS", 32); err != nil {
+		return nil, err
+	}
+	if cfg.PriorityAging, err = envDuration("OCR_PRIORITY_AGING", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ResultStoreMax, err = envInt("OCR_RESULT_STORE_MAX", 10000); err != nil {
+		return nil, err
+	}
+
+	cfg.ExportSigningKey = os.Getenv("OCR_EXPORT_SIGNING_KEY")
+	if err := cfg.LogLevel.UnmarshalText([]byte(envOr("OCR_LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("OCR_LOG_LEVEL: %w", err)
+	}
+
+	cfg.PresetsFile = os.Getenv("OCR_PRESETS_FILE")
+	cfg.TemplatesFile = os.Getenv("OCR_TEMPLATES_FILE")
+	cfg.PIIFile = os.Getenv("OCR_PII_FILE")
+	cfg.CompatFile = os.Getenv("OCR_COMPAT_FILE")
+	cfg.TenantsFile = os.Getenv("OCR_TENANTS_FILE")
+	cfg.SignatureRootsFile = os.Getenv("OCR_SIGNATURE_ROOTS_FILE")
+	cfg.MiddlewareFile = os.Getenv("OCR_MIDDLEWARE_FILE")
+	cfg.EnvFile = os.Getenv("OCR_ENV_FILE")
+	cfg.ContractValidation = envOr("OCR_CONTRACT_VALIDATION", contractRequests)
+	if !slices.Contains(contractModes, cfg.ContractValidation) {
+		return nil, fmt.Errorf("OCR_CONTRACT_VALIDATION debe ser uno de: %s", strings.Join(contractModes, ", "))
+	}
+	cfg.Region = os.Getenv("OCR_REGION")
+	cfg.Admin.Port = os.Getenv("OCR_ADMIN_PORT")
+	cfg.Admin.Token = os.Getenv("OCR_ADMIN_TOKEN")
+	cfg.ErrorTracking.DSN = envOr("OCR_SENTRY_DSN", os.Getenv("SENTRY_DSN"))
+	cfg.ErrorTracking.Environment = envOr("OCR_SENTRY_ENVIRONMENT", os.Getenv("SENTRY_ENVIRONMENT"))
+
+	cfg.TLS = TLSConfig{
+		CertFile:         os.Getenv("OCR_TLS_CERT_FILE"),
+		KeyFile:          os.Getenv("OCR_TLS_KEY_FILE"),
+		AutocertDomains:  splitList(os.Getenv("OCR_TLS_AUTOCERT_DOMAINS")),
+		AutocertCacheDir: envOr("OCR_TLS_AUTOCERT_CACHE_DIR", "autocert-cache"),
+		AutocertEmail:    os.Getenv("OCR_TLS_AUTOCERT_EMAIL"),
+		ClientCAFile:     os.Getenv("OCR_TLS_CLIENT_CA_FILE"),
+		ClientAuth:       envOr("OCR_TLS_CLIENT_AUTH", "require"),
+	}
+	switch {
+	case (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == ""):
+		return nil, fmt.Errorf("OCR_TLS_CERT_FILE y OCR_TLS_KEY_FILE van juntos")
+	case cfg.TLS.CertFile != "" && len(cfg.TLS.AutocertDomains) > 0:
+		return nil, fmt.Errorf("OCR_TLS_CERT_FILE y OCR_TLS_AUTOCERT_DOMAINS son excluyentes")
+	case cfg.TLS.ClientCAFile != "" && !cfg.TLS.enabled():
+		return nil, fmt.Errorf("OCR_TLS_CLIENT_CA_FILE requiere OCR_TLS_CERT_FILE u OCR_TLS_AUTOCERT_DOMAINS")
+	case cfg.TLS.ClientAuth != "require" && cfg.TLS.ClientAuth != "optional":
+		return nil, fmt.Errorf("OCR_TLS_CLIENT_AUTH debe ser require u optional")
+	}
+
+	if cfg.Server, err = loadServerConfig(cfg.RouteTimeout); err != nil {
+		return nil, err
+	}
+
+	cfg.Queue.URL = os.Getenv("OCR_QUEUE_URL")
+	if cfg.Queue.VisibilityTimeout, err = envDuration("OCR_QUEUE_VISIBILITY_TIMEOUT", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Queue.JobTimeout, err = envDuration("OCR_JOB_TIMEOUT", 2*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Queue.JobTTL, err = envDuration("OCR_JOB_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Queue.VisibilityTimeout <= cfg.Queue.JobTimeout {
+		return nil, fmt.Errorf("OCR_QUEUE_VISIBILITY_TIMEOUT debe ser mayor que OCR_JOB_TIMEOUT")
+	}
+	cfg.Queue.ResultStoreMax = cfg.ResultStoreMax
+	cfg.Mode = envOr("OCR_MODE", serveFull)
+	if !slices.Contains(serveModes, cfg.Mode) {
+		return nil, fmt.Errorf("OCR_MODE debe ser uno de: %s", strings.Join(serveModes, ", "))
+	}
+	cfg.Queue.ReadOnly = cfg.Mode == serveReader
+	if cfg.Mode != serveFull && (cfg.Queue.URL == "" || cfg.Queue.URL == "memory://") {
+		return nil, fmt.Errorf("OCR_MODE=%s requiere OCR_QUEUE_URL: la cola y los resultados se comparten por Redis", cfg.Mode)
+	}
+
+	cfg.Economy.Windows = envOr("OCR_OFFPEAK_WINDOWS", "22:00-06:00")
+	cfg.Economy.Timezone = envOr("OCR_OFFPEAK_TIMEZONE", "UTC")
+	if cfg.Economy.MaxDelay, err = envDuration("OCR_ECONOMY_MAX_DELAY", 12*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Economy.MaxDelay <= cfg.Queue.JobTimeout {
+		return nil, fmt.Errorf("OCR_ECONOMY_MAX_DELAY debe ser mayor que OCR_JOB_TIMEOUT")
+	}
+
+	cfg.Demo.Engine = envOr("OCR_DEMO_ENGINE", "mock")
+	if cfg.Demo.Enabled, err = envBool("OCR_DEMO_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.Demo.TrustForwardedFor, err = envBool("OCR_DEMO_TRUST_FORWARDED_FOR", false); err != nil {
+		return nil, err
+	}
+	if cfg.Demo.RatePerMinute, err = envInt("OCR_DEMO_RATE_PER_MINUTE", 5); err != nil {
+		return nil, err
+	}
+	if cfg.Demo.DailyLimit, err = envInt("OCR_DEMO_DAILY_LIMIT", 500); err != nil {
+		return nil, err
+	}
+	if cfg.Demo.MaxTextBytes, err = envInt("OCR_DEMO_MAX_TEXT_BYTES", 2000); err != nil {
+		return nil, err
+	}
+
+	res := &cfg.Engine.Resilience
+	if res.Retries, err = envNonNegativeInt("OCR_ENGINE_RETRIES", 2); err != nil {
+		return nil, err
+	}
+	if res.RetryBaseDelay, err = envDuration("OCR_ENGINE_RETRY_BASE_DELAY", 200*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if res.RetryMaxDelay, err = envDuration("OCR_ENGINE_RETRY_MAX_DELAY", 2*time.Second); err != nil {
+		return nil, err
+	}
+	if res.BreakerFailures, err = envInt("OCR_BREAKER_FAILURES", 5); err != nil {
+		return nil, err
+	}
+	if res.BreakerCooldown, err = envDuration("OCR_BREAKER_COOLDOWN", 30*time.Second); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadServerConfig(routeTimeout time.Duration) (ServerConfig, error) {
+	var err error
+	c := ServerConfig{UnixSocket: os.Getenv("OCR_UNIX_SOCKET")}
+	if c.ReadTimeout, err = envOptionalDuration("OCR_HTTP_READ_TIMEOUT", time.Minute); err != nil {
+		return c, err
+	}
+	if c.ReadHeaderTimeout, err = envOptionalDuration("OCR_HTTP_READ_HEADER_TIMEOUT", 10*time.Second); err != nil {
+		return c, err
+	}
+	if c.WriteTimeout, err = envOptionalDuration("OCR_HTTP_WRITE_TIMEOUT", 0); err != nil {
+		return c, err
+	}
+	if c.IdleTimeout, err = envOptionalDuration("OCR_HTTP_IDLE_TIMEOUT", 2*time.Minute); err != nil {
+		return c, err
+	}
+	if c.MaxHeaderBytes, err = envInt("OCR_HTTP_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes); err != nil {
+		return c, err
+	}
+	if c.KeepAlives, err = envBool("OCR_HTTP_KEEPALIVES", true); err != nil {
+		return c, err
+	}
+	if c.TCPKeepAlive, err = envDuration("OCR_HTTP_TCP_KEEPALIVE", 15*time.Second); err != nil {
+		return c, err
+	}
+	mode, err := strconv.ParseUint(envOr("OCR_UNIX_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil || mode > 0o777 {
+		return c, fmt.Errorf("OCR_UNIX_SOCKET_MODE debe ser un modo octal, p. ej. 0660")
+	}
+	c.UnixSocketMode = os.FileMode(mode)
+	// Con un límite menor, las respuestas lentas se cortarían sin el 408
+	// del timeout de la ruta
+	if c.WriteTimeout > 0 && c.WriteTimeout <= routeTimeout {
+		return c, fmt.Errorf("OCR_HTTP_WRITE_TIMEOUT debe ser mayor que OCR_ROUTE_TIMEOUT o 0")
+	}
+	return c, nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) (int, error) {
+	v, err := envInt64(key, int64(def))
+	return int(v), err
+}
+
+func envInt64(key string, def int64) (int64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s debe ser un entero positivo, se recibió %q", key, v)
+	}
+	return n, nil
+}
+
+func envNonNegativeInt64(key string, def int64) (int64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s debe ser un entero mayor o igual a 0, se recibió %q", key, v)
+	}
+	return n, nil
+}
+
+func envNonNegativeInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s debe ser un entero mayor o igual a 0, se recibió %q", key, v)
+	}
+	return n, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s debe ser una duración positiva (p. ej. 500ms, 30s), se recibió %q", key, v)
+	}
+	return d, nil
+}
+
+// envOptionalDuration es envDuration, pero acepta 0 para no limitar.
+func envOptionalDuration(key string, def time.Duration) (time.Duration, error) {
+	if os.Getenv(key) == "0" {
+		return 0, nil
+	}
+	return envDuration(key, def)
+}
+
+func envFloat(key string, def float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("%s debe ser un número entre 0 y 1, se recibió %q", key, v)
+	}
+	return f, nil
+}
+
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s debe ser true o false, se recibió %q", key, v)
+	}
+	return b, nil
+}
+
+// splitList separa una lista por comas, normalizada a minúsculas.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
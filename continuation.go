@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// minTextBytes es el menor max_text_bytes aceptado.
+const minTextBytes = 64
+
+// continuation es el resto de un texto truncado, a partir de offset.
+type continuation struct {
+	key      string
+	text     string
+	offset   int
+	maxBytes int
+	expires  time.Time
+}
+
+// continuationStore guarda en memoria los textos truncados hasta que vencen.
+// Los tokens no se consumen: pedir dos veces el mismo token devuelve el mismo
+// fragmento, así los clientes pueden reintentar.
+type continuationStore struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items map[string]continuation
+}
+
+var continuations = &continuationStore{ttl: 15 * time.Minute, items: map[string]continuation{}}
+
+func (s *continuationStore) put(c continuation) string {
+	token := newID(16)
+	c.expires = time.Now().Add(s.ttl)
+	s.mu.Lock()
+	s.items[token] = c
+	s.mu.Unlock()
+	return token
+}
+
+func (s *continuationStore) get(token string) (continuation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[token]
+	if !ok || time.Now().After(c.expires) {
+		return continuation{}, false
+	}
+	return c, true
+}
+
+// sweep elimina periódicamente las continuaciones vencidas.
+func (s *continuationStore) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		s.mu.Lock()
+		for token, c := range s.items {
+			if now.After(c.expires) {
+				delete(s.items, token)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// truncateText corta text en maxBytes bytes sin partir caracteres UTF-8.
+func truncateText(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
+
+// applyTruncation limita full_text a max_text_bytes y, si sobra texto, lo
+// guarda y devuelve un token para pedir el resto.
+func applyTruncation(resp *APIResponse, maxBytes int) {
+	if maxBytes <= 0 || len(resp.Body) <= maxBytes {
+		return
+	}
+	full := resp.Body
+	resp.Body = truncateText(full, maxBytes)
+	resp.Truncated = true
+	resp.ContinuationToken = continuations.put(continuation{
+		key:      resp.Key,
+		text:     full,
+		offset:   len(resp.Body),
+		maxBytes: maxBytes,
+	})
+}
+
+// ContinuationResponse es un fragmento de un texto truncado.
+type ContinuationResponse struct {
+	Key               string `json:"key"`
+	Offset            int    `json:"offset"`
+	Body              string `json:"full_text"`
+	Truncated         bool   `json:"truncated"`
+	ContinuationToken string `json:"continuation_token,omitempty"`
+}
+
+// GET /ocr/continuations/{token}?max_text_bytes= -> siguiente fragmento del texto
+func handleContinuation(w http.ResponseWriter, r *http.Request) {
+	c, ok := continuations.get(chi.URLParam(r, "token"))
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, "Token de continuación inexistente o vencido"))
+		return
+	}
+
+	maxBytes := c.maxBytes
+	if v := r.URL.Query().Get("max_text_bytes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minTextBytes {
+			writeProblem(w, r, newProblem(CodeInvalidInput, "max_text_bytes debe ser un entero mayor o igual a "+strconv.Itoa(minTextBytes)))
+			return
+		}
+		maxBytes = n
+	}
+
+	chunk := truncateText(c.text[c.offset:], maxBytes)
+	out := ContinuationResponse{Key: c.key, Offset: c.offset, Body: chunk}
+	if next := c.offset + len(chunk); next < len(c.text) {
+		out.Truncated = true
+		out.ContinuationToken = continuations.put(continuation{
+			key:      c.key,
+			text:     c.text,
+			offset:   next,
+			maxBytes: maxBytes,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
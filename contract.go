@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Validación contra el contrato OpenAPI (OCR_CONTRACT_VALIDATION):
+//
+//   - requests (default): rechaza con 400 INVALID_INPUT las requests cuyo
+//     body JSON o parámetros de query no cumplen el contrato: tipos, valores
+//     admitidos y campos requeridos. Un body que no es JSON lo sigue
+//     reportando el handler.
+//   - all: además valida cada respuesta y registra las que no lo cumplen,
+//     sin modificarlas. Pensado para staging y pruebas de integración.
+//   - off: no valida.
+const (
+	contractRequests = "requests"
+	contractAll      = "all"
+	contractOff      = "off"
+)
+
+var contractModes = []string{contractRequests, contractAll, contractOff}
+
+// maxValidatedResponse acota la respuesta que se guarda para validarla; las
+// más grandes no se validan.
+const maxValidatedResponse = 16 << 20
+
+var contractViolationsTotal = newCounterVec("ocr_contract_violations_total",
+	"Requests y respuestas que no cumplen el contrato OpenAPI, por operación.", "direction", "operation")
+
+// Dirección de un body. Los campos requeridos se exigen solo en las
+// requests, salvo los readOnly, que asigna el servidor.
+const (
+	directionRequest  = "request"
+	directionResponse = "response"
+)
+
+// buildContract arma el contrato y lo compara con las rutas de r.
+func buildContract(r chi.Routes, aliases []CompatAlias, demoEnabled bool, validation string, limits LimitsConfig) (*apiContract, error) {
+	c, err := newContract(contractOperations(aliases, demoEnabled))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkRoutes(r); err != nil {
+		return nil, err
+	}
+	c.validation, c.maxBodyBytes = validation, limits.MaxBodyBytes
+	return c, nil
+}
+
+// validateContract valida la request contra la operación de su ruta y, con
+// OCR_CONTRACT_VALIDATION=all, también la respuesta. Va después del resto
+// del stack del grupo: las requests sin auth se rechazan antes y la
+// respuesta se valida sin comprimir.
+func validateContract(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := contract.load()
+		if c == nil || c.validation == contractOff {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+		op := c.operations[id]
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !c.skip[id] {
+			invalid, err := c.checkRequest(r, op)
+			if err != nil {
+				writeProblem(w, r, newProblem(CodeInvalidInput, "No se pudo leer el body"))
+				return
+			}
+			if len(invalid) > 0 {
+				contractViolationsTotal.Inc(directionRequest, id)
+				p := newProblem(CodeInvalidInput, "La request no cumple el contrato de la API (GET /openapi.json)")
+				p.InvalidParams = invalid
+				writeProblem(w, r, p)
+				return
+			}
+		}
+
+		// Un upgrade a WebSocket no tiene respuesta que validar
+		if c.validation != contractAll || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &contractRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.overflow {
+			return
+		}
+		if violations := c.checkResponse(op, rec); len(violations) > 0 {
+			contractViolationsTotal.Inc(directionResponse, id)
+			reasons := make([]string, len(violations))
+			for i, v := range violations {
+				reasons[i] = v.Name + ": " + v.Reason
+			}
+			loggerFrom(r.Context()).Warn("response does not match the API contract",
+				"operation", id, "status", rec.status, "violations", strings.Join(reasons, "; "))
+		}
+	})
+}
+
+// checkRequest valida los parámetros de query y el body JSON. Deja el body
+// en r para el handler.
+func (c *apiContract) checkRequest(r *http.Request, op *openAPIOperation) ([]InvalidParam, error) {
+	var invalid []InvalidParam
+	q := r.URL.Query()
+	for _, p := range op.Parameters {
+		if v := q.Get(p.Name); p.In == "query" && v != "" {
+			if reason := checkParam(p.Schema, v); reason != "" {
+				invalid = append(invalid, InvalidParam{Name: p.Name, Reason: reason})
+			}
+		}
+	}
+
+	if op.RequestBody == nil {
+		return invalid, nil
+	}
+	// Los handlers leen como JSON cualquier Content-Type que no sea uno de
+	// los alternativos (CSV, JSONL, texto)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	media, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		media = op.RequestBody.Content["application/json"]
+	}
+	schema := media.Schema
+	if schema == nil {
+		return invalid, nil
+	}
+	// Un body que supera el límite lo rechaza el handler con
+	// PAYLOAD_TOO_LARGE; acá se lee como mucho hasta el límite.
+	body, err := io.ReadAll(io.LimitReader(r.Body, c.maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if int64(len(body)) > c.maxBodyBytes {
+		return invalid, nil
+	}
+	v, err := decodeJSON(body)
+	if err != nil {
+		return invalid, nil // JSON inválido: lo reporta el handler
+	}
+	c.check(schema, v, "", directionRequest, &invalid)
+	return invalid, nil
+}
+
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkParam valida el valor de un parámetro de query.
+func checkParam(s *jsonSchema, v string) string {
+	switch s.Type {
+	case "integer":
+		if _, err := strconv.Atoi(v); err != nil {
+			return "debe ser un entero"
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "debe ser un número"
+		}
+	}
+	if reason := checkFormat(s.Format, v); reason != "" {
+		return reason
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		return "debe ser uno de: " + strings.Join(s.Enum, ", ")
+	}
+	return ""
+}
+
+func checkFormat(format, v string) string {
+	switch format {
+	case "date":
+		if _, err := time.Parse(time.DateOnly, v); err != nil {
+			return "debe ser una fecha YYYY-MM-DD"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return "debe ser una fecha RFC 3339"
+		}
+	}
+	return ""
+}
+
+// check valida v contra s y agrega a invalid cada campo que no lo cumple,
+// con el path del campo como los demás errores de validación (items[0].url).
+func (c *apiContract) check(s *jsonSchema, v any, path, direction string, invalid *[]InvalidParam) {
+	if s.Ref != "" {
+		c.check(c.doc.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)], v, path, direction, invalid)
+		return
+	}
+	name := path
+	if name == "" {
+		name = "body"
+	}
+	if len(s.AnyOf) > 0 {
+		// Con un valor no null, un campo nullable tiene una sola alternativa
+		// y se informan sus errores
+		alts := s.AnyOf
+		if v != nil {
+			alts = slices.DeleteFunc(slices.Clone(alts), func(a *jsonSchema) bool { return a.Type == "null" })
+		}
+		if len(alts) == 1 {
+			c.check(alts[0], v, path, direction, invalid)
+			return
+		}
+		for _, alt := range alts {
+			var errs []InvalidParam
+			if c.check(alt, v, path, direction, &errs); len(errs) == 0 {
+				return
+			}
+		}
+		*invalid = append(*invalid, InvalidParam{Name: name, Reason: "no cumple ninguna de las alternativas del schema"})
+		return
+	}
+
+	types := schemaTypes(s)
+	if len(types) > 0 && !slices.Contains(types, jsonType(v)) &&
+		!(jsonType(v) == "integer" && slices.Contains(types, "number")) {
+		*invalid = append(*invalid, InvalidParam{Name: name, Reason: "debe ser de tipo " + strings.Join(types, " o ")})
+		return
+	}
+
+	switch v := v.(type) {
+	case string:
+		if reason := checkFormat(s.Format, v); reason != "" {
+			*invalid = append(*invalid, InvalidParam{Name: name, Reason: reason})
+		} else if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+			*invalid = append(*invalid, InvalidParam{Name: name, Reason: "debe ser uno de: " + strings.Join(s.Enum, ", ")})
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				c.check(s.Items, item, fmt.Sprintf("%s[%d]", path, i), direction, invalid)
+			}
+		}
+	case map[string]any:
+		prefix := path
+		if prefix != "" {
+			prefix += "."
+		}
+		if direction == directionRequest {
+			for _, req := range s.Required {
+				if _, ok := v[req]; !ok && !s.Properties[req].ReadOnly {
+					*invalid = append(*invalid, InvalidParam{Name: prefix + req, Reason: "es requerido"})
+				}
+			}
+		}
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			ps, ok := s.Properties[k]
+			if !ok {
+				ps = s.AdditionalProperties
+			}
+			if ps == nil {
+				continue // los campos desconocidos se ignoran, como en encoding/json
+			}
+			c.check(ps, v[k], prefix+k, direction, invalid)
+		}
+	}
+}
+
+// schemaTypes devuelve los tipos admitidos por s; vacío admite cualquiera.
+func schemaTypes(s *jsonSchema) []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
+
+// jsonType es el tipo JSON de un valor decodificado con UseNumber.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return ""
+}
+
+// contractRecorder deja pasar la respuesta y guarda una copia para
+// validarla al terminar.
+type contractRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *contractRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *contractRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(p) > maxValidatedResponse {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *contractRecorder) Flush() {
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *contractRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// checkResponse valida el status, el media type y el body de la respuesta.
+func (c *apiContract) checkResponse(op *openAPIOperation, rec *contractRecorder) []InvalidParam {
+	resp := op.Responses[strconv.Itoa(rec.status)]
+	if resp == nil {
+		if rec.status < 400 {
+			return []InvalidParam{{Name: "status", Reason: fmt.Sprintf("%d no está en el contrato", rec.status)}}
+		}
+		resp = op.Responses["default"]
+	}
+	if len(resp.Content) == 0 {
+		if rec.body.Len() > 0 {
+			return []InvalidParam{{Name: "body", Reason: "la respuesta no debería tener body"}}
+		}
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	media, ok := resp.Content[mediaType]
+	if !ok {
+		return []InvalidParam{{Name: "Content-Type", Reason: fmt.Sprintf("%q no está en el contrato", mediaType)}}
+	}
+	if media.Schema == nil {
+		return nil
+	}
+
+	body := rec.body.Bytes()
+	var invalid []InvalidParam
+	if mediaType == mediaTypeNDJSON {
+		for i, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+			v, err := decodeJSON(line)
+			if err != nil {
+				invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("línea %d", i+1), Reason: "JSON inválido"})
+				continue
+			}
+			c.check(media.Schema, v, fmt.Sprintf("[%d]", i), directionResponse, &invalid)
+		}
+		return invalid
+	}
+	v, err := decodeJSON(body)
+	if err != nil {
+		return []InvalidParam{{Name: "body", Reason: "JSON inválido"}}
+	}
+	c.check(media.Schema, v, "", directionResponse, &invalid)
+	return invalid
+}
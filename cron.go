@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec es una expresión cron de cinco campos (minuto, hora, día del
+// mes, mes y día de la semana) con la semántica clásica de Vixie cron: *,
+// listas, rangos y pasos, y si día del mes y día de la semana están
+// restringidos alcanza con que coincida uno de los dos.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// cronMacros son las abreviaturas aceptadas.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronHorizon es hasta dónde se busca la próxima ejecución; una expresión
+// sin ejecuciones en ese plazo (p. ej. el 30 de febrero) es inválida.
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+func parseCron(expr string) (*cronSpec, error) {
+	if m, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("se esperan 5 campos (minuto hora día mes día-de-semana), hay %d", len(fields))
+	}
+	c := &cronSpec{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for i, f := range []struct {
+		name     string
+		dst      *uint64
+		min, max int
+	}{
+		{"minuto", &c.minute, 0, 59},
+		{"hora", &c.hour, 0, 23},
+		{"día del mes", &c.dom, 1, 31},
+		{"mes", &c.month, 1, 12},
+		{"día de la semana", &c.dow, 0, 7},
+	} {
+		bits, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.dst = bits
+	}
+	// 7 también es domingo
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%q nunca se ejecuta", expr)
+	}
+	return c, nil
+}
+
+// parseCronField lee una lista de "*", "n", "a-b", con "/paso" opcional.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		lo, hi := min, max
+		switch from, to, isRange := strings.Cut(rng, "-"); {
+		case rng == "*":
+		case isRange:
+			var err error
+			if lo, err = cronNumber(from, min, max); err != nil {
+				return 0, err
+			}
+			if hi, err = cronNumber(to, min, max); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("rango invertido %q", rng)
+			}
+		default:
+			n, err := cronNumber(rng, min, max)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = n, n
+			if hasStep {
+				hi = max
+			}
+		}
+		inc := 1
+		if hasStep {
+			n, err := strconv.Atoi(step)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("paso inválido %q", step)
+			}
+			inc = n
+		}
+		for v := lo; v <= hi; v += inc {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func cronNumber(s string, min, max int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%q no es un número entre %d y %d", s, min, max)
+	}
+	return n, nil
+}
+
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next devuelve la primera ejecución posterior a after, en la zona horaria
+// de after, o el tiempo cero si no hay ninguna dentro de cronHorizon. Salta
+// meses, días y horas enteros que no coinciden.
+func (c *cronSpec) next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for limit := after.Add(cronHorizon); t.Before(limit); {
+		y, m, d := t.Date()
+		var skip time.Time
+		switch {
+		case c.month&(1<<int(m)) == 0:
+			skip = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			skip = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			skip = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			skip = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// En un cambio de horario time.Date puede normalizar hacia atrás
+		if !skip.After(t) {
+			skip = t.Add(time.Minute)
+		}
+		t = skip
+	}
+	return time.Time{}
+}
@@ -0,0 +1,96 @@
+package main
+
+import "encoding/json"
+
+// Deduplicación dentro de un batch: los ítems que piden la misma imagen con
+// las mismas opciones se procesan una sola vez, con el job del primero, y su
+// resultado se copia a los demás con su key y "deduplicated": true.
+
+var batchDeduplicatedTotal = newCounterVec("ocr_batch_deduplicated_items_total", "Ítems de batches resueltos con el resultado de otro ítem igual.")
+
+// BatchDuplicate es un ítem de un batch que repite a otro. Index es su
+// posición en el batch.
+type BatchDuplicate struct {
+	Index    int               `json:"index"`
+	Key      string            `json:"key"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// dedupKey identifica lo que pide el ítem: la URL y las opciones que
+// cambian el resultado. Key, prioridad y metadata no lo cambian, y el
+// preset ya está aplicado en las opciones.
+func (req OCRRequest) dedupKey() string {
+	req.Key, req.Priority, req.Preset = "", "", ""
+	req.Metadata = nil
+	b, _ := json.Marshal(req)
+	return string(b)
+}
+
+// dedupItems devuelve las posiciones de los ítems a procesar, en orden, y
+// los duplicados de cada una. Sin dedup cada ítem se procesa aparte.
+func dedupItems(items []OCRRequest, dedup bool) ([]int, map[int][]BatchDuplicate) {
+	unique := make([]int, 0, len(items))
+	dups := map[int][]BatchDuplicate{}
+	first := map[string]int{}
+	for i, item := range items {
+		if dedup {
+			k := item.dedupKey()
+			if j, ok := first[k]; ok {
+				dups[j] = append(dups[j], BatchDuplicate{Index: i, Key: item.Key, Metadata: item.Metadata})
+				continue
+			}
+			first[k] = i
+		}
+		unique = append(unique, i)
+	}
+	if n := len(items) - len(unique); n > 0 {
+		batchDeduplicatedTotal.Add(float64(n))
+	}
+	return unique, dups
+}
+
+// uniqueItems cuenta los documentos que procesaría el batch, para la cuota.
+func uniqueItems(items []OCRRequest, dedup bool) int {
+	if !dedup {
+		return len(items)
+	}
+	keys := map[string]bool{}
+	for _, item := range items {
+		keys[item.dedupKey()] = true
+	}
+	return len(keys)
+}
+
+// result es el resultado del duplicado a partir del de su original.
+func (d BatchDuplicate) result(resp *APIResponse) *APIResponse {
+	if resp == nil {
+		return nil
+	}
+	out := *resp
+	out.Key = d.Key
+	out.Metadata = d.Metadata
+	out.Deduplicated = true
+	return &out
+}
+
+// jobIndexes reconstruye la posición en el batch de cada job: los
+// duplicados guardan la suya, y los jobs, creados en el orden de los ítems,
+// ocupan las restantes.
+func jobIndexes(jobs []Job) []int {
+	taken := map[int]bool{}
+	for _, j := range jobs {
+		for _, d := range j.Duplicates {
+			taken[d.Index] = true
+		}
+	}
+	indexes := make([]int, len(jobs))
+	next := 0
+	for i := range jobs {
+		for taken[next] {
+			next++
+		}
+		indexes[i] = next
+		next++
+	}
+	return indexes
+}
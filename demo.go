@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Modo demo: POST /demo/ocr es un playground público, sin autenticación,
+// para compartir el servicio sin exponer un tenant. Procesa solo imágenes de
+// una página con el motor de OCR_DEMO_ENGINE, con prioridad baja, y marca el
+// resultado como demostración. No se archiva ni se guarda; el uso se cuenta
+// en el tenant demo.
+
+const (
+	demoTenant       = "demo"
+	demoMaxBodyBytes = 4 << 10
+	demoWatermark    = "[DEMO api-ocr] Resultado de demostración, no apto para uso productivo."
+)
+
+var demoRequestsTotal = newCounterVec("ocr_demo_requests_total", "Requests a /demo/ocr por resultado.", "outcome")
+
+// demo es nil cuando el modo demo está deshabilitado.
+var demo *demoMode
+
+type demoMode struct {
+	cfg     DemoConfig
+	limiter *rateLimiter
+}
+
+type demoKey struct{}
+
+// isDemo indica si el contexto es de un request de /demo/ocr.
+func isDemo(ctx context.Context) bool {
+	v, _ := ctx.Value(demoKey{}).(bool)
+	return v
+}
+
+func setupDemo(cfg DemoConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if engines[cfg.Engine] == nil {
+		return fmt.Errorf("OCR_DEMO_ENGINE: motor %q inexistente (disponibles: %s)", cfg.Engine, strings.Join(engineNames(), ", "))
+	}
+	demo = &demoMode{cfg: cfg, limiter: newRateLimiter(cfg.RatePerMinute, time.Minute)}
+	go demo.limiter.sweep(time.Minute)
+	slog.Warn("demo mode enabled, /demo/ocr accepts unauthenticated requests",
+		"engine", cfg.Engine, "rate_per_minute", cfg.RatePerMinute, "daily_limit", cfg.DailyLimit)
+	return nil
+}
+
+// DemoRequest es el body de POST /demo/ocr.
+type DemoRequest struct {
+	URL string `json:"url"`
+}
+
+// POST /demo/ocr -> recibe {url} y responde el OCR marcado como demo
+func handleDemoOCR(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r, demo.cfg.TrustForwardedFor)
+	addLogAttrs(r.Context(), slog.String("tenant", demoTenant), slog.String("client_ip", ip))
+	if ok, wait := demo.limiter.allow(ip, time.Now()); !ok {
+		demoRequestsTotal.Inc("rate_limited")
+		retryAfter(w, wait)
+		writeProblem(w, r, newProblem(CodeRateLimited,
+			fmt.Sprintf("La demo admite %d requests por minuto por cliente", demo.cfg.RatePerMinute)))
+		return
+	}
+
+	var in DemoRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, demoMaxBodyBytes)).Decode(&in); err != nil || in.URL == "" {
+		demoRequestsTotal.Inc("invalid")
+		writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera {url}"))
+		return
+	}
+	reason := checkURL(in.URL, liveConfig.load().Limits)
+	if reason == "" && isMultiPage(in.URL) {
+		reason = "la demo procesa solo imágenes de una página"
+	}
+	if reason != "" {
+		demoRequestsTotal.Inc("invalid")
+		p := newProblem(CodeInvalidInput, "La request contiene campos inválidos")
+		p.InvalidParams = []InvalidParam{{Name: "url", Reason: reason}}
+		writeProblem(w, r, p)
+		return
+	}
+
+	ctx := context.WithValue(withTenant(r.Context(), demoTenant), demoKey{}, true)
+	now := time.Now()
+	used, err := usageStore.Documents(ctx, demoTenant, usageDay(now))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	if used >= demo.cfg.DailyLimit {
+		demoRequestsTotal.Inc("daily_limit")
+		retryAfter(w, now.UTC().Truncate(24*time.Hour).Add(24*time.Hour).Sub(now))
+		writeProblem(w, r, newProblem(CodeQuotaExceeded, "La demo alcanzó su límite diario de documentos"))
+		return
+	}
+
+	includePages := false
+	req := OCRRequest{
+		Key:          "demo-" + newID(8),
+		URL:          in.URL,
+		Engine:       demo.cfg.Engine,
+		Priority:     priorityLow,
+		IncludePages: &includePages,
+	}
+	addLogAttrs(r.Context(), slog.String("key", req.Key))
+	resp, _ := pool.run(ctx, req)
+	if resp.ErrorCode != "" {
+		demoRequestsTotal.Inc("error")
+		p := newProblem(resp.ErrorCode, resp.Err)
+		p.Key = req.Key
+		p.Timeout = resp.Timeout
+		p.Rejection, p.Quality = resp.Rejection, resp.Quality
+		writeProblem(w, r, p)
+		return
+	}
+	demoRequestsTotal.Inc("ok")
+	if len(resp.Body) > demo.cfg.MaxTextBytes {
+		resp.Body = truncateText(resp.Body, demo.cfg.MaxTextBytes)
+		resp.Truncated = true
+	}
+	resp.Body = demoWatermark + "\n\n" + resp.Body
+	resp.Demo = true
+	w.Header().Set("X-OCR-Demo", "true")
+	writeJSON(w, http.StatusOK, resp)
+}
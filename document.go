@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Page es una página del escaneo de entrada. content es el texto "impreso"
+// en la página, ink la fracción de píxeles oscuros y quality (0-1) qué tan
+// legible es la imagen; como este servicio es un mock, se generan al cargar
+// el documento, igual que handwritten. source da acceso al original para
+// los motores de nube y seed identifica la página para la aleatoriedad de
+// los motores mock. mode es el modo con que la reconoce el motor.
+type Page struct {
+	Number   int
+	title    string // tipo del documento al que pertenece
+	content  string
+	ink      float64
+	quality  float64
+	barcodes []Barcode
+	source   *documentSource
+	seed     uint64
+
+	handwritten bool
+	mode        string
+}
+
+// documentSource descarga el original del documento una sola vez, al
+// cargarlo; las páginas y el archivado lo comparten.
+type documentSource struct {
+	url       string
+	multiPage bool
+
+	mu          sync.Mutex
+	raw         []byte // tal como se descargó, que es lo que se archiva
+	rawType     string
+	data        []byte
+	contentType string
+}
+
+// download descarga el original si todavía no se descargó. Un error no se
+// guarda: el próximo intento vuelve a descargarlo. Se llama con mu tomado.
+func (s *documentSource) download(ctx context.Context) error {
+	if s.raw != nil {
+		return nil
+	}
+	data, contentType, err := fetchOriginal(ctx, s.url)
+	if err != nil {
+		return fetchError(err)
+	}
+	s.raw, s.rawType = data, contentType
+	return nil
+}
+
+// original devuelve los bytes y el tipo del documento, ya convertido si su
+// formato no va tal cual a los motores (ver normalizeOriginal).
+func (s *documentSource) original(ctx context.Context) ([]byte, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		if err := s.download(ctx); err != nil {
+			return nil, "", err
+		}
+		data, contentType, err := normalizeOriginal(s.raw, s.rawType)
+		if err != nil {
+			return nil, "", err
+		}
+		s.data, s.contentType = data, contentType
+	}
+	return s.data, s.contentType, nil
+}
+
+// downloaded devuelve el original sin convertir, con el tipo que informó
+// el servidor.
+func (s *documentSource) downloaded(ctx context.Context) ([]byte, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.download(ctx); err != nil {
+		return nil, "", err
+	}
+	return s.raw, s.rawType, nil
+}
+
+// blankInkThreshold es la cobertura de tinta por debajo de la cual una página
+// se considera en blanco (polvo o ruido del escáner incluidos).
+const blankInkThreshold = 0.005
+
+// isBlankPage indica si la página está en blanco o casi en blanco.
+func isBlankPage(p Page) bool {
+	return p.ink < blankInkThreshold
+}
+
+// Document es la imagen o escaneo referenciado por la URL de un OCRRequest.
+// Un PDF/TIFF puede contener varios documentos concatenados (escaneo por lote).
+type Document struct {
+	URL   string
+	Pages []Page
+
+	source *documentSource
+}
+
+var randomTexts = []string{
+	"Documento de identificación",
+	"Pasaporte República Argentina",
+	"Licencia de conducir",
+	"Factura comercial No. 12345",
+	"Certificado de nacimiento",
+	"Contrato de trabajo",
+	"Recibo de pago mensual",
+	"Diploma universitario",
+	"Tarjeta de crédito VISA",
+	"Boleta de servicios públicos",
+}
+
+var watermarks = []string{"COPIA", "CONFIDENCIAL", "BORRADOR", "MUESTRA"}
+
+var additionalWords = []string{"validez", "expedición", "número", "fecha", "código", "serie", "emisión"}
+
+// isMultiPage indica si la URL apunta a un formato que puede tener varias páginas.
+func isMultiPage(rawURL string) bool {
+	p := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		p = u.Path
+	}
+	switch strings.ToLower(path.Ext(p)) {
+	case ".pdf", ".tif", ".tiff":
+		return true
+	}
+	return false
+}
+
+// loadDocument descarga el original y verifica su formato antes de que
+// llegue a cualquier motor, así todos (los mock incluidos) rechazan los
+// mismos originales con UNSUPPORTED_FORMAT; después simula su rasterizado.
+// Las imágenes simples tienen una página; los PDF/TIFF contienen entre 1 y 3 documentos
+// de 1 a 3 páginas cada uno, con el pie "Página i de n" de cada documento,
+// a veces una marca de agua diagonal y a veces páginas en blanco intercaladas
+// como las que agregan los escáneres. La primera página de cada documento
+// puede traer códigos de barras según su tipo y las de facturas, recibos y
+// boletas, tablas (ver extractTables); las de actas y contratos pueden ser
+// manuscritas (ver markHandwritten). En modo determinístico el
+// documento depende solo de key y rawURL.
+func loadDocument(ctx context.Context, key, rawURL string) (*Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	source := &documentSource{url: rawURL, multiPage: isMultiPage(rawURL)}
+	if _, _, err := source.original(ctx); err != nil {
+		return nil, err
+	}
+
+	r := mockRand.load().source("document", key, rawURL)
+	doc := &Document{URL: rawURL, source: source}
+	if !source.multiPage {
+		title := randomTexts[r.IntN(len(randomTexts))]
+		text := title
+		if r.Float32() < 0.7 {
+			text += " " + randomBody(r)
+		}
+		if r.Float32() < 0.1 {
+			text += "\n" + randomWatermark(r)
+		}
+		doc.Pages = []Page{{Number: 1, title: title, content: text, ink: randomInk(r), quality: randomQuality(r), barcodes: randomBarcodes(r, title, 1), source: source, seed: r.Uint64()}}
+		markHandwritten(doc)
+		return doc, nil
+	}
+
+	docs := r.IntN(3) + 1
+	for d := 0; d < docs; d++ {
+		title := randomTexts[r.IntN(len(randomTexts))]
+		watermark := ""
+		if r.Float32() < 0.2 {
+			watermark = randomWatermark(r) + "\n"
+		}
+		n := r.IntN(3) + 1
+		for i := 1; i <= n; i++ {
+			number := len(doc.Pages) + 1
+			var barcodes []Barcode
+			if i == 1 {
+				barcodes = randomBarcodes(r, title, number)
+			}
+			doc.Pages = append(doc.Pages, Page{
+				Number:   number,
+				title:    title,
+				content:  fmt.Sprintf("%s\n%s%s\nPágina %d de %d", title, watermark, randomBody(r), i, n),
+				ink:      randomInk(r),
+				quality:  randomQuality(r),
+				barcodes: barcodes,
+				source:   source,
+				seed:     r.Uint64(),
+			})
+			if r.Float32() < 0.25 {
+				doc.Pages = append(doc.Pages, Page{
+					Number: len(doc.Pages) + 1,
+					ink:    r.Float64() * blankInkThreshold,
+					source: source,
+					seed:   r.Uint64(),
+				})
+			}
+		}
+	}
+	markHandwritten(doc)
+	return doc, nil
+}
+
+func randomBody(r *rand.Rand) string {
+	return additionalWords[r.IntN(len(additionalWords))] + " " + fmt.Sprintf("%d", r.IntN(9999)+1000)
+}
+
+func randomInk(r *rand.Rand) float64 {
+	return 0.03 + r.Float64()*0.12
+}
+
+func randomQuality(r *rand.Rand) float64 {
+	return 0.55 + r.Float64()*0.45
+}
+
+// randomWatermark devuelve una marca de agua tal como la leería el OCR:
+// a veces entera y a veces con las letras separadas.
+func randomWatermark(r *rand.Rand) string {
+	w := watermarks[r.IntN(len(watermarks))]
+	if r.Float32() < 0.5 {
+		return strings.Join(strings.Split(w, ""), " ")
+	}
+	return w
+}
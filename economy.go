@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // OCR_OFFPEAK_TIMEZONE no depende del zoneinfo del sistema
+)
+
+// Clases de procesamiento. economy difiere el job a la próxima ventana
+// off-peak (menor precio del motor cloud y menor carga), con un tiempo
+// máximo de finalización garantizado.
+const (
+	classStandard = "standard"
+	classEconomy  = "economy"
+)
+
+var processingClasses = []string{classStandard, classEconomy}
+
+// economyOnlyAsync es el motivo con que los endpoints sincrónicos rechazan
+// economy: la respuesta llegaría horas después.
+var economyOnlyAsync = InvalidParam{Name: "processing_class", Reason: "economy solo se admite en /ocr/jobs y /ocr/batches"}
+
+// clockWindow es una ventana diaria en minutos desde medianoche, [start, end).
+// Si end <= start la ventana cruza la medianoche.
+type clockWindow struct {
+	start, end int
+}
+
+func (w clockWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// offPeakSchedule define cuándo se liberan los jobs economy.
+type offPeakSchedule struct {
+	windows  []clockWindow
+	loc      *time.Location
+	maxDelay time.Duration // desde que se encola hasta que debe estar terminado
+}
+
+var economy *offPeakSchedule
+
+func setupEconomy(cfg EconomyConfig) error {
+	windows, err := parseOffPeakWindows(cfg.Windows)
+	if err != nil {
+		return fmt.Errorf("OCR_OFFPEAK_WINDOWS: %w", err)
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("OCR_OFFPEAK_TIMEZONE: %w", err)
+	}
+	economy = &offPeakSchedule{windows: windows, loc: loc, maxDelay: cfg.MaxDelay}
+	return nil
+}
+
+// parseOffPeakWindows lee ventanas "22:00-06:00,12:00-14:00".
+func parseOffPeakWindows(spec string) ([]clockWindow, error) {
+	var windows []clockWindow
+	for _, part := range strings.Split(spec, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("ventana off-peak inválida %q: se espera HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("ventana off-peak vacía %q", part)
+		}
+		windows = append(windows, clockWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("hora inválida %q: se espera HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// nextWindow devuelve now si está dentro de una ventana o el próximo inicio.
+func (s *offPeakSchedule) nextWindow(now time.Time) time.Time {
+	local := now.In(s.loc)
+	minute := local.Hour()*60 + local.Minute()
+	var next time.Time
+	for _, w := range s.windows {
+		if w.contains(minute) {
+			return now
+		}
+		for day := 0; day <= 1; day++ {
+			start := time.Date(local.Year(), local.Month(), local.Day()+day, w.start/60, w.start%60, 0, 0, s.loc)
+			if start.After(now) && (next.IsZero() || start.Before(next)) {
+				next = start
+				break
+			}
+		}
+	}
+	return next
+}
+
+// release calcula cuándo liberar un job economy encolado en now y con qué
+// prioridad. Si la próxima ventana queda después del último momento que
+// permite terminar a tiempo, el job se libera en ese momento con prioridad
+// alta para cumplir completeBy.
+func (s *offPeakSchedule) release(now time.Time) (at, completeBy time.Time, priority string) {
+	completeBy = now.Add(s.maxDelay)
+	latest := completeBy.Add(-jobTimeout)
+	at = s.nextWindow(now)
+	if at.After(latest) {
+		return latest, completeBy, priorityHigh
+	}
+	return at, completeBy, priorityLow
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cifrado en reposo (envelope encryption): lo que se persiste con texto de
+// OCR —resultados y jobs en Redis, y el original y el result.json
+// archivados— se cifra con AES-256-GCM con una clave de datos, que a su vez
+// va cifrada con la clave maestra (local o de AWS KMS) dentro del registro.
+// Cada registro lleva el id de la clave maestra, así que después de rotarla
+// lo anterior se sigue leyendo mientras la vieja siga configurada, y la key
+// bajo la que se guarda, autenticada: un registro copiado bajo otra key no
+// se descifra. Lo guardado antes de habilitar el cifrado se lee tal cual.
+
+// envelopeMagic abre los registros cifrados; ni un JSON ni las imágenes
+// soportadas empiezan así.
+const envelopeMagic = "OCRE\x01"
+
+// maxOpenedDataKeys acota las claves de datos descifradas en memoria.
+const maxOpenedDataKeys = 1024
+
+var dataKeysTotal = newCounterVec("ocr_encryption_data_keys_total", "Claves de datos generadas o descifradas con la clave maestra, por operación.", "op")
+
+// masterKey cifra las claves de datos.
+type masterKey interface {
+	// ID identifica la clave en los registros.
+	ID() string
+	// newDataKey genera una clave de datos y la devuelve en claro y cifrada.
+	newDataKey(ctx context.Context) (plain, wrapped []byte, err error)
+	unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// localMasterKey es una clave AES-256 de OCR_ENCRYPTION_KEYS.
+type localMasterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func (k *localMasterKey) ID() string { return k.id }
+
+func (k *localMasterKey) newDataKey(context.Context) ([]byte, []byte, error) {
+	plain := make([]byte, 32)
+	rand.Read(plain)
+	nonce := make([]byte, k.aead.NonceSize())
+	rand.Read(nonce)
+	return plain, k.aead.Seal(nonce, nonce, plain, []byte(k.id)), nil
+}
+
+func (k *localMasterKey) unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, errors.New("clave de datos inválida")
+	}
+	return k.aead.Open(nil, wrapped[:n], wrapped[n:], []byte(k.id))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseLocalKeys interpreta OCR_ENCRYPTION_KEYS: id:clave,... con claves
+// de 32 bytes en base64.
+func parseLocalKeys(raw string) ([]masterKey, error) {
+	var keys []masterKey
+	seen := map[string]bool{}
+	for part := range strings.SplitSeq(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(part, ":")
+		if !ok || id == "" || len(id) > 64 || strings.HasPrefix(id, kmsKeyPrefix) {
+			return nil, fmt.Errorf("OCR_ENCRYPTION_KEYS: %q debe ser id:clave, con un id de hasta 64 caracteres", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("OCR_ENCRYPTION_KEYS: id %q repetido", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("OCR_ENCRYPTION_KEYS: la clave %q debe ser de 32 bytes en base64", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		seen[id] = true
+		keys = append(keys, &localMasterKey{id: id, aead: aead})
+	}
+	return keys, nil
+}
+
+// dataKey es la clave de datos con que se cifra hasta expires.
+type dataKey struct {
+	masterID string
+	wrapped  []byte
+	aead     cipher.AEAD
+	expires  time.Time
+}
+
+// envelope cifra y descifra registros. active cifra las claves de datos
+// nuevas; keys resuelve las de los registros, y kms las de cualquier clave
+// de KMS.
+type envelope struct {
+	active     masterKey
+	keys       map[string]masterKey
+	kms        *kmsClient
+	dataKeyTTL time.Duration
+
+	mu      sync.Mutex
+	current *dataKey
+	opened  map[string]cipher.AEAD // claves de datos descifradas, por su versión cifrada
+}
+
+// encryption es nil cuando el cifrado en reposo está deshabilitado.
+var encryption *envelope
+
+// setupEncryption arma el cifrado de OCR_ENCRYPTION_*; sin claves lo deja
+// deshabilitado.
+func setupEncryption(cfg EncryptionConfig) error {
+	local, err := parseLocalKeys(cfg.Keys)
+	if err != nil {
+		return err
+	}
+	if len(local) == 0 && cfg.KMSKeyID == "" {
+		encryption = nil
+		return nil
+	}
+	e := &envelope{keys: map[string]masterKey{}, dataKeyTTL: cfg.DataKeyTTL, opened: map[string]cipher.AEAD{}}
+	for _, k := range local {
+		e.keys[k.ID()] = k
+	}
+	if cfg.KMSKeyID != "" {
+		if cfg.KMSAccessKey == "" || cfg.KMSSecretKey == "" {
+			return errors.New("OCR_ENCRYPTION_KMS_KEY_ID requiere credenciales de AWS")
+		}
+		e.kms = newKMSClient(cfg)
+		e.active = e.kms.key(cfg.KMSKeyID)
+		if len(e.active.ID()) > 255 {
+			return errors.New("OCR_ENCRYPTION_KMS_KEY_ID: el id es demasiado largo")
+		}
+	} else {
+		e.active = local[0]
+	}
+	encryption = e
+	return nil
+}
+
+// masterKey devuelve la clave maestra de id.
+func (e *envelope) masterKey(id string) (masterKey, error) {
+	if k, ok := e.keys[id]; ok {
+		return k, nil
+	}
+	if keyID, ok := strings.CutPrefix(id, kmsKeyPrefix); ok && e.kms != nil {
+		return e.kms.key(keyID), nil
+	}
+	return nil, fmt.Errorf("clave maestra %q no configurada", id)
+}
+
+// dataKey devuelve la clave de datos vigente, o genera otra si venció.
+func (e *envelope) dataKey(ctx context.Context) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if e.current != nil && now.Before(e.current.expires) {
+		return e.current, nil
+	}
+	plain, wrapped, err := e.active.newDataKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("generando clave de datos con %s: %w", e.active.ID(), err)
+	}
+	dataKeysTotal.Inc("generate")
+	aead, err := newAEAD(plain)
+	if err != nil {
+		return nil, err
+	}
+	dk := &dataKey{masterID: e.active.ID(), wrapped: wrapped, aead: aead, expires: now.Add(e.dataKeyTTL)}
+	if e.dataKeyTTL > 0 {
+		e.current = dk
+	}
+	return dk, nil
+}
+
+// openDataKey descifra la clave de datos de un registro, o la toma de las
+// ya descifradas.
+func (e *envelope) openDataKey(ctx context.Context, masterID string, wrapped []byte) (cipher.AEAD, error) {
+	cacheKey := masterID + "\x00" + string(wrapped)
+	e.mu.Lock()
+	aead, ok := e.opened[cacheKey]
+	e.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+	mk, err := e.masterKey(masterID)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := mk.unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("descifrando la clave de datos con %s: %w", masterID, err)
+	}
+	dataKeysTotal.Inc("unwrap")
+	if aead, err = newAEAD(plain); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	if len(e.opened) >= maxOpenedDataKeys {
+		clear(e.opened)
+	}
+	e.opened[cacheKey] = aead
+	e.mu.Unlock()
+	return aead, nil
+}
+
+// seal cifra plain, que se guarda bajo recordID. El registro es
+// envelopeMagic, el id de la clave maestra, recordID, la clave de datos
+// cifrada (cada uno precedido por su largo), el nonce y el texto cifrado;
+// todo lo anterior al nonce se autentica con el texto.
+func (e *envelope) seal(ctx context.Context, recordID string, plain []byte) ([]byte, error) {
+	dk, err := e.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := []byte(envelopeMagic)
+	header = append(header, byte(len(dk.masterID)))
+	header = append(header, dk.masterID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(recordID)))
+	header = append(header, recordID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(dk.wrapped)))
+	header = append(header, dk.wrapped...)
+	nonce := make([]byte, dk.aead.NonceSize())
+	rand.Read(nonce)
+	out := append(header, nonce...)
+	return dk.aead.Seal(out, nonce, plain, header), nil
+}
+
+// sealedRecord es un registro cifrado ya separado en sus partes.
+type sealedRecord struct {
+	masterID string
+	recordID string
+	wrapped  []byte
+	header   []byte
+	body     []byte // nonce y texto cifrado
+}
+
+func parseSealed(data []byte) (sealedRecord, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(envelopeMagic))
+	// next consume un campo precedido por su largo, de size bytes
+	next := func(size int) ([]byte, bool) {
+		if len(rest) < size {
+			return nil, false
+		}
+		n := int(rest[0])
+		if size == 2 {
+			n = int(binary.BigEndian.Uint16(rest))
+		}
+		if len(rest) < size+n {
+			return nil, false
+		}
+		f := rest[size : size+n]
+		rest = rest[size+n:]
+		return f, true
+	}
+	var rec sealedRecord
+	masterID, ok1 := next(1)
+	recordID, ok2 := next(2)
+	wrapped, ok3 := next(2)
+	if !ok || !ok1 || !ok2 || !ok3 {
+		return sealedRecord{}, errors.New("registro cifrado inválido")
+	}
+	rec.masterID, rec.recordID, rec.wrapped = string(masterID), string(recordID), wrapped
+	rec.header, rec.body = data[:len(data)-len(rest)], rest
+	return rec, nil
+}
+
+// open descifra un registro guardado bajo recordID; con recordID vacío no
+// lo verifica.
+func (e *envelope) open(ctx context.Context, recordID string, data []byte) ([]byte, error) {
+	rec, err := parseSealed(data)
+	if err != nil {
+		return nil, err
+	}
+	if recordID != "" && rec.recordID != recordID {
+		return nil, fmt.Errorf("el registro cifrado es de %q, no de %q", rec.recordID, recordID)
+	}
+	aead, err := e.openDataKey(ctx, rec.masterID, rec.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(rec.body) < n {
+		return nil, errors.New("registro cifrado inválido")
+	}
+	plain, err := aead.Open(nil, rec.body[:n], rec.body[n:], rec.header)
+	if err != nil {
+		return nil, fmt.Errorf("registro cifrado alterado o de otra clave: %w", err)
+	}
+	return plain, nil
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopeMagic))
+}
+
+// sealRecord cifra data, que se guarda bajo id, si el cifrado está
+// habilitado.
+func sealRecord(ctx context.Context, id string, data []byte) ([]byte, error) {
+	if encryption == nil {
+		return data, nil
+	}
+	return encryption.seal(ctx, id, data)
+}
+
+// openRecord descifra data si está cifrado; lo guardado sin cifrar se
+// devuelve tal cual.
+func openRecord(ctx context.Context, id string, data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	if encryption == nil {
+		return nil, fmt.Errorf("%s está cifrado y no hay claves configuradas (OCR_ENCRYPTION_KEYS u OCR_ENCRYPTION_KMS_KEY_ID)", id)
+	}
+	return encryption.open(ctx, id, data)
+}
+
+// needsRewrap indica si data no está cifrado con la clave maestra activa.
+func needsRewrap(data []byte) bool {
+	if !isSealed(data) {
+		return true
+	}
+	rec, err := parseSealed(data)
+	return err == nil && rec.masterID != encryption.active.ID()
+}
+
+// rewrapRecord vuelve a cifrar data con la clave maestra activa.
+func rewrapRecord(ctx context.Context, id string, data []byte) ([]byte, error) {
+	plain, err := openRecord(ctx, id, data)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.seal(ctx, id, plain)
+}
+
+// rewrapper lo implementan los stores que persisten registros cifrados:
+// rewrap vuelve a cifrar con la clave maestra activa los que están con
+// otra o sin cifrar, y devuelve cuántos cambió.
+type rewrapper interface {
+	rewrap(ctx context.Context) (int, error)
+}
+
+// RewrapResult informa cuántos registros se volvieron a cifrar, por store.
+type RewrapResult struct {
+	KeyID     string         `json:"key_id"`
+	Rewrapped map[string]int `json:"rewrapped"`
+}
+
+// POST /admin/encryption/rewrap -> vuelve a cifrar con la clave maestra
+// activa los resultados y jobs guardados con otra o sin cifrar, y los
+// objetos archivados cifrados con otra, para poder retirar una clave vieja.
+func handleAdminRewrap(w http.ResponseWriter, r *http.Request) {
+	if encryption == nil {
+		writeProblem(w, r, newProblem(CodeConflict, "El cifrado en reposo no está habilitado (OCR_ENCRYPTION_KEYS u OCR_ENCRYPTION_KMS_KEY_ID)"))
+		return
+	}
+	out := RewrapResult{KeyID: encryption.active.ID(), Rewrapped: map[string]int{}}
+	stores := map[string]any{"results": results, "jobs": jobStore}
+	if archiveStore != nil {
+		stores["archive"] = archiveRewrapper{archiveStore}
+	}
+	for name, store := range stores {
+		rw, ok := store.(rewrapper)
+		if !ok {
+			continue
+		}
+		n, err := rw.rewrap(r.Context())
+		out.Rewrapped[name] = n
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeInternal, fmt.Sprintf("Volviendo a cifrar %s (%d ya cifrados): %v", name, n, err)))
+			return
+		}
+	}
+	auditAdmin(r, "encryption.rewrap", fmt.Sprintf("key_id=%s results=%d jobs=%d archive=%d", out.KeyID, out.Rewrapped["results"], out.Rewrapped["jobs"], out.Rewrapped["archive"]))
+	writeJSON(w, http.StatusOK, out)
+}
+
+// rewrapRedisKeys vuelve a cifrar los valores de las keys de Redis que
+// cumplen pattern, strings o listas, y devuelve cuántos cambió. recordID es
+// el id con que se cifró cada valor de la key.
+func rewrapRedisKeys(ctx context.Context, rdb *redis.Client, pattern string, recordID func(key string) string) (int, error) {
+	n := 0
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, pattern, 500).Result()
+		if err != nil {
+			return n, err
+		}
+		for _, key := range keys {
+			changed, err := rewrapRedisKey(ctx, rdb, key, recordID(key))
+			n += changed
+			if err != nil {
+				return n, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return n, nil
+		}
+	}
+}
+
+// rewrapRedisKey reescribe la key con WATCH, reintentando si otra réplica
+// la cambió en el medio. Las listas se reescriben enteras y los strings
+// conservan su TTL.
+func rewrapRedisKey(ctx context.Context, rdb *redis.Client, key, recordID string) (int, error) {
+	for range 5 {
+		changed := 0
+		err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+			kind, err := tx.Type(ctx, key).Result()
+			if err != nil {
+				return err
+			}
+			var values []string
+			switch kind {
+			case "string":
+				v, err := tx.Get(ctx, key).Result()
+				if err != nil {
+					return err
+				}
+				values = []string{v}
+			case "list":
+				if values, err = tx.LRange(ctx, key, 0, -1).Result(); err != nil {
+					return err
+				}
+			default:
+				return nil // venció o se borró después del SCAN
+			}
+			out := make([]any, len(values))
+			for i, v := range values {
+				out[i] = v
+				if !needsRewrap([]byte(v)) {
+					continue
+				}
+				if out[i], err = rewrapRecord(ctx, recordID, []byte(v)); err != nil {
+					return err
+				}
+				changed++
+			}
+			if changed == 0 {
+				return nil
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if kind == "string" {
+					pipe.Set(ctx, key, out[0], redis.KeepTTL)
+					return nil
+				}
+				ttl := tx.PTTL(ctx, key).Val()
+				pipe.Del(ctx, key)
+				pipe.RPush(ctx, key, out...)
+				if ttl > 0 {
+					pipe.PExpire(ctx, key, ttl)
+				}
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return changed, err
+		}
+	}
+	return 0, redis.TxFailedErr
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKey es una clave de OCR_ENCRYPTION_KEYS con id y 32 bytes al azar.
+func testKey(t *testing.T, id string) string {
+	t.Helper()
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	return id + ":" + base64.StdEncoding.EncodeToString(raw)
+}
+
+// useKeys habilita el cifrado con keys (la primera es la activa) hasta que
+// termina el test.
+func useKeys(t *testing.T, keys ...string) {
+	t.Helper()
+	if err := setupEncryption(EncryptionConfig{Keys: strings.Join(keys, ","), DataKeyTTL: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { encryption = nil })
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	useKeys(t, testKey(t, "k1"))
+	ctx := context.Background()
+	plain := []byte(`{"full_text":"Pasaporte República Argentina"}`)
+	sealed, err := encryption.seal(ctx, "ocr:result:default:a", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(sealed) || bytes.Contains(sealed, []byte("Pasaporte")) {
+		t.Fatalf("el registro no quedó cifrado: %q", sealed)
+	}
+	got, err := openRecord(ctx, "ocr:result:default:a", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("open = %q, se esperaba %q", got, plain)
+	}
+}
+
+// TestEnvelopeRejectsTampering altera un byte de cada parte del registro:
+// todo lo anterior al nonce va autenticado como AAD.
+func TestEnvelopeRejectsTampering(t *testing.T) {
+	useKeys(t, testKey(t, "k1"))
+	ctx := context.Background()
+	sealed, err := encryption.seal(ctx, "id", []byte("texto del OCR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := parseSealed(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, i := range map[string]int{
+		// El id del registro: con recordID vacío no se compara, así que
+		// solo lo detecta la AAD
+		"record id":        len(envelopeMagic) + 1 + len(rec.masterID) + 2,
+		"clave de datos":   len(rec.header) - 1,
+		"nonce":            len(rec.header),
+		"texto cifrado":    len(sealed) - 20,
+		"tag":              len(sealed) - 1,
+		"largo del header": len(envelopeMagic) + 1 + len(rec.masterID) + 1,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tampered := bytes.Clone(sealed)
+			tampered[i] ^= 0x01
+			if _, err := encryption.open(ctx, "", tampered); err == nil {
+				t.Fatalf("se abrió el registro alterado en el byte %d", i)
+			}
+		})
+	}
+}
+
+func TestEnvelopeRejectsOtherRecordID(t *testing.T) {
+	useKeys(t, testKey(t, "k1"))
+	ctx := context.Background()
+	sealed, err := encryption.seal(ctx, "ocr:result:acme:a", []byte("texto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Un registro copiado bajo otra key, p. ej. de otro tenant
+	if _, err := openRecord(ctx, "ocr:result:otro:a", sealed); err == nil {
+		t.Fatal("se abrió el registro bajo otro id")
+	}
+	// Reescribir el id del header tampoco alcanza: va en la AAD
+	forged := bytes.Replace(sealed, []byte("ocr:result:acme:a"), []byte("ocr:result:otro:a"), 1)
+	if _, err := openRecord(ctx, "ocr:result:otro:a", forged); err == nil {
+		t.Fatal("se abrió el registro con el id del header reescrito")
+	}
+}
+
+func TestEnvelopeUnknownMasterKey(t *testing.T) {
+	useKeys(t, testKey(t, "k1"))
+	ctx := context.Background()
+	sealed, err := encryption.seal(ctx, "id", []byte("texto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, testKey(t, "k2"))
+	if _, err := openRecord(ctx, "id", sealed); err == nil || !strings.Contains(err.Error(), "k1") {
+		t.Fatalf("open sin la clave k1 = %v, se esperaba un error que la nombre", err)
+	}
+	// Otra clave con el mismo id no descifra la clave de datos
+	useKeys(t, testKey(t, "k1"))
+	if _, err := openRecord(ctx, "id", sealed); err == nil {
+		t.Fatal("se abrió el registro con otra clave de id k1")
+	}
+}
+
+// TestRewrapRotation rota la clave maestra: lo cifrado con la vieja se
+// vuelve a cifrar con la nueva y después se lee sin la vieja.
+func TestRewrapRotation(t *testing.T) {
+	k1, k2 := testKey(t, "k1"), testKey(t, "k2")
+	ctx := context.Background()
+	plain := []byte("Licencia de conducir")
+
+	useKeys(t, k1)
+	sealed, err := encryption.seal(ctx, "id", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if needsRewrap(sealed) {
+		t.Fatal("needsRewrap con la clave activa")
+	}
+
+	useKeys(t, k2, k1)
+	if !needsRewrap(sealed) || !needsRewrap(plain) {
+		t.Fatal("needsRewrap tiene que ser true para lo cifrado con k1 y lo guardado sin cifrar")
+	}
+	rewrapped, err := rewrapRecord(ctx, "id", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := parseSealed(rewrapped); rec.masterID != "k2" || rec.recordID != "id" {
+		t.Fatalf("rewrap = clave %q, id %q; se esperaba k2 e id", rec.masterID, rec.recordID)
+	}
+
+	useKeys(t, k2)
+	got, err := openRecord(ctx, "id", rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("open = %q, se esperaba %q", got, plain)
+	}
+	if needsRewrap(rewrapped) {
+		t.Fatal("needsRewrap después del rewrap")
+	}
+}
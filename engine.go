@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OCREngine reconoce el texto de una página.
+type OCREngine interface {
+	Name() string
+	Version() string
+	Recognize(ctx context.Context, p Page) (Recognition, error)
+}
+
+// Recognition es el resultado de un motor sobre una página.
+type Recognition struct {
+	Text       string
+	Confidence float64
+}
+
+// mockEngine simula un motor OCR: la confianza depende de la calidad de la
+// página y boost representa cuánto mejor es el motor sobre páginas difíciles.
+// handwriting simula un modelo que reconoce letra manuscrita.
+type mockEngine struct {
+	name        string
+	version     string
+	minLatency  time.Duration
+	maxLatency  time.Duration
+	boost       float64
+	handwriting bool
+}
+
+func (e *mockEngine) Name() string { return e.name }
+
+func (e *mockEngine) Version() string { return e.version }
+
+func (e *mockEngine) Recognize(ctx context.Context, p Page) (Recognition, error) {
+	mock := mockRand.load()
+	r := mock.source(e.name, p.seed)
+	latency := mock.engineLatency(r, e.minLatency, e.maxLatency)
+	select {
+	case <-time.After(latency):
+	case <-ctx.Done():
+		return Recognition{}, ctx.Err()
+	}
+	if r.Float64() < mock.failureRate {
+		return Recognition{}, fmt.Errorf("%s: %w", e.name, errEngineUnavailable)
+	}
+	return e.recognizePage(r, p), nil
+}
+
+// recognizePage simula el reconocimiento de la página con la fuente r.
+func (e *mockEngine) recognizePage(r *rand.Rand, p Page) Recognition {
+	conf := math.Min(0.99, p.quality*(0.92+r.Float64()*0.08)+e.boost)
+	switch {
+	case p.handwritten && (p.mode == modePrinted || !e.handwriting):
+		// Un modelo de impresos lee la letra manuscrita a medias
+		conf *= 0.6
+	case !p.handwritten && p.mode == modeHandwritten:
+		conf = math.Max(0, conf-0.05)
+	}
+	text := p.content
+	if conf < 0.75 {
+		text = degradeText(text)
+	}
+	return Recognition{Text: text, Confidence: math.Round(conf*1000) / 1000}
+}
+
+// Ping simula el chequeo de conexión con el backend del motor.
+func (e *mockEngine) Ping(ctx context.Context) error {
+	if mockRand.load().failureRate >= 1 {
+		return fmt.Errorf("%s: %w", e.name, errEngineUnavailable)
+	}
+	return ctx.Err()
+}
+
+// degradeText introduce las confusiones típicas de un OCR sobre una mala imagen.
+func degradeText(text string) string {
+	return strings.NewReplacer("O", "0", "l", "1", "S", "5").Replace(text)
+}
+
+// languageEngine lo implementan los motores que reconocen solo algunos
+// idiomas (códigos ISO 639-1); los demás reconocen cualquiera.
+type languageEngine interface {
+	Languages() []string
+}
+
+// Languages son los idiomas de los textos que generan los mocks.
+func (e *mockEngine) Languages() []string { return []string{"en", "es", "pt"} }
+
+// engineLanguages devuelve los idiomas que reconoce el motor, o nil si
+// reconoce cualquiera.
+func engineLanguages(e *resilientEngine) []string {
+	if le, ok := e.OCREngine.(languageEngine); ok {
+		return le.Languages()
+	}
+	return nil
+}
+
+// engines registra los motores disponibles por nombre.
+var engines = map[string]OCREngine{
+	"mock": &mockEngine{
+		name:       "mock",
+		version:    "1.0.0",
+		minLatency: 1000 * time.Millisecond,
+		maxLatency: 4000 * time.Millisecond,
+	},
+	"mock-accurate": &mockEngine{
+		name:       "mock-accurate",
+		version:    "1.2.0",
+		minLatency: 1500 * time.Millisecond,
+		maxLatency: 3500 * time.Millisecond,
+		boost:      0.2,
+	},
+	"mock-cloud": &mockBatchEngine{
+		mockEngine: mockEngine{
+			name:        "mock-cloud",
+			version:     "2.0.0",
+			boost:       0.1,
+			handwriting: true,
+		},
+		callOverhead: 800 * time.Millisecond,
+		perPage:      50 * time.Millisecond,
+		maxBatch:     16,
+	},
+}
+
+// engineSet son los motores configurados: primary procesa todas las
+// páginas (salvo que el request elija otro) y fallbacks, en orden,
+// reprocesan las que fallan o quedan con confianza menor a minConfidence.
+// Todos los motores van envueltos con reintentos y circuit breaker en
+// resilient.
+type engineSet struct {
+	resilient     map[string]*resilientEngine
+	primary       *resilientEngine
+	fallbacks     []*resilientEngine
+	minConfidence float64
+	// timeout acota cada llamada a un motor, reintentos incluidos; 0 no la
+	// acota.
+	timeout time.Duration
+}
+
+// activeEngineSet es la configuración de motores vigente; se reemplaza
+// entera al recargar.
+var activeEngineSet = newConfigValue(engineSet{})
+
+// setupEngines registra los proveedores de nube, que quedan fijos hasta
+// reiniciar, y aplica la configuración de motores.
+func setupEngines(cfg EngineConfig) error {
+	registerCloudEngines(cfg.Cloud)
+	set, err := buildEngines(cfg)
+	if err != nil {
+		return err
+	}
+	applyEngines(cfg, set)
+	return nil
+}
+
+// buildEngines arma los motores de cfg sin aplicarlos. Los circuit
+// breakers empiezan cerrados.
+func buildEngines(cfg EngineConfig) (engineSet, error) {
+	// Los motores con API batch agrupan páginas antes de los reintentos,
+	// así un reintento vuelve a entrar en el próximo batch.
+	set := engineSet{resilient: map[string]*resilientEngine{}, minConfidence: cfg.FallbackMinConfidence, timeout: cfg.Timeout}
+	for name, e := range engines {
+		if be, ok := e.(BatchEngine); ok && cfg.BatchMaxItems > 1 {
+			e = newEngineBatcher(be, cfg.BatchWindow, cfg.BatchMaxItems)
+		}
+		set.resilient[name] = newResilientEngine(e, cfg.Resilience)
+	}
+
+	var ok bool
+	if set.primary, ok = set.resilient[cfg.Primary]; !ok {
+		return set, fmt.Errorf("motor OCR desconocido: %q", cfg.Primary)
+	}
+	for _, name := range cfg.Fallbacks {
+		e, ok := set.resilient[name]
+		if !ok {
+			return set, fmt.Errorf("motor OCR de fallback desconocido: %q", name)
+		}
+		if slices.Contains(set.fallbacks, e) {
+			return set, fmt.Errorf("motor OCR de fallback repetido: %q", name)
+		}
+		set.fallbacks = append(set.fallbacks, e)
+	}
+	return set, nil
+}
+
+// applyEngines reemplaza los motores vigentes y la simulación de los mocks.
+func applyEngines(cfg EngineConfig, set engineSet) {
+	mockRand.store(mockRandom{deterministic: cfg.MockDeterministic, seed: cfg.MockSeed, latency: cfg.MockLatency, failureRate: cfg.MockFailureRate})
+	activeEngineSet.store(set)
+}
+
+// primaryEngine devuelve el motor primario vigente.
+func primaryEngine() *resilientEngine {
+	return activeEngineSet.load().primary
+}
+
+// engineFor devuelve el motor pedido por el request o el primario.
+func (s engineSet) engineFor(name string) *resilientEngine {
+	if e, ok := s.resilient[name]; ok {
+		return e
+	}
+	return s.primary
+}
+
+// engineFor devuelve el motor vigente pedido por el request o el primario.
+func engineFor(name string) *resilientEngine {
+	return activeEngineSet.load().engineFor(name)
+}
+
+// engineNames devuelve los nombres de los motores registrados, ordenados.
+func engineNames() []string {
+	return slices.Sorted(maps.Keys(engines))
+}
+
+// activeEngines devuelve los motores configurados, primario primero.
+func activeEngines() []*resilientEngine {
+	set := activeEngineSet.load()
+	if set.primary == nil {
+		return nil
+	}
+	return set.chain(set.primary)
+}
+
+// chain devuelve los motores que se prueban sobre una página: primary y
+// después los de fallback, sin repetir primary.
+func (s engineSet) chain(primary *resilientEngine) []*resilientEngine {
+	chain := []*resilientEngine{primary}
+	for _, e := range s.fallbacks {
+		if e != primary {
+			chain = append(chain, e)
+		}
+	}
+	return chain
+}
+
+var _ = newGaugeFunc("ocr_engine_circuit_state", "Estado del circuit breaker de cada motor (1 en el estado actual).",
+	[]string{"engine", "state"}, func(emit func(float64, ...string)) {
+		for _, e := range activeEngines() {
+			current := e.breaker.status().State
+			for _, state := range []string{breakerClosed, breakerOpen, breakerHalfOpen} {
+				v := 0.0
+				if state == current {
+					v = 1
+				}
+				emit(v, e.Name(), state)
+			}
+		}
+	})
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// OCR_ENV_FILE es un archivo de variables de entorno, una NOMBRE=valor por
+// línea (las vacías y las que empiezan con # se ignoran). Se aplica sobre el
+// entorno del proceso antes de leer la configuración, al arrancar y en cada
+// recarga: así se pueden cambiar sin reiniciar las variables recargables
+// (ver reload.go). Sus valores pisan los del entorno; una variable que se
+// quita del archivo vuelve al valor que tenía el proceso.
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envFileVars son las variables aplicadas desde OCR_ENV_FILE, y
+// envOriginal el valor que tenían antes en el proceso (nil si no estaban
+// definidas). Solo se tocan al arrancar y bajo reloadMu.
+var (
+	envFileVars map[string]string
+	envOriginal = map[string]*string{}
+)
+
+// readEnvFile lee las variables del archivo.
+func readEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s:%d: se esperaba NOMBRE=valor", path, n)
+		}
+		if name == "OCR_ENV_FILE" {
+			return nil, fmt.Errorf("%s:%d: OCR_ENV_FILE no se puede definir en el propio archivo", path, n)
+		}
+		vars[name] = strings.TrimSpace(value)
+	}
+	return vars, sc.Err()
+}
+
+// setEnvFile aplica vars sobre el entorno del proceso en lugar de las
+// aplicadas antes, que devuelve para poder volver a ellas.
+func setEnvFile(vars map[string]string) map[string]string {
+	previous := envFileVars
+	for name := range previous {
+		if _, ok := vars[name]; ok {
+			continue
+		}
+		if v := envOriginal[name]; v != nil {
+			os.Setenv(name, *v)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+	for name, value := range vars {
+		if _, ok := envOriginal[name]; !ok {
+			if v, set := os.LookupEnv(name); set {
+				envOriginal[name] = &v
+			} else {
+				envOriginal[name] = nil
+			}
+		}
+		os.Setenv(name, value)
+	}
+	envFileVars = vars
+	return previous
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode es un código de error estable y legible por máquinas. Los
+// clientes deben decidir en base al código y no al texto de detail.
+type ErrorCode string
+
+const (
+	CodeInvalidInput        ErrorCode = "INVALID_INPUT"
+	CodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	CodeForbidden           ErrorCode = "FORBIDDEN"
+	CodePayloadTooLarge     ErrorCode = "PAYLOAD_TOO_LARGE"
+	CodeNotFound            ErrorCode = "NOT_FOUND"
+	CodeMethodNotAllowed    ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeNotAcceptable       ErrorCode = "NOT_ACCEPTABLE"
+	CodeConflict            ErrorCode = "CONFLICT"
+	CodeFetchFailed         ErrorCode = "FETCH_FAILED"
+	CodeUnsupportedFormat   ErrorCode = "UNSUPPORTED_FORMAT"
+	CodeEngineTimeout       ErrorCode = "ENGINE_TIMEOUT"
+	CodeEngineError         ErrorCode = "ENGINE_ERROR"
+	CodeEngineUnavailable   ErrorCode = "ENGINE_UNAVAILABLE"
+	CodeArchiveFailed       ErrorCode = "ARCHIVE_FAILED"
+	CodeRequestCancelled    ErrorCode = "REQUEST_CANCELLED"
+	CodeQuotaExceeded       ErrorCode = "QUOTA_EXCEEDED"
+	CodeRateLimited         ErrorCode = "RATE_LIMITED"
+	CodeQueueUnavailable    ErrorCode = "QUEUE_UNAVAILABLE"
+	CodeQueueFull           ErrorCode = "QUEUE_FULL"
+	CodeDependencyFailed    ErrorCode = "DEPENDENCY_FAILED"
+	CodeLowConfidence       ErrorCode = "REJECTED_LOW_CONFIDENCE"
+	CodeLowQuality          ErrorCode = "REJECTED_LOW_QUALITY"
+	CodeInsufficientStorage ErrorCode = "INSUFFICIENT_STORAGE"
+	CodeInternal            ErrorCode = "INTERNAL_ERROR"
+)
+
+// ErrorDefinition documenta un código del catálogo.
+type ErrorDefinition struct {
+	Code   ErrorCode `json:"code"`
+	Status int       `json:"status"`
+	Title  string    `json:"title"`
+}
+
+// errorCatalog es la lista de códigos que puede devolver el servicio.
+var errorCatalog = []ErrorDefinition{
+	{CodeInvalidInput, http.StatusBadRequest, "Request inválida"},
+	{CodeUnauthorized, http.StatusUnauthorized, "Credenciales inválidas o ausentes"},
+	{CodeForbidden, http.StatusForbidden, "Operación no permitida"},
+	{CodePayloadTooLarge, http.StatusRequestEntityTooLarge, "Request demasiado grande"},
+	{CodeNotFound, http.StatusNotFound, "Recurso inexistente"},
+	{CodeMethodNotAllowed, http.StatusMethodNotAllowed, "Método no permitido"},
+	{CodeNotAcceptable, http.StatusNotAcceptable, "Formato de respuesta no disponible"},
+	{CodeConflict, http.StatusConflict, "Conflicto con el estado del recurso"},
+	{CodeFetchFailed, http.StatusBadGateway, "No se pudo descargar la imagen"},
+	{CodeUnsupportedFormat, http.StatusUnsupportedMediaType, "Formato de imagen no soportado"},
+	{CodeEngineTimeout, http.StatusRequestTimeout, "Timeout del motor OCR"},
+	{CodeEngineError, http.StatusInternalServerError, "Error del motor OCR"},
+	{CodeEngineUnavailable, http.StatusServiceUnavailable, "Motor OCR no disponible"},
+	{CodeArchiveFailed, http.StatusInternalServerError, "No se pudo archivar el resultado"},
+	{CodeRequestCancelled, 499, "Request cancelada por el cliente"},
+	{CodeQuotaExceeded, http.StatusTooManyRequests, "Cuota excedida"},
+	{CodeRateLimited, http.StatusTooManyRequests, "Demasiadas requests"},
+	{CodeQueueUnavailable, http.StatusServiceUnavailable, "Cola de jobs no disponible"},
+	{CodeQueueFull, http.StatusTooManyRequests, "Cola de procesamiento saturada"},
+	{CodeDependencyFailed, http.StatusFailedDependency, "Falló un job del que depende"},
+	{CodeLowConfidence, http.StatusUnprocessableEntity, "Confianza menor al mínimo del tenant"},
+	{CodeLowQuality, http.StatusUnprocessableEntity, "Calidad de imagen insuficiente para el OCR"},
+	{CodeInsufficientStorage, http.StatusInsufficientStorage, "Espacio en disco insuficiente"},
+	{CodeInternal, http.StatusInternalServerError, "Error interno"},
+}
+
+func lookupError(code ErrorCode) ErrorDefinition {
+	for _, d := range errorCatalog {
+		if d.Code == code {
+			return d
+		}
+	}
+	return ErrorDefinition{Code: code, Status: http.StatusInternalServerError, Title: "Error interno"}
+}
+
+// problemSlug es el último segmento del type URI de un código.
+func problemSlug(code ErrorCode) string {
+	return strings.ToLower(strings.ReplaceAll(string(code), "_", "-"))
+}
+
+// newProblem arma el Problem de un código del catálogo.
+func newProblem(code ErrorCode, detail string) Problem {
+	def := lookupError(code)
+	return Problem{
+		Type:   "/problems/" + problemSlug(code),
+		Title:  def.Title,
+		Status: def.Status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// codedError asocia un código del catálogo a un error interno.
+type codedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *codedError) Error() string { return e.Err.Error() }
+func (e *codedError) Unwrap() error { return e.Err }
+
+// errorCodeOf devuelve el código asociado a err, infiriendo los de
+// cancelación del contexto, o def si no hay ninguno.
+func errorCodeOf(err error, def ErrorCode) ErrorCode {
+	var ce *codedError
+	switch {
+	case errors.As(err, &ce):
+		return ce.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeEngineTimeout
+	case errors.Is(err, context.Canceled):
+		return CodeRequestCancelled
+	}
+	return def
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Reporte de errores a un servicio compatible con Sentry (Sentry, GlitchTip,
+// etc.): los panics de los handlers y los errores inesperados del motor se
+// envían con el contexto del request y, en los panics, el stack trace.
+
+var errorReportsTotal = newCounterVec("ocr_error_reports_total", "Eventos enviados al error tracker por resultado.", "outcome")
+
+// sensitiveHeaders no se envían al error tracker.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "X-Api-Key"}
+
+// errorReporter envía los eventos al endpoint de envelopes del DSN. Los
+// envía en segundo plano y descarta los que no entran en el buffer, para no
+// frenar requests si el servicio está caído.
+type errorReporter struct {
+	endpoint    string
+	auth        string
+	environment string
+	serverName  string
+	client      *http.Client
+	events      chan sentryEvent
+}
+
+// reporter es nil cuando no hay DSN configurado.
+var reporter *errorReporter
+
+func newErrorReporter(cfg ErrorTrackingConfig) (*errorReporter, error) {
+	u, err := url.Parse(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	// El proyecto es el último segmento; lo anterior es un prefijo opcional
+	path, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		path, project = "/"+project[:i], project[i+1:]
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User.Username() == "" || project == "" {
+		return nil, errors.New("se espera un DSN https://<clave>@<host>/<proyecto>")
+	}
+	host, _ := os.Hostname()
+	er := &errorReporter{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, path, project),
+		auth:        "Sentry sentry_version=7, sentry_client=api-ocr/1.0, sentry_key=" + u.User.Username(),
+		environment: cfg.Environment,
+		serverName:  host,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		events:      make(chan sentryEvent, 100),
+	}
+	go er.run()
+	return er, nil
+}
+
+// sentryEvent es el subconjunto del formato de eventos de Sentry que se usa.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Exception   sentryExceptions  `json:"exception"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]any    `json:"extra,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// report encola el evento, completando el contexto del request o job.
+func (er *errorReporter) report(ctx context.Context, ev sentryEvent) {
+	if er == nil {
+		return
+	}
+	ev.EventID = newID(16)
+	ev.Timestamp = time.Now().UTC()
+	ev.Platform = "go"
+	ev.ServerName = er.serverName
+	ev.Environment = er.environment
+	ev.Tags = map[string]string{"tenant": tenantFrom(ctx)}
+	if id := requestIDFrom(ctx); id != "" {
+		ev.Tags["request_id"] = id
+	}
+	ev.Extra = map[string]any{}
+	for _, a := range logAttrs(ctx) {
+		ev.Extra[a.Key] = a.Value.Resolve().Any()
+	}
+	select {
+	case er.events <- ev:
+	default:
+		errorReportsTotal.Inc("dropped")
+		slog.Warn("error report dropped", "event_id", ev.EventID)
+	}
+}
+
+func (er *errorReporter) run() {
+	for ev := range er.events {
+		if err := er.send(ev); err != nil {
+			errorReportsTotal.Inc("failed")
+			slog.Warn("error report not sent", "event_id", ev.EventID, "error", err)
+			continue
+		}
+		errorReportsTotal.Inc("sent")
+	}
+}
+
+// send publica el evento como envelope: encabezado, ítem y payload, uno por
+// línea.
+func (er *errorReporter) send(ev sentryEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "{\"event_id\":%q,\"sent_at\":%q}\n", ev.EventID, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&body, "{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, er.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", er.auth)
+	resp, err := er.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("respuesta %s", resp.Status)
+	}
+	return nil
+}
+
+// reportPanic informa un panic recuperado en un handler.
+func reportPanic(r *http.Request, v any, frames []sentryFrame) {
+	reporter.report(r.Context(), panicEvent(v, frames, requestInfo(r)))
+}
+
+// reportTaskPanic informa un panic recuperado fuera de un handler, en un
+// worker del pool o en un consumidor de jobs; ctx es el de la tarea.
+func reportTaskPanic(ctx context.Context, v any, frames []sentryFrame) {
+	reporter.report(ctx, panicEvent(v, frames, nil))
+}
+
+func panicEvent(v any, frames []sentryFrame, req *sentryRequest) sentryEvent {
+	return sentryEvent{
+		Level: "fatal",
+		Exception: sentryExceptions{Values: []sentryException{{
+			Type:       "panic",
+			Value:      fmt.Sprint(v),
+			Stacktrace: &sentryStacktrace{Frames: frames},
+		}}},
+		Request: req,
+	}
+}
+
+// reportError informa un error inesperado, como los del motor que no son
+// timeouts ni indisponibilidad.
+func reportError(ctx context.Context, err error) {
+	reporter.report(ctx, sentryEvent{
+		Level: "error",
+		Exception: sentryExceptions{Values: []sentryException{{
+			Type:  string(errorCodeOf(err, CodeInternal)),
+			Value: err.Error(),
+		}}},
+	})
+}
+
+// requestInfo describe el request sin los headers con credenciales.
+func requestInfo(r *http.Request) *sentryRequest {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	info := &sentryRequest{
+		Method:      r.Method,
+		URL:         scheme + "://" + r.Host + r.URL.Path,
+		QueryString: r.URL.RawQuery,
+		Headers:     map[string]string{},
+	}
+	for name := range r.Header {
+		info.Headers[name] = r.Header.Get(name)
+	}
+	for _, name := range sensitiveHeaders {
+		if _, ok := info.Headers[name]; ok {
+			info.Headers[name] = "[Filtered]"
+		}
+	}
+	return info
+}
+
+// stackFrames devuelve el stack del llamador, del frame más externo al más
+// interno como espera Sentry. skip es cuántos frames se omiten a partir del
+// llamador de stackFrames, incluido.
+func stackFrames(skip int) []sentryFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	var frames []sentryFrame
+	it := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := it.Next()
+		frames = append(frames, sentryFrame{
+			Function: f.Function,
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    strings.HasPrefix(f.Function, "main."),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+// recoverer reemplaza a middleware.Recoverer: responde 500 INTERNAL_ERROR,
+// registra el panic con su stack y lo informa al error tracker.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			// Se omiten esta función y runtime.gopanic
+			reportPanic(r, v, stackFrames(2))
+			loggerFrom(r.Context()).Error("panic recovered", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if r.Header.Get("Connection") != "Upgrade" {
+				writeProblem(w, r, newProblem(CodeInternal, "Error interno inesperado"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// exportSigner firma el manifiesto de los paquetes de exportación.
+var exportSigner ed25519.PrivateKey
+
+// setupExportSigner carga la clave de firma (seed Ed25519 de 32 bytes en
+// base64). Sin clave se genera una efímera, válida hasta el próximo reinicio.
+func setupExportSigner(seed string) (ephemeral bool, err error) {
+	if seed == "" {
+		_, exportSigner, err = ed25519.GenerateKey(rand.Reader)
+		return true, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil || len(raw) != ed25519.SeedSize {
+		return false, fmt.Errorf("OCR_EXPORT_SIGNING_KEY debe ser un seed Ed25519 de %d bytes en base64", ed25519.SeedSize)
+	}
+	exportSigner = ed25519.NewKeyFromSeed(raw)
+	return false, nil
+}
+
+func exportPublicKey() string {
+	return hex.EncodeToString(exportSigner.Public().(ed25519.PublicKey))
+}
+
+// ExportManifest describe el contenido del paquete; manifest.sig es la
+// firma Ed25519 de manifest.json tal como está en el zip.
+type ExportManifest struct {
+	JobID         string       `json:"job_id"`
+	Key           string       `json:"key"`
+	URL           string       `json:"url"`
+	ExportedAt    time.Time    `json:"exported_at"`
+	Files         []ExportFile `json:"files"`
+	OriginalError string       `json:"original_error,omitempty"`
+	Algorithm     string       `json:"signature_algorithm"`
+	PublicKey     string       `json:"public_key"`
+}
+
+// ExportFile es un archivo del paquete con su hash.
+type ExportFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// EngineVersion es un motor que intervino en el job.
+type EngineVersion struct {
+	Engine  string `json:"engine"`
+	Version string `json:"version"`
+}
+
+// AuditEntry es un evento del ciclo de vida del job o de su revisión.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// buildExport arma el zip de un job terminado: original, resultado, traza,
+// motores, auditoría y el manifiesto firmado.
+func buildExport(ctx context.Context, job Job) ([]byte, error) {
+	manifest := ExportManifest{
+		JobID:      job.ID,
+		Key:        job.Item.Key,
+		URL:        job.Item.URL,
+		ExportedAt: time.Now().UTC(),
+		Algorithm:  "ed25519",
+		PublicKey:  exportPublicKey(),
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: manifest.ExportedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, ExportFile{Name: name, Size: len(data), SHA256: hex.EncodeToString(sum[:])})
+		return nil
+	}
+	addJSON := func(name string, v any) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, data)
+	}
+
+	// El original se vuelve a descargar; si ya no está disponible el paquete
+	// se genera igual y el manifiesto lo indica.
+	original, _, err := fetchOriginal(ctx, job.Item.URL)
+	if err != nil {
+		manifest.OriginalError = err.Error()
+	} else if err := add(originalName(job.Item.URL), original); err != nil {
+		return nil, err
+	}
+
+	if err := addJSON("result.json", job.Result); err != nil {
+		return nil, err
+	}
+	if err := addJSON("trace.json", job.Trace); err != nil {
+		return nil, err
+	}
+	if err := addJSON("engines.json", usedEngines(job.Trace)); err != nil {
+		return nil, err
+	}
+	if err := addJSON("audit.json", jobAudit(job)); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := add("manifest.json", data); err != nil {
+		return nil, err
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(exportSigner, data))
+	if err := add("manifest.sig", []byte(sig)); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// usedEngines devuelve los motores y versiones registrados en la traza.
+func usedEngines(trace []TraceEvent) []EngineVersion {
+	out := []EngineVersion{}
+	seen := map[EngineVersion]bool{}
+	for _, ev := range trace {
+		if ev.Engine == "" {
+			continue
+		}
+		v := EngineVersion{ev.Engine, ev.EngineVersion}
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// jobAudit reconstruye el ciclo de vida del job y agrega las anotaciones de
+// revisión hechas sobre su resultado.
+func jobAudit(job Job) []AuditEntry {
+	entries := []AuditEntry{{Time: job.CreatedAt, Action: "job.submitted", Detail: job.Item.URL}}
+	for _, ev := range job.Trace {
+		if ev.Stage == "attempt" {
+			entries = append(entries, AuditEntry{Time: ev.Time, Action: "job.started", Detail: ev.Detail})
+		}
+	}
+	entries = append(entries, AuditEntry{Time: job.UpdatedAt, Action: "job." + job.Status})
+
+	// Solo si el resultado guardado para la key sigue siendo el de este job
+	if res, ok, err := results.Get(job.tenant(), job.Item.Key); err == nil && ok && !res.CreatedAt.After(job.UpdatedAt) {
+		for _, a := range res.Annotations {
+			entries = append(entries, AuditEntry{Time: a.CreatedAt, Action: "annotation." + a.Type, Actor: a.Author, Detail: a.ID})
+		}
+	}
+	return entries
+}
+
+// GET /ocr/jobs/{id}/export -> zip firmado para auditoría
+func handleExportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok, err := tenantJob(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, errJobNotFound.Error()))
+		return
+	}
+	if !job.finished() {
+		writeProblem(w, r, newProblem(CodeConflict, "El job todavía no terminó"))
+		return
+	}
+
+	data, err := buildExport(r.Context(), job)
+	if err != nil {
+		writeProblem(w, r, newProblem(errorCodeOf(err, CodeInternal), err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="job-%s.zip"`, job.ID))
+	w.Write(data)
+}
+
+// GET /ocr/exports/public-key -> clave para verificar manifest.sig
+func handleExportPublicKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"algorithm":  "ed25519",
+		"public_key": exportPublicKey(),
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Las URLs de los clientes (originales, manifiestos de schedules y
+// preflight) las descarga el servidor, desde su red: sin control, un cliente
+// podría usarlas para llegar a servicios internos (SSRF). fetchClient se
+// conecta solo a direcciones públicas, verificadas con la IP ya resuelta al
+// conectar, también en cada redirección, y con un tiempo máximo.
+// OCR_FETCH_ALLOWED_NETWORKS habilita redes internas puntuales. No usa
+// HTTP_PROXY: detrás de un proxy la IP que se verifica sería la del proxy.
+
+// maxFetchRedirects acota las redirecciones de una descarga.
+const maxFetchRedirects = 5
+
+// deniedNetworks son las redes no públicas que netip no clasifica: "esta
+// red", CGNAT, asignaciones de IETF, benchmarking y reservadas.
+var deniedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// errFetchDenied es el error de las URLs que resuelven a una dirección no
+// permitida.
+var errFetchDenied = errors.New("dirección no permitida")
+
+var fetchClient = newFetchClient(FetchConfig{Timeout: 30 * time.Second})
+
+func setupFetch(cfg FetchConfig) {
+	fetchClient = newFetchClient(cfg)
+}
+
+// allowed indica si se puede descargar de ip.
+func (cfg FetchConfig) allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range cfg.AllowedNetworks {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, p := range deniedNetworks {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func newFetchClient(cfg FetchConfig) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		// address ya viene resuelto: se verifica la IP a la que se conecta
+		Control: func(_, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !cfg.allowed(ap.Addr()) {
+				return fmt.Errorf("%w: %s", errFetchDenied, ap.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("más de %d redirecciones", maxFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirección a %s:// no permitida", req.URL.Scheme)
+			}
+			// Los hostnames se verifican al conectar
+			if ip, err := netip.ParseAddr(strings.Trim(req.URL.Hostname(), "[]")); err == nil && !cfg.allowed(ip) {
+				return fmt.Errorf("%w: %s", errFetchDenied, ip)
+			}
+			return nil
+		},
+	}
+}
+
+// parseNetworks interpreta una lista de CIDRs separados por comas.
+func parseNetworks(key, raw string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for part := range strings.SplitSeq(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q no es un CIDR (p. ej. 10.0.0.0/8)", key, part)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
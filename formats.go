@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Formatos de salida de un resultado.
+const (
+	formatJSON = "json"
+	formatText = "text"
+	formatHOCR = "hocr"
+	formatALTO = "alto"
+)
+
+var outputFormats = []string{formatJSON, formatText, formatHOCR, formatALTO}
+
+// formatMediaTypes asocia los media types de Accept a cada formato.
+var formatMediaTypes = []struct{ mediaType, format string }{
+	{"application/json", formatJSON},
+	{"text/plain", formatText},
+	{"text/vnd.hocr+html", formatHOCR},
+	{"application/xhtml+xml", formatHOCR},
+	{"text/html", formatHOCR},
+	{"application/alto+xml", formatALTO},
+	{"application/xml", formatALTO},
+	{"text/xml", formatALTO},
+}
+
+// negotiateFormat elige el formato por el parámetro format= o, si no está,
+// por el header Accept (respetando q). Sin Accept o con */* es JSON.
+func negotiateFormat(r *http.Request) (string, *Problem) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if !slices.Contains(outputFormats, f) {
+			p := newProblem(CodeInvalidInput, "Formato de salida desconocido")
+			p.InvalidParams = []InvalidParam{{Name: "format", Reason: "debe ser uno de: " + strings.Join(outputFormats, ", ")}}
+			return "", &p
+		}
+		return f, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, nil
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		format := ""
+		switch mediaType {
+		case "*/*", "application/*":
+			format = formatJSON
+		default:
+			for _, m := range formatMediaTypes {
+				if m.mediaType == mediaType {
+					format = m.format
+					break
+				}
+			}
+		}
+		if format != "" && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best == "" {
+		p := newProblem(CodeNotAcceptable, "Formatos disponibles: application/json, text/plain, text/vnd.hocr+html, application/alto+xml")
+		return "", &p
+	}
+	return best, nil
+}
+
+// mediaTypeNDJSON es el formato de streaming de /ocr/batch: un resultado
+// JSON por línea, en el orden en que terminan.
+const mediaTypeNDJSON = "application/x-ndjson"
+
+// acceptsNDJSON indica si el header Accept prefiere NDJSON a JSON.
+func acceptsNDJSON(r *http.Request) bool {
+	ndjsonQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case mediaTypeNDJSON:
+			ndjsonQ = max(ndjsonQ, q)
+		case "application/json", "*/*", "application/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return ndjsonQ > 0 && ndjsonQ >= jsonQ
+}
+
+// needsPages indica si el formato se arma por página.
+func needsPages(format string) bool {
+	return format == formatHOCR || format == formatALTO
+}
+
+// writeResult escribe un resultado exitoso en el formato negociado.
+func writeResult(w http.ResponseWriter, format string, resp *APIResponse) {
+	switch format {
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if resp.Truncated {
+			w.Header().Set("X-Continuation-Token", resp.ContinuationToken)
+		}
+	case formatHOCR:
+		w.Header().Set("Content-Type", "text/vnd.hocr+html; charset=utf-8")
+	case formatALTO:
+		w.Header().Set("Content-Type", "application/alto+xml; charset=utf-8")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(renderResult(format, resp))
+}
+
+// renderResult arma el resultado en el formato pedido.
+func renderResult(format string, resp *APIResponse) []byte {
+	switch format {
+	case formatText:
+		return []byte(resp.Body)
+	case formatHOCR:
+		return renderHOCR(resp)
+	case formatALTO:
+		return renderALTO(resp)
+	}
+	data, _ := json.Marshal(resp)
+	return append(data, '\n')
+}
+
+// resultPages devuelve las páginas del resultado; si no se guardaron, todo
+// el texto como una única página.
+func resultPages(resp *APIResponse) []PageResult {
+	if len(resp.Pages) > 0 {
+		return resp.Pages
+	}
+	return []PageResult{{Number: 1, Text: resp.Body, Confidence: resp.Confidence, Engine: resp.Engine}}
+}
+
+// pageLines devuelve las líneas con texto de la página.
+func pageLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// renderHOCR arma un documento hOCR con páginas, líneas y palabras. El
+// motor no devuelve posiciones, así que no hay bbox; x_wconf es la
+// confianza de la página.
+func renderHOCR(resp *APIResponse) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">` + "\n")
+	b.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="es" lang="es">` + "\n<head>\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(resp.Key))
+	b.WriteString(`<meta http-equiv="Content-Type" content="text/html;charset=utf-8" />` + "\n")
+	fmt.Fprintf(&b, "<meta name=\"ocr-system\" content=\"api-ocr %s\" />\n", html.EscapeString(resp.Engine))
+	b.WriteString(`<meta name="ocr-capabilities" content="ocr_page ocr_line ocrx_word" />` + "\n</head>\n<body>\n")
+	for _, p := range resultPages(resp) {
+		fmt.Fprintf(&b, "<div class=\"ocr_page\" id=\"page_%d\" title=\"ppageno %d\">\n", p.Number, p.Number-1)
+		wconf := int(p.Confidence * 100)
+		for i, line := range pageLines(p.Text) {
+			fmt.Fprintf(&b, " <span class=\"ocr_line\" id=\"line_%d_%d\">", p.Number, i+1)
+			for j, word := range strings.Fields(line) {
+				if j > 0 {
+					b.WriteString(" ")
+				}
+				fmt.Fprintf(&b, "<span class=\"ocrx_word\" id=\"word_%d_%d_%d\" title=\"x_wconf %d\">%s</span>",
+					p.Number, i+1, j+1, wconf, html.EscapeString(word))
+			}
+			b.WriteString("</span>\n")
+		}
+		b.WriteString("</div>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// Elementos de ALTO v4 que se generan. Sin posiciones: HPOS, VPOS, WIDTH y
+// HEIGHT son opcionales en ALTO 4.
+type altoDocument struct {
+	XMLName     xml.Name `xml:"alto"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	Description struct {
+		MeasurementUnit string `xml:"MeasurementUnit"`
+		OCRProcessing   struct {
+			ID   string `xml:"ID,attr"`
+			Step struct {
+				Software struct {
+					Name    string `xml:"softwareName"`
+					Version string `xml:"softwareVersion,omitempty"`
+				} `xml:"processingSoftware"`
+			} `xml:"ocrProcessingStep"`
+		} `xml:"OCRProcessing"`
+	} `xml:"Description"`
+	Pages []altoPage `xml:"Layout>Page"`
+}
+
+type altoPage struct {
+	ID         string          `xml:"ID,attr"`
+	PhysicalNr int             `xml:"PHYSICAL_IMG_NR,attr"`
+	PC         float64         `xml:"PC,attr,omitempty"`
+	Blocks     []altoTextBlock `xml:"PrintSpace>TextBlock"`
+}
+
+type altoTextBlock struct {
+	ID    string         `xml:"ID,attr"`
+	Lines []altoTextLine `xml:"TextLine"`
+}
+
+type altoTextLine struct {
+	ID      string `xml:"ID,attr"`
+	Content []any
+}
+
+type altoString struct {
+	XMLName xml.Name `xml:"String"`
+	ID      string   `xml:"ID,attr"`
+	Content string   `xml:"CONTENT,attr"`
+	WC      float64  `xml:"WC,attr"`
+}
+
+type altoSpace struct {
+	XMLName xml.Name `xml:"SP"`
+}
+
+// renderALTO arma un documento ALTO v4 con un TextBlock por página.
+func renderALTO(resp *APIResponse) []byte {
+	doc := altoDocument{Xmlns: "http://www.loc.gov/standards/alto/ns-v4#"}
+	doc.Description.MeasurementUnit = "pixel"
+	doc.Description.OCRProcessing.ID = "OCR_0"
+	doc.Description.OCRProcessing.Step.Software.Name = "api-ocr"
+	doc.Description.OCRProcessing.Step.Software.Version = resp.Engine
+
+	for _, p := range resultPages(resp) {
+		page := altoPage{ID: fmt.Sprintf("P%d", p.Number), PhysicalNr: p.Number, PC: p.Confidence}
+		block := altoTextBlock{ID: fmt.Sprintf("P%d_TB1", p.Number)}
+		for i, line := range pageLines(p.Text) {
+			tl := altoTextLine{ID: fmt.Sprintf("P%d_TL%d", p.Number, i+1)}
+			for j, word := range strings.Fields(line) {
+				if j > 0 {
+					tl.Content = append(tl.Content, altoSpace{})
+				}
+				tl.Content = append(tl.Content, altoString{
+					ID:      fmt.Sprintf("P%d_TL%d_S%d", p.Number, i+1, j+1),
+					Content: word,
+					WC:      p.Confidence,
+				})
+			}
+			block.Lines = append(block.Lines, tl)
+		}
+		if len(block.Lines) > 0 {
+			page.Blocks = append(page.Blocks, block)
+		}
+		doc.Pages = append(doc.Pages, page)
+	}
+
+	out, _ := xml.MarshalIndent(doc, "", "  ")
+	return append([]byte(xml.Header), append(out, '\n')...)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Modo "gateway": endpoints que aceptan el formato de request de APIs de
+// OCR conocidas (Google Vision, AWS Textract) para que el código cliente
+// existente apunte a este servicio cambiando solo el endpoint. Solo se
+// admiten imágenes por referencia; el contenido inline se rechaza con el
+// error propio de cada API.
+
+// gatewayObjectURL traduce una referencia a un objeto de bucket a la URL
+// HTTPS equivalente: gs://bucket/obj y s3://bucket/obj.
+func gatewayObjectURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	switch u.Scheme {
+	case "gs":
+		return "https://storage.googleapis.com/" + u.Host + u.Path
+	case "s3":
+		return s3ObjectURL(u.Host, strings.TrimPrefix(u.Path, "/"))
+	}
+	return raw
+}
+
+func s3ObjectURL(bucket, key string) string {
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucket, awsEscapePath(key))
+}
+
+// recognizeGatewayItems procesa las URLs como un batch y devuelve un
+// resultado por URL, con las páginas siempre incluidas para poder armar la
+// respuesta de cada API. Las URLs inválidas no se procesan.
+func recognizeGatewayItems(ctx context.Context, limits LimitsConfig, prefix string, urls []string) []APIResponse {
+	out := make([]APIResponse, len(urls))
+	var items []OCRRequest
+	var index []int
+	includePages := true
+	for i, u := range urls {
+		key := prefix + "-" + newID(8)
+		if reason := checkURL(u, limits); reason != "" || u == "" {
+			if reason == "" {
+				reason = "falta la URL de la imagen"
+			}
+			out[i] = *errorResponse(key, CodeInvalidInput, "url: "+reason)
+			continue
+		}
+		items = append(items, OCRRequest{Key: key, URL: u, Priority: priorityNormal, IncludePages: &includePages})
+		index = append(index, i)
+	}
+	if err := checkQuota(ctx, uniqueItems(items, true)); err != nil && len(items) > 0 {
+		for j, item := range items {
+			out[index[j]] = *errorResponse(item.Key, errorCodeOf(err, CodeQueueUnavailable), err.Error())
+		}
+		return out
+	}
+	if len(items) > 0 {
+		batch := processBatchOCR(ctx, items, true, nil)
+		for j, res := range batch.Results {
+			out[index[j]] = res
+		}
+	}
+	return out
+}
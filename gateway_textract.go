@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Operaciones de Textract (protocolo JSON de AWS, por X-Amz-Target) que se
+// traducen a OCR de texto. AnalyzeDocument ignora FeatureTypes.
+var textractOperations = map[string]bool{
+	"Textract.DetectDocumentText": true,
+	"Textract.AnalyzeDocument":    true,
+}
+
+type textractRequest struct {
+	Document struct {
+		Bytes    string `json:"Bytes"`
+		S3Object *struct {
+			Bucket string `json:"Bucket"`
+			Name   string `json:"Name"`
+		} `json:"S3Object"`
+	} `json:"Document"`
+}
+
+type textractResponse struct {
+	DocumentMetadata struct {
+		Pages int `json:"Pages"`
+	} `json:"DocumentMetadata"`
+	Blocks                         []textractBlock `json:"Blocks"`
+	DetectDocumentTextModelVersion string          `json:"DetectDocumentTextModelVersion"`
+}
+
+type textractBlock struct {
+	BlockType     string                 `json:"BlockType"` // PAGE | LINE | WORD
+	ID            string                 `json:"Id"`
+	Page          int                    `json:"Page"`
+	Text          string                 `json:"Text,omitempty"`
+	Confidence    float64                `json:"Confidence,omitempty"` // 0-100
+	Relationships []textractRelationship `json:"Relationships,omitempty"`
+}
+
+type textractRelationship struct {
+	Type string   `json:"Type"`
+	IDs  []string `json:"Ids"`
+}
+
+// textractErrors mapea los códigos propios a excepciones de Textract.
+var textractErrors = map[ErrorCode]string{
+	CodeInvalidInput:      "InvalidParameterException",
+	CodeFetchFailed:       "InvalidS3ObjectException",
+	CodeUnsupportedFormat: "UnsupportedDocumentException",
+	CodePayloadTooLarge:   "DocumentTooLargeException",
+	CodeQuotaExceeded:     "ProvisionedThroughputExceededException",
+	CodeEngineUnavailable: "ThrottlingException",
+	CodeQueueUnavailable:  "ThrottlingException",
+}
+
+// POST /compat/textract con X-Amz-Target: Textract.DetectDocumentText
+func handleTextract(limits LimitsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		if !textractOperations[target] {
+			writeTextractError(w, http.StatusBadRequest, "UnknownOperationException", fmt.Sprintf("operación no soportada: %q", target))
+			return
+		}
+
+		var in textractRequest
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+		if err == nil {
+			err = json.Unmarshal(body, &in)
+		}
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr):
+			writeTextractError(w, http.StatusBadRequest, "DocumentTooLargeException", fmt.Sprintf("El body supera el máximo de %d bytes", limits.MaxBodyBytes))
+			return
+		case err != nil:
+			writeTextractError(w, http.StatusBadRequest, "SerializationException", "JSON inválido: "+err.Error())
+			return
+		case in.Document.Bytes != "":
+			writeTextractError(w, http.StatusBadRequest, "InvalidParameterException", "Document.Bytes no está soportado; use Document.S3Object")
+			return
+		case in.Document.S3Object == nil || in.Document.S3Object.Bucket == "" || in.Document.S3Object.Name == "":
+			writeTextractError(w, http.StatusBadRequest, "InvalidParameterException", "Document.S3Object requiere Bucket y Name")
+			return
+		}
+
+		url := s3ObjectURL(in.Document.S3Object.Bucket, in.Document.S3Object.Name)
+		res := recognizeGatewayItems(r.Context(), limits, "textract", []string{url})[0]
+		if res.ErrorCode != "" {
+			kind, ok := textractErrors[res.ErrorCode]
+			status := http.StatusBadRequest
+			if !ok {
+				kind, status = "InternalServerError", http.StatusInternalServerError
+			}
+			writeTextractError(w, status, kind, res.Err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(textractResult(res))
+	}
+}
+
+// textractResult arma los bloques PAGE, LINE y WORD a partir de las páginas.
+// No hay geometría: el motor no devuelve posiciones.
+func textractResult(res APIResponse) textractResponse {
+	var out textractResponse
+	out.DetectDocumentTextModelVersion = "1.0"
+	out.Blocks = []textractBlock{}
+	out.DocumentMetadata.Pages = len(res.Pages)
+	for _, p := range res.Pages {
+		conf := math.Round(p.Confidence*100*1000) / 1000
+		page := textractBlock{BlockType: "PAGE", ID: newID(16), Page: p.Number}
+		var blocks []textractBlock
+		for _, line := range strings.Split(p.Text, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			lineBlock := textractBlock{BlockType: "LINE", ID: newID(16), Page: p.Number, Text: line, Confidence: conf}
+			var words []textractBlock
+			for _, word := range strings.Fields(line) {
+				words = append(words, textractBlock{BlockType: "WORD", ID: newID(16), Page: p.Number, Text: word, Confidence: conf})
+			}
+			lineBlock.Relationships = childRelationship(words)
+			blocks = append(blocks, lineBlock)
+			blocks = append(blocks, words...)
+		}
+		var lines []textractBlock
+		for _, b := range blocks {
+			if b.BlockType == "LINE" {
+				lines = append(lines, b)
+			}
+		}
+		page.Relationships = childRelationship(lines)
+		out.Blocks = append(out.Blocks, page)
+		out.Blocks = append(out.Blocks, blocks...)
+	}
+	return out
+}
+
+func childRelationship(children []textractBlock) []textractRelationship {
+	if len(children) == 0 {
+		return nil
+	}
+	ids := make([]string, len(children))
+	for i, c := range children {
+		ids[i] = c.ID
+	}
+	return []textractRelationship{{Type: "CHILD", IDs: ids}}
+}
+
+func writeTextractError(w http.ResponseWriter, status int, kind, msg string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Header().Set("X-Amzn-ErrorType", kind)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"__type": kind, "message": msg})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Formato de images:annotate de Google Cloud Vision (v1).
+type visionAnnotateRequest struct {
+	Requests []struct {
+		Image struct {
+			Content string `json:"content"`
+			Source  struct {
+				ImageURI    string `json:"imageUri"`
+				GCSImageURI string `json:"gcsImageUri"`
+			} `json:"source"`
+		} `json:"image"`
+		Features []struct {
+			Type string `json:"type"`
+		} `json:"features"`
+	} `json:"requests"`
+}
+
+type visionAnnotateResponse struct {
+	Responses []visionImageResponse `json:"responses"`
+}
+
+type visionImageResponse struct {
+	TextAnnotations    []visionEntity      `json:"textAnnotations,omitempty"`
+	FullTextAnnotation *visionTextDocument `json:"fullTextAnnotation,omitempty"`
+	Error              *visionStatus       `json:"error,omitempty"`
+}
+
+type visionEntity struct {
+	Description string `json:"description"`
+}
+
+type visionTextDocument struct {
+	Text  string       `json:"text"`
+	Pages []visionPage `json:"pages"`
+}
+
+type visionPage struct {
+	Confidence float64 `json:"confidence"`
+}
+
+// visionStatus es un google.rpc.Status.
+type visionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status,omitempty"`
+}
+
+// Features de Vision que producen texto; las demás se ignoran.
+var visionTextFeatures = map[string]bool{"TEXT_DETECTION": true, "DOCUMENT_TEXT_DETECTION": true}
+
+// visionCodes mapea los códigos propios a códigos gRPC.
+var visionCodes = map[ErrorCode]int{
+	CodeInvalidInput:        3,  // INVALID_ARGUMENT
+	CodeFetchFailed:         3,  // INVALID_ARGUMENT
+	CodeUnsupportedFormat:   3,  // INVALID_ARGUMENT
+	CodeEngineTimeout:       4,  // DEADLINE_EXCEEDED
+	CodeNotFound:            5,  // NOT_FOUND
+	CodeQuotaExceeded:       8,  // RESOURCE_EXHAUSTED
+	CodeRequestCancelled:    1,  // CANCELLED
+	CodeEngineUnavailable:   14, // UNAVAILABLE
+	CodeQueueUnavailable:    14, // UNAVAILABLE
+	CodeInsufficientStorage: 14, // UNAVAILABLE
+}
+
+// POST /compat/vision/v1/images:annotate
+func handleVisionAnnotate(limits LimitsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in visionAnnotateRequest
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+		if err == nil {
+			err = json.Unmarshal(body, &in)
+		}
+		if err != nil {
+			status, msg := http.StatusBadRequest, "JSON inválido: "+err.Error()
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				status, msg = http.StatusRequestEntityTooLarge, fmt.Sprintf("El body supera el máximo de %d bytes", limits.MaxBodyBytes)
+			}
+			writeVisionError(w, status, "INVALID_ARGUMENT", msg)
+			return
+		}
+		if len(in.Requests) == 0 || len(in.Requests) > limits.MaxBatchItems {
+			writeVisionError(w, http.StatusBadRequest, "INVALID_ARGUMENT",
+				fmt.Sprintf("requests debe tener entre 1 y %d ítems", limits.MaxBatchItems))
+			return
+		}
+
+		out := visionAnnotateResponse{Responses: make([]visionImageResponse, len(in.Requests))}
+		var urls []string
+		var index []int
+		for i, req := range in.Requests {
+			wantsText := false
+			for _, f := range req.Features {
+				wantsText = wantsText || visionTextFeatures[f.Type]
+			}
+			switch {
+			case !wantsText:
+				// Sin features de texto la respuesta de Vision viene vacía
+			case req.Image.Content != "":
+				out.Responses[i].Error = &visionStatus{Code: 3, Message: "image.content no está soportado; use image.source.imageUri"}
+			default:
+				src := req.Image.Source.ImageURI
+				if src == "" {
+					src = req.Image.Source.GCSImageURI
+				}
+				urls = append(urls, gatewayObjectURL(src))
+				index = append(index, i)
+			}
+		}
+
+		for j, res := range recognizeGatewayItems(r.Context(), limits, "vision", urls) {
+			out.Responses[index[j]] = visionResult(res)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// visionResult arma la respuesta de Vision para una imagen.
+func visionResult(res APIResponse) visionImageResponse {
+	if res.ErrorCode != "" {
+		code, ok := visionCodes[res.ErrorCode]
+		if !ok {
+			code = 13 // INTERNAL
+		}
+		return visionImageResponse{Error: &visionStatus{Code: code, Message: res.Err}}
+	}
+	doc := &visionTextDocument{Text: res.Body, Pages: []visionPage{}}
+	for _, p := range res.Pages {
+		doc.Pages = append(doc.Pages, visionPage{Confidence: p.Confidence})
+	}
+	return visionImageResponse{
+		TextAnnotations:    []visionEntity{{Description: res.Body}},
+		FullTextAnnotation: doc,
+	}
+}
+
+func writeVisionError(w http.ResponseWriter, status int, grpcStatus, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]visionStatus{
+		"error": {Code: status, Message: msg, Status: grpcStatus},
+	})
+}
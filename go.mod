@@ -1,5 +1,24 @@
 module api-ocr
 
-go 1.25
+go 1.25.0
 
-require github.com/go-chi/chi/v5 v5.2.3
+require (
+	github.com/gen2brain/heic v0.7.1
+	github.com/go-chi/chi/v5 v5.2.3
+	github.com/redis/go-redis/v9 v9.22.0
+	github.com/spf13/cobra v1.10.2
+	golang.org/x/crypto v0.45.0
+	golang.org/x/image v0.34.0
+	golang.org/x/net v0.47.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/ebitengine/purego v0.10.1 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.9 // indirect
+	github.com/tetratelabs/wazero v1.12.0 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/sys v0.44.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+)
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HealthStatus es la respuesta de /health.
+type HealthStatus struct {
+	Status  string                   `json:"status"`
+	Engines map[string]BreakerStatus `json:"engines"`
+	Mode    string                   `json:"mode,omitempty"` // OCR_MODE, salvo full
+}
+
+// GET /health -> "degraded" si el circuit breaker del motor primario no está cerrado
+func handleHealth(w http.ResponseWriter, _ *http.Request) {
+	out := HealthStatus{Status: "ok", Engines: map[string]BreakerStatus{}}
+	if serveMode != serveFull {
+		out.Mode = serveMode
+	}
+	for _, e := range activeEngines() {
+		out.Engines[e.Name()] = e.breaker.status()
+	}
+	if out.Engines[primaryEngine().Name()].State != breakerClosed {
+		out.Status = "degraded"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// POST /ocr  -> recibe {key,url} y responde un OCR "mock"
+func handleOCR(w http.ResponseWriter, r *http.Request) {
+	var in OCRRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Key == "" || in.URL == "" {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera {key,url}"))
+		return
+	}
+	if in.ProcessingClass == classEconomy {
+		p := newProblem(CodeInvalidInput, "La request contiene campos inválidos")
+		p.InvalidParams = []InvalidParam{economyOnlyAsync}
+		writeProblem(w, r, p)
+		return
+	}
+	addLogAttrs(r.Context(), slog.String("key", in.Key))
+
+	format, problem := negotiateFormat(r)
+	if problem != nil {
+		writeProblem(w, r, *problem)
+		return
+	}
+	if needsPages(format) {
+		includePages := true
+		in.IncludePages = &includePages
+	}
+	if in.Priority == "" {
+		in.Priority = priorityNormal
+	}
+	if in.MaxWaitMs > 0 {
+		if problem := checkMaxWait(r, in.MaxWaitMs); problem != nil {
+			writeProblem(w, r, *problem)
+			return
+		}
+		ocrWithMaxWait(w, r, in, format)
+		return
+	}
+	if !admit(w, r, in.Priority) {
+		return
+	}
+
+	// Crear canal para recibir el resultado del procesamiento
+	resultChan := make(chan *APIResponse, 1)
+
+	// Ejecutar procesamiento OCR en el pool de workers
+	go func() {
+		result, _ := pool.run(r.Context(), in)
+		resultChan <- result
+	}()
+
+	// Esperar resultado o timeout
+	select {
+	case result := <-resultChan:
+		writeOCRResponse(w, r, in, format, result)
+	case <-r.Context().Done():
+		// Timeout de la ruta o el cliente canceló la request
+		p := newProblem(errorCodeOf(r.Context().Err(), CodeRequestCancelled), r.Context().Err().Error())
+		p.Key = in.Key
+		if p.Timeout = timeoutInfo(r.Context(), r.Context().Err(), ""); p.Timeout != nil {
+			p.Detail = p.Timeout.Detail()
+		}
+		writeProblem(w, r, p)
+	}
+}
+
+// writeOCRResponse responde el resultado de /ocr o, si falló, su problem.
+func writeOCRResponse(w http.ResponseWriter, r *http.Request, in OCRRequest, format string, result *APIResponse) {
+	if result.ErrorCode != "" {
+		p := newProblem(result.ErrorCode, result.Err)
+		p.Key = in.Key
+		p.Timeout, p.Quality = result.Timeout, result.Quality
+		if p.Rejection = result.Rejection; p.Rejection != nil && in.IncludeRejectedText {
+			p.Result = result
+		}
+		writeProblem(w, r, p)
+		return
+	}
+	writeResult(w, format, result)
+}
+
+// POST /ocr/batch -> recibe {items: [{key,url},...]} y responde {results: [{key,status_code,full_text,err},...]}
+func handleBatchOCR(w http.ResponseWriter, r *http.Request) {
+	var batchReq BatchOCRRequest
+	if err := json.NewDecoder(r.Body).Decode(&batchReq); err != nil || len(batchReq.Items) == 0 {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera {items: [{key,url},...]}"))
+		return
+	}
+
+	// Validate all items have required fields
+	var invalid []InvalidParam
+	for i, item := range batchReq.Items {
+		if item.Key == "" || item.URL == "" {
+			invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("items[%d]", i), Reason: "key y url son requeridos"})
+		}
+		if item.ProcessingClass == classEconomy {
+			invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("items[%d].", i) + economyOnlyAsync.Name, Reason: economyOnlyAsync.Reason})
+		}
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "Hay ítems incompletos o inválidos")
+		p.InvalidParams = invalid
+		writeProblem(w, r, p)
+		return
+	}
+
+	if !admit(w, r, batchPriority(batchReq.Items)) {
+		return
+	}
+
+	// Process batch
+	addLogAttrs(r.Context(), slog.Int("items", len(batchReq.Items)))
+	if acceptsNDJSON(r) {
+		streamBatchOCR(w, r, batchReq.Items, batchReq.dedup())
+		return
+	}
+	result := processBatchOCR(r.Context(), batchReq.Items, batchReq.dedup(), nil)
+	failed := 0
+	for _, res := range result.Results {
+		if res.ErrorCode != "" {
+			failed++
+		}
+	}
+	addLogAttrs(r.Context(), slog.Int("items_failed", failed))
+	writeBatchResponse(w, result)
+}
+
+// batchFlushItems es cada cuántos resultados se envía lo ya escrito de la
+// respuesta de /ocr/batch.
+const batchFlushItems = 50
+
+// writeBatchResponse escribe la respuesta de /ocr/batch resultado por
+// resultado: con PDFs de cientos de páginas el JSON pesa varios MB, y así
+// no se arma entero en memoria antes de empezar a enviarlo. Como se envía
+// antes de conocer el largo, va con Transfer-Encoding: chunked.
+func writeBatchResponse(w http.ResponseWriter, out *BatchAPIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if out.BatchID != "" {
+		id, _ := json.Marshal(out.BatchID)
+		fmt.Fprintf(w, `{"batch_id":%s,"results":[`, id)
+	} else {
+		io.WriteString(w, `{"results":[`)
+	}
+	enc := json.NewEncoder(w)
+	for i, res := range out.Results {
+		if i > 0 {
+			io.WriteString(w, ",")
+			if i%batchFlushItems == 0 {
+				rc.Flush()
+			}
+		}
+		enc.Encode(res)
+	}
+	io.WriteString(w, "]}\n")
+}
+
+// BatchStreamItem es una línea de la respuesta NDJSON de /ocr/batch.
+type BatchStreamItem struct {
+	BatchID string `json:"batch_id"`
+	Index   int    `json:"index"`
+	APIResponse
+}
+
+// streamBatchOCR responde el batch como NDJSON, escribiendo cada resultado
+// apenas termina. index es la posición del ítem en el request.
+func streamBatchOCR(w http.ResponseWriter, r *http.Request, items []OCRRequest, dedup bool) {
+	w.Header().Set("Content-Type", mediaTypeNDJSON)
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	failed := 0
+	processBatchOCR(r.Context(), items, dedup, func(batchID string, i int, resp *APIResponse) {
+		if resp.ErrorCode != "" {
+			failed++
+		}
+		enc.Encode(BatchStreamItem{BatchID: batchID, Index: i, APIResponse: *resp})
+		rc.Flush()
+	})
+	addLogAttrs(r.Context(), slog.Int("items_failed", failed))
+}
+
+// GET /problems -> catálogo de códigos de error
+func handleErrorCatalog(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(errorCatalog)
+}
+
+// GET /problems/{slug} -> definición de un código (type URI de los Problem)
+func handleErrorDefinition(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+	for _, d := range errorCatalog {
+		if problemSlug(d.Code) == slug {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(d)
+			return
+		}
+	}
+	handleNotFound(w, r)
+}
+
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(CodeNotFound, "No existe "+r.URL.Path))
+}
+
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(CodeMethodNotAllowed, r.Method+" no está soportado en "+r.URL.Path))
+}
@@ -0,0 +1,106 @@
+package main
+
+import "slices"
+
+// Modos de reconocimiento de OCRRequest.Mode. Sin mode se detecta si cada
+// página está impresa o manuscrita y las manuscritas van a los motores que
+// reconocen letra manuscrita.
+const (
+	modePrinted     = "printed"
+	modeHandwritten = "handwritten"
+	modeMixed       = "mixed" // impreso y manuscrito en la misma página
+)
+
+var recognitionModes = []string{modePrinted, modeHandwritten, modeMixed}
+
+// handwrittenTitles son los tipos de documento que en el corpus suelen
+// llegar manuscritos (actas de nacimiento, contratos viejos), con la
+// probabilidad de que lo esté cada página.
+var handwrittenTitles = map[string]float64{
+	"Certificado de nacimiento": 0.7,
+	"Contrato de trabajo":       0.4,
+}
+
+// markHandwritten simula la detección de escritura de las páginas. Usa su
+// propia fuente para no cambiar el resto del documento.
+func markHandwritten(doc *Document) {
+	for i := range doc.Pages {
+		p := &doc.Pages[i]
+		if rate, ok := handwrittenTitles[p.title]; ok {
+			p.handwritten = mockRand.load().source("handwriting", p.seed).Float64() < rate
+		}
+	}
+}
+
+// handwritingEngine lo implementan los motores que pueden reconocer letra
+// manuscrita; los demás solo reconocen texto impreso.
+type handwritingEngine interface {
+	Handwriting() bool
+}
+
+// Handwriting indica si el mock simula un modelo de manuscritos.
+func (e *mockEngine) Handwriting() bool { return e.handwriting }
+
+// Los tres proveedores de nube reconocen manuscritos con el mismo modelo
+// que usan para texto impreso.
+func (e *visionEngine) Handwriting() bool   { return true }
+func (e *textractEngine) Handwriting() bool { return true }
+func (e *azureEngine) Handwriting() bool    { return true }
+
+// engineHandwriting indica si el motor reconoce manuscritos. Se consulta el
+// motor registrado: el del engineSet puede estar envuelto en un
+// engineBatcher.
+func engineHandwriting(e *resilientEngine) bool {
+	hw, ok := engines[e.Name()].(handwritingEngine)
+	return ok && hw.Handwriting()
+}
+
+// handwritingChain devuelve los motores de chain que reconocen manuscritos.
+// Con anyEngine (el request no eligió motor) siguen los demás registrados
+// que los reconocen, por nombre, para que las páginas manuscritas no
+// dependan de que haya uno configurado como primario o fallback.
+func (s engineSet) handwritingChain(chain []*resilientEngine, anyEngine bool) []*resilientEngine {
+	var hw []*resilientEngine
+	for _, e := range chain {
+		if engineHandwriting(e) {
+			hw = append(hw, e)
+		}
+	}
+	if !anyEngine {
+		return hw
+	}
+	for _, name := range engineNames() {
+		if e := s.resilient[name]; engineHandwriting(e) && !slices.Contains(hw, e) {
+			hw = append(hw, e)
+		}
+	}
+	return hw
+}
+
+// pageMode es el modo con que se reconoce la página: el pedido o, sin mode,
+// el detectado.
+func pageMode(mode string, p Page) string {
+	switch {
+	case mode != "":
+		return mode
+	case p.handwritten:
+		return modeHandwritten
+	}
+	return modePrinted
+}
+
+// summarizeModes devuelve el modo usado en las páginas con texto: el de
+// todas o mixed si difieren.
+func summarizeModes(pages []PageResult) string {
+	mode := ""
+	for _, p := range pages {
+		switch {
+		case p.Blank:
+		case mode == "":
+			mode = p.Mode
+		case mode != p.Mode:
+			return modeMixed
+		}
+	}
+	return mode
+}
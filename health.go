@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// readinessTimeout acota cada chequeo de dependencia.
+const readinessTimeout = 2 * time.Second
+
+// readinessCheck verifica que una dependencia esté lista para recibir tráfico.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+var readinessChecks []readinessCheck
+
+// addReadinessCheck registra una dependencia para /health/ready.
+func addReadinessCheck(name string, check func(ctx context.Context) error) {
+	readinessChecks = append(readinessChecks, readinessCheck{name: name, check: check})
+}
+
+// allReadinessChecks son las dependencias registradas más los motores
+// vigentes, que cambian al recargar la configuración.
+func allReadinessChecks() []readinessCheck {
+	checks := slices.Clone(readinessChecks)
+	for _, e := range activeEngines() {
+		checks = append(checks, readinessCheck{name: "engine:" + e.Name(), check: engineReadiness(e)})
+	}
+	return checks
+}
+
+// DependencyStatus es el resultado del chequeo de una dependencia.
+type DependencyStatus struct {
+	Status    string `json:"status"` // ok | error
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// ReadinessStatus es la respuesta de /health/ready.
+type ReadinessStatus struct {
+	Status       string                      `json:"status"` // ready | not_ready
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// GET /health/live -> el proceso está vivo (no verifica dependencias)
+func handleLiveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "alive"})
+}
+
+// GET /health/ready -> 200 si todas las dependencias responden, 503 si no
+func handleReadiness(w http.ResponseWriter, r *http.Request) {
+	out := ReadinessStatus{Status: "ready", Dependencies: map[string]DependencyStatus{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range allReadinessChecks() {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.check(ctx)
+			st := DependencyStatus{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				st.Status = "error"
+				st.Error = err.Error()
+			}
+			mu.Lock()
+			out.Dependencies[c.name] = st
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, d := range out.Dependencies {
+		if d.Status != "ok" {
+			out.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(out)
+}
+
+// pinger lo implementan las dependencias que saben verificar su conexión.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// engineReadiness falla si el circuit breaker del motor está abierto o si
+// el motor no responde a Ping.
+func engineReadiness(e *resilientEngine) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if st := e.breaker.status(); st.State == breakerOpen {
+			return fmt.Errorf("circuit breaker abierto tras %d fallas", st.Failures)
+		}
+		if p, ok := e.OCREngine.(pinger); ok {
+			return p.Ping(ctx)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// JobTransition es un cambio de estado de un job. En los fallos, reason y
+// error_code salen del resultado.
+type JobTransition struct {
+	Status    string    `json:"status"`
+	At        time.Time `json:"at"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	ErrorCode ErrorCode `json:"error_code,omitempty"`
+}
+
+// setStatus cambia el estado del job y lo registra en su historial. Si no
+// se da reason, los fallos y los jobs sin exportar usan el error del
+// resultado, que por eso se asigna antes.
+func (j *Job) setStatus(status, reason string) {
+	now := time.Now().UTC()
+	t := JobTransition{Status: status, At: now, Reason: reason}
+	if status == jobRunning {
+		t.Attempt = j.Attempts
+	}
+	if j.Result != nil {
+		switch status {
+		case jobFailed:
+			t.ErrorCode = j.Result.ErrorCode
+			if t.Reason == "" {
+				t.Reason = j.Result.Err
+			}
+		case jobUnexported:
+			if t.Reason == "" {
+				t.Reason = j.Result.ExportError
+			}
+		}
+	}
+	j.Status = status
+	j.UpdatedAt = now
+	j.History = append(j.History, t)
+}
+
+// JobHistory es el historial de estados de un job. Con ?at= las
+// transiciones llegan hasta ese instante y status es el estado de entonces.
+type JobHistory struct {
+	JobID       string          `json:"job_id"`
+	Status      string          `json:"status"`
+	At          *time.Time      `json:"at,omitempty"`
+	Transitions []JobTransition `json:"transitions"`
+}
+
+// GET /ocr/jobs/{id}/history -> transiciones de estado del job; ?at=RFC3339
+// devuelve el estado que tenía en ese momento
+func handleJobHistory(w http.ResponseWriter, r *http.Request) {
+	var at *time.Time
+	if v := r.URL.Query().Get("at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			p := newProblem(CodeInvalidInput, "Parámetro at inválido")
+			p.InvalidParams = []InvalidParam{{Name: "at", Reason: "debe ser una fecha RFC 3339"}}
+			writeProblem(w, r, p)
+			return
+		}
+		t = t.UTC()
+		at = &t
+	}
+
+	job, ok, err := tenantJob(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, errJobNotFound.Error()))
+		return
+	}
+
+	out := JobHistory{JobID: job.ID, Status: job.Status, At: at, Transitions: []JobTransition{}}
+	for _, t := range job.History {
+		if at != nil && t.At.After(*at) {
+			break
+		}
+		out.Transitions = append(out.Transitions, t)
+	}
+	if at != nil {
+		if len(out.Transitions) == 0 {
+			p := newProblem(CodeInvalidInput, "Parámetro at inválido")
+			p.InvalidParams = []InvalidParam{{Name: "at", Reason: "es anterior a la creación del job"}}
+			writeProblem(w, r, p)
+			return
+		}
+		out.Status = out.Transitions[len(out.Transitions)-1].Status
+	}
+	writeJSON(w, http.StatusOK, out)
+}
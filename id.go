@@ -0,0 +1,13 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newID devuelve un identificador aleatorio de n bytes en hexadecimal.
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gen2brain/heic"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/webp"
+)
+
+// Formatos de entrada. El formato del original se detecta por sus primeros
+// bytes y no por el Content-Type del servidor, que suele ser genérico (las
+// fotos HEIC de iPhone llegan como application/octet-stream). JPEG, PNG,
+// TIFF y PDF van a los motores tal cual; HEIC/HEIF, WebP, BMP y GIF no los
+// aceptan todos los motores, así que se decodifican y se pasan a PNG antes
+// del OCR. Cualquier otro formato responde UNSUPPORTED_FORMAT.
+
+const (
+	mediaJPEG = "image/jpeg"
+	mediaPNG  = "image/png"
+	mediaGIF  = "image/gif"
+	mediaBMP  = "image/bmp"
+	mediaWebP = "image/webp"
+	mediaTIFF = "image/tiff"
+	mediaHEIC = "image/heic"
+	mediaHEIF = "image/heif"
+	mediaAVIF = "image/avif"
+	mediaPDF  = "application/pdf"
+)
+
+// passthroughFormats son los formatos que se envían a los motores sin
+// convertir.
+var passthroughFormats = []string{mediaJPEG, mediaPNG, mediaTIFF, mediaPDF}
+
+// imageDecoder decodifica uno de los formatos que se convierten a PNG.
+// config lee solo las dimensiones, para rechazar las imágenes demasiado
+// grandes antes de reservar memoria para decodificarlas.
+type imageDecoder struct {
+	decode func(io.Reader) (image.Image, error)
+	config func(io.Reader) (image.Config, error)
+}
+
+var imageDecoders = map[string]imageDecoder{
+	mediaHEIC: {heic.Decode, heic.DecodeConfig},
+	mediaHEIF: {heic.Decode, heic.DecodeConfig},
+	mediaWebP: {webp.Decode, webp.DecodeConfig},
+	mediaBMP:  {bmp.Decode, bmp.DecodeConfig},
+	mediaGIF:  {gif.Decode, gif.DecodeConfig}, // el primer cuadro
+}
+
+// supportedContentTypes son los formatos de entrada aceptados.
+var supportedContentTypes = []string{
+	mediaJPEG, mediaPNG, mediaTIFF, mediaPDF, mediaHEIC, mediaHEIF, mediaWebP, mediaBMP, mediaGIF,
+}
+
+var inputFormatsTotal = newCounterVec("ocr_input_formats_total", "Originales descargados para OCR, por formato detectado (other si no es soportado).", "format")
+
+// ISO BMFF: brands del box ftyp de HEIC (HEVC) y del contenedor HEIF
+// genérico, que también usa AVIF.
+var (
+	heicBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx"}
+	heifBrands = []string{"mif1", "msf1", "heif"}
+)
+
+// sniffFormat identifica el formato por la firma de sus primeros bytes; ""
+// si no es uno de los conocidos.
+func sniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return mediaJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return mediaPNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return mediaGIF
+	case len(data) >= 14 && bytes.HasPrefix(data, []byte("BM")):
+		return mediaBMP
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return mediaWebP
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return mediaTIFF
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return mediaPDF
+	case len(data) >= 16 && string(data[4:8]) == "ftyp":
+		return sniffFtyp(data)
+	}
+	return ""
+}
+
+// sniffFtyp distingue HEIC y AVIF por las brands del box ftyp: la principal
+// y las compatibles.
+func sniffFtyp(data []byte) string {
+	size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	size = min(max(size, 16), len(data))
+	var brands []string
+	for i := 8; i+4 <= size; i += 4 {
+		if i != 12 { // 12..16 es la versión menor
+			brands = append(brands, string(data[i:i+4]))
+		}
+	}
+	has := func(set []string) bool {
+		return slices.ContainsFunc(brands, func(b string) bool { return slices.Contains(set, b) })
+	}
+	switch {
+	case slices.Contains(brands, "avif"), slices.Contains(brands, "avis"):
+		return mediaAVIF
+	case has(heicBrands):
+		return mediaHEIC
+	case has(heifBrands):
+		return mediaHEIF
+	}
+	return ""
+}
+
+// detectFormat devuelve el tipo del original: el de su firma o, si no se
+// reconoce, el Content-Type declarado o el que deduce net/http.
+func detectFormat(data []byte, declared string) string {
+	if mediaType := sniffFormat(data); mediaType != "" {
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(declared)
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	return mediaType
+}
+
+// unsupportedFormat es el error de un formato que no se puede procesar.
+func unsupportedFormat(mediaType string) error {
+	return &codedError{CodeUnsupportedFormat, fmt.Errorf("formato %q no soportado (soportados: %s)",
+		mediaType, strings.Join(supportedContentTypes, ", "))}
+}
+
+// normalizeOriginal detecta el formato del original y, si los motores no lo
+// reciben tal cual, lo convierte a PNG. Devuelve los bytes y el tipo a
+// enviar a los motores. Las imágenes a convertir de más de
+// OCR_MAX_IMAGE_PIXELS se rechazan sin decodificarlas: unos KB comprimidos
+// pueden declarar dimensiones que no entran en memoria.
+func normalizeOriginal(data []byte, declared string) ([]byte, string, error) {
+	mediaType := detectFormat(data, declared)
+	decoder, convert := imageDecoders[mediaType]
+	if !convert && !slices.Contains(passthroughFormats, mediaType) {
+		inputFormatsTotal.Inc("other")
+		return nil, "", unsupportedFormat(mediaType)
+	}
+	inputFormatsTotal.Inc(mediaType)
+	if !convert {
+		return data, mediaType, nil
+	}
+	cfg, err := decoder.config(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", &codedError{CodeUnsupportedFormat, fmt.Errorf("no se pudo decodificar la imagen %s: %w", mediaType, err)}
+	}
+	if limit := liveConfig.load().Limits.MaxImagePixels; limit > 0 && int64(cfg.Width)*int64(cfg.Height) > limit {
+		return nil, "", &codedError{CodePayloadTooLarge, fmt.Errorf("la imagen %s de %dx%d píxeles supera el máximo de %d píxeles (OCR_MAX_IMAGE_PIXELS)", mediaType, cfg.Width, cfg.Height, limit)}
+	}
+	img, err := decoder.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", &codedError{CodeUnsupportedFormat, fmt.Errorf("no se pudo decodificar la imagen %s: %w", mediaType, err)}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", fmt.Errorf("convirtiendo %s a PNG: %w", mediaType, err)
+	}
+	return buf.Bytes(), mediaPNG, nil
+}
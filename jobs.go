@@ -0,0 +1,934 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Estados de un Job.
+const (
+	jobWaiting    = "waiting" // espera a sus dependencias
+	jobQueued     = "queued"
+	jobRunning    = "running"
+	jobCompleted  = "completed"
+	jobUnexported = "completed_unexported" // OCR completo, archivado fallido
+	jobFailed     = "failed"
+	jobCancelled  = "cancelled"
+)
+
+var jobStatuses = []string{jobWaiting, jobQueued, jobRunning, jobCompleted, jobUnexported, jobFailed, jobCancelled}
+
+// Job es un ítem de OCR procesado de forma asíncrona a través de la cola.
+type Job struct {
+	ID        string       `json:"id"`
+	Tenant    string       `json:"tenant,omitempty"`
+	BatchID   string       `json:"batch_id,omitempty"`
+	Status    string       `json:"status"`
+	Item      OCRRequest   `json:"item"`
+	Attempts  int          `json:"attempts"`
+	RequestID string       `json:"request_id,omitempty"`
+	APIKeyID  string       `json:"api_key_id,omitempty"` // quién lo envió, para la auditoría
+	Result    *APIResponse `json:"result,omitempty"`
+	Trace     []TraceEvent `json:"trace,omitempty"`
+	// Duplicates son los ítems del batch iguales a Item, que reciben una
+	// copia del resultado.
+	Duplicates []BatchDuplicate `json:"duplicates,omitempty"`
+
+	// Jobs economy: cuándo se libera a la cola y hasta cuándo debe terminar.
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	CompleteBy   *time.Time `json:"complete_by,omitempty"`
+
+	// Workflow: jobs de los que depende, jobs que lo esperan y resultados
+	// de las dependencias recibidos al liberarse.
+	DependsOn  []string   `json:"depends_on,omitempty"`
+	Dependents []string   `json:"dependents,omitempty"`
+	Inputs     []JobInput `json:"inputs,omitempty"`
+
+	// History registra cada cambio de estado; ver setStatus.
+	History []JobTransition `json:"history,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (j Job) finished() bool {
+	return j.succeeded() || j.Status == jobFailed || j.Status == jobCancelled
+}
+
+// succeeded indica si el OCR del job terminó bien, se haya archivado o no.
+func (j Job) succeeded() bool {
+	return j.Status == jobCompleted || j.Status == jobUnexported
+}
+
+// JobStore guarda el estado de los jobs, compartido entre réplicas cuando
+// el backend lo permite.
+type JobStore interface {
+	Put(ctx context.Context, job Job) error
+	Get(ctx context.Context, id string) (Job, bool, error)
+	// Update aplica fn al job de forma atómica y devuelve el job actualizado.
+	Update(ctx context.Context, id string, fn func(*Job) error) (Job, error)
+	// BatchJobs devuelve los jobs de un batch en el orden en que se encolaron.
+	BatchJobs(ctx context.Context, batchID string) ([]Job, error)
+	// JobsByStatus recorre todos los jobs vigentes y devuelve los que están
+	// en alguno de los estados; es para operaciones de administración.
+	JobsByStatus(ctx context.Context, statuses ...string) ([]Job, error)
+	Ping(ctx context.Context) error
+}
+
+var errJobNotFound = &codedError{CodeNotFound, errors.New("job inexistente o vencido")}
+
+// memoryJobStore guarda los jobs en memoria hasta que vencen.
+type memoryJobStore struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func newMemoryJobStore(ttl time.Duration) *memoryJobStore {
+	s := &memoryJobStore{ttl: ttl, jobs: map[string]Job{}}
+	go s.sweep(time.Minute)
+	return s
+}
+
+func (s *memoryJobStore) Put(_ context.Context, job Job) error {
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryJobStore) Get(_ context.Context, id string) (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok, nil
+}
+
+func (s *memoryJobStore) Update(_ context.Context, id string, fn func(*Job) error) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	if err := fn(&job); err != nil {
+		return Job{}, err
+	}
+	s.jobs[id] = job
+	return job, nil
+}
+
+func (s *memoryJobStore) BatchJobs(_ context.Context, batchID string) ([]Job, error) {
+	s.mu.Lock()
+	var jobs []Job
+	for _, job := range s.jobs {
+		if job.BatchID == batchID {
+			jobs = append(jobs, job)
+		}
+	}
+	s.mu.Unlock()
+	slices.SortFunc(jobs, func(a, b Job) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return jobs, nil
+}
+
+func (s *memoryJobStore) JobsByStatus(_ context.Context, statuses ...string) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []Job
+	for _, job := range s.jobs {
+		if slices.Contains(statuses, job.Status) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (s *memoryJobStore) Ping(_ context.Context) error { return nil }
+
+func (s *memoryJobStore) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		cutoff := time.Now().Add(-s.ttl)
+		s.mu.Lock()
+		for id, job := range s.jobs {
+			if job.UpdatedAt.Before(cutoff) {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+var (
+	jobQueue          JobQueue
+	jobStore          JobStore
+	jobTimeout        time.Duration
+	visibilityTimeout time.Duration
+	jobTTL            time.Duration
+)
+
+// setupQueue elige el backend de cola y jobs según OCR_QUEUE_URL.
+func setupQueue(ctx context.Context, cfg QueueConfig) error {
+	jobTimeout = cfg.JobTimeout
+	visibilityTimeout = cfg.VisibilityTimeout
+	jobTTL = cfg.JobTTL
+	if cfg.URL == "" || cfg.URL == "memory://" {
+		jobQueue = newMemoryJobQueue(cfg.VisibilityTimeout)
+		jobStore = newMemoryJobStore(cfg.JobTTL)
+		usageStore = newMemoryUsageStore()
+		rollupStore = newMemoryRollupStore()
+		operationStore = newMemoryOperationStore()
+		wordlistStore = newMemoryWordlistStore()
+		scheduleStore = newMemoryScheduleStore()
+		tenantPauses = newMemoryTenantPauseStore()
+		return nil
+	}
+
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("OCR_QUEUE_URL inválida: %w", err)
+	}
+	rdb := redis.NewClient(opts)
+	// Los consumer groups se crean ya: Redis tiene que estar disponible
+	err = waitForDependency(ctx, "queue", func(ctx context.Context) error { return rdb.Ping(ctx).Err() })
+	if err != nil {
+		return err
+	}
+	if cfg.ReadOnly {
+		// Solo se consulta la profundidad de la cola, para /admin/queue
+		jobQueue = &redisJobQueue{rdb: rdb, visibility: cfg.VisibilityTimeout}
+	} else {
+		q, err := newRedisJobQueue(ctx, rdb, cfg.VisibilityTimeout)
+		if err != nil {
+			return err
+		}
+		jobQueue = q
+	}
+	jobStore = &redisJobStore{rdb: rdb, ttl: cfg.JobTTL}
+	usageStore = &redisUsageStore{rdb: rdb}
+	rollupStore = &redisRollupStore{rdb: rdb}
+	operationStore = &redisOperationStore{rdb: rdb}
+	wordlistStore = &redisWordlistStore{rdb: rdb}
+	scheduleStore = &redisScheduleStore{rdb: rdb}
+	tenantPauses = &redisTenantPauseStore{rdb: rdb}
+	rs := &redisResultStore{rdb: rdb, max: cfg.ResultStoreMax}
+	if !cfg.ReadOnly {
+		go rs.buildSearchIndex(context.Background())
+	}
+	results = rs
+	addReadinessCheck("queue", jobQueue.Ping)
+	return nil
+}
+
+// submitJob crea el job y lo encola.
+func submitJob(ctx context.Context, item OCRRequest, batchID string) (Job, error) {
+	return enqueueNewJob(ctx, newJob(ctx, item, batchID))
+}
+
+// enqueueNewJob guarda y encola un job armado con newJob.
+func enqueueNewJob(ctx context.Context, job Job) (Job, error) {
+	msg := job.schedule(job.CreatedAt)
+	job.setStatus(jobQueued, job.deferredReason())
+	if err := jobStore.Put(ctx, job); err != nil {
+		return Job{}, &codedError{CodeQueueUnavailable, err}
+	}
+	if err := enqueueJob(ctx, job, msg); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+func newJob(ctx context.Context, item OCRRequest, batchID string) Job {
+	now := time.Now().UTC()
+	return Job{
+		ID:        newID(12),
+		Tenant:    tenantFrom(ctx),
+		BatchID:   batchID,
+		Item:      item,
+		RequestID: requestIDFrom(ctx),
+		APIKeyID:  apiKeyIDFrom(ctx),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// schedule arma el mensaje de cola del job y, si es economy, calcula cuándo
+// se libera y hasta cuándo debe terminar.
+func (j *Job) schedule(now time.Time) QueueMessage {
+	msg := QueueMessage{JobID: j.ID, Priority: j.Item.Priority}
+	if j.Item.ProcessingClass == classEconomy {
+		at, completeBy, priority := economy.release(now)
+		msg.Priority = priority
+		j.CompleteBy = &completeBy
+		if at.After(now) {
+			j.ScheduledFor = &at
+		}
+	}
+	return msg
+}
+
+// deferredReason explica en el historial por qué un job queda diferido.
+func (j Job) deferredReason() string {
+	if j.ScheduledFor == nil {
+		return ""
+	}
+	return "diferido hasta " + j.ScheduledFor.Format(time.RFC3339)
+}
+
+func enqueueJob(ctx context.Context, job Job, msg QueueMessage) error {
+	var err error
+	if job.ScheduledFor != nil {
+		err = jobQueue.EnqueueAt(ctx, msg, *job.ScheduledFor)
+	} else {
+		err = jobQueue.Enqueue(ctx, msg)
+	}
+	if err != nil {
+		return &codedError{CodeQueueUnavailable, err}
+	}
+	return nil
+}
+
+// waitJobs espera a que terminen los jobs o a que termine ctx, y devuelve
+// el último estado conocido de cada uno. Si done no es nil, se llama con
+// cada job apenas termina.
+func waitJobs(ctx context.Context, ids []string, done func(i int, job Job)) []Job {
+	jobs := make([]Job, len(ids))
+	pending := len(ids)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		for i, id := range ids {
+			if id == "" || jobs[i].finished() {
+				continue
+			}
+			job, ok, err := jobStore.Get(ctx, id)
+			if err == nil && ok {
+				jobs[i] = job
+				if job.finished() {
+					pending--
+					if done != nil {
+						done(i, job)
+					}
+				}
+			}
+		}
+		if pending <= 0 {
+			return jobs
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return jobs
+		}
+	}
+}
+
+// runningJob es un job que procesa esta réplica.
+type runningJob struct {
+	key    string
+	cancel context.CancelFunc
+}
+
+// runningJobs guarda, por id, los jobs que procesa esta réplica.
+var (
+	runningMu   sync.Mutex
+	runningJobs = map[string]runningJob{}
+)
+
+// cancelJob marca el job como cancelado si todavía no terminó y, si lo está
+// procesando esta réplica, cancela su contexto; las demás réplicas lo ven en
+// watchCancellation. cancelled indica si esta llamada lo canceló.
+func cancelJob(ctx context.Context, id string) (job Job, cancelled bool, err error) {
+	job, err = jobStore.Update(ctx, id, func(j *Job) error {
+		cancelled = !j.finished()
+		if cancelled {
+			reason := ""
+			if id := requestIDFrom(ctx); id != "" {
+				reason = "cancelado por la request " + id
+			}
+			j.setStatus(jobCancelled, reason)
+		}
+		return nil
+	})
+	runningMu.Lock()
+	if rj, ok := runningJobs[id]; ok {
+		rj.cancel()
+	}
+	runningMu.Unlock()
+	if cancelled {
+		releaseDependents(ctx, job)
+	}
+	return job, cancelled, err
+}
+
+// watchCancellation cancela el contexto de un job en proceso cuando otra
+// réplica lo marca como cancelado. Termina con ctx.
+func watchCancellation(ctx context.Context, id string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if job, ok, err := jobStore.Get(ctx, id); err == nil && ok && job.Status == jobCancelled {
+				cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// localJobsByKey devuelve los ids de los jobs con esa key que procesa esta réplica.
+func localJobsByKey(key string) []string {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	ids := []string{}
+	for id, rj := range runningJobs {
+		if rj.key == key {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// jobConsumers son los consumidores de la cola; toman un job por vez, así
+// que su cantidad sigue al tamaño del pool para no retener mensajes que
+// otra réplica podría procesar.
+var jobConsumers struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	size    int
+	running int
+}
+
+// startJobConsumers lanza n consumidores de la cola que procesan los jobs
+// en el pool de workers.
+func startJobConsumers(ctx context.Context, n int) {
+	jobConsumers.mu.Lock()
+	jobConsumers.ctx = ctx
+	jobConsumers.mu.Unlock()
+	resizeJobConsumers(n)
+}
+
+// resizeJobConsumers ajusta la cantidad de consumidores; los que sobran
+// terminan después de su job actual.
+func resizeJobConsumers(n int) {
+	c := &jobConsumers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = n
+	for c.running < n {
+		c.running++
+		go consumeJobs(c.ctx)
+	}
+}
+
+func consumeJobs(ctx context.Context) {
+	c := &jobConsumers
+	for {
+		c.mu.Lock()
+		if c.running > c.size {
+			c.running--
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+
+		d, err := jobQueue.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("queue dequeue failed", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		processJob(ctx, d)
+	}
+}
+
+// processJob procesa una entrega. Como la entrega es at-least-once, un job
+// que ya terminó (o que fue cancelado) solo se confirma.
+func processJob(ctx context.Context, d Delivery) {
+	id := d.Message().JobID
+	// Un panic no mata al consumidor: se informa al error tracker y el job
+	// falla con INTERNAL_ERROR. jctx, cancel y tr son los del intento, una
+	// vez que empezó.
+	var (
+		job    Job
+		jctx   = ctx
+		cancel context.CancelFunc
+		tr     *processingTrace
+	)
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		// Se omiten esta función y runtime.gopanic
+		reportTaskPanic(jctx, v, stackFrames(2))
+		loggerFrom(jctx).Error("panic recovered", "job_id", id, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+		var events []TraceEvent
+		if cancel != nil {
+			runningMu.Lock()
+			delete(runningJobs, id)
+			runningMu.Unlock()
+			cancel()
+			events = tr.Events()
+		}
+		resp := errorResponse(job.Item.Key, CodeInternal, "Error interno inesperado").withMetadata(job.Item.Metadata)
+		if _, err := finishJob(ctx, id, resp, events); err != nil {
+			slog.Error("job result not saved", "job_id", id, "error", err)
+			return
+		}
+		d.Ack(ctx)
+	}()
+
+	if deferPausedJob(ctx, d) {
+		return
+	}
+	job, err := jobStore.Update(ctx, id, func(j *Job) error {
+		if !j.finished() {
+			j.Attempts++
+			j.setStatus(jobRunning, "")
+		}
+		return nil
+	})
+	if errors.Is(err, errJobNotFound) {
+		d.Ack(ctx)
+		return
+	}
+	if err != nil {
+		slog.Error("job update failed", "job_id", id, "error", err)
+		d.Nack(ctx)
+		return
+	}
+	if job.Status != jobRunning {
+		d.Ack(ctx)
+		return
+	}
+
+	jctx, cancel = withTimeoutLimit(withStageTracker(withAPIKeyID(withTenant(withRequestID(ctx, job.RequestID), job.Tenant), job.APIKeyID)), limitJob, jobTimeout)
+	jctx, tr = withTrace(jctx)
+	traceEvent(jctx, TraceEvent{Stage: "attempt", Detail: fmt.Sprintf("intento %d", job.Attempts)})
+	runningMu.Lock()
+	runningJobs[id] = runningJob{key: job.Item.Key, cancel: cancel}
+	runningMu.Unlock()
+	go watchCancellation(jctx, id, cancel)
+
+	resp, _ := pool.run(jctx, job.Item)
+	if resp.ErrorCode == "" {
+		for _, d := range job.Duplicates {
+			if err := saveResult(jctx, d.result(resp), job.Item.OnConflict); err != nil {
+				loggerFrom(jctx).Warn("duplicate result not saved", "key", d.Key, "error", err)
+			}
+		}
+	}
+
+	runningMu.Lock()
+	delete(runningJobs, id)
+	runningMu.Unlock()
+	cancel()
+
+	job, err = finishJob(ctx, id, resp, tr.Events())
+	if err != nil {
+		// Sin Ack el mensaje se reentrega tras el visibility timeout
+		slog.Error("job result not saved", "job_id", id, "error", err)
+		return
+	}
+	loggerFrom(jctx).Info("job finished", "job_id", id, "batch_id", job.BatchID, "key", job.Item.Key,
+		"status", job.Status, "attempts", job.Attempts)
+	d.Ack(ctx)
+}
+
+// finishJob guarda el resultado de un intento del job, salvo que lo hayan
+// cancelado, y libera a los jobs que lo esperan.
+func finishJob(ctx context.Context, id string, resp *APIResponse, trace []TraceEvent) (Job, error) {
+	job, err := jobStore.Update(ctx, id, func(j *Job) error {
+		j.Trace = append(j.Trace, trace...)
+		if j.Status == jobCancelled {
+			return nil
+		}
+		j.Result = resp
+		switch {
+		case resp.ErrorCode != "":
+			j.setStatus(jobFailed, "")
+		case resp.ExportError != "":
+			j.setStatus(jobUnexported, "")
+		default:
+			j.setStatus(jobCompleted, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return job, err
+	}
+	releaseDependents(ctx, job)
+	return job, nil
+}
+
+var _ = newGaugeFunc("ocr_jobs_queued", "Jobs en la cola distribuida, por prioridad.",
+	[]string{"priority"}, func(emit func(float64, ...string)) {
+		if jobQueue == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		depth, err := jobQueue.Depth(ctx)
+		if err != nil {
+			return
+		}
+		for _, p := range priorities {
+			emit(float64(depth[p]), p)
+		}
+	})
+
+// JobRequest es el body de POST /ocr/jobs: un request de OCR que puede
+// esperar a otros jobs.
+type JobRequest struct {
+	OCRRequest
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// POST /ocr/jobs -> encola {key,url,...} y responde 202 con el job; con
+// depends_on el job espera a esos jobs y la url es opcional
+func handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var in JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Key == "" || (in.URL == "" && len(in.DependsOn) == 0) {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera {key,url} o {key,depends_on}"))
+		return
+	}
+	if in.Priority == "" {
+		in.Priority = priorityNormal
+	}
+
+	var job Job
+	var err error
+	if len(in.DependsOn) > 0 {
+		job, err = submitDependentJob(r.Context(), in.OCRRequest, in.DependsOn)
+	} else {
+		job, err = submitJob(r.Context(), in.OCRRequest, "")
+	}
+	if err != nil {
+		writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+		return
+	}
+	addLogAttrs(r.Context(), slog.String("key", in.Key), slog.String("job_id", job.ID))
+	writeAcceptedJob(w, job)
+}
+
+// writeAcceptedJob responde 202 con el job y su Location.
+func writeAcceptedJob(w http.ResponseWriter, job Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/ocr/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GET /ocr/jobs/{id} -> estado y, si terminó, resultado del job
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok, err := tenantJob(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, errJobNotFound.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// JobState resume un job dentro de un batch o de una cancelación.
+type JobState struct {
+	ID     string `json:"id"`
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	// Deduplicated indica que la key comparte el job de un ítem igual.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// BatchStatus es el estado de un batch asíncrono. Los resultados se piden
+// paginados en /ocr/batches/{id}/results.
+type BatchStatus struct {
+	BatchID string         `json:"batch_id"`
+	Total   int            `json:"total"`
+	Counts  map[string]int `json:"counts"`
+	// Deduplicated son los ítems resueltos con el job de otro ítem igual;
+	// Total y Counts cuentan jobs.
+	Deduplicated int        `json:"deduplicated,omitempty"`
+	Jobs         []JobState `json:"jobs,omitempty"`
+}
+
+// Paginado de /ocr/batches/{id}/results.
+const (
+	defaultResultsLimit = 100
+	maxResultsLimit     = 1000
+)
+
+// BatchResultsPage es una página de resultados de un batch.
+type BatchResultsPage struct {
+	BatchID    string        `json:"batch_id"`
+	Total      int           `json:"total"` // ítems que cumplen el filtro
+	Offset     int           `json:"offset"`
+	Limit      int           `json:"limit"`
+	NextOffset *int          `json:"next_offset,omitempty"`
+	Results    []BatchResult `json:"results"`
+}
+
+// BatchResult es un ítem del batch; index es su posición en el request.
+type BatchResult struct {
+	Index  int          `json:"index"`
+	JobID  string       `json:"job_id"`
+	Key    string       `json:"key"`
+	Status string       `json:"status"`
+	Result *APIResponse `json:"result,omitempty"`
+	// Deduplicated indica que el resultado es el del job de un ítem igual.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// CancelSummary informa cuántos jobs canceló la request y cuántos ya
+// habían terminado (completados, fallidos o cancelados antes).
+type CancelSummary struct {
+	BatchID         string     `json:"batch_id,omitempty"`
+	Cancelled       int        `json:"cancelled"`
+	AlreadyFinished int        `json:"already_finished"`
+	Jobs            []JobState `json:"jobs"`
+}
+
+// cancelJobs cancela los jobs y arma el resumen.
+func cancelJobs(ctx context.Context, jobs []Job) (CancelSummary, error) {
+	out := CancelSummary{Jobs: []JobState{}}
+	for _, j := range jobs {
+		job, cancelled, err := cancelJob(ctx, j.ID)
+		if errors.Is(err, errJobNotFound) {
+			continue // venció entre la lectura y la cancelación
+		}
+		if err != nil {
+			return CancelSummary{}, err
+		}
+		if cancelled {
+			out.Cancelled++
+		} else {
+			out.AlreadyFinished++
+		}
+		out.Jobs = append(out.Jobs, JobState{ID: job.ID, Key: job.Item.Key, Status: job.Status})
+	}
+	return out, nil
+}
+
+// DELETE /ocr/jobs/{id} -> cancela el job si todavía no terminó
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+	job, ok, err := tenantJob(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, errJobNotFound.Error()))
+		return
+	}
+	out, err := cancelJobs(ctx, []Job{job})
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	addLogAttrs(r.Context(), slog.String("job_id", job.ID), slog.Int("cancelled", out.Cancelled))
+	writeJSON(w, http.StatusOK, out)
+}
+
+// POST /ocr/batches -> encola {items:[...]} y responde 202 con el batch
+func handleSubmitBatch(w http.ResponseWriter, r *http.Request) {
+	var in BatchOCRRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || len(in.Items) == 0 {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera {items: [{key,url},...]}"))
+		return
+	}
+	var invalid []InvalidParam
+	for i, item := range in.Items {
+		if item.Key == "" || item.URL == "" {
+			invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("items[%d]", i), Reason: "key y url son requeridos"})
+		}
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "Hay ítems incompletos")
+		p.InvalidParams = invalid
+		writeProblem(w, r, p)
+		return
+	}
+
+	out, _, err := submitBatch(r.Context(), in)
+	if err != nil {
+		writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+		return
+	}
+	addLogAttrs(r.Context(), slog.String("batch_id", out.BatchID), slog.Int("items", len(out.Jobs)))
+	w.Header().Set("Location", "/ocr/batches/"+out.BatchID)
+	writeJSON(w, http.StatusAccepted, out)
+}
+
+// submitBatch encola un job por ítem único del batch. Si falla al encolar
+// uno, cancela los ya encolados: sin batch completo no hay batch.
+func submitBatch(ctx context.Context, in BatchOCRRequest) (BatchStatus, []Job, error) {
+	out := BatchStatus{BatchID: newID(12), Counts: map[string]int{}, Jobs: []JobState{}}
+	var jobs []Job
+	unique, dups := dedupItems(in.Items, in.dedup())
+	for _, i := range unique {
+		item := in.Items[i]
+		if item.Priority == "" {
+			item.Priority = priorityLow
+		}
+		job := newJob(ctx, item, out.BatchID)
+		job.Duplicates = dups[i]
+		job, err := enqueueNewJob(ctx, job)
+		if err != nil {
+			cancelJobs(context.WithoutCancel(ctx), jobs)
+			return BatchStatus{}, nil, err
+		}
+		jobs = append(jobs, job)
+		out.Jobs = append(out.Jobs, JobState{ID: job.ID, Key: item.Key, Status: job.Status})
+		for _, d := range job.Duplicates {
+			out.Jobs = append(out.Jobs, JobState{ID: job.ID, Key: d.Key, Status: job.Status, Deduplicated: true})
+		}
+		out.Counts[job.Status]++
+		out.Deduplicated += len(job.Duplicates)
+	}
+	out.Total = len(jobs)
+	return out, jobs, nil
+}
+
+// batchJobs devuelve los jobs del batch o escribe el problem si no existe.
+func batchJobs(w http.ResponseWriter, r *http.Request) (string, []Job, bool) {
+	id := chi.URLParam(r, "id")
+	jobs, err := tenantBatchJobs(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return "", nil, false
+	}
+	if len(jobs) == 0 {
+		writeProblem(w, r, newProblem(CodeNotFound, "batch inexistente o vencido"))
+		return "", nil, false
+	}
+	return id, jobs, true
+}
+
+// GET /ocr/batches/{id} -> cantidad de jobs del batch por estado
+func handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	id, jobs, ok := batchJobs(w, r)
+	if !ok {
+		return
+	}
+	out := BatchStatus{BatchID: id, Total: len(jobs), Counts: map[string]int{}}
+	for _, j := range jobs {
+		out.Counts[j.Status]++
+		out.Deduplicated += len(j.Duplicates)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /ocr/batches/{id}/results?offset=&limit=&status= -> una página de
+// resultados, en el orden del request; status filtra por estado del job
+// (lista separada por comas, p. ej. failed,cancelled)
+func handleBatchResults(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset, limit := 0, defaultResultsLimit
+	var invalid []InvalidParam
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			invalid = append(invalid, InvalidParam{Name: "offset", Reason: "debe ser un entero mayor o igual a 0"})
+		}
+		offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxResultsLimit {
+			invalid = append(invalid, InvalidParam{Name: "limit", Reason: fmt.Sprintf("debe ser un entero entre 1 y %d", maxResultsLimit)})
+		}
+		limit = n
+	}
+	var statuses []string
+	if v := q.Get("status"); v != "" {
+		statuses = splitList(v)
+		for _, s := range statuses {
+			if !slices.Contains(jobStatuses, s) {
+				invalid = append(invalid, InvalidParam{Name: "status", Reason: "debe ser uno de: " + strings.Join(jobStatuses, ", ")})
+				break
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "Parámetros de paginado inválidos")
+		p.InvalidParams = invalid
+		writeProblem(w, r, p)
+		return
+	}
+
+	id, jobs, ok := batchJobs(w, r)
+	if !ok {
+		return
+	}
+	var matched []BatchResult
+	for _, res := range batchResults(jobs) {
+		if len(statuses) == 0 || slices.Contains(statuses, res.Status) {
+			matched = append(matched, res)
+		}
+	}
+
+	out := BatchResultsPage{BatchID: id, Total: len(matched), Offset: offset, Limit: limit, Results: []BatchResult{}}
+	if offset < len(matched) {
+		end := min(offset+limit, len(matched))
+		out.Results = matched[offset:end]
+		if end < len(matched) {
+			out.NextOffset = &end
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// batchResults devuelve el resultado de cada ítem del batch, duplicados
+// incluidos, en el orden del request.
+func batchResults(jobs []Job) []BatchResult {
+	var out []BatchResult
+	indexes := jobIndexes(jobs)
+	for i, j := range jobs {
+		out = append(out, BatchResult{Index: indexes[i], JobID: j.ID, Key: j.Item.Key, Status: j.Status, Result: j.Result})
+		for _, d := range j.Duplicates {
+			out = append(out, BatchResult{Index: d.Index, JobID: j.ID, Key: d.Key, Status: j.Status, Result: d.result(j.Result), Deduplicated: true})
+		}
+	}
+	slices.SortFunc(out, func(a, b BatchResult) int { return a.Index - b.Index })
+	return out
+}
+
+// DELETE /ocr/batches/{id} -> cancela los jobs del batch que no terminaron
+func handleCancelBatch(w http.ResponseWriter, r *http.Request) {
+	id, jobs, ok := batchJobs(w, r)
+	if !ok {
+		return
+	}
+	out, err := cancelJobs(context.WithoutCancel(r.Context()), jobs)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	out.BatchID = id
+	addLogAttrs(r.Context(), slog.String("batch_id", id), slog.Int("cancelled", out.Cancelled))
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// kmsKeyPrefix distingue en los registros las claves de KMS de las locales.
+const kmsKeyPrefix = "kms:"
+
+// kmsClient llama a la API JSON de AWS KMS firmando con SigV4.
+type kmsClient struct {
+	endpoint string
+	signer   awsSigner
+	client   *http.Client
+}
+
+func newKMSClient(cfg EncryptionConfig) *kmsClient {
+	endpoint := cfg.KMSEndpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + cfg.KMSRegion + ".amazonaws.com"
+	}
+	return &kmsClient{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		signer:   awsSigner{region: cfg.KMSRegion, service: "kms", accessKey: cfg.KMSAccessKey, secretKey: cfg.KMSSecretKey},
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key es la clave maestra keyID (id, ARN o alias) de KMS.
+func (c *kmsClient) key(keyID string) masterKey {
+	return &kmsMasterKey{client: c, keyID: keyID}
+}
+
+// call invoca la operación de TrentService con in y decodifica la respuesta
+// en out. Los errores tienen el mismo formato que los de Textract.
+func (c *kmsClient) call(ctx context.Context, operation string, in, out any) error {
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+operation)
+	c.signer.sign(req, sha256Hex(body), time.Now().UTC())
+	_, err = cloudDo("kms", c.client, req, out, textractErrorMessage)
+	return err
+}
+
+// kmsMasterKey genera las claves de datos con GenerateDataKey y las
+// descifra con Decrypt: la clave maestra nunca sale de KMS.
+type kmsMasterKey struct {
+	client *kmsClient
+	keyID  string
+}
+
+func (k *kmsMasterKey) ID() string { return kmsKeyPrefix + k.keyID }
+
+func (k *kmsMasterKey) newDataKey(ctx context.Context) ([]byte, []byte, error) {
+	var out struct {
+		CiphertextBlob []byte
+		Plaintext      []byte
+	}
+	err := k.client.call(ctx, "GenerateDataKey", map[string]string{"KeyId": k.keyID, "KeySpec": "AES_256"}, &out)
+	return out.Plaintext, out.CiphertextBlob, err
+}
+
+func (k *kmsMasterKey) unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext []byte
+	}
+	err := k.client.call(ctx, "Decrypt", map[string]any{"KeyId": k.keyID, "CiphertextBlob": wrapped}, &out)
+	return out.Plaintext, err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// logOutput es donde escribe slog: stdout en el servidor; la CLI lo cambia
+// a stderr para dejar stdout a los resultados.
+var logOutput io.Writer = os.Stdout
+
+// setupLogging configura slog con salida JSON en logOutput.
+func setupLogging(level slog.Level) {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, &slog.HandlerOptions{Level: level})))
+}
+
+// requestLog acompaña a un request (o a un job encolado por él): lleva el
+// request_id y los atributos que los handlers agregan a la línea final.
+type requestLog struct {
+	id string
+
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+type requestLogKey struct{}
+
+// withRequestID devuelve un contexto con el request_id, para propagarlo a
+// trabajo que se procesa fuera del request (los jobs de un batch).
+func withRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestLogKey{}, &requestLog{id: id})
+}
+
+func requestIDFrom(ctx context.Context) string {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return rl.id
+	}
+	return ""
+}
+
+// addLogAttrs agrega atributos a la línea de log del request.
+func addLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		rl.mu.Lock()
+		rl.attrs = append(rl.attrs, attrs...)
+		rl.mu.Unlock()
+	}
+}
+
+// logAttrs devuelve los atributos que los handlers agregaron al log del
+// request.
+func logAttrs(ctx context.Context) []slog.Attr {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return slices.Clone(rl.attrs)
+	}
+	return nil
+}
+
+// loggerFrom devuelve el logger con el request_id del contexto, si hay.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// validRequestID acepta ids de hasta 128 caracteres imprimibles sin espacios,
+// para no llevar a los logs lo que mande un cliente arbitrario.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(r rune) bool { return r <= ' ' || r > '~' })
+}
+
+// requestLogger reemplaza a middleware.Logger: asigna el request_id
+// (respetando X-Request-ID), lo devuelve en la respuesta y escribe una
+// línea JSON por request con los atributos que agregaron los handlers.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newID(8)
+		}
+		w.Header().Set("X-Request-ID", id)
+		rl := &requestLog{id: id}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+			slog.String("remote", r.RemoteAddr),
+		}
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			attrs = append(attrs, slog.String("client_cert", r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+		rl.mu.Lock()
+		attrs = append(attrs, rl.attrs...)
+		rl.mu.Unlock()
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
@@ -2,243 +2,343 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
-	"math/rand"
+	"log/slog"
 	"net/http"
 	"os"
+	"reflect"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
-type OCRRequest struct {
-	Key string `json:"key"`
-	URL string `json:"url"`
+func main() {
+	if err := rootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
 }
 
-type BatchOCRRequest struct {
-	Items []OCRRequest `json:"items"`
-}
+// setup carga la configuración y arma lo que comparten el servidor y la
+// CLI: motores, archivos de configuración, el pool y los resultados.
+func setup() *Config {
+	setupLogging(slog.LevelInfo)
+	if path := os.Getenv("OCR_ENV_FILE"); path != "" {
+		vars, err := readEnvFile(path)
+		if err != nil {
+			fatal("invalid env file", err)
+		}
+		setEnvFile(vars)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+	setupLogging(cfg.LogLevel)
+	setupStartup(cfg.Startup)
+	if cfg.ErrorTracking.DSN != "" {
+		reporter, err = newErrorReporter(cfg.ErrorTracking)
+		if err != nil {
+			fatal("invalid error tracking configuration", err)
+		}
+	}
+	setupQuality(cfg.Quality)
+	setupAdmission(cfg.Admission)
+	if err := setupEncryption(cfg.Encryption); err != nil {
+		fatal("invalid encryption configuration", err)
+	}
+	setupFetch(cfg.Fetch)
+	if err := setupTempDir(cfg.Temp); err != nil {
+		fatal("invalid temp dir configuration", err)
+	}
+	if err := setupEngines(cfg.Engine); err != nil {
+		fatal("invalid engine configuration", err)
+	}
 
-type APIResponse struct {
-	Key        string `json:"key"`
-	StatusCode int    `json:"status_code"`
-	Body       string `json:"full_text"`
-	Err        string `json:"err,omitempty"`
-}
+	if cfg.SignatureRootsFile != "" {
+		signatureRoots, err = loadSignatureRoots(cfg.SignatureRootsFile)
+		if err != nil {
+			fatal("invalid signature roots file", err)
+		}
+	}
 
-type BatchAPIResponse struct {
-	Results []APIResponse `json:"results"`
-}
+	if cfg.PIIFile != "" {
+		detectors, err := loadPIIDetectors(cfg.PIIFile)
+		if err != nil {
+			fatal("invalid PII file", err)
+		}
+		piiDetectors.store(detectors)
+	}
 
-func processOCR(ctx context.Context, key, url string) (*APIResponse, error) {
-	// Simular latencia de procesamiento OCR (1-4 segundos)
-	processingTime := time.Duration(rand.Intn(3000)+1000) * time.Millisecond
-
-	select {
-	case <-time.After(processingTime):
-		// Procesamiento completado
-	case <-ctx.Done():
-		// Contexto cancelado
-		return &APIResponse{
-			Key:        key,
-			StatusCode: 408,
-			Body:       "",
-			Err:        "Procesamiento cancelado por timeout",
-		}, ctx.Err()
-	}
-
-	// Generar texto aleatorio simulando extracción OCR
-	randomTexts := []string{
-		"Documento de identificación",
-		"Pasaporte República Argentina",
-		"Licencia de conducir",
-		"Factura comercial No. 12345",
-		"Certificado de nacimiento",
-		"Contrato de trabajo",
-		"Recibo de pago mensual",
-		"Diploma universitario",
-		"Tarjeta de crédito VISA",
-		"Boleta de servicios públicos",
-	}
-
-	selectedText := randomTexts[rand.Intn(len(randomTexts))]
-
-	// Agregar algunas palabras adicionales aleatorias
-	additionalWords := []string{"validez", "expedición", "número", "fecha", "código", "serie", "emisión"}
-	if rand.Float32() < 0.7 {
-		additional := additionalWords[rand.Intn(len(additionalWords))]
-		selectedText += " " + additional + " " + fmt.Sprintf("%d", rand.Intn(9999)+1000)
-	}
-
-	return &APIResponse{
-		Key:        key,
-		StatusCode: 200,
-		Body:       selectedText,
-	}, nil
-}
+	// Antes que los presets, que pueden elegir una plantilla
+	if cfg.TemplatesFile != "" {
+		tf, err := loadTemplates(cfg.TemplatesFile)
+		if err != nil {
+			fatal("invalid templates file", err)
+		}
+		templates.store(tf)
+	}
 
-func processBatchOCR(ctx context.Context, items []OCRRequest) *BatchAPIResponse {
-	results := make([]APIResponse, len(items))
+	if cfg.PresetsFile != "" {
+		pf, err := loadPresets(cfg.PresetsFile, cfg.Limits, currentRequestConfig())
+		if err != nil {
+			fatal("invalid presets file", err)
+		}
+		presets.store(pf)
+	}
 
-	// Process each item concurrently
-	type result struct {
-		index int
-		resp  *APIResponse
+	if cfg.TenantsFile != "" {
+		tr, err := loadTenants(cfg.TenantsFile)
+		if err != nil {
+			fatal("invalid tenants file", err)
+		}
+		tenants.store(tr)
 	}
 
-	resultChan := make(chan result, len(items))
+	results = newMemoryResultStore(cfg.ResultStoreMax)
+	pool = newWorkerPool(cfg.Workers, cfg.PriorityAging)
+	continuations.ttl = cfg.ContinuationTTL
+	region = cfg.Region
+	go continuations.sweep(time.Minute)
+	liveConfig.store(cfg)
+	return cfg
+}
 
-	for i, item := range items {
-		go func(index int, req OCRRequest) {
-			resp, err := processOCR(ctx, req.Key, req.URL)
-			if err != nil {
-				resp = &APIResponse{
-					Key:        req.Key,
-					StatusCode: 500,
-					Body:       "",
-					Err:        err.Error(),
-				}
-			}
-			resultChan <- result{index: index, resp: resp}
-		}(i, item)
-	}
-
-	// Collect all results
-	for i := 0; i < len(items); i++ {
-		select {
-		case res := <-resultChan:
-			results[res.index] = *res.resp
-		case <-ctx.Done():
-			// If context is cancelled, fill remaining slots with timeout errors
-			for j := i; j < len(items); j++ {
-				if results[j].Key == "" { // Only fill empty slots
-					results[j] = APIResponse{
-						Key:        items[j].Key,
-						StatusCode: 408,
-						Body:       "",
-						Err:        "Batch processing cancelled or timed out",
-					}
-				}
-			}
-			break
+// serve levanta la API: cola, scheduler, archivado y los routers.
+func serve(cfg *Config) {
+	var err error
+	if cfg.Archive.URL != "" {
+		archiveStore, err = newObjectStore(cfg.Archive)
+		if err != nil {
+			fatal("invalid archive configuration", err)
 		}
+		addReadinessCheck("archive", archiveStore.Ping)
+		archiveDownloadTTL = cfg.Archive.DownloadTTL
 	}
 
-	return &BatchAPIResponse{
-		Results: results,
+	go watchConfigReload(cfg.ConfigWatchInterval)
+
+	if cfg.CompatFile != "" {
+		compatAliases, err = loadCompatAliases(cfg.CompatFile)
+		if err != nil {
+			fatal("invalid compat file", err)
+		}
 	}
-}
 
-func main() {
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(15 * time.Second))
+	serveMode = cfg.Mode
+	readOnly := serveMode == serveReader
+	if err := setupQueue(context.Background(), cfg.Queue); err != nil {
+		fatal("queue setup failed", err)
+	}
+	if !readOnly {
+		if err := setupEconomy(cfg.Economy); err != nil {
+			fatal("invalid economy configuration", err)
+		}
+	}
+	if serveMode == serveFull {
+		if err := setupDemo(cfg.Demo); err != nil {
+			fatal("invalid demo configuration", err)
+		}
+	}
+	if err := waitForDependencies(context.Background()); err != nil {
+		fatal("dependencies not available", err)
+	}
+	if readOnly {
+		slog.Info("read-only mode: not consuming the queue nor running schedules")
+	} else {
+		startJobConsumers(context.Background(), cfg.Workers)
+		startScheduler(context.Background())
+	}
 
-	r.Get("/health", func(w http.ResponseWriter, _ *http.Request) {
-		w.Write([]byte("ok"))
+	ephemeral, err := setupExportSigner(cfg.ExportSigningKey)
+	if err != nil {
+		fatal("invalid export configuration", err)
+	}
+	if ephemeral {
+		slog.Warn("OCR_EXPORT_SIGNING_KEY not set, export bundles are signed with an ephemeral key")
+	}
+
+	r, c, err := buildRouter(cfg)
+	if err != nil {
+		fatal("invalid routes configuration", err)
+	}
+	apiRouter.store(r)
+	contract.store(c)
+	if serveMode == serveWorker {
+		slog.Info("worker mode: only health and metrics are exposed")
+	}
+	// Las recargas reemplazan el router sin cortar las conexiones
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiRouter.load().ServeHTTP(w, r)
 	})
 
-	// POST /ocr  -> recibe {key,url} y responde un OCR "mock"
-	r.Post("/ocr", func(w http.ResponseWriter, r *http.Request) {
-		var in OCRRequest
-		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Key == "" || in.URL == "" {
-			out := APIResponse{
-				Key:        "",
-				StatusCode: 400,
-				Body:       "",
-				Err:        "JSON inválido. Se espera {key,url}",
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(out)
-			return
+	var tlsConfig *tls.Config
+	if cfg.TLS.enabled() {
+		tlsConfig, err = newTLSConfig(cfg.TLS)
+		if err != nil {
+			fatal("invalid TLS configuration", err)
 		}
+	}
 
-		// Crear canal para recibir el resultado del procesamiento
-		resultChan := make(chan *APIResponse, 1)
-		errorChan := make(chan error, 1)
-
-		// Ejecutar procesamiento OCR en goroutine
+	if cfg.Admin.Port != "" {
+		admin := chi.NewRouter()
+		admin.Use(requestLogger)
+		admin.Use(recoverer)
+		if readOnly {
+			admin.Use(rejectWrites)
+		}
+		admin.NotFound(handleNotFound)
+		admin.MethodNotAllowed(handleMethodNotAllowed)
+		admin.Mount("/admin", adminRouter(cfg))
 		go func() {
-			result, err := processOCR(r.Context(), in.Key, in.URL)
-			if err != nil {
-				errorChan <- err
-			} else {
-				resultChan <- result
+			slog.Info("admin API listening", "port", cfg.Admin.Port, "tls", tlsConfig != nil)
+			if err := listenAndServe(":"+cfg.Admin.Port, admin, tlsConfig, cfg.Server); err != nil {
+				slog.Error("admin server failed to start", "error", err)
 			}
 		}()
+	}
 
-		// Esperar resultado o timeout
-		select {
-		case result := <-resultChan:
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(result)
-		case <-errorChan:
-			// Error durante procesamiento (timeout)
-			out := APIResponse{
-				Key:        in.Key,
-				StatusCode: 408,
-				Body:       "",
-				Err:        "Timeout durante procesamiento",
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusRequestTimeout)
-			json.NewEncoder(w).Encode(out)
-		case <-r.Context().Done():
-			// Cliente canceló la request
-			out := APIResponse{
-				Key:        in.Key,
-				StatusCode: 499,
-				Body:       "",
-				Err:        "Cliente canceló la request",
+	if cfg.Server.UnixSocket != "" {
+		go func() {
+			slog.Info("API listening on unix socket", "path", cfg.Server.UnixSocket)
+			if err := listenUnix(cfg.Server.UnixSocket, cfg.Server.UnixSocketMode, handler, cfg.Server); err != nil {
+				fatal("unix socket server failed to start", err)
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(499)
-			json.NewEncoder(w).Encode(out)
-		}
-	})
+		}()
+	}
+	slog.Info("API listening", "port", cfg.Port, "tls", tlsConfig != nil)
+	if err := listenAndServe(":"+cfg.Port, handler, tlsConfig, cfg.Server); err != nil {
+		fatal("server failed to start", err)
+	}
+}
 
-	// POST /ocr/batch -> recibe {items: [{key,url},...]} y responde {results: [{key,status_code,full_text,err},...]}
-	r.Post("/ocr/batch", func(w http.ResponseWriter, r *http.Request) {
-		var batchReq BatchOCRRequest
-		if err := json.NewDecoder(r.Body).Decode(&batchReq); err != nil || len(batchReq.Items) == 0 {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "JSON inválido. Se espera {items: [{key,url},...]}",
-			})
-			return
-		}
+// compatAliases son los aliases de OCR_COMPAT_FILE, que se leen solo al
+// arrancar.
+var compatAliases []CompatAlias
 
-		// Validate all items have required fields
-		for i, item := range batchReq.Items {
-			if item.Key == "" || item.URL == "" {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{
-					"error": fmt.Sprintf("Item %d: key y url son requeridos", i),
-				})
-				return
-			}
+// apiRouter es el router del puerto principal; se reemplaza al recargar la
+// configuración.
+var apiRouter = newConfigValue[http.Handler](http.NotFoundHandler())
+
+// buildRouter arma el router del puerto principal con el stack de cada
+// grupo de OCR_MIDDLEWARE_FILE, los límites y timeouts de cfg y, fuera del
+// modo worker, el contrato de la API. Se vuelve a armar en cada recarga.
+func buildRouter(cfg *Config) (http.Handler, *apiContract, error) {
+	groups := defaultMiddleware(cfg.RouteTimeout)
+	if cfg.MiddlewareFile != "" {
+		var err error
+		groups, err = loadMiddleware(cfg.MiddlewareFile, cfg.RouteTimeout, cfg.TenantsFile != "")
+		if err != nil {
+			return nil, nil, err
 		}
+	}
+
+	r := chi.NewRouter()
+	r.Use(requestLogger)
+	r.Use(recoverer)
+	if serveMode == serveReader {
+		r.Use(rejectWrites)
+	}
 
-		// Process batch
-		result := processBatchOCR(r.Context(), batchReq.Items)
+	r.NotFound(handleNotFound)
+	r.MethodNotAllowed(handleMethodNotAllowed)
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(result)
+	routeGroup(r, groups, groupPublic, func(r chi.Router) {
+		r.Get("/health", handleHealth)
+		r.Get("/health/live", handleLiveness)
+		r.Get("/health/ready", handleReadiness)
+		r.Get("/metrics", handleMetrics)
+		if serveMode == serveWorker {
+			return
+		}
+		r.Get("/presets", handlePresets)
+		r.Get("/templates", handleTemplates)
+		r.Get("/problems", handleErrorCatalog)
+		r.Get("/problems/{slug}", handleErrorDefinition)
+		r.Get("/openapi.json", handleOpenAPI)
+		r.Get("/docs", handleDocs)
+		r.Get("/ocr/archive/{token}", handleArchiveDownload)
 	})
+	var c *apiContract
+	if serveMode != serveWorker {
+		apiRoutes(r, cfg, groups, compatAliases)
+		var err error
+		if c, err = buildContract(r, compatAliases, demo != nil, cfg.ContractValidation, cfg.Limits); err != nil {
+			return nil, nil, fmt.Errorf("contrato de la API: %w", err)
+		}
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if cfg.Admin.Port == "" && cfg.Admin.Token != "" {
+		r.With(requestTimeout(cfg.RouteTimeout)).Mount("/admin", adminRouter(cfg))
 	}
-	fmt.Println("API listening on :" + port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		fmt.Printf("Server failed to start: %v\n", err)
+	return r, c, nil
+}
+
+// apiRoutes monta la API sobre r, con el stack de cada grupo.
+func apiRoutes(r chi.Router, cfg *Config, groups map[string]*MiddlewareGroup, aliases []CompatAlias) {
+	if demo != nil {
+		routeGroup(r, groups, groupDemo, func(r chi.Router) {
+			r.Post("/demo/ocr", handleDemoOCR)
+		})
 	}
+
+	// Las rutas con datos de un tenant lo identifican con auth
+	routeGroup(r, groups, groupTenant, func(r chi.Router) {
+		r.Get("/usage", handleUsage)
+		r.Get("/usage/rollups", handleRollups)
+		r.With(requireTenantAdmin).Get("/audit", handleAudit)
+		r.Get("/wordlists", handleListWordlists)
+		r.Get("/wordlists/{name}", handleGetWordlist)
+		r.With(requireTenantAdmin).Put("/wordlists/{name}", handlePutWordlist(cfg.Limits))
+		r.With(requireTenantAdmin).Delete("/wordlists/{name}", handleDeleteWordlist)
+		r.Get("/schedules", handleListSchedules)
+		r.Get("/schedules/{id}", handleGetSchedule)
+		r.With(requireTenantAdmin).Post("/schedules", handleCreateSchedule(cfg.Limits))
+		r.With(requireTenantAdmin).Delete("/schedules/{id}", handleDeleteSchedule)
+		r.With(requireTenantAdmin).Post("/schedules/{id}/run", handleRunSchedule(cfg.Limits))
+		r.With(validateInput(cfg.Limits)).Post("/ocr", handleOCR)
+		r.With(validateBatchInput(cfg.Limits)).Post("/ocr/batch", handleBatchOCR)
+		r.Post("/ocr/validate", handleValidate(cfg.Limits))
+		r.Get("/ocr/ws", handleOCRWebSocket(cfg.Limits, cfg.RouteTimeout, cfg.WebSocket))
+		r.With(validateInput(cfg.Limits)).Post("/ocr/jobs", handleSubmitJob)
+		r.Get("/ocr/jobs/{id}", handleGetJob)
+		r.Delete("/ocr/jobs/{id}", handleCancelJob)
+		r.Get("/ocr/jobs/{id}/history", handleJobHistory)
+		r.Post("/ocr/jobs/reexport", handleReexport)
+		r.With(validateBatchInput(cfg.Limits)).Post("/ocr/batches", handleSubmitBatch)
+		r.Get("/ocr/batches/{id}", handleGetBatch)
+		r.Get("/ocr/batches/{id}/results", handleBatchResults)
+		r.Get("/ocr/batch/{id}/results", handleBatchResults)
+		r.Post("/ocr/batches/{id}/retry", handleRetryBatch)
+		r.Post("/ocr/batch/{id}/retry", handleRetryBatch)
+		r.Delete("/ocr/batches/{id}", handleCancelBatch)
+		r.Get("/ocr/jobs/{id}/export", handleExportJob)
+		r.Get("/ocr/exports/public-key", handleExportPublicKey)
+		r.Get("/ocr/continuations/{token}", handleContinuation)
+		r.Get("/ocr/clusters", handleResultClusters)
+		r.Get("/ocr/results", handleSearchResults)
+		r.Get("/ocr/results/{key}", handleGetResult)
+		r.Get("/ocr/results/{key}/versions", handleResultVersions)
+		r.Get("/ocr/results/{key}/download", handleResultDownload)
+		r.Get("/ocr/results/{key}/annotations", handleListAnnotations)
+		r.Post("/ocr/results/{key}/annotations", handleCreateAnnotation)
+		r.Delete("/ocr/results/{key}/annotations/{id}", handleDeleteAnnotation)
+
+		r.Post("/compat/vision/v1/images:annotate", handleVisionAnnotate(cfg.Limits))
+		r.Post("/compat/textract", handleTextract(cfg.Limits))
+		r.Post("/compat/xml/ocr", handleXMLCompat(validateInput(cfg.Limits)(http.HandlerFunc(handleOCR)),
+			reflect.TypeFor[OCRRequest](), "OCRResponse", cfg.Limits))
+		r.Post("/compat/xml/ocr/batch", handleXMLCompat(validateBatchInput(cfg.Limits)(http.HandlerFunc(handleBatchOCR)),
+			reflect.TypeFor[xmlBatchRequest](), "BatchOCRResponse", cfg.Limits))
+
+		mountCompatAliases(r, aliases, map[string]http.Handler{
+			compatTargetOCR:   validateInput(cfg.Limits)(http.HandlerFunc(handleOCR)),
+			compatTargetBatch: validateBatchInput(cfg.Limits)(http.HandlerFunc(handleBatchOCR)),
+		})
+	})
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ocrWithMaxWait procesa el ítem de /ocr fuera del contexto del request,
+// acotado por OCR_JOB_TIMEOUT en lugar del timeout de la ruta. Si no
+// termina en max_wait_ms (o antes vence el timeout de la ruta) responde 202
+// con un job que recibe el resultado cuando termina, en esta réplica.
+func ocrWithMaxWait(w http.ResponseWriter, r *http.Request, in OCRRequest, format string) {
+	ctx, cancel := withTimeoutLimit(withStageTracker(context.WithoutCancel(r.Context())), limitJob, jobTimeout)
+	ctx, tr := withTrace(ctx)
+	done := make(chan *APIResponse, 1)
+	go func() {
+		resp, _ := pool.run(ctx, in)
+		done <- resp
+	}()
+
+	wait := time.NewTimer(time.Duration(in.MaxWaitMs) * time.Millisecond)
+	defer wait.Stop()
+	reason := fmt.Sprintf("superó max_wait_ms=%d, sigue en segundo plano", in.MaxWaitMs)
+	select {
+	case resp := <-done:
+		cancel()
+		writeOCRResponse(w, r, in, format, resp)
+		return
+	case <-wait.C:
+	case <-r.Context().Done():
+		reason = "venció el request (" + context.Cause(r.Context()).Error() + "), sigue en segundo plano"
+	}
+
+	// El request pudo haber vencido: el job se guarda igual
+	sctx, scancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer scancel()
+	job := newJob(r.Context(), in, "")
+	job.Attempts = 1
+	job.setStatus(jobRunning, reason)
+	if err := jobStore.Put(sctx, job); err != nil {
+		cancel()
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	runningMu.Lock()
+	runningJobs[job.ID] = runningJob{key: in.Key, cancel: cancel}
+	runningMu.Unlock()
+	go watchCancellation(ctx, job.ID, cancel)
+	go finishDetachedJob(ctx, job.ID, done, tr, cancel)
+
+	addLogAttrs(r.Context(), slog.String("job_id", job.ID))
+	writeAcceptedJob(w, job)
+}
+
+// checkMaxWait rechaza un max_wait_ms mayor que el timeout del request
+// (OCR_ROUTE_TIMEOUT o X-Request-Timeout): esa espera nunca se cumpliría,
+// porque antes vence el request y responde 202.
+func checkMaxWait(r *http.Request, maxWaitMs int) *Problem {
+	limit := requestTimeoutFrom(r.Context()).Milliseconds()
+	if limit == 0 || int64(maxWaitMs) <= limit {
+		return nil
+	}
+	p := newProblem(CodeInvalidInput, "La request contiene campos inválidos")
+	p.InvalidParams = []InvalidParam{{Name: "max_wait_ms", Reason: fmt.Sprintf("debe ser a lo sumo %d, el timeout del request (OCR_ROUTE_TIMEOUT o X-Request-Timeout)", limit)}}
+	return &p
+}
+
+// finishDetachedJob espera el resultado de un /ocr que pasó a job y lo
+// guarda en el job. No pasa por la cola: si la réplica cae antes, el job
+// queda running hasta que lo reencole POST /admin/jobs/requeue-stuck.
+func finishDetachedJob(ctx context.Context, id string, done <-chan *APIResponse, tr *processingTrace, cancel context.CancelFunc) {
+	resp := <-done
+	runningMu.Lock()
+	delete(runningJobs, id)
+	runningMu.Unlock()
+	cancel()
+
+	sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer scancel()
+	job, err := finishJob(sctx, id, resp, tr.Events())
+	if err != nil {
+		loggerFrom(ctx).Error("job result not saved", "job_id", id, "error", err)
+		return
+	}
+	loggerFrom(ctx).Info("job finished", "job_id", id, "key", job.Item.Key, "status", job.Status, "attempts", job.Attempts)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Metadata del request: pares clave-valor opacos del cliente (p. ej. su id
+// de caso u orden) que se guardan con el resultado y vuelven en la
+// respuesta, los jobs y los webhooks, para relacionar el OCR con sus datos
+// sin una tabla aparte. En GET /ocr/results se filtra con metadata.<clave>.
+
+const (
+	maxMetadataKeys  = 20
+	maxMetadataValue = 256 // bytes
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// metadataParams revisa la metadata del request.
+func metadataParams(md map[string]string, prefix string) []InvalidParam {
+	var invalid []InvalidParam
+	if len(md) > maxMetadataKeys {
+		invalid = append(invalid, InvalidParam{Name: prefix + "metadata", Reason: fmt.Sprintf("debe tener hasta %d claves", maxMetadataKeys)})
+	}
+	for _, k := range slices.Sorted(maps.Keys(md)) {
+		if !metadataKeyPattern.MatchString(k) {
+			invalid = append(invalid, InvalidParam{Name: prefix + "metadata", Reason: fmt.Sprintf("clave %q inválida: hasta 64 letras, números, _, . o -", k)})
+			continue
+		}
+		if len(md[k]) > maxMetadataValue {
+			invalid = append(invalid, InvalidParam{Name: prefix + "metadata." + k, Reason: fmt.Sprintf("debe tener hasta %d bytes", maxMetadataValue)})
+		}
+	}
+	return invalid
+}
+
+// withMetadata agrega la metadata del request al resultado, también a los
+// fallidos, que se arman sin ella.
+func (resp *APIResponse) withMetadata(md map[string]string) *APIResponse {
+	if resp != nil && resp.Metadata == nil {
+		resp.Metadata = md
+	}
+	return resp
+}
+
+// metadataFilter lee los parámetros metadata.<clave>=valor de la búsqueda;
+// el resultado tiene que tener todos.
+func metadataFilter(q url.Values) (map[string]string, []InvalidParam) {
+	var md map[string]string
+	var invalid []InvalidParam
+	for _, name := range slices.Sorted(maps.Keys(q)) {
+		values := q[name]
+		k, ok := strings.CutPrefix(name, "metadata.")
+		if !ok {
+			continue
+		}
+		if !metadataKeyPattern.MatchString(k) || len(values) > 1 {
+			invalid = append(invalid, InvalidParam{Name: name, Reason: "debe ser una clave de metadata válida, una sola vez"})
+			continue
+		}
+		if md == nil {
+			md = map[string]string{}
+		}
+		md[k] = values[0]
+	}
+	return md, invalid
+}
+
+// matchesMetadata indica si got tiene todos los pares de want.
+func matchesMetadata(got, want map[string]string) bool {
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Métricas en formato de exposición de texto de Prometheus.
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   []metric
+)
+
+func register(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics = append(metrics, m)
+}
+
+// CounterVec es un contador con etiquetas.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var values []string
+		if len(c.labels) > 0 {
+			values = strings.Split(k, "\xff")
+		}
+		fmt.Fprintf(w, "%s%s %g\n", c.name, formatLabels(c.labels, values), c.values[k])
+	}
+}
+
+// GaugeFunc es un gauge cuyo valor se calcula al momento de exponerlo.
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+func newGaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	g.collect(func(value float64, labelValues ...string) {
+		fmt.Fprintf(w, "%s%s %g\n", g.name, formatLabels(g.labels, labelValues), value)
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		pairs[i] = fmt.Sprintf(`%s="%s"`, n, v)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// GET /metrics
+func handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Stack de middleware de cada grupo de rutas, configurable con
+// OCR_MIDDLEWARE_FILE. Cada grupo lista sus middlewares en orden, del más
+// externo al más interno; requestLogger y recoverer van siempre antes, en
+// todas las rutas. Los grupos que el archivo no menciona conservan el stack
+// por defecto.
+
+// Grupos de rutas.
+const (
+	groupPublic = "public" // health, métricas y catálogos
+	groupTenant = "tenant" // rutas con datos de un tenant
+	groupDemo   = "demo"   // /demo/ocr
+)
+
+// Middlewares configurables.
+const (
+	mwAuth      = "auth"
+	mwRateLimit = "rate_limit"
+	mwTimeout   = "timeout"
+	mwCompress  = "compress"
+	mwCORS      = "cors"
+)
+
+var middlewareNames = []string{mwAuth, mwRateLimit, mwTimeout, mwCompress, mwCORS}
+
+var rateLimitedTotal = newCounterVec("ocr_rate_limited_total", "Requests rechazadas por el rate limit de su grupo de rutas.", "group")
+
+// compressibleTypes son las respuestas que comprime compress. El NDJSON de
+// /ocr/batch también: cada Flush del streaming vacía el compresor.
+var compressibleTypes = []string{
+	"application/json", "application/problem+json", mediaTypeNDJSON, "text/plain", "text/vnd.hocr+html",
+	"application/alto+xml", "application/xml", "text/xml", "text/html", "application/xhtml+xml",
+}
+
+// MiddlewareGroup es el stack de un grupo. Timeout, RateLimit y CORS
+// configuran los middlewares del mismo nombre.
+type MiddlewareGroup struct {
+	Middleware []string         `json:"middleware"`
+	Timeout    string           `json:"timeout,omitempty"` // default OCR_ROUTE_TIMEOUT
+	RateLimit  *RateLimitConfig `json:"rate_limit,omitempty"`
+	CORS       *CORSConfig      `json:"cors,omitempty"`
+
+	timeout time.Duration
+}
+
+// RateLimitConfig limita las requests por minuto de cada cliente (IP) o de
+// cada tenant, en cada réplica.
+type RateLimitConfig struct {
+	PerMinute int    `json:"per_minute"`
+	By        string `json:"by,omitempty"` // ip (default) | tenant
+	// TrustForwardedFor toma la IP del último X-Forwarded-For.
+	TrustForwardedFor bool `json:"trust_forwarded_for,omitempty"`
+}
+
+// CORSConfig habilita requests de navegadores desde AllowedOrigins ("*"
+// para cualquiera). AllowCredentials deja enviar cookies y certificados de
+// cliente; no se admite con "*".
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	MaxAgeSeconds    int      `json:"max_age_seconds,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+}
+
+// corsMethods son los métodos que se pueden permitir; corsDefaultMethods,
+// los que usa la API.
+var (
+	corsMethods        = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	corsDefaultMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+)
+
+// corsDefaultHeaders son los headers de request que acepta el servicio.
+var corsDefaultHeaders = []string{"Content-Type", "Accept", "X-API-Key", "X-Tenant-ID", "X-Request-Timeout", "X-Request-ID"}
+
+// corsExposedHeaders son los headers de respuesta que puede leer el navegador.
+var corsExposedHeaders = []string{"Location", "Retry-After", "X-Continuation-Token", "X-Timeout-Reason", "X-OCR-Demo", "X-Request-ID"}
+
+// defaultMiddleware es el stack sin OCR_MIDDLEWARE_FILE. compress va
+// primero para comprimir también los errores del resto del stack.
+func defaultMiddleware(routeTimeout time.Duration) map[string]*MiddlewareGroup {
+	return map[string]*MiddlewareGroup{
+		groupPublic: {Middleware: []string{mwCompress, mwTimeout}, timeout: routeTimeout},
+		groupTenant: {Middleware: []string{mwCompress, mwTimeout, mwAuth}, timeout: routeTimeout},
+		groupDemo:   {Middleware: []string{mwCompress, mwTimeout}, timeout: routeTimeout},
+	}
+}
+
+// loadMiddleware lee OCR_MIDDLEWARE_FILE: {"groups": {"tenant": {...}}}.
+// Con tenants configurados el grupo tenant no puede quitar auth.
+func loadMiddleware(path string, routeTimeout time.Duration, tenantsConfigured bool) (map[string]*MiddlewareGroup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Groups map[string]*MiddlewareGroup `json:"groups"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	groups := defaultMiddleware(routeTimeout)
+	for name, g := range file.Groups {
+		if _, ok := groups[name]; !ok {
+			return nil, fmt.Errorf("%s: grupo %q inexistente (grupos: %s, %s, %s)", path, name, groupPublic, groupTenant, groupDemo)
+		}
+		if g == nil {
+			return nil, fmt.Errorf("%s: grupo %s vacío", path, name)
+		}
+		if err := g.validate(name, tenantsConfigured); err != nil {
+			return nil, fmt.Errorf("%s: grupo %s: %w", path, name, err)
+		}
+		g.timeout = routeTimeout
+		if g.Timeout != "" {
+			g.timeout, _ = time.ParseDuration(g.Timeout)
+		}
+		groups[name] = g
+	}
+	return groups, nil
+}
+
+func (g *MiddlewareGroup) validate(name string, tenantsConfigured bool) error {
+	seen := map[string]int{}
+	for i, mw := range g.Middleware {
+		if !slices.Contains(middlewareNames, mw) {
+			return fmt.Errorf("middleware %q inexistente (disponibles: %s)", mw, strings.Join(middlewareNames, ", "))
+		}
+		if _, dup := seen[mw]; dup {
+			return fmt.Errorf("middleware %s repetido", mw)
+		}
+		seen[mw] = i
+	}
+	auth, hasAuth := seen[mwAuth]
+	switch {
+	case name == groupTenant && !hasAuth && tenantsConfigured:
+		return fmt.Errorf("con OCR_TENANTS_FILE las rutas de tenant requieren auth")
+	case name == groupDemo && hasAuth:
+		return fmt.Errorf("la demo es pública: no admite auth")
+	}
+	if g.Timeout != "" {
+		if d, err := time.ParseDuration(g.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("timeout debe ser una duración positiva (p. ej. 30s), se recibió %q", g.Timeout)
+		}
+	}
+
+	if i, ok := seen[mwRateLimit]; !ok {
+		if g.RateLimit != nil {
+			return fmt.Errorf("rate_limit está configurado pero no figura en middleware")
+		}
+	} else {
+		rl := g.RateLimit
+		switch {
+		case rl == nil || rl.PerMinute <= 0:
+			return fmt.Errorf("rate_limit requiere per_minute mayor a 0")
+		case rl.By != "" && rl.By != "ip" && rl.By != "tenant":
+			return fmt.Errorf("rate_limit.by debe ser ip o tenant")
+		case rl.By == "tenant" && (!hasAuth || auth > i):
+			return fmt.Errorf("rate_limit por tenant debe ir después de auth")
+		}
+	}
+
+	if i, ok := seen[mwCORS]; !ok {
+		if g.CORS != nil {
+			return fmt.Errorf("cors está configurado pero no figura en middleware")
+		}
+	} else {
+		switch {
+		case g.CORS == nil || len(g.CORS.AllowedOrigins) == 0:
+			return fmt.Errorf("cors requiere allowed_origins")
+		case g.CORS.AllowCredentials && slices.Contains(g.CORS.AllowedOrigins, "*"):
+			// Cualquier sitio podría llamar a la API con las cookies del usuario
+			return fmt.Errorf("cors.allow_credentials no admite allowed_origins \"*\"")
+		case g.CORS.MaxAgeSeconds < 0:
+			return fmt.Errorf("cors.max_age_seconds debe ser mayor o igual a 0")
+		case hasAuth && auth < i:
+			// Los preflight no llevan credenciales
+			return fmt.Errorf("cors debe ir antes que auth")
+		}
+		for _, m := range g.CORS.AllowedMethods {
+			if !slices.Contains(corsMethods, m) {
+				return fmt.Errorf("cors.allowed_methods: %q no es uno de %s", m, strings.Join(corsMethods, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// handlers arma el stack del grupo en orden.
+func (g *MiddlewareGroup) handlers(name string) []func(http.Handler) http.Handler {
+	var out []func(http.Handler) http.Handler
+	for _, mw := range g.Middleware {
+		switch mw {
+		case mwAuth:
+			out = append(out, identifyTenant)
+		case mwRateLimit:
+			out = append(out, rateLimit(name, *g.RateLimit))
+		case mwTimeout:
+			out = append(out, requestTimeout(g.timeout))
+		case mwCompress:
+			out = append(out, middleware.Compress(5, compressibleTypes...))
+		case mwCORS:
+			out = append(out, cors(*g.CORS))
+		}
+	}
+	return out
+}
+
+// routeGroup registra las rutas de routes con el stack del grupo y, al
+// final, la validación del contrato. Con cors además registra OPTIONS en
+// cada ruta nueva, para que los preflight lleguen al middleware.
+func routeGroup(r chi.Router, groups map[string]*MiddlewareGroup, name string, routes func(r chi.Router)) {
+	g := groups[name]
+	r.Group(func(r chi.Router) {
+		r.Use(g.handlers(name)...)
+		if name == groupTenant {
+			r.Use(responseMetadata)
+		}
+		r.Use(validateContract)
+		if !slices.Contains(g.Middleware, mwCORS) {
+			routes(r)
+			return
+		}
+		before := routePatterns(r)
+		routes(r)
+		for pattern := range routePatterns(r) {
+			if !before[pattern] {
+				r.Options(pattern, func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				})
+			}
+		}
+	})
+}
+
+func routePatterns(r chi.Routes) map[string]bool {
+	out := map[string]bool{}
+	chi.Walk(r, func(_, pattern string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		out[pattern] = true
+		return nil
+	})
+	return out
+}
+
+// groupLimiters son los rate limiters de cada grupo, por límite. Si una
+// recarga no cambia el límite del grupo, el router nuevo sigue con los
+// buckets del anterior.
+var groupLimiters = struct {
+	sync.Mutex
+	byKey map[string]*rateLimiter
+}{byKey: map[string]*rateLimiter{}}
+
+// groupLimiter devuelve el rate limiter del grupo con el límite perMinute.
+func groupLimiter(group string, perMinute int) *rateLimiter {
+	groupLimiters.Lock()
+	defer groupLimiters.Unlock()
+	key := group + ":" + strconv.Itoa(perMinute)
+	if l, ok := groupLimiters.byKey[key]; ok {
+		return l
+	}
+	l := newRateLimiter(perMinute, time.Minute)
+	go l.sweep(time.Minute)
+	groupLimiters.byKey[key] = l
+	return l
+}
+
+// rateLimit rechaza con 429 RATE_LIMITED las requests que superan
+// cfg.PerMinute por cliente o por tenant.
+func rateLimit(group string, cfg RateLimitConfig) func(http.Handler) http.Handler {
+	limiter := groupLimiter(group, cfg.PerMinute)
+	by := "cliente"
+	if cfg.By == "tenant" {
+		by = "tenant"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := clientIP(r, cfg.TrustForwardedFor)
+			if cfg.By == "tenant" {
+				key = tenantFrom(r.Context())
+			}
+			if ok, wait := limiter.allow(key, time.Now()); !ok {
+				rateLimitedTotal.Inc(group)
+				retryAfter(w, wait)
+				writeProblem(w, r, newProblem(CodeRateLimited,
+					fmt.Sprintf("Se admiten %d requests por minuto por %s", cfg.PerMinute, by)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// cors agrega los headers CORS a las requests de los orígenes permitidos y
+// responde los preflight sin pasar al resto del stack.
+func cors(cfg CORSConfig) func(http.Handler) http.Handler {
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = corsDefaultMethods
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = corsDefaultHeaders
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if origin == "" || (!anyOrigin && !slices.Contains(cfg.AllowedOrigins, origin)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+				if cfg.MaxAgeSeconds > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAgeSeconds))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"time"
+)
+
+// Aleatoriedad de los mocks. Cada simulación (un documento, una llamada a
+// un motor) usa su propia fuente, así las simulaciones concurrentes no
+// comparten estado. Por defecto la fuente tiene semilla aleatoria; en modo
+// determinístico se deriva de OCR_MOCK_SEED y de lo que se simula, así el
+// mismo key y url dan siempre el mismo documento, texto, confianza, códigos
+// y fallas, y la latencia es fija: sirve para tests de contrato contra el
+// sandbox.
+
+// mockRand configura la aleatoriedad de los mocks; la arma applyEngines.
+var mockRand = newConfigValue(mockRandom{})
+
+type mockRandom struct {
+	deterministic bool
+	seed          uint64
+	// latency fija la latencia de las llamadas a los motores en modo
+	// determinístico; 0 usa la mínima de cada motor.
+	latency time.Duration
+	// failureRate es la fracción de llamadas en que fallan los mocks, para
+	// simular caídas transitorias del backend.
+	failureRate float64
+}
+
+// source devuelve una fuente para simular lo identificado por parts, que
+// solo cuentan en modo determinístico.
+func (m mockRandom) source(parts ...any) *rand.Rand {
+	if !m.deterministic {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	h := fnv.New64a()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%v\x00", p)
+	}
+	return rand.New(rand.NewPCG(m.seed, h.Sum64()))
+}
+
+// engineLatency es la latencia de una llamada a un motor mock: al azar
+// entre min y max, o fija en modo determinístico.
+func (m mockRandom) engineLatency(r *rand.Rand, min, max time.Duration) time.Duration {
+	switch {
+	case m.deterministic && m.latency > 0:
+		return m.latency
+	case m.deterministic || max <= min:
+		return min
+	}
+	return min + time.Duration(r.Int64N(int64(max-min)))
+}
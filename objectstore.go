@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ObjectStore guarda objetos en un bucket y devuelve su URI.
+type ObjectStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+	// Get lee el objeto de uri, que tiene que ser de este bucket (la que
+	// devolvió Put).
+	Get(ctx context.Context, uri string) ([]byte, error)
+	// Key es la key con que Put guardó el objeto de uri.
+	Key(uri string) (string, error)
+	// Walk llama a fn con la key y la URI de cada objeto guardado.
+	Walk(ctx context.Context, fn func(key, uri string) error) error
+	// Ping verifica que el bucket exista y sea accesible.
+	Ping(ctx context.Context) error
+}
+
+// newObjectStore crea el store según el esquema de la URL configurada.
+func newObjectStore(cfg ArchiveConfig) (ObjectStore, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("OCR_ARCHIVE_URL inválida: %w", err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+
+	switch u.Scheme {
+	case "file":
+		return &fileStore{dir: u.Path, minFree: cfg.MinFreeBytes}, nil
+	case "s3", "gs":
+		endpoint := cfg.Endpoint
+		region := cfg.Region
+		if endpoint == "" && u.Scheme == "gs" {
+			// API XML de GCS, compatible con S3 usando claves HMAC
+			endpoint = "https://storage.googleapis.com"
+			region = "auto"
+		}
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+		if cfg.AccessKey == "" || cfg.SecretKey == "" {
+			return nil, fmt.Errorf("faltan credenciales para %s://", u.Scheme)
+		}
+		return &s3Store{
+			scheme:   u.Scheme,
+			endpoint: strings.TrimRight(endpoint, "/"),
+			bucket:   u.Host,
+			prefix:   prefix,
+			signer:   awsSigner{region: region, service: "s3", accessKey: cfg.AccessKey, secretKey: cfg.SecretKey},
+			client:   &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+	return nil, fmt.Errorf("esquema de archivo no soportado: %q", u.Scheme)
+}
+
+// spaceChecker lo implementan los stores con espacio limitado, para fallar
+// antes de descargar el original en lugar de a mitad de la escritura.
+type spaceChecker interface {
+	checkSpace(n int64) error
+}
+
+// urlSigner lo implementan los stores que pueden dar URLs firmadas para
+// descargar un objeto sin credenciales. file:// no: no hay a quién firmarle.
+type urlSigner interface {
+	signedURL(uri string, ttl time.Duration, now time.Time) (string, error)
+}
+
+// fileStore escribe los objetos en un directorio local (desarrollo/tests).
+// Nunca deja menos de minFree bytes libres en el disco; con minFree cero no
+// lo verifica.
+type fileStore struct {
+	dir     string
+	minFree int64
+}
+
+func (s *fileStore) Put(_ context.Context, key, _ string, data []byte) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	if err := s.checkSpace(int64(len(data))); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(p), nil
+}
+
+func (s *fileStore) Get(_ context.Context, uri string) ([]byte, error) {
+	key, err := s.Key(uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+func (s *fileStore) Key(uri string) (string, error) {
+	p, ok := strings.CutPrefix(uri, "file://")
+	if !ok {
+		return "", fmt.Errorf("%s no es un archivo local", uri)
+	}
+	rel, err := filepath.Rel(s.dir, filepath.FromSlash(p))
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s no es de %s", uri, s.dir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Walk recorre el directorio, salvo los archivos ocultos (los de Ping).
+func (s *fileStore) Walk(ctx context.Context, fn func(key, uri string) error) error {
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), "file://"+filepath.ToSlash(p))
+	})
+	// Todavía no se archivó nada
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileStore) Ping(_ context.Context) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	if err := s.checkSpace(0); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".ping-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkSpace falla si al escribir n bytes quedarían menos de minFree libres.
+func (s *fileStore) checkSpace(n int64) error {
+	if s.minFree == 0 {
+		return nil
+	}
+	free, err := diskFree(s.dir)
+	if err != nil {
+		return err
+	}
+	if free-n < s.minFree {
+		return fmt.Errorf("espacio en disco insuficiente en %s: quedan %d bytes libres y el mínimo es %d (OCR_ARCHIVE_MIN_FREE_BYTES)", s.dir, free, s.minFree)
+	}
+	return nil
+}
+
+var _ = newGaugeFunc("ocr_archive_disk_free_bytes", "Espacio libre en el disco del archivado file://.",
+	nil, func(emit func(float64, ...string)) {
+		s, ok := archiveStore.(*fileStore)
+		if !ok {
+			return
+		}
+		if free, err := diskFree(s.dir); err == nil {
+			emit(float64(free))
+		}
+	})
+
+// s3Store escribe en un bucket S3 (o compatible) firmando con AWS SigV4.
+type s3Store struct {
+	scheme   string
+	endpoint string
+	bucket   string
+	prefix   string
+	signer   awsSigner
+	client   *http.Client
+}
+
+func (s *s3Store) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	objKey := path.Join(s.prefix, key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		s.endpoint+"/"+s.bucket+"/"+awsEscapePath(objKey), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.signer.sign(req, sha256Hex(data), time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("PUT %s: %s: %s", objKey, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return s.scheme + "://" + s.bucket + "/" + objKey, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, uri string) ([]byte, error) {
+	objKey, err := s.objectKey(uri)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/"+s.bucket+"/"+awsEscapePath(objKey), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.signer.sign(req, sha256Hex(nil), time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("GET %s: %s: %s", objKey, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3Store) Key(uri string) (string, error) {
+	objKey, err := s.objectKey(uri)
+	if err != nil || s.prefix == "" {
+		return objKey, err
+	}
+	key, ok := strings.CutPrefix(objKey, s.prefix+"/")
+	if !ok {
+		return "", fmt.Errorf("%s no está bajo el prefijo %s", uri, s.prefix)
+	}
+	return key, nil
+}
+
+// s3ListPage es una página de ListObjectsV2.
+type s3ListPage struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// Walk lista el bucket bajo el prefijo con ListObjectsV2, de a 1000.
+func (s *s3Store) Walk(ctx context.Context, fn func(key, uri string) error) error {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/"+s.bucket, nil)
+		if err != nil {
+			return err
+		}
+		// La query firmada tiene que estar en forma canónica
+		req.URL.RawQuery = strings.ReplaceAll(q.Encode(), "+", "%20")
+		s.signer.sign(req, sha256Hex(nil), time.Now().UTC())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		var page s3ListPage
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return fmt.Errorf("listando bucket %s: %s: %s", s.bucket, resp.Status, strings.TrimSpace(string(body)))
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("listando bucket %s: %w", s.bucket, err)
+		}
+		for _, obj := range page.Contents {
+			if err := fn(strings.TrimPrefix(obj.Key, prefix), s.scheme+"://"+s.bucket+"/"+obj.Key); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Ping hace HEAD sobre el bucket.
+func (s *s3Store) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.endpoint+"/"+s.bucket, nil)
+	if err != nil {
+		return err
+	}
+	s.signer.sign(req, sha256Hex(nil), time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HEAD bucket %s: %s", s.bucket, resp.Status)
+	}
+	return nil
+}
+
+// signedURL prefirma un GET del objeto de uri, que tiene que ser de este
+// bucket (la que devolvió Put).
+func (s *s3Store) signedURL(uri string, ttl time.Duration, now time.Time) (string, error) {
+	objKey, err := s.objectKey(uri)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(s.endpoint + "/" + s.bucket + "/" + awsEscapePath(objKey))
+	if err != nil {
+		return "", err
+	}
+	return s.signer.presign(u, ttl, now), nil
+}
+
+// objectKey es la key en el bucket del objeto de uri.
+func (s *s3Store) objectKey(uri string) (string, error) {
+	objKey, ok := strings.CutPrefix(uri, s.scheme+"://"+s.bucket+"/")
+	if !ok {
+		return "", fmt.Errorf("%s no es del bucket %s", uri, s.bucket)
+	}
+	return objKey, nil
+}
+
+// awsEscapePath codifica cada segmento según RFC 3986, como exige SigV4.
+func awsEscapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		var b strings.Builder
+		for _, c := range []byte(seg) {
+			if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+				c == '-' || c == '_' || c == '.' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segs[i] = b.String()
+	}
+	return strings.Join(segs, "/")
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+type OCRRequest struct {
+	Key      string `json:"key"`
+	URL      string `json:"url"`
+	Priority string `json:"priority,omitempty"` // high | normal | low
+	// ProcessingClass economy difiere el job a una ventana off-peak (solo jobs asíncronos).
+	ProcessingClass string `json:"processing_class,omitempty"` // standard (default) | economy
+	Engine          string `json:"engine,omitempty"`           // default OCR_ENGINE
+	Preset          string `json:"preset,omitempty"`           // preset del servidor ya aplicado por validateInput
+	SplitDocuments  bool   `json:"split_documents,omitempty"`
+	DetectBarcodes  bool   `json:"detect_barcodes,omitempty"`
+	ExtractTables   bool   `json:"extract_tables,omitempty"`
+	Template        string `json:"template,omitempty"` // plantilla de extracción de OCR_TEMPLATES_FILE
+	Language        string `json:"language,omitempty"` // idioma del documento (ISO 639-1); el motor debe reconocerlo
+	// Mode es printed, handwritten o mixed; sin mode se detecta por página.
+	Mode   string `json:"mode,omitempty"`
+	Redact bool   `json:"redact,omitempty"` // enmascara datos personales
+	// IncludeRejectedText devuelve el resultado completo aunque se rechace
+	// por min_confidence del tenant.
+	IncludeRejectedText bool `json:"include_rejected_text,omitempty"`
+	// VerifySignatures verifica las firmas digitales de los PDF.
+	VerifySignatures bool `json:"verify_signatures,omitempty"`
+
+	// Opciones de armado del texto
+	PageSeparator  *string  `json:"page_separator,omitempty"`  // default "\n\n"
+	IncludePages   *bool    `json:"include_pages,omitempty"`   // default: solo multi-página
+	HeadersFooters string   `json:"headers_footers,omitempty"` // keep (default) | strip
+	Remove         []string `json:"remove,omitempty"`          // headers, footers, page_numbers, watermarks
+
+	// MaxTextBytes limita full_text; el resto se pide con el continuation_token.
+	MaxTextBytes int `json:"max_text_bytes,omitempty"`
+
+	// MaxWaitMs es cuánto espera /ocr el resultado: si el procesamiento
+	// tarda más responde 202 con un job y sigue en segundo plano.
+	MaxWaitMs int `json:"max_wait_ms,omitempty"`
+
+	// OnConflict es qué hacer si la key ya tiene un resultado guardado:
+	// reject | replace (default) | version.
+	OnConflict string `json:"on_conflict,omitempty"`
+
+	// Metadata son pares opacos del cliente que vuelven con el resultado.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type BatchOCRRequest struct {
+	Items []OCRRequest `json:"items"`
+	// Deduplicate procesa una sola vez los ítems iguales (default: true).
+	Deduplicate *bool `json:"deduplicate,omitempty"`
+}
+
+func (b BatchOCRRequest) dedup() bool {
+	return b.Deduplicate == nil || *b.Deduplicate
+}
+
+// PageResult es el texto extraído de una página.
+type PageResult struct {
+	Number     int     `json:"page"`
+	Text       string  `json:"text"`
+	Blank      bool    `json:"blank,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+	Engine     string  `json:"engine,omitempty"`
+	Mode       string  `json:"mode,omitempty"`
+	// EnginesTried son los motores probados en orden, si hubo fallback.
+	EnginesTried []string `json:"engines_tried,omitempty"`
+}
+
+type APIResponse struct {
+	Key        string    `json:"key"`
+	StatusCode int       `json:"status_code"`
+	Body       string    `json:"full_text"`
+	Err        string    `json:"err,omitempty"`
+	ErrorCode  ErrorCode `json:"error_code,omitempty"`
+	Confidence float64   `json:"confidence,omitempty"`
+	Engine     string    `json:"engine,omitempty"`
+	// Mode es el modo con que se reconocieron las páginas: el pedido, el
+	// detectado o mixed si difiere entre páginas.
+	Mode      string           `json:"mode,omitempty"`
+	Pages     []PageResult     `json:"pages,omitempty"`
+	Documents []DocumentResult `json:"documents,omitempty"`
+	Barcodes  []Barcode        `json:"barcodes,omitempty"`
+	Tables    []Table          `json:"tables,omitempty"`
+	// Signatures son las firmas del PDF, si se pidió verify_signatures.
+	Signatures []PDFSignature `json:"signatures,omitempty"`
+	// Redacted indica que se enmascararon los datos personales; PIIEntities
+	// ubica las máscaras en full_text.
+	Redacted    bool        `json:"redacted,omitempty"`
+	PIIEntities []PIIEntity `json:"pii_entities,omitempty"`
+	// Corrections son los términos corregidos con los wordlists del tenant.
+	Corrections []Correction `json:"corrections,omitempty"`
+	// Fields son los campos de la plantilla pedida en template.
+	Fields  map[string]ExtractedField `json:"fields,omitempty"`
+	Archive *ArchiveInfo              `json:"archive,omitempty"`
+	// ExportError indica que el OCR terminó bien pero el archivado falló;
+	// se reintenta con POST /ocr/jobs/reexport.
+	ExportError string `json:"export_error,omitempty"`
+	// Deduplicated indica que el resultado es el de otro ítem igual del
+	// batch, procesado una sola vez.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+	// Demo marca los resultados de /demo/ocr.
+	Demo bool `json:"demo,omitempty"`
+	// Timeout explica un ENGINE_TIMEOUT: qué límite se alcanzó y en qué
+	// etapa.
+	Timeout *TimeoutInfo `json:"timeout,omitempty"`
+	// Rejection explica un REJECTED_LOW_CONFIDENCE.
+	Rejection *RejectionInfo `json:"rejection,omitempty"`
+	// Quality son las métricas de la imagen, si se configuró un mínimo;
+	// explican un REJECTED_LOW_QUALITY.
+	Quality *ImageQuality `json:"quality,omitempty"`
+	// Metadata es la del request.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	Truncated         bool   `json:"truncated,omitempty"`
+	ContinuationToken string `json:"continuation_token,omitempty"`
+}
+
+type BatchAPIResponse struct {
+	BatchID string        `json:"batch_id,omitempty"`
+	Results []APIResponse `json:"results"`
+}
+
+func processOCR(ctx context.Context, req OCRRequest) (resp *APIResponse, err error) {
+	start := time.Now()
+	var engineTime time.Duration
+	var pages []PageResult
+	defer func() {
+		resp.withMetadata(req.Metadata)
+		logItem(ctx, req, resp, time.Since(start), engineTime)
+		noteProcessed(ctx, pageEngines(pages), time.Since(start))
+	}()
+
+	setStage(ctx, stageFetch)
+	doc, err := loadDocument(ctx, req.Key, req.URL)
+	var barcodes []Barcode
+	var tables []Table
+	var signatures []PDFSignature
+	var quality *ImageQuality
+	if err == nil {
+		traceEvent(ctx, TraceEvent{Stage: "load", Detail: fmt.Sprintf("%d páginas", len(doc.Pages))})
+		var rejected *APIResponse
+		if quality, rejected = checkQuality(ctx, req, doc); rejected != nil {
+			// Sin pasar por el motor: el escaneo no sirve
+			return rejected, nil
+		}
+		setStage(ctx, stageEngine)
+		engineStart := time.Now()
+		pages, err = recognizePages(ctx, doc, req.Engine, req.Mode)
+		engineTime = time.Since(engineStart)
+	}
+	if err == nil && req.DetectBarcodes {
+		setStage(ctx, stageBarcodes)
+		barcodes, err = detectBarcodes(ctx, doc)
+		if err == nil {
+			traceEvent(ctx, TraceEvent{Stage: "barcodes", Detail: fmt.Sprintf("%d códigos", len(barcodes))})
+		}
+	}
+	if err == nil && req.ExtractTables {
+		setStage(ctx, stageTables)
+		tables, err = extractTables(ctx, doc)
+		if err == nil {
+			traceEvent(ctx, TraceEvent{Stage: "tables", Detail: fmt.Sprintf("%d tablas", len(tables))})
+		}
+	}
+	if err == nil && req.VerifySignatures {
+		setStage(ctx, stageSignatures)
+		signatures, err = verifySignatures(ctx, doc)
+		if err == nil {
+			traceEvent(ctx, TraceEvent{Stage: "signatures", Detail: fmt.Sprintf("%d firmas", len(signatures))})
+		}
+	}
+	if err != nil {
+		traceEvent(ctx, TraceEvent{Stage: "error", Detail: err.Error()})
+		if errorCodeOf(err, CodeEngineError) == CodeEngineError {
+			reportError(ctx, err)
+		}
+		resp := errorResponse(req.Key, errorCodeOf(err, CodeEngineError), err.Error())
+		if resp.Timeout = timeoutInfo(ctx, err, ""); resp.Timeout != nil {
+			resp.Err = resp.Timeout.Detail()
+		}
+		return resp, err
+	}
+
+	// Antes de armar el texto, para que los encabezados repetidos se
+	// detecten ya corregidos
+	corrections := correctPages(ctx, pages)
+	assembled, text := assembleText(pages, req)
+	traceEvent(ctx, TraceEvent{Stage: "assemble", Detail: fmt.Sprintf("%d bytes", len(text))})
+	var entities []PIIEntity
+	if req.Redact {
+		// Antes de documents y de la plantilla, que se arman con este texto
+		text, entities = redactResult(assembled, text, barcodes)
+		traceEvent(ctx, TraceEvent{Stage: "redact", Detail: fmt.Sprintf("%d datos", len(entities))})
+	}
+	resp = &APIResponse{
+		Key:         req.Key,
+		StatusCode:  200,
+		Body:        text,
+		Redacted:    req.Redact,
+		PIIEntities: entities,
+		Metadata:    req.Metadata,
+	}
+	if !req.Redact {
+		// Las correcciones repiten el texto original, que puede tener datos
+		// personales
+		resp.Corrections = corrections
+	}
+	resp.Confidence, resp.Engine = summarizePages(pages)
+	resp.Quality = quality
+	resp.Mode = summarizeModes(pages)
+	resp.Barcodes = barcodes
+	resp.Tables = tables
+	resp.Signatures = signatures
+	if (req.IncludePages == nil && len(pages) > 1) || (req.IncludePages != nil && *req.IncludePages) {
+		resp.Pages = assembled
+	}
+	if req.SplitDocuments {
+		// Los límites se detectan sobre el texto original: el pie
+		// "Página 1 de n" puede haberse quitado al armar el texto.
+		resp.Documents = splitDocuments(pages)
+		fillDocumentText(resp.Documents, assembled, req.pageSeparator())
+	}
+	if req.Template != "" {
+		// Una recarga pudo haber quitado la plantilla después de validar el request
+		if t := templates.load().Templates[req.Template]; t != nil {
+			resp.Fields = t.extractFields(text)
+			traceEvent(ctx, TraceEvent{Stage: "extract", Detail: fmt.Sprintf("%d campos", len(resp.Fields))})
+		} else {
+			traceEvent(ctx, TraceEvent{Stage: "extract", Detail: fmt.Sprintf("plantilla %q inexistente tras recargar la configuración", req.Template)})
+		}
+	}
+
+	if rejected := rejectLowConfidence(ctx, req, resp); rejected != nil {
+		// No se archiva ni se guarda: el resultado no debe llegar a los
+		// sistemas que consumen los resultados
+		return rejected, nil
+	}
+
+	if isDemo(ctx) {
+		// Los resultados de la demo no se archivan ni se guardan
+		return resp, nil
+	}
+
+	if archiveStore != nil {
+		// Una falla del archivado no invalida el OCR: el resultado se
+		// devuelve con export_error y se puede reexportar después.
+		setStage(ctx, stageArchive)
+		archive, err := archiveResult(ctx, req, resp, doc.source)
+		if err != nil {
+			resp.ExportError = err.Error()
+			traceEvent(ctx, TraceEvent{Stage: "archive_failed", Detail: err.Error()})
+		} else {
+			resp.Archive = archive
+			traceEvent(ctx, TraceEvent{Stage: "archive", Detail: archive.ResultURI})
+		}
+	}
+
+	if err := saveResult(ctx, resp, req.OnConflict); err != nil {
+		return errorResponse(req.Key, errorCodeOf(err, CodeInternal), "No se pudo guardar el resultado: "+err.Error()), nil
+	}
+	applyTruncation(resp, req.MaxTextBytes)
+	return resp, nil
+}
+
+// logItem escribe una línea por ítem procesado, con el request_id del
+// request o del job que lo originó.
+func logItem(ctx context.Context, req OCRRequest, resp *APIResponse, elapsed, engineTime time.Duration) {
+	attrs := []slog.Attr{
+		slog.String("key", req.Key),
+		slog.Int64("duration_ms", elapsed.Milliseconds()),
+		slog.Int64("engine_ms", engineTime.Milliseconds()),
+	}
+	level := slog.LevelInfo
+	if resp.ErrorCode != "" {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("outcome", "error"), slog.String("error_code", string(resp.ErrorCode)), slog.String("error", resp.Err))
+	} else {
+		attrs = append(attrs, slog.String("outcome", "ok"), slog.String("engine", resp.Engine),
+			slog.Float64("confidence", resp.Confidence))
+	}
+	loggerFrom(ctx).LogAttrs(ctx, level, "ocr item", attrs...)
+}
+
+// recognizePages corre el motor pedido (o el primario) en paralelo sobre las
+// páginas no vacías. Si falla en una página o su confianza queda por debajo
+// de la mínima del engineSet, la reprocesa con los motores de fallback en
+// orden hasta alcanzarla, y se queda con el resultado de mayor confianza.
+// La página falla solo si fallan todos. Las páginas manuscritas (o todas,
+// con mode handwritten o mixed) van solo a los motores que reconocen
+// manuscritos; si no hay ninguno se leen como impresas.
+func recognizePages(ctx context.Context, doc *Document, engine, mode string) ([]PageResult, error) {
+	pages := make([]PageResult, len(doc.Pages))
+	errs := make(chan error, len(doc.Pages))
+	// Todo el documento usa los motores vigentes al empezar, aunque se
+	// recargue la configuración en el medio
+	set := activeEngineSet.load()
+	chain := set.chain(set.engineFor(engine))
+	hwChain := set.handwritingChain(chain, engine == "")
+	if isDemo(ctx) {
+		// La demo usa solo su motor: el fallback podría llegar a uno de nube
+		chain = chain[:1]
+		hwChain = set.handwritingChain(chain, false)
+	}
+	var wg sync.WaitGroup
+
+	for i, p := range doc.Pages {
+		pages[i] = PageResult{Number: p.Number}
+		if isBlankPage(p) {
+			pages[i].Blank = true
+			traceEvent(ctx, TraceEvent{Stage: "blank", Page: p.Number})
+			continue
+		}
+
+		p.mode = pageMode(mode, p)
+		chain := chain
+		if p.mode != modePrinted {
+			if len(hwChain) == 0 {
+				p.mode = modePrinted
+			} else {
+				chain = hwChain
+				traceEvent(ctx, TraceEvent{Stage: "handwriting", Page: p.Number, Detail: p.mode})
+			}
+		}
+		pages[i].Mode = p.mode
+
+		wg.Add(1)
+		go func(index int, page Page) {
+			defer wg.Done()
+			var best Recognition
+			var bestEngine *resilientEngine
+			var lastErr error
+			var tried []string
+			for n, engine := range chain {
+				stage := "recognize"
+				if n > 0 {
+					stage = "fallback"
+				}
+				tried = append(tried, engine.Name())
+				rec, err := recognizeWithTimeout(ctx, engine, page)
+				if err != nil {
+					traceEvent(ctx, TraceEvent{Stage: stage + "_failed", Page: page.Number,
+						Engine: engine.Name(), EngineVersion: engine.Version(), Detail: err.Error()})
+					lastErr = err
+					if ctx.Err() != nil {
+						// Venció el request o el job: no hay tiempo para otro motor
+						break
+					}
+					continue
+				}
+				traceEvent(ctx, engineEvent(stage, page.Number, engine, rec))
+				if bestEngine == nil || rec.Confidence > best.Confidence {
+					best, bestEngine = rec, engine
+				}
+				if rec.Confidence >= set.minConfidence {
+					break
+				}
+			}
+			if bestEngine == nil {
+				errs <- lastErr
+				return
+			}
+			pages[index].Text = best.Text
+			pages[index].Confidence = best.Confidence
+			pages[index].Engine = bestEngine.Name()
+			if len(tried) > 1 {
+				pages[index].EnginesTried = tried
+			}
+		}(i, p)
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// summarizePages devuelve la confianza media de las páginas con texto y el
+// motor que las produjo ("mixed" si intervino más de uno).
+func summarizePages(pages []PageResult) (float64, string) {
+	var sum float64
+	var n int
+	engine := ""
+	for _, p := range pages {
+		if p.Blank {
+			continue
+		}
+		sum += p.Confidence
+		n++
+		if engine == "" {
+			engine = p.Engine
+		} else if engine != p.Engine {
+			engine = "mixed"
+		}
+	}
+	if n == 0 {
+		return 0, ""
+	}
+	return math.Round(sum/float64(n)*1000) / 1000, engine
+}
+
+// errorResponse arma el resultado fallido de un ítem con el status del catálogo.
+func errorResponse(key string, code ErrorCode, detail string) *APIResponse {
+	return &APIResponse{
+		Key:        key,
+		StatusCode: lookupError(code).Status,
+		Body:       "",
+		Err:        detail,
+		ErrorCode:  code,
+	}
+}
+
+// processBatchOCR encola los ítems como jobs de un batch y espera sus
+// resultados. Con dedup los ítems iguales comparten un job. Si emit no es
+// nil, recibe cada resultado apenas está listo, en orden de llegada.
+func processBatchOCR(ctx context.Context, items []OCRRequest, dedup bool, emit func(batchID string, i int, resp *APIResponse)) *BatchAPIResponse {
+	results := make([]APIResponse, len(items))
+	batchID := newID(12)
+	if emit == nil {
+		emit = func(string, int, *APIResponse) {}
+	}
+	unique, dups := dedupItems(items, dedup)
+	// set guarda el resultado del ítem i y el de sus duplicados
+	set := func(i int, resp *APIResponse) {
+		results[i] = *resp
+		emit(batchID, i, &results[i])
+		for _, d := range dups[i] {
+			results[d.Index] = *d.result(resp)
+			emit(batchID, d.Index, &results[d.Index])
+		}
+	}
+
+	// Encolar cada ítem; cualquier réplica puede procesarlo
+	ids := make([]string, len(unique))
+	for u, i := range unique {
+		item := items[i]
+		if item.Priority == "" {
+			item.Priority = priorityLow
+		}
+		job := newJob(ctx, item, batchID)
+		job.Duplicates = dups[i]
+		job, err := enqueueNewJob(ctx, job)
+		if err != nil {
+			set(i, errorResponse(item.Key, errorCodeOf(err, CodeQueueUnavailable), err.Error()).withMetadata(item.Metadata))
+			continue
+		}
+		ids[u] = job.ID
+	}
+
+	jobs := waitJobs(ctx, ids, func(u int, job Job) {
+		if job.Result != nil {
+			set(unique[u], job.Result)
+		}
+		noteJob(ctx, job)
+	})
+	for u, job := range jobs {
+		i := unique[u]
+		switch {
+		case ids[u] == "" || job.Result != nil:
+			// ya tiene el error de encolado o el resultado
+		default:
+			// If context is cancelled, cancel the pending jobs and report timeout errors
+			cancelJob(context.WithoutCancel(ctx), ids[u])
+			code := errorCodeOf(ctx.Err(), CodeEngineTimeout)
+			if job.Status == jobCancelled && ctx.Err() == nil {
+				code = CodeRequestCancelled
+			}
+			resp := errorResponse(items[i].Key, code, "Batch processing cancelled or timed out").withMetadata(items[i].Metadata)
+			stage := stageProcessing
+			if job.Status == jobQueued {
+				stage = stageQueue
+			}
+			if resp.Timeout = timeoutInfo(ctx, ctx.Err(), stage); resp.Timeout != nil {
+				resp.Err = resp.Timeout.Detail()
+			}
+			set(i, resp)
+		}
+	}
+
+	return &BatchAPIResponse{
+		BatchID: batchID,
+		Results: results,
+	}
+}
@@ -0,0 +1,840 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Contrato OpenAPI 3.1 de la API, servido en GET /openapi.json y navegable
+// con Swagger UI en GET /docs. Las operaciones se declaran en
+// contractOperations y los schemas se generan de los tipos de Go de cada
+// body: un campo nuevo en un tipo aparece en el contrato sin tocarlo. Al
+// arrancar, el contrato se compara con las rutas del router y una ruta sin
+// operación, o una operación sin ruta, impide arrancar. validateContract
+// valida las requests (y, si se pide, las respuestas) contra este documento.
+
+// Tags de las operaciones.
+const (
+	tagHealth    = "salud"
+	tagCatalogs  = "catálogos"
+	tagOCR       = "ocr"
+	tagJobs      = "jobs"
+	tagBatches   = "batches"
+	tagResults   = "resultados"
+	tagWordlists = "wordlists"
+	tagSchedules = "schedules"
+	tagUsage     = "uso"
+	tagCompat    = "compatibilidad"
+	tagDemo      = "demo"
+)
+
+// apiContent asocia cada media type de un body a su tipo de Go; nil es un
+// body sin schema (texto, zip, HTML).
+type apiContent map[string]any
+
+func jsonContent(v any) apiContent {
+	return apiContent{"application/json": v}
+}
+
+// archiveContent son los tipos de un objeto archivado: el resultado JSON o
+// el original, en uno de los formatos de entrada.
+func archiveContent() apiContent {
+	c := apiContent{"application/json": nil, "application/octet-stream": nil}
+	for _, mediaType := range supportedContentTypes {
+		c[mediaType] = nil
+	}
+	return c
+}
+
+// apiParam es un parámetro de query.
+type apiParam struct {
+	name        string
+	description string
+	schema      *jsonSchema
+}
+
+// apiOperation es una operación del contrato. responses[0] es la respuesta
+// de error; si no está, es un Problem.
+type apiOperation struct {
+	method    string
+	path      string // con los parámetros como en chi: /ocr/jobs/{id}
+	tag       string
+	summary   string
+	public    bool // sin auth (grupos public y demo)
+	query     []apiParam
+	request   apiContent
+	responses map[int]apiContent
+	// skipRequest no valida la request: la operación informa los errores
+	// en su respuesta (/ocr/validate) o en el formato de otra API.
+	skipRequest bool
+}
+
+// Tipos de los parámetros de query.
+var (
+	paramInt      = &jsonSchema{Type: "integer"}
+	paramNumber   = &jsonSchema{Type: "number"}
+	paramString   = &jsonSchema{Type: "string"}
+	paramDate     = &jsonSchema{Type: "string", Format: "date"}
+	paramDateTime = &jsonSchema{Type: "string", Format: "date-time"}
+)
+
+var (
+	formatParam = apiParam{"format", "Formato de la respuesta; sin él se elige por Accept", &jsonSchema{Type: "string", Enum: outputFormats}}
+	usageParams = []apiParam{
+		{"from", "Primer día (YYYY-MM-DD); default hace 29 días", paramDate},
+		{"to", "Último día (YYYY-MM-DD), inclusive; default hoy", paramDate},
+	}
+	resultsPageParams = []apiParam{
+		{"offset", "Posición del primer resultado", paramInt},
+		{"limit", fmt.Sprintf("Resultados por página, hasta %d", maxResultsLimit), paramInt},
+		{"status", "Estados de job separados por comas (" + strings.Join(jobStatuses, ", ") + ")", paramString},
+	}
+	batchRetryParam = apiParam{"error_code", "Códigos de error separados por comas (p. ej. ENGINE_TIMEOUT); default todos", paramString}
+)
+
+// xmlContent es un body de /compat/xml; xmlProblem, sus errores.
+var (
+	xmlContent = apiContent{"application/xml": nil, "text/xml": nil}
+	xmlProblem = apiContent{"application/problem+xml": nil, "text/xml": nil}
+)
+
+// resultFormats es un resultado en JSON o en los otros formatos de salida.
+func resultFormats(v any) apiContent {
+	return apiContent{
+		"application/json":     v,
+		"text/plain":           nil,
+		"text/vnd.hocr+html":   nil,
+		"application/alto+xml": nil,
+	}
+}
+
+// batchBody es el envelope JSON de un batch o el batch en CSV o JSONL.
+func batchBody(v any) apiContent {
+	c := jsonContent(v)
+	for _, mt := range slices.Concat(csvMediaTypes, jsonlMediaTypes) {
+		c[mt] = nil
+	}
+	return c
+}
+
+// contractOperations devuelve las operaciones del contrato: las fijas, la
+// demo si está habilitada y los alias de OCR_COMPAT_FILE.
+func contractOperations(aliases []CompatAlias, demoEnabled bool) []apiOperation {
+	ops := []apiOperation{
+		{method: "GET", path: "/health", tag: tagHealth, public: true,
+			summary:   "Estado del servicio; degraded si el circuit breaker del motor primario no está cerrado",
+			responses: map[int]apiContent{200: jsonContent(HealthStatus{})}},
+		{method: "GET", path: "/health/live", tag: tagHealth, public: true,
+			summary: "El proceso está vivo (no verifica dependencias)",
+			responses: map[int]apiContent{200: jsonContent(struct {
+				Status string `json:"status"`
+			}{})}},
+		{method: "GET", path: "/health/ready", tag: tagHealth, public: true,
+			summary:   "Estado de las dependencias; 503 si alguna no responde",
+			responses: map[int]apiContent{200: jsonContent(ReadinessStatus{}), 503: jsonContent(ReadinessStatus{})}},
+		{method: "GET", path: "/metrics", tag: tagHealth, public: true,
+			summary:   "Métricas en formato Prometheus",
+			responses: map[int]apiContent{200: {"text/plain": nil}}},
+		{method: "GET", path: "/presets", tag: tagCatalogs, public: true,
+			summary:   "Defaults y presets del servidor",
+			responses: map[int]apiContent{200: jsonContent(PresetCatalog{})}},
+		{method: "GET", path: "/templates", tag: tagCatalogs, public: true,
+			summary:   "Plantillas de extracción y locales soportados",
+			responses: map[int]apiContent{200: jsonContent(TemplateCatalog{})}},
+		{method: "GET", path: "/problems", tag: tagCatalogs, public: true,
+			summary:   "Catálogo de códigos de error",
+			responses: map[int]apiContent{200: jsonContent([]ErrorDefinition{})}},
+		{method: "GET", path: "/problems/{slug}", tag: tagCatalogs, public: true,
+			summary:   "Definición de un código de error (type de los Problem)",
+			responses: map[int]apiContent{200: jsonContent(ErrorDefinition{})}},
+		{method: "GET", path: "/openapi.json", tag: tagCatalogs, public: true,
+			summary:   "Este contrato",
+			responses: map[int]apiContent{200: jsonContent(nil)}},
+		{method: "GET", path: "/docs", tag: tagCatalogs, public: true,
+			summary:   "Swagger UI del contrato",
+			responses: map[int]apiContent{200: {"text/html": nil}}},
+
+		{method: "POST", path: "/ocr", tag: tagOCR,
+			summary:   "OCR de un documento; con max_wait_ms, si tarda más responde 202 con un job",
+			query:     []apiParam{formatParam},
+			request:   jsonContent(OCRRequest{}),
+			responses: map[int]apiContent{200: resultFormats(APIResponse{}), 202: jsonContent(Job{})}},
+		{method: "POST", path: "/ocr/batch", tag: tagOCR,
+			summary: "OCR de varios documentos; con Accept: application/x-ndjson cada resultado se envía apenas termina",
+			request: batchBody(BatchOCRRequest{}),
+			responses: map[int]apiContent{200: {
+				"application/json": BatchAPIResponse{},
+				mediaTypeNDJSON:    BatchStreamItem{},
+			}}},
+		{method: "POST", path: "/ocr/validate", tag: tagOCR, skipRequest: true,
+			summary: "Valida un request o un batch sin procesarlo",
+			request: batchBody(struct {
+				OCRRequest
+				Items       []OCRRequest `json:"items,omitempty"`
+				Deduplicate *bool        `json:"deduplicate,omitempty"`
+			}{}),
+			responses: map[int]apiContent{200: jsonContent(ValidationReport{})}},
+		{method: "GET", path: "/ocr/ws", tag: tagOCR,
+			summary:   "Sesión WebSocket: un request de OCR por mensaje; cada resultado llega como WSResult o WSError",
+			responses: map[int]apiContent{101: nil}},
+
+		{method: "POST", path: "/ocr/jobs", tag: tagJobs,
+			summary:   "Encola un job; con depends_on espera a esos jobs y la url es opcional",
+			request:   jsonContent(JobRequest{}),
+			responses: map[int]apiContent{202: jsonContent(Job{})}},
+		{method: "GET", path: "/ocr/jobs/{id}", tag: tagJobs,
+			summary:   "Estado y, si terminó, resultado del job",
+			responses: map[int]apiContent{200: jsonContent(Job{})}},
+		{method: "DELETE", path: "/ocr/jobs/{id}", tag: tagJobs,
+			summary:   "Cancela el job si todavía no terminó",
+			responses: map[int]apiContent{200: jsonContent(CancelSummary{})}},
+		{method: "GET", path: "/ocr/jobs/{id}/history", tag: tagJobs,
+			summary:   "Transiciones de estado del job",
+			query:     []apiParam{{"at", "Devuelve el estado que tenía el job en ese momento (RFC 3339)", paramDateTime}},
+			responses: map[int]apiContent{200: jsonContent(JobHistory{})}},
+		{method: "POST", path: "/ocr/jobs/reexport", tag: tagJobs,
+			summary:   "Reintenta el archivado de jobs completed_unexported",
+			request:   jsonContent(ReexportRequest{}),
+			responses: map[int]apiContent{200: jsonContent(ReexportSummary{})}},
+		{method: "GET", path: "/ocr/jobs/{id}/export", tag: tagJobs,
+			summary:   "Zip firmado con el job para auditoría",
+			responses: map[int]apiContent{200: {"application/zip": nil}}},
+		{method: "GET", path: "/ocr/exports/public-key", tag: tagJobs,
+			summary: "Clave pública para verificar manifest.sig",
+			responses: map[int]apiContent{200: jsonContent(struct {
+				Algorithm string `json:"algorithm"`
+				PublicKey string `json:"public_key"`
+			}{})}},
+
+		{method: "POST", path: "/ocr/batches", tag: tagBatches,
+			summary:   "Encola un job por ítem del batch",
+			request:   batchBody(BatchOCRRequest{}),
+			responses: map[int]apiContent{202: jsonContent(BatchStatus{})}},
+		{method: "GET", path: "/ocr/batches/{id}", tag: tagBatches,
+			summary:   "Cantidad de jobs del batch por estado",
+			responses: map[int]apiContent{200: jsonContent(BatchStatus{})}},
+		{method: "GET", path: "/ocr/batches/{id}/results", tag: tagBatches,
+			summary:   "Página de resultados del batch, en el orden del request",
+			query:     resultsPageParams,
+			responses: map[int]apiContent{200: jsonContent(BatchResultsPage{})}},
+		{method: "GET", path: "/ocr/batch/{id}/results", tag: tagBatches,
+			summary:   "Alias de /ocr/batches/{id}/results",
+			query:     resultsPageParams,
+			responses: map[int]apiContent{200: jsonContent(BatchResultsPage{})}},
+		{method: "POST", path: "/ocr/batches/{id}/retry", tag: tagBatches,
+			summary:   "Vuelve a encolar los jobs fallidos del batch",
+			query:     []apiParam{batchRetryParam},
+			responses: map[int]apiContent{202: jsonContent(BatchRetrySummary{})}},
+		{method: "POST", path: "/ocr/batch/{id}/retry", tag: tagBatches,
+			summary:   "Alias de /ocr/batches/{id}/retry",
+			query:     []apiParam{batchRetryParam},
+			responses: map[int]apiContent{202: jsonContent(BatchRetrySummary{})}},
+		{method: "DELETE", path: "/ocr/batches/{id}", tag: tagBatches,
+			summary:   "Cancela los jobs del batch que no terminaron",
+			responses: map[int]apiContent{200: jsonContent(CancelSummary{})}},
+
+		{method: "GET", path: "/ocr/continuations/{token}", tag: tagResults,
+			summary:   "Siguiente fragmento de un texto truncado por max_text_bytes",
+			query:     []apiParam{{"max_text_bytes", "Largo máximo del fragmento", paramInt}},
+			responses: map[int]apiContent{200: jsonContent(ContinuationResponse{})}},
+		{method: "GET", path: "/ocr/clusters", tag: tagResults,
+			summary: "Clusters de documentos parecidos del tenant",
+			query: append([]apiParam{
+				{"threshold", "Similitud mínima, entre 0.5 y 1", paramNumber},
+				{"min_size", "Documentos mínimos por cluster", paramInt},
+			}, usageParams...),
+			responses: map[int]apiContent{200: jsonContent(ClusterReport{})}},
+		{method: "GET", path: "/ocr/results", tag: tagResults,
+			summary: "Búsqueda de texto completo en los resultados guardados del tenant",
+			query: []apiParam{
+				{"query", `Palabras (todas deben aparecer), "frases exactas" y prefijos (fact*)`, paramString},
+				{"document_type", "Tipo de documento", &jsonSchema{Type: "string", Enum: documentTypeNames()}},
+				{"from", "Primer día de proceso (YYYY-MM-DD)", paramDate},
+				{"to", "Último día de proceso (YYYY-MM-DD), inclusive", paramDate},
+				{"sort", "Orden; default relevance con query y -created_at sin ella", &jsonSchema{Type: "string", Enum: searchSorts}},
+				{"offset", "Posición del primer resultado", paramInt},
+				{"limit", fmt.Sprintf("Resultados por página, hasta %d", maxResultsLimit), paramInt},
+			},
+			responses: map[int]apiContent{200: jsonContent(ResultSearchPage{})}},
+		{method: "GET", path: "/ocr/results/{key}", tag: tagResults,
+			summary: "Resultado guardado con sus anotaciones",
+			query: []apiParam{
+				formatParam,
+				{"version", "Versión anterior a devolver (ver /versions); default la actual", paramInt},
+			},
+			responses: map[int]apiContent{200: resultFormats(StoredResult{})}},
+		{method: "GET", path: "/ocr/results/{key}/download", tag: tagResults,
+			summary: "URLs firmadas de la imagen original y del resultado archivados",
+			query: []apiParam{
+				{"version", "Versión anterior (ver /versions); default la actual", paramInt},
+			},
+			responses: map[int]apiContent{200: jsonContent(ResultDownload{})}},
+		{method: "GET", path: "/ocr/archive/{token}", tag: tagResults, public: true,
+			summary:   "Objeto archivado cifrado, descifrado en el servidor (enlace de /download)",
+			responses: map[int]apiContent{200: archiveContent()}},
+		{method: "GET", path: "/ocr/results/{key}/versions", tag: tagResults,
+			summary:   "Versiones guardadas de la key, de la más reciente a la más antigua",
+			responses: map[int]apiContent{200: jsonContent(ResultVersions{})}},
+		{method: "GET", path: "/ocr/results/{key}/annotations", tag: tagResults,
+			summary: "Anotaciones del resultado",
+			responses: map[int]apiContent{200: jsonContent(struct {
+				Annotations []Annotation `json:"annotations"`
+			}{})}},
+		{method: "POST", path: "/ocr/results/{key}/annotations", tag: tagResults,
+			summary:   "Agrega una anotación al resultado",
+			request:   jsonContent(Annotation{}),
+			responses: map[int]apiContent{201: jsonContent(Annotation{})}},
+		{method: "DELETE", path: "/ocr/results/{key}/annotations/{id}", tag: tagResults,
+			summary:   "Borra una anotación",
+			responses: map[int]apiContent{204: nil}},
+
+		{method: "GET", path: "/wordlists", tag: tagWordlists,
+			summary: "Wordlists del tenant, sin las entradas",
+			responses: map[int]apiContent{200: jsonContent(struct {
+				Wordlists []Wordlist `json:"wordlists"`
+			}{})}},
+		{method: "GET", path: "/wordlists/{name}", tag: tagWordlists,
+			summary:   "Wordlist con sus entradas",
+			responses: map[int]apiContent{200: jsonContent(Wordlist{})}},
+		{method: "PUT", path: "/wordlists/{name}", tag: tagWordlists,
+			summary: "Crea o reemplaza el wordlist (administrador del tenant); en text/plain, una entrada por línea",
+			request: apiContent{
+				"application/json": struct {
+					Entries []string `json:"entries"`
+				}{},
+				"text/plain": nil,
+			},
+			responses: map[int]apiContent{200: jsonContent(Wordlist{}), 201: jsonContent(Wordlist{})}},
+		{method: "DELETE", path: "/wordlists/{name}", tag: tagWordlists,
+			summary:   "Borra el wordlist (administrador del tenant)",
+			responses: map[int]apiContent{204: nil}},
+
+		{method: "GET", path: "/schedules", tag: tagSchedules,
+			summary: "Schedules del tenant, del más antiguo al más nuevo",
+			responses: map[int]apiContent{200: jsonContent(struct {
+				Schedules []Schedule `json:"schedules"`
+			}{})}},
+		{method: "POST", path: "/schedules", tag: tagSchedules,
+			summary:   "Crea un schedule (administrador del tenant)",
+			request:   jsonContent(Schedule{}),
+			responses: map[int]apiContent{201: jsonContent(Schedule{})}},
+		{method: "GET", path: "/schedules/{id}", tag: tagSchedules,
+			summary:   "Schedule con su próxima y su última ejecución",
+			responses: map[int]apiContent{200: jsonContent(Schedule{})}},
+		{method: "DELETE", path: "/schedules/{id}", tag: tagSchedules,
+			summary:   "Borra el schedule (administrador del tenant)",
+			responses: map[int]apiContent{204: nil}},
+		{method: "POST", path: "/schedules/{id}/run", tag: tagSchedules,
+			summary:   "Corre el schedule ahora (administrador del tenant)",
+			responses: map[int]apiContent{202: jsonContent(Schedule{})}},
+
+		{method: "GET", path: "/usage", tag: tagUsage,
+			summary:   "Documentos procesados por día del tenant",
+			query:     usageParams,
+			responses: map[int]apiContent{200: jsonContent(UsageReport{})}},
+		{method: "GET", path: "/usage/rollups", tag: tagUsage,
+			summary: "Volumen, latencia, errores y confianza del tenant por hora o día y motor",
+			query: append([]apiParam{
+				{"granularity", "hour o day (default)", &jsonSchema{Type: "string", Enum: rollupGranularities}},
+				{"engine", "Filtra por motor", paramString},
+			}, usageParams...),
+			responses: map[int]apiContent{200: jsonContent(RollupReport{})}},
+		{method: "GET", path: "/audit", tag: tagUsage,
+			summary: "Auditoría de los documentos procesados del tenant (administrador del tenant)",
+			query: append([]apiParam{
+				{"key", "Filtra por key", paramString},
+				{"api_key_id", "Filtra por API key (los primeros 16 hex de su SHA-256)", paramString},
+				{"status", "completed o failed", &jsonSchema{Type: "string", Enum: auditStatuses}},
+				{"engine", "Filtra por motor", paramString},
+				{"format", "json (default) o csv, que exporta todas las entradas sin paginar", &jsonSchema{Type: "string", Enum: []string{"json", "csv"}}},
+				{"offset", "Posición de la primera entrada", paramInt},
+				{"limit", fmt.Sprintf("Entradas por página, hasta %d", maxResultsLimit), paramInt},
+			}, usageParams...),
+			responses: map[int]apiContent{200: {"application/json": OperationPage{}, "text/csv": nil}}},
+
+		{method: "POST", path: "/compat/vision/v1/images:annotate", tag: tagCompat, skipRequest: true,
+			summary: "images:annotate de Google Cloud Vision (TEXT_DETECTION y DOCUMENT_TEXT_DETECTION)",
+			request: jsonContent(visionAnnotateRequest{}),
+			responses: map[int]apiContent{
+				200: jsonContent(visionAnnotateResponse{}),
+				0: jsonContent(struct {
+					Error visionStatus `json:"error"`
+				}{}),
+			}},
+		{method: "POST", path: "/compat/textract", tag: tagCompat, skipRequest: true,
+			summary: "DetectDocumentText de AWS Textract (X-Amz-Target: Textract.DetectDocumentText)",
+			request: apiContent{"application/x-amz-json-1.1": textractRequest{}},
+			responses: map[int]apiContent{
+				200: {"application/x-amz-json-1.1": textractResponse{}},
+				0: {"application/x-amz-json-1.1": struct {
+					Type    string `json:"__type"`
+					Message string `json:"message"`
+				}{}},
+			}},
+		{method: "POST", path: "/compat/xml/ocr", tag: tagCompat, skipRequest: true,
+			summary:   "POST /ocr en XML: los campos JSON como elementos, también dentro de un envelope SOAP 1.1",
+			request:   xmlContent,
+			responses: map[int]apiContent{200: xmlContent, 0: xmlProblem}},
+		{method: "POST", path: "/compat/xml/ocr/batch", tag: tagCompat, skipRequest: true,
+			summary:   "POST /ocr/batch en XML: los arrays con un hijo <item> por elemento",
+			request:   xmlContent,
+			responses: map[int]apiContent{200: xmlContent, 0: xmlProblem}},
+	}
+
+	if demoEnabled {
+		ops = append(ops, apiOperation{method: "POST", path: "/demo/ocr", tag: tagDemo, public: true,
+			summary:   "OCR de prueba de una imagen, sin auth y con límites propios",
+			request:   jsonContent(DemoRequest{}),
+			responses: map[int]apiContent{200: jsonContent(APIResponse{})}})
+	}
+	for _, a := range aliases {
+		// El payload es el del consumidor legado: no tiene schema
+		ops = append(ops, apiOperation{method: "POST", path: a.Path, tag: tagCompat, skipRequest: true,
+			summary:   fmt.Sprintf("Alias %s de OCR_COMPAT_FILE (target %s)", a.Name, a.Target),
+			request:   jsonContent(json.RawMessage{}),
+			responses: map[int]apiContent{200: jsonContent(json.RawMessage{}), 0: jsonContent(json.RawMessage{})}})
+	}
+	return ops
+}
+
+// schemaHint completa el schema de un tipo con lo que no se deduce de Go:
+// los campos requeridos en una request, los valores admitidos y los campos
+// que asigna el servidor (readOnly) o que no se devuelven (writeOnly).
+// omitempty no sirve para deducir los requeridos: muchos tipos se usan en
+// requests y en respuestas.
+type schemaHint struct {
+	required  []string
+	enum      map[string][]string
+	readOnly  []string
+	writeOnly []string
+}
+
+var schemaHints = map[string]schemaHint{
+	"OCRRequest": {
+		required: []string{"key", "url"},
+		enum: map[string][]string{
+			"priority":         priorities,
+			"processing_class": processingClasses,
+			"headers_footers":  {headersFootersKeep, headersFootersStrip},
+			"mode":             recognitionModes,
+			"remove":           removeOptions,
+			"on_conflict":      conflictModes,
+		},
+	},
+	"JobRequest":      {required: []string{"key"}},
+	"BatchOCRRequest": {required: []string{"items"}},
+	"DemoRequest":     {required: []string{"url"}},
+	"Job":             {enum: map[string][]string{"status": jobStatuses}},
+	"JobState":        {enum: map[string][]string{"status": jobStatuses}},
+	"BatchResult":     {enum: map[string][]string{"status": jobStatuses}},
+	"Annotation": {
+		required: []string{"type"},
+		enum:     map[string][]string{"type": {annotationComment, annotationHighlight, annotationCorrection}},
+		readOnly: []string{"id", "created_at"},
+	},
+	"Schedule": {
+		required:  []string{"cron", "manifest_url"},
+		readOnly:  []string{"id", "tenant", "created_at", "next_run", "last_run"},
+		writeOnly: []string{"webhook_secret"},
+	},
+	"ScheduleRun": {enum: map[string][]string{"status": {runRunning, runCompleted, runFailed}}},
+}
+
+// typeEnums son los valores admitidos de los tipos con nombre.
+var typeEnums = map[reflect.Type][]string{
+	reflect.TypeFor[ErrorCode](): errorCodes(),
+}
+
+func errorCodes() []string {
+	codes := make([]string, len(errorCatalog))
+	for i, d := range errorCatalog {
+		codes[i] = string(d.Code)
+	}
+	return codes
+}
+
+// jsonSchema es el subconjunto de JSON Schema 2020-12 que usa el contrato.
+// Type es un string, o una lista con "null" si el campo puede ser null.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 any                    `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	ReadOnly             bool                   `json:"readOnly,omitempty"`
+	WriteOnly            bool                   `json:"writeOnly,omitempty"`
+}
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// nullable permite además null, como un puntero, slice o map sin omitempty.
+func (s *jsonSchema) nullable() *jsonSchema {
+	switch t := s.Type.(type) {
+	case string:
+		out := *s
+		out.Type = []string{t, "null"}
+		return &out
+	case nil:
+		if s.Ref != "" {
+			return &jsonSchema{AnyOf: []*jsonSchema{s, {Type: "null"}}}
+		}
+	}
+	return s
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// schemaGenerator arma los schemas de los tipos de Go siguiendo las reglas
+// de encoding/json. Los structs con nombre van a components/schemas.
+type schemaGenerator struct {
+	schemas map[string]*jsonSchema
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) *jsonSchema {
+	switch t {
+	case timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &jsonSchema{}
+	}
+	if enum, ok := typeEnums[t]; ok {
+		return &jsonSchema{Type: "string", Enum: enum}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaOf(t.Elem())
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// Se registra antes de armarlo por los tipos recursivos
+			s := &jsonSchema{}
+			g.schemas[name] = s
+			*s = *g.structSchema(t)
+		}
+		return &jsonSchema{Ref: schemaRefPrefix + name}
+	}
+	return &jsonSchema{} // interfaces: cualquier valor
+}
+
+// schemaName es el nombre del tipo con mayúscula inicial.
+func schemaName(t reflect.Type) string {
+	r, n := utf8.DecodeRuneInString(t.Name())
+	return string(unicode.ToUpper(r)) + t.Name()[n:]
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	g.addFields(s, t, false)
+	s.Required = schemaHints[t.Name()].required
+	return s
+}
+
+// addFields agrega los campos de t a s. Los de un struct embebido se
+// promueven, salvo los que el struct externo ya define.
+func (g *schemaGenerator) addFields(s *jsonSchema, t reflect.Type, embedded bool) {
+	hint := schemaHints[t.Name()]
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft, true)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := s.Properties[name]; ok && embedded {
+			continue
+		}
+
+		fs := g.schemaOf(ft)
+		if enum, ok := hint.enum[name]; ok {
+			if fs.Type == "array" {
+				fs.Items.Enum = enum
+			} else {
+				fs.Enum = enum
+			}
+		}
+		omitempty := slices.Contains(strings.Split(opts, ","), "omitempty")
+		switch ft.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map:
+			if !omitempty {
+				fs = fs.nullable()
+			}
+		}
+		if slices.Contains(hint.readOnly, name) || slices.Contains(hint.writeOnly, name) {
+			if fs.Ref != "" {
+				fs = &jsonSchema{AnyOf: []*jsonSchema{fs}}
+			}
+			fs.ReadOnly = slices.Contains(hint.readOnly, name)
+			fs.WriteOnly = slices.Contains(hint.writeOnly, name)
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// Documento OpenAPI.
+type (
+	openAPIDocument struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       openAPIInfo                             `json:"info"`
+		Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+		Components openAPIComponents                       `json:"components"`
+	}
+	openAPIInfo struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	}
+	openAPIComponents struct {
+		Schemas         map[string]*jsonSchema           `json:"schemas"`
+		SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+	}
+	openAPISecurityScheme struct {
+		Type        string `json:"type"`
+		In          string `json:"in"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	openAPIOperation struct {
+		Tags        []string                    `json:"tags"`
+		Summary     string                      `json:"summary"`
+		Security    []map[string][]string       `json:"security"`
+		Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+		RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*openAPIResponse `json:"responses"`
+	}
+	openAPIParameter struct {
+		Name        string      `json:"name"`
+		In          string      `json:"in"`
+		Description string      `json:"description,omitempty"`
+		Required    bool        `json:"required,omitempty"`
+		Schema      *jsonSchema `json:"schema"`
+	}
+	openAPIRequestBody struct {
+		Required bool                    `json:"required"`
+		Content  map[string]openAPIMedia `json:"content"`
+	}
+	openAPIResponse struct {
+		Description string                  `json:"description"`
+		Content     map[string]openAPIMedia `json:"content,omitempty"`
+	}
+	openAPIMedia struct {
+		Schema *jsonSchema `json:"schema,omitempty"`
+	}
+)
+
+// apiContract es el contrato armado: el documento servido en /openapi.json
+// y las operaciones por método y path, que usa validateContract.
+type apiContract struct {
+	doc        openAPIDocument
+	json       []byte
+	operations map[string]*openAPIOperation // "POST /ocr"
+	skip       map[string]bool              // operaciones con skipRequest
+
+	validation   string
+	maxBodyBytes int64
+}
+
+// contract es el contrato de las rutas vigentes; se reemplaza junto con el
+// router al recargar la configuración.
+var contract = newConfigValue[*apiContract](nil)
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// newContract arma el contrato de las operaciones.
+func newContract(ops []apiOperation) (*apiContract, error) {
+	g := &schemaGenerator{schemas: map[string]*jsonSchema{}}
+	c := &apiContract{
+		doc: openAPIDocument{
+			OpenAPI: "3.1.0",
+			Info: openAPIInfo{
+				Title:       "api-ocr",
+				Version:     "1",
+				Description: "Servicio de OCR. Los errores son Problem (RFC 7807) con un code del catálogo de GET /problems.",
+			},
+			Paths: map[string]map[string]*openAPIOperation{},
+			Components: openAPIComponents{
+				Schemas: g.schemas,
+				SecuritySchemes: map[string]openAPISecurityScheme{
+					"apiKey":   {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "API key de un tenant de OCR_TENANTS_FILE"},
+					"tenantId": {Type: "apiKey", In: "header", Name: "X-Tenant-ID", Description: "Tenant sin API key; sin OCR_TENANTS_FILE es opcional"},
+				},
+			},
+		},
+		operations: map[string]*openAPIOperation{},
+		skip:       map[string]bool{},
+	}
+	problem := g.schemaOf(reflect.TypeFor[Problem]())
+
+	for _, op := range ops {
+		id := op.method + " " + op.path
+		if c.operations[id] != nil {
+			return nil, fmt.Errorf("operación %s repetida", id)
+		}
+		o := &openAPIOperation{
+			Tags:      []string{op.tag},
+			Summary:   op.summary,
+			Security:  []map[string][]string{},
+			Responses: map[string]*openAPIResponse{},
+		}
+		if !op.public {
+			o.Security = []map[string][]string{{"apiKey": {}}, {"tenantId": {}}}
+		}
+		for _, m := range pathParamPattern.FindAllStringSubmatch(op.path, -1) {
+			o.Parameters = append(o.Parameters, openAPIParameter{Name: m[1], In: "path", Required: true, Schema: paramString})
+		}
+		for _, p := range op.query {
+			o.Parameters = append(o.Parameters, openAPIParameter{Name: p.name, In: "query", Description: p.description, Schema: p.schema})
+		}
+		if op.request != nil {
+			o.RequestBody = &openAPIRequestBody{Required: true, Content: g.media(op.request)}
+		}
+		for status, content := range op.responses {
+			if status == 0 {
+				continue
+			}
+			o.Responses[fmt.Sprint(status)] = &openAPIResponse{Description: http.StatusText(status), Content: g.media(content)}
+		}
+		if content, ok := op.responses[0]; ok {
+			o.Responses["default"] = &openAPIResponse{Description: "Error", Content: g.media(content)}
+		} else {
+			o.Responses["default"] = &openAPIResponse{
+				Description: "Error",
+				Content:     map[string]openAPIMedia{"application/problem+json": {Schema: problem}},
+			}
+		}
+
+		if c.doc.Paths[op.path] == nil {
+			c.doc.Paths[op.path] = map[string]*openAPIOperation{}
+		}
+		c.doc.Paths[op.path][strings.ToLower(op.method)] = o
+		c.operations[id] = o
+		c.skip[id] = op.skipRequest
+	}
+
+	var err error
+	if c.json, err = json.MarshalIndent(c.doc, "", "  "); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (g *schemaGenerator) media(content apiContent) map[string]openAPIMedia {
+	out := map[string]openAPIMedia{}
+	for mt, v := range content {
+		var m openAPIMedia
+		if v != nil {
+			m.Schema = g.schemaOf(reflect.TypeOf(v))
+		}
+		out[mt] = m
+	}
+	return out
+}
+
+// checkRoutes compara el contrato con las rutas del router. /admin no es
+// parte del contrato y los OPTIONS los agrega cors.
+func (c *apiContract) checkRoutes(r chi.Routes) error {
+	var missing []string
+	routes := map[string]bool{}
+	chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if method == http.MethodOptions || strings.HasPrefix(route, "/admin/") {
+			return nil
+		}
+		id := method + " " + route
+		routes[id] = true
+		if c.operations[id] == nil {
+			missing = append(missing, "ruta sin operación: "+id)
+		}
+		return nil
+	})
+	for id := range c.operations {
+		if !routes[id] {
+			missing = append(missing, "operación sin ruta: "+id)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("el contrato OpenAPI no coincide con el router: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// GET /openapi.json -> el contrato
+func handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(contract.load().json)
+}
+
+// swaggerUIPage carga Swagger UI desde un CDN: el servicio no lo empaqueta.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="es">
+<head>
+<meta charset="utf-8">
+<title>api-ocr</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// GET /docs -> Swagger UI del contrato
+func handleDocs(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Detección y redacción de datos personales (PII). Con "redact": true el
+// texto se enmascara antes de armar la respuesta, así full_text, pages,
+// documents, los campos de la plantilla, el archivado y los formatos
+// alternativos nunca llevan el dato original.
+
+var piiEntitiesTotal = newCounterVec("ocr_pii_entities_total", "Datos personales redactados por tipo.", "type")
+
+// PIIEntity es un dato personal enmascarado; start y end son offsets en
+// bytes de la máscara dentro de full_text.
+type PIIEntity struct {
+	Type  string `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// piiDetector reconoce un tipo de dato. Si la expresión tiene grupos solo se
+// enmascara el primero (p. ej. el nombre y no la etiqueta "Nombre:"); valid,
+// si no es nil, descarta falsos positivos.
+type piiDetector struct {
+	typ   string
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+var builtinPIIDetectors = []piiDetector{
+	{typ: "email", re: regexp.MustCompile(`[\p{L}0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	// CUIT/CUIL, DNI con puntos y números tras "DNI", "Pasaporte", etc.
+	{typ: "document_id", re: regexp.MustCompile(`\b\d{2}-\d{8}-\d\b`)},
+	{typ: "document_id", re: regexp.MustCompile(`\b\d{1,2}\.\d{3}\.\d{3}\b`)},
+	{typ: "document_id", re: regexp.MustCompile(`(?i:DNI|documento|pasaporte|passport)\s*(?:N[°º.o]?\s*)?:?\s*([A-Z]{0,3}\d{6,9})\b`)},
+	{typ: "credit_card", re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhnValid},
+	{typ: "phone", re: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)[ .-]?)?\b(?:\d{2,4}[ .-]?)?\d{3,4}[ .-]\d{4}\b`)},
+	{typ: "name", re: regexp.MustCompile(`(?i:nombres?|apellidos?|titular)(?: y (?i:nombres?|apellidos?))?\s*:\s*(\p{Lu}[\p{L}']+(?:[ ]+\p{Lu}[\p{L}']+)*)`)},
+}
+
+// piiDetectors son los detectores activos: los incorporados menos los
+// deshabilitados en OCR_PII_FILE, más los que define ese archivo.
+var piiDetectors = newConfigValue(builtinPIIDetectors)
+
+// PIIFile es el formato de OCR_PII_FILE.
+//
+//	{
+//	  "disable": ["phone"],
+//	  "patterns": {
+//	    "name": "(?:Sr\\.|Sra\\.)\\s+(\\p{Lu}\\p{L}+(?:\\s+\\p{Lu}\\p{L}+)*)",
+//	    "employee_id": "LEG-\\d{6}"
+//	  }
+//	}
+type PIIFile struct {
+	Disable  []string          `json:"disable"`
+	Patterns map[string]string `json:"patterns"`
+}
+
+// loadPIIDetectors arma los detectores activos a partir del archivo.
+func loadPIIDetectors(path string) ([]piiDetector, error) {
+	var pf PIIFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var builtin []string
+	for _, d := range builtinPIIDetectors {
+		if !slices.Contains(builtin, d.typ) {
+			builtin = append(builtin, d.typ)
+		}
+	}
+	for _, typ := range pf.Disable {
+		if !slices.Contains(builtin, typ) {
+			return nil, fmt.Errorf("%s: disable: %q no es uno de: %s", path, typ, strings.Join(builtin, ", "))
+		}
+	}
+	var detectors []piiDetector
+	for _, d := range builtinPIIDetectors {
+		if !slices.Contains(pf.Disable, d.typ) {
+			detectors = append(detectors, d)
+		}
+	}
+	for _, typ := range slices.Sorted(maps.Keys(pf.Patterns)) {
+		re, err := regexp.Compile(pf.Patterns[typ])
+		if err != nil {
+			return nil, fmt.Errorf("%s: patterns.%s: %w", path, typ, err)
+		}
+		detectors = append(detectors, piiDetector{typ: typ, re: re})
+	}
+	return detectors, nil
+}
+
+type piiSpan struct {
+	typ        string
+	start, end int
+}
+
+// findPII devuelve los datos encontrados en s, ordenados y sin solaparse: si
+// dos detectores encuentran datos que se pisan gana el que empieza antes y,
+// a igual inicio, el más largo.
+func findPII(s string) []piiSpan {
+	var spans []piiSpan
+	for _, d := range piiDetectors.load() {
+		for _, m := range d.re.FindAllStringSubmatchIndex(s, -1) {
+			start, end := m[0], m[1]
+			if len(m) > 2 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			if start == end || (d.valid != nil && !d.valid(s[start:end])) {
+				continue
+			}
+			spans = append(spans, piiSpan{typ: d.typ, start: start, end: end})
+		}
+	}
+	slices.SortStableFunc(spans, func(a, b piiSpan) int {
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return b.end - a.end
+	})
+	var out []piiSpan
+	for _, sp := range spans {
+		if len(out) == 0 || sp.start >= out[len(out)-1].end {
+			out = append(out, sp)
+		}
+	}
+	return out
+}
+
+// redactText reemplaza cada dato por su tipo entre corchetes, p. ej.
+// [EMAIL], y devuelve las posiciones de las máscaras en el texto resultante.
+func redactText(s string) (string, []PIIEntity) {
+	var b strings.Builder
+	var entities []PIIEntity
+	last := 0
+	for _, sp := range findPII(s) {
+		b.WriteString(s[last:sp.start])
+		start := b.Len()
+		b.WriteString("[" + strings.ToUpper(sp.typ) + "]")
+		entities = append(entities, PIIEntity{Type: sp.typ, Start: start, End: b.Len()})
+		last = sp.end
+	}
+	if last == 0 {
+		return s, nil
+	}
+	b.WriteString(s[last:])
+	return b.String(), entities
+}
+
+// redactResult enmascara las páginas armadas, el texto completo y los
+// códigos de barras, cuyo contenido (p. ej. el PDF417 de un DNI) se oculta
+// entero.
+func redactResult(pages []PageResult, text string, barcodes []Barcode) (string, []PIIEntity) {
+	for i := range pages {
+		pages[i].Text, _ = redactText(pages[i].Text)
+	}
+	text, entities := redactText(text)
+	for _, e := range entities {
+		piiEntitiesTotal.Inc(e.Type)
+	}
+	for i := range barcodes {
+		barcodes[i].Value = "[BARCODE]"
+		piiEntitiesTotal.Inc("barcode")
+	}
+	return text, entities
+}
+
+// luhnValid verifica el dígito de control de un número de tarjeta.
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Prioridades de OCRRequest.Priority, de mayor a menor.
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+var priorities = []string{priorityHigh, priorityNormal, priorityLow}
+
+func priorityIndex(p string) int {
+	for i, name := range priorities {
+		if name == p {
+			return i
+		}
+	}
+	return 1
+}
+
+// task es un ítem esperando en la cola del pool.
+type task struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	req      OCRRequest
+	tenant   string
+	queued   time.Time
+	started  time.Time
+	priority int
+	done     chan taskResult
+}
+
+type taskResult struct {
+	resp *APIResponse
+	err  error
+}
+
+// workerPool procesa ítems de OCR con size workers. Los workers toman
+// siempre de la cola de mayor prioridad, salvo que la tarea más antigua de
+// una cola inferior lleve esperando más que aging. Las tareas de un tenant
+// que llegó a su max_concurrent esperan sin bloquear a las de otros. size
+// se puede cambiar en caliente con resize.
+type workerPool struct {
+	aging time.Duration
+	// ocr procesa cada ítem: processOCR, salvo en los benchmarks.
+	ocr func(ctx context.Context, req OCRRequest) (*APIResponse, error)
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  [3][]*task
+	running map[*task]struct{}
+	tenants map[string]int // tareas en proceso por tenant
+	size    int
+	workers int // goroutines vivas; baja hasta size a medida que terminan
+	busy    int
+	// service es la media móvil de lo que tarda un ítem en proceso, para
+	// estimar la espera de la cola.
+	service time.Duration
+}
+
+// serviceWeight es el peso de cada ítem nuevo en la media de service.
+const serviceWeight = 0.2
+
+var pool *workerPool
+
+func newWorkerPool(workers int, aging time.Duration) *workerPool {
+	p := &workerPool{aging: aging, ocr: processOCR, running: map[*task]struct{}{}, tenants: map[string]int{}}
+	p.cond = sync.NewCond(&p.mu)
+	p.resize(workers)
+	return p
+}
+
+// resize ajusta la cantidad de workers. Los que sobran terminan al quedar
+// libres, sin cortar el ítem que están procesando.
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	p.size = n
+	for p.workers < n {
+		p.workers++
+		go p.work()
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// retire indica si el worker debe terminar. Debe llamarse con p.mu tomado.
+func (p *workerPool) retire() bool {
+	if p.workers > p.size {
+		p.workers--
+		return true
+	}
+	return false
+}
+
+// run encola el request y espera su resultado o la cancelación del contexto.
+func (p *workerPool) run(ctx context.Context, req OCRRequest) (*APIResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t := &task{
+		ctx:      ctx,
+		cancel:   cancel,
+		req:      req,
+		tenant:   tenantFrom(ctx),
+		queued:   time.Now(),
+		priority: priorityIndex(req.Priority),
+		done:     make(chan taskResult, 1),
+	}
+
+	setStage(ctx, stageQueue)
+	p.mu.Lock()
+	p.queues[t.priority] = append(p.queues[t.priority], t)
+	p.mu.Unlock()
+	p.cond.Signal()
+
+	select {
+	case res := <-t.done:
+		return res.resp, res.err
+	case <-ctx.Done():
+		p.remove(t)
+		resp := errorResponse(req.Key, errorCodeOf(ctx.Err(), CodeEngineTimeout), "Cancelado mientras esperaba en la cola").withMetadata(req.Metadata)
+		if resp.Timeout = timeoutInfo(ctx, ctx.Err(), stageQueue); resp.Timeout != nil {
+			resp.Err = resp.Timeout.Detail()
+		}
+		return resp, ctx.Err()
+	}
+}
+
+// remove saca de la cola una tarea cuyo contexto terminó antes de empezar.
+func (p *workerPool) remove(t *task) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q := p.queues[t.priority]
+	for i, queued := range q {
+		if queued == t {
+			p.queues[t.priority] = append(q[:i], q[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *workerPool) work() {
+	for {
+		p.mu.Lock()
+		if p.retire() {
+			p.mu.Unlock()
+			return
+		}
+		t := p.next()
+		for t == nil {
+			p.cond.Wait()
+			if p.retire() {
+				p.mu.Unlock()
+				return
+			}
+			t = p.next()
+		}
+		p.busy++
+		t.started = time.Now()
+		p.running[t] = struct{}{}
+		p.mu.Unlock()
+
+		p.process(t)
+	}
+}
+
+// process procesa la tarea y libera su lugar en el pool. Un panic no mata
+// al worker: se informa al error tracker y, si la tarea no tenía resultado
+// todavía, termina con INTERNAL_ERROR.
+func (p *workerPool) process(t *task) {
+	defer p.release(t)
+	answered := false
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		// Se omiten esta función y runtime.gopanic
+		reportTaskPanic(t.ctx, v, stackFrames(2))
+		loggerFrom(t.ctx).Error("panic recovered", "key", t.req.Key, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+		if !answered {
+			resp := errorResponse(t.req.Key, CodeInternal, "Error interno inesperado").withMetadata(t.req.Metadata)
+			t.done <- taskResult{resp: resp, err: &codedError{CodeInternal, fmt.Errorf("panic: %v", v)}}
+		}
+	}()
+
+	noteQueueWait(t.ctx, t.started.Sub(t.queued))
+	resp, err := p.ocr(t.ctx, t.req)
+	t.done <- taskResult{resp: resp, err: err}
+	answered = true
+	recordUsage(t.tenant, resp)
+	recordRollup(t.tenant, t.req, resp, time.Since(t.queued))
+	recordOperation(t.ctx, t.req, resp, time.Since(t.queued))
+}
+
+// release saca la tarea de las que están en proceso.
+func (p *workerPool) release(t *task) {
+	p.mu.Lock()
+	p.busy--
+	if took := time.Since(t.started); p.service == 0 {
+		p.service = took
+	} else {
+		p.service += time.Duration(serviceWeight * float64(took-p.service))
+	}
+	delete(p.running, t)
+	if p.tenants[t.tenant]--; p.tenants[t.tenant] == 0 {
+		delete(p.tenants, t.tenant)
+	}
+	p.mu.Unlock()
+	// Puede haber quedado lugar para una tarea de ese tenant
+	p.cond.Signal()
+}
+
+// next saca la próxima tarea a procesar. Debe llamarse con p.mu tomado.
+func (p *workerPool) next() *task {
+	pick, at := -1, 0
+	for i := range p.queues {
+		j := p.firstEligible(i)
+		if j < 0 {
+			continue
+		}
+		if pick < 0 {
+			pick, at = i, j
+			continue
+		}
+		if time.Since(p.queues[i][j].queued) > p.aging {
+			pick, at = i, j
+			break
+		}
+	}
+	if pick < 0 {
+		return nil
+	}
+	t := p.queues[pick][at]
+	p.queues[pick] = slices.Delete(p.queues[pick], at, at+1)
+	p.tenants[t.tenant]++
+	return t
+}
+
+// firstEligible devuelve la posición de la primera tarea de la cola cuyo
+// tenant no llegó a su límite de concurrencia, o -1. Debe llamarse con
+// p.mu tomado.
+func (p *workerPool) firstEligible(queue int) int {
+	for j, t := range p.queues[queue] {
+		limit := tenants.load().get(t.tenant).MaxConcurrent
+		if limit == 0 || p.tenants[t.tenant] < limit {
+			return j
+		}
+	}
+	return -1
+}
+
+// depth devuelve la cantidad de tareas esperando por prioridad.
+func (p *workerPool) depth() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := map[string]int{}
+	for i, name := range priorities {
+		out[name] = len(p.queues[i])
+	}
+	return out
+}
+
+// estimate devuelve cuántos ítems esperan en la cola y cuánto esperaría uno
+// nuevo con esa prioridad: los de su prioridad o mayor que tiene delante,
+// repartidos entre los workers, a la media de service. Sin ítems
+// procesados todavía no hay con qué estimar y la espera es 0.
+func (p *workerPool) estimate(priority int) (queued int, wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ahead := 0
+	for i, q := range p.queues {
+		queued += len(q)
+		if i <= priority {
+			ahead += len(q)
+		}
+	}
+	if p.size == 0 || p.busy+ahead < p.size {
+		return queued, 0
+	}
+	return queued, time.Duration(ahead+p.busy-p.size+1) * p.service / time.Duration(p.size)
+}
+
+// drainTime estima cuánto tardan los workers en sacar n ítems de la cola.
+func (p *workerPool) drainTime(n int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size == 0 {
+		return 0
+	}
+	return time.Duration(n) * p.service / time.Duration(p.size)
+}
+
+// ActiveTask es un ítem esperando o en proceso en el pool.
+type ActiveTask struct {
+	Key       string     `json:"key"`
+	Tenant    string     `json:"tenant"`
+	Priority  string     `json:"priority"`
+	State     string     `json:"state"` // queued | running
+	QueuedAt  time.Time  `json:"queued_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// active lista los ítems en proceso y en cola, en ese orden.
+func (p *workerPool) active() []ActiveTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := []ActiveTask{}
+	for t := range p.running {
+		started := t.started
+		out = append(out, ActiveTask{Key: t.req.Key, Tenant: t.tenant, Priority: priorities[t.priority], State: "running", QueuedAt: t.queued, StartedAt: &started})
+	}
+	for i, q := range p.queues {
+		for _, t := range q {
+			out = append(out, ActiveTask{Key: t.req.Key, Tenant: t.tenant, Priority: priorities[i], State: "queued", QueuedAt: t.queued})
+		}
+	}
+	return out
+}
+
+// cancelKey cancela los ítems en cola o en proceso con esa key y devuelve
+// cuántos encontró.
+func (p *workerPool) cancelKey(key string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for t := range p.running {
+		if t.req.Key == key {
+			t.cancel()
+			n++
+		}
+	}
+	for _, q := range p.queues {
+		for _, t := range q {
+			if t.req.Key == key {
+				t.cancel()
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// PoolStats resume el estado del pool.
+type PoolStats struct {
+	Workers int            `json:"workers"`
+	Busy    int            `json:"busy"`
+	Queued  map[string]int `json:"queued"`
+	Aging   string         `json:"priority_aging"`
+}
+
+func (p *workerPool) stats() PoolStats {
+	queued := p.depth()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{Workers: p.size, Busy: p.busy, Queued: queued, Aging: p.aging.String()}
+}
+
+var (
+	_ = newGaugeFunc("ocr_workers", "Workers configurados en el pool.",
+		nil, func(emit func(float64, ...string)) {
+			if pool == nil {
+				return
+			}
+			pool.mu.Lock()
+			size := pool.size
+			pool.mu.Unlock()
+			emit(float64(size))
+		})
+	_ = newGaugeFunc("ocr_queue_depth", "Ítems esperando un worker, por prioridad.",
+		[]string{"priority"}, func(emit func(float64, ...string)) {
+			if pool == nil {
+				return
+			}
+			depth := pool.depth()
+			for _, name := range priorities {
+				emit(float64(depth[name]), name)
+			}
+		})
+	_ = newGaugeFunc("ocr_workers_busy", "Workers procesando un ítem.",
+		nil, func(emit func(float64, ...string)) {
+			if pool == nil {
+				return
+			}
+			pool.mu.Lock()
+			busy := pool.busy
+			pool.mu.Unlock()
+			emit(float64(busy))
+		})
+)
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchPool arma un pool de workers cuyo procesamiento es ocr, con los
+// stores en memoria que usa al terminar cada ítem.
+func benchPool(b *testing.B, workers int, aging time.Duration, ocr func(context.Context, OCRRequest) (*APIResponse, error)) *workerPool {
+	b.Helper()
+	if err := setupQueue(context.Background(), QueueConfig{}); err != nil {
+		b.Fatal(err)
+	}
+	p := newWorkerPool(0, aging)
+	p.ocr = ocr
+	p.resize(workers)
+	b.Cleanup(func() { p.resize(0) })
+	return p
+}
+
+func instantOCR(_ context.Context, req OCRRequest) (*APIResponse, error) {
+	return &APIResponse{Key: req.Key, StatusCode: 200, Engine: "bench"}, nil
+}
+
+// BenchmarkWorkerPoolRun mide el costo del pool por ítem (encolar, elegir
+// la tarea, despertar al worker y devolver el resultado) con muchos
+// clientes compitiendo por pocos workers, en las tres prioridades.
+func BenchmarkWorkerPoolRun(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			p := benchPool(b, workers, time.Second, instantOCR)
+			var n atomic.Int64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				ctx := context.Background()
+				for pb.Next() {
+					i := n.Add(1)
+					req := OCRRequest{Key: fmt.Sprint("k", i), Priority: priorities[i%3]}
+					if _, err := p.run(ctx, req); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkWorkerPoolAging mide la espera de los ítems low cuando los
+// high no dejan de llegar: sin aging esperarían a que se vacíe la cola
+// high. Informa la espera media de un low en ms.
+func BenchmarkWorkerPoolAging(b *testing.B) {
+	for _, aging := range []time.Duration{time.Millisecond, 10 * time.Millisecond} {
+		b.Run(fmt.Sprintf("aging=%s", aging), func(b *testing.B) {
+			p := benchPool(b, 2, aging, func(ctx context.Context, req OCRRequest) (*APIResponse, error) {
+				time.Sleep(50 * time.Microsecond)
+				return instantOCR(ctx, req)
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// Presión constante de ítems high
+			for i := range 8 {
+				go func() {
+					for ctx.Err() == nil {
+						p.run(ctx, OCRRequest{Key: fmt.Sprint("high-", i), Priority: priorityHigh})
+					}
+				}()
+			}
+
+			var waited time.Duration
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				if _, err := p.run(ctx, OCRRequest{Key: fmt.Sprint("low-", i), Priority: priorityLow}); err != nil {
+					b.Fatal(err)
+				}
+				waited += time.Since(start)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(waited.Milliseconds())/float64(b.N), "low-wait-ms/op")
+		})
+	}
+}
+
+// BenchmarkWorkerPoolNext mide la elección de la próxima tarea con las
+// colas llenas, cuando la más antigua de low ya superó el aging y hay que
+// recorrer las tres colas.
+func BenchmarkWorkerPoolNext(b *testing.B) {
+	for _, queued := range []int{100, 10000} {
+		b.Run(fmt.Sprintf("queued=%d", queued), func(b *testing.B) {
+			p := newWorkerPool(0, time.Millisecond)
+			old := time.Now().Add(-time.Second)
+			fill := func() {
+				for i := range p.queues {
+					p.queues[i] = p.queues[i][:0]
+					for j := 0; j < queued/3; j++ {
+						p.queues[i] = append(p.queues[i], &task{tenant: defaultTenant, queued: old, priority: i})
+					}
+				}
+				clear(p.tenants)
+			}
+			fill()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.mu.Lock()
+				if p.next() == nil {
+					b.StopTimer()
+					fill()
+					b.StartTimer()
+				}
+				p.mu.Unlock()
+			}
+		})
+	}
+}
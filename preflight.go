@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Validación en seco: /ocr/validate revisa un request o batch como lo haría
+// /ocr o /ocr/batches, y además descarga cada URL para comprobar que
+// responde, su tipo, su tamaño y cuántas páginas tiene, sin hacer OCR, sin
+// consumir cuota y sin registrar uso.
+
+const (
+	preflightConcurrency = 8
+	preflightTimeout     = 10 * time.Second
+)
+
+// ValidationItem es el resultado de validar un ítem. HTTPStatus,
+// ContentType, SizeBytes y Pages quedan vacíos si no se llegó a descargar;
+// Pages también si no se pudieron contar.
+type ValidationItem struct {
+	Index       int            `json:"index"`
+	Key         string         `json:"key,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Valid       bool           `json:"valid"`
+	Errors      []InvalidParam `json:"errors,omitempty"`
+	Warnings    []string       `json:"warnings,omitempty"`
+	HTTPStatus  int            `json:"http_status,omitempty"`
+	ContentType string         `json:"content_type,omitempty"`
+	SizeBytes   int64          `json:"size_bytes,omitempty"`
+	Pages       int            `json:"pages,omitempty"`
+	Engine      string         `json:"engine"`
+}
+
+// ValidationQuota compara los documentos del request con la cuota diaria
+// que le queda al tenant.
+type ValidationQuota struct {
+	DailyDocuments int  `json:"daily_documents"`
+	Used           int  `json:"used"`
+	Remaining      int  `json:"remaining"`
+	Sufficient     bool `json:"sufficient"`
+}
+
+// ValidationReport es la respuesta de /ocr/validate. Documents son los que
+// se procesarían, descontados los ítems repetidos si hay dedup, y Pages la
+// suma de las páginas contadas.
+type ValidationReport struct {
+	Valid     bool             `json:"valid"`
+	Documents int              `json:"documents"`
+	Pages     int              `json:"pages"`
+	Quota     *ValidationQuota `json:"quota,omitempty"`
+	Items     []ValidationItem `json:"items"`
+}
+
+// POST /ocr/validate -> {key,url,...} o {items:[...]} (también CSV o JSONL)
+// -> 200 con la validación de cada ítem; valid es false si alguno falla
+func handleValidate(limits LimitsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeProblem(w, r, newProblem(CodePayloadTooLarge,
+					fmt.Sprintf("El body supera el máximo de %d bytes", limits.MaxBodyBytes)))
+				return
+			}
+			writeProblem(w, r, newProblem(CodeInvalidInput, "No se pudo leer el body"))
+			return
+		}
+		body, err = batchEnvelope(r.Header.Get("Content-Type"), body, true)
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeInvalidInput, err.Error()))
+			return
+		}
+		body, invalid, err := presets.load().resolvePresets(body)
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+			return
+		}
+		if len(invalid) > 0 {
+			p := newProblem(CodeInvalidInput, "La request usa presets inválidos")
+			p.InvalidParams = invalid
+			writeProblem(w, r, p)
+			return
+		}
+		var in struct {
+			OCRRequest
+			Items       []OCRRequest `json:"items"`
+			Deduplicate *bool        `json:"deduplicate"`
+		}
+		if err := json.Unmarshal(body, &in); err != nil {
+			writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+			return
+		}
+		items, prefix := in.Items, "items[%d]."
+		if items == nil {
+			items, prefix = []OCRRequest{in.OCRRequest}, ""
+		}
+		if len(items) == 0 {
+			writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera {key,url} o {items: [{key,url},...]}"))
+			return
+		}
+		if len(items) > limits.MaxBatchItems {
+			writeProblem(w, r, newProblem(CodePayloadTooLarge,
+				fmt.Sprintf("El batch tiene %d ítems; el máximo es %d", len(items), limits.MaxBatchItems)))
+			return
+		}
+
+		out := ValidationReport{Valid: true, Items: make([]ValidationItem, len(items))}
+		sem := make(chan struct{}, preflightConcurrency)
+		var wg sync.WaitGroup
+		for i, item := range items {
+			p := prefix
+			if p != "" {
+				p = fmt.Sprintf(prefix, i)
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				out.Items[i] = preflightItem(r.Context(), i, item, p, limits)
+			}()
+		}
+		wg.Wait()
+
+		batch := BatchOCRRequest{Items: items, Deduplicate: in.Deduplicate}
+		out.Documents = uniqueItems(items, batch.dedup())
+		failed := 0
+		for _, item := range out.Items {
+			out.Pages += item.Pages
+			if !item.Valid {
+				out.Valid = false
+				failed++
+			}
+		}
+		if t := tenants.load().get(tenantFrom(r.Context())); t.DailyDocuments > 0 {
+			used, err := usageStore.Documents(r.Context(), t.ID, usageDay(time.Now()))
+			if err != nil {
+				writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+				return
+			}
+			q := &ValidationQuota{DailyDocuments: t.DailyDocuments, Used: used, Remaining: max(0, t.DailyDocuments-used)}
+			q.Sufficient = out.Documents <= q.Remaining
+			out.Quota = q
+			out.Valid = out.Valid && q.Sufficient
+		}
+		addLogAttrs(r.Context(), slog.Int("items", len(items)), slog.Int("items_failed", failed))
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// preflightItem valida las opciones del ítem y, si la URL es aceptable, la
+// descarga.
+func preflightItem(ctx context.Context, index int, req OCRRequest, prefix string, limits LimitsConfig) ValidationItem {
+	item := ValidationItem{Index: index, Key: req.Key, URL: req.URL, Engine: engineFor(req.Engine).Name()}
+	if req.Key == "" {
+		item.Errors = append(item.Errors, InvalidParam{Name: prefix + "key", Reason: "es requerido"})
+	}
+	if req.URL == "" {
+		item.Errors = append(item.Errors, InvalidParam{Name: prefix + "url", Reason: "es requerido"})
+	}
+	invalid := req.validate(prefix, limits)
+	item.Errors = append(item.Errors, invalid...)
+	if req.URL != "" && !slices.ContainsFunc(invalid, func(p InvalidParam) bool { return p.Name == prefix+"url" }) {
+		ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+		defer cancel()
+		if err := probeURL(ctx, req.URL, &item); err != nil {
+			item.Errors = append(item.Errors, InvalidParam{Name: prefix + "url", Reason: err.Error()})
+		}
+	}
+	item.Valid = len(item.Errors) == 0
+	return item
+}
+
+// probeURL descarga la URL y completa estado, tipo, tamaño y páginas. Las
+// imágenes simples se cortan apenas se conoce el tipo; los PDF y TIFF se
+// leen completos, hasta maxOriginalBytes, para contar sus páginas.
+func probeURL(ctx context.Context, rawURL string, item *ValidationItem) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("no respondió en %s", preflightTimeout)
+		}
+		if errors.Is(err, errFetchDenied) {
+			return errors.New("apunta a una dirección de red no permitida")
+		}
+		return fmt.Errorf("no se pudo descargar: %v", err)
+	}
+	defer resp.Body.Close()
+	item.HTTPStatus = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET respondió %s", resp.Status)
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("no se pudo descargar: %v", err)
+	}
+	head = head[:n]
+	mediaType := detectFormat(head, resp.Header.Get("Content-Type"))
+	item.ContentType = mediaType
+	if !slices.Contains(supportedContentTypes, mediaType) {
+		return fmt.Errorf("formato %q no soportado (soportados: %s)", mediaType, strings.Join(supportedContentTypes, ", "))
+	}
+	switch declared, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); declared {
+	case mediaType:
+	case "", "application/octet-stream":
+		item.Warnings = append(item.Warnings, "el servidor no informa el tipo de contenido; se detecta por los primeros bytes")
+	default:
+		item.Warnings = append(item.Warnings, fmt.Sprintf("el servidor informa %s pero el contenido es %s", declared, mediaType))
+	}
+	if _, convert := imageDecoders[mediaType]; convert {
+		item.Warnings = append(item.Warnings, mediaType+" se convierte a PNG antes del OCR")
+	}
+	item.SizeBytes = resp.ContentLength
+	if item.SizeBytes > maxOriginalBytes {
+		return fmt.Errorf("pesa %d bytes; el máximo es %d", item.SizeBytes, maxOriginalBytes)
+	}
+
+	if mediaType != mediaPDF && mediaType != mediaTIFF {
+		item.Pages = 1
+		if item.SizeBytes < 0 {
+			item.SizeBytes = 0
+			item.Warnings = append(item.Warnings, "el servidor no informa el tamaño")
+		}
+		return nil
+	}
+	rest, err := io.ReadAll(io.LimitReader(resp.Body, maxOriginalBytes+1-int64(len(head))))
+	if err != nil {
+		return fmt.Errorf("no se pudo descargar: %v", err)
+	}
+	data := append(head, rest...)
+	item.SizeBytes = int64(len(data))
+	if item.SizeBytes > maxOriginalBytes {
+		return fmt.Errorf("pesa más de %d bytes", maxOriginalBytes)
+	}
+	if mediaType == mediaPDF {
+		item.Pages = countPDFPages(data)
+	} else {
+		item.Pages = countTIFFPages(data)
+	}
+	if item.Pages == 0 {
+		item.Warnings = append(item.Warnings, "no se pudieron contar las páginas")
+	}
+	return nil
+}
+
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// countPDFPages cuenta los objetos de página. No sigue object streams
+// comprimidos, donde el conteo da 0.
+func countPDFPages(data []byte) int {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return 0
+	}
+	return len(pdfPagePattern.FindAllIndex(data, -1))
+}
+
+// countTIFFPages recorre la cadena de IFDs, uno por página.
+func countTIFFPages(data []byte) int {
+	if len(data) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	pages := 0
+	seen := map[uint32]bool{}
+	for off := order.Uint32(data[4:]); off != 0 && !seen[off]; pages++ {
+		seen[off] = true
+		if int64(off)+2 > int64(len(data)) {
+			break
+		}
+		entries := int64(order.Uint16(data[off:]))
+		next := int64(off) + 2 + entries*12
+		if next+4 > int64(len(data)) {
+			break
+		}
+		off = order.Uint32(data[next:])
+	}
+	return pages
+}
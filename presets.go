@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// PresetFile es el formato de OCR_PRESETS_FILE: opciones por defecto para
+// todos los requests y presets con nombre que el cliente elige con "preset".
+//
+//	{
+//	  "defaults": {"headers_footers": "strip"},
+//	  "presets": {
+//	    "ar_invoices_fast": {"engine": "mock", "split_documents": true, "remove": ["page_numbers"]}
+//	  }
+//	}
+type PresetFile struct {
+	Defaults map[string]json.RawMessage            `json:"defaults"`
+	Presets  map[string]map[string]json.RawMessage `json:"presets"`
+}
+
+// presets es la configuración cargada; vacía si no hay OCR_PRESETS_FILE.
+var presets = newConfigValue(PresetFile{})
+
+// presetReserved son campos que identifican el request y no pueden venir de
+// un preset ni de los defaults.
+var presetReserved = []string{"key", "url", "preset", "items"}
+
+// loadPresets lee el archivo de presets y lo valida contra las plantillas
+// y los motores de rc.
+func loadPresets(path string, limits LimitsConfig, rc requestConfig) (PresetFile, error) {
+	var pf PresetFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pf, err
+	}
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return pf, fmt.Errorf("%s: %w", path, err)
+	}
+
+	check := func(name string, opts map[string]json.RawMessage) error {
+		for _, field := range presetReserved {
+			if _, ok := opts[field]; ok {
+				return fmt.Errorf("%s: %s no puede definir %q", path, name, field)
+			}
+		}
+		raw, _ := json.Marshal(opts)
+		var req OCRRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		if invalid := req.validateWith(name+".", limits, rc); len(invalid) > 0 {
+			return fmt.Errorf("%s: %s: %s", path, invalid[0].Name, invalid[0].Reason)
+		}
+		return nil
+	}
+	if err := check("defaults", pf.Defaults); err != nil {
+		return pf, err
+	}
+	for name, opts := range pf.Presets {
+		if err := check("presets."+name, opts); err != nil {
+			return pf, err
+		}
+	}
+	return pf, nil
+}
+
+// applyPreset devuelve el ítem con los defaults y el preset elegido (o
+// inherited, el preset del batch) aplicados; los campos del cliente tienen
+// prioridad. Devuelve un InvalidParam si el preset no existe.
+func (pf PresetFile) applyPreset(item map[string]json.RawMessage, inherited, prefix string) (map[string]json.RawMessage, *InvalidParam) {
+	name := inherited
+	if raw, ok := item["preset"]; ok {
+		if err := json.Unmarshal(raw, &name); err != nil {
+			return nil, &InvalidParam{Name: prefix + "preset", Reason: "debe ser un string"}
+		}
+	}
+
+	merged := maps.Clone(pf.Defaults)
+	if merged == nil {
+		merged = map[string]json.RawMessage{}
+	}
+	if name != "" {
+		preset, ok := pf.Presets[name]
+		if !ok {
+			return nil, &InvalidParam{Name: prefix + "preset", Reason: fmt.Sprintf("preset %q inexistente", name)}
+		}
+		maps.Copy(merged, preset)
+		merged["preset"], _ = json.Marshal(name)
+	}
+	maps.Copy(merged, item)
+	return merged, nil
+}
+
+// resolvePresets aplica defaults y presets al body de /ocr o /ocr/batch. En
+// un batch, el "preset" de nivel superior vale para los ítems que no eligen uno.
+func (pf PresetFile) resolvePresets(body []byte) ([]byte, []InvalidParam, error) {
+	if len(pf.Defaults) == 0 && len(pf.Presets) == 0 {
+		return body, nil, nil
+	}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(body, &top); err != nil {
+		return nil, nil, err
+	}
+
+	rawItems, isBatch := top["items"]
+	if !isBatch {
+		merged, invalid := pf.applyPreset(top, "", "")
+		if invalid != nil {
+			return nil, []InvalidParam{*invalid}, nil
+		}
+		out, err := json.Marshal(merged)
+		return out, nil, err
+	}
+
+	var batchPreset string
+	if raw, ok := top["preset"]; ok {
+		if err := json.Unmarshal(raw, &batchPreset); err != nil {
+			return nil, []InvalidParam{{Name: "preset", Reason: "debe ser un string"}}, nil
+		}
+		if _, ok := pf.Presets[batchPreset]; !ok {
+			return nil, []InvalidParam{{Name: "preset", Reason: fmt.Sprintf("preset %q inexistente", batchPreset)}}, nil
+		}
+	}
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(rawItems, &items); err != nil {
+		return nil, nil, err
+	}
+	var invalid []InvalidParam
+	for i, item := range items {
+		merged, bad := pf.applyPreset(item, batchPreset, fmt.Sprintf("items[%d].", i))
+		if bad != nil {
+			invalid = append(invalid, *bad)
+			continue
+		}
+		items[i] = merged
+	}
+	if len(invalid) > 0 {
+		return nil, invalid, nil
+	}
+	top["items"], _ = json.Marshal(items)
+	out, err := json.Marshal(top)
+	return out, nil, err
+}
+
+// PresetCatalog es la respuesta de GET /presets.
+type PresetCatalog struct {
+	Defaults map[string]json.RawMessage            `json:"defaults"`
+	Presets  map[string]map[string]json.RawMessage `json:"presets"`
+	Names    []string                              `json:"names"`
+}
+
+// GET /presets -> defaults y presets disponibles
+func handlePresets(w http.ResponseWriter, r *http.Request) {
+	pf := presets.load()
+	out := PresetCatalog{pf.Defaults, pf.Presets, slices.Sorted(maps.Keys(pf.Presets))}
+	if out.Defaults == nil {
+		out.Defaults = map[string]json.RawMessage{}
+	}
+	if out.Presets == nil {
+		out.Presets = map[string]map[string]json.RawMessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Problem es un error HTTP con formato RFC 7807 (application/problem+json).
+// Code y Key son miembros de extensión: el código estable del catálogo y la
+// key del ítem afectado, si corresponde.
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          ErrorCode      `json:"code,omitempty"`
+	Key           string         `json:"key,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+	// Timeout explica un 408: el límite alcanzado, el tiempo transcurrido
+	// y la etapa. También va en el header X-Timeout-Reason.
+	Timeout *TimeoutInfo `json:"timeout,omitempty"`
+	// Rejection explica un REJECTED_LOW_CONFIDENCE y Result trae el
+	// resultado rechazado si se pidió include_rejected_text.
+	Rejection *RejectionInfo `json:"rejection,omitempty"`
+	Result    *APIResponse   `json:"result,omitempty"`
+	// Quality explica un REJECTED_LOW_QUALITY con las métricas medidas.
+	Quality *ImageQuality `json:"quality,omitempty"`
+}
+
+// InvalidParam identifica un campo rechazado por la validación.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	addLogAttrs(r.Context(), slog.String("error_code", string(p.Code)))
+	if p.Timeout != nil {
+		addLogAttrs(r.Context(), slog.String("timeout_limit", p.Timeout.Limit), slog.String("timeout_stage", p.Timeout.Stage))
+		w.Header().Set("X-Timeout-Reason", p.Timeout.String())
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
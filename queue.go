@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// QueueMessage es lo que viaja por la cola: el job se lee del JobStore.
+type QueueMessage struct {
+	JobID    string
+	Priority string
+}
+
+// JobQueue es una cola de jobs con entrega at-least-once: un mensaje
+// entregado que no se confirma con Ack antes del visibility timeout se
+// vuelve a entregar, posiblemente a otra réplica.
+type JobQueue interface {
+	Enqueue(ctx context.Context, msg QueueMessage) error
+	// EnqueueAt encola el mensaje recién a partir de at (jobs diferidos).
+	EnqueueAt(ctx context.Context, msg QueueMessage, at time.Time) error
+	// Dequeue bloquea hasta que haya un mensaje o termine ctx.
+	Dequeue(ctx context.Context) (Delivery, error)
+	// Depth devuelve los mensajes pendientes por prioridad y los diferidos
+	// en "deferred".
+	Depth(ctx context.Context) (map[string]int, error)
+	Ping(ctx context.Context) error
+}
+
+// Delivery es un mensaje entregado a un worker.
+type Delivery interface {
+	Message() QueueMessage
+	// Ack confirma el procesamiento y elimina el mensaje.
+	Ack(ctx context.Context) error
+	// Nack devuelve el mensaje a la cola para reintentarlo.
+	Nack(ctx context.Context) error
+}
+
+// memoryJobQueue es la cola por defecto, local al proceso: no comparte
+// trabajo entre réplicas ni sobrevive a un reinicio.
+type memoryJobQueue struct {
+	visibility time.Duration
+
+	mu       sync.Mutex
+	queues   [3][]QueueMessage
+	inflight map[string]memoryInflight
+	deferred []memoryDeferred
+	wake     chan struct{}
+}
+
+type memoryDeferred struct {
+	msg QueueMessage
+	at  time.Time
+}
+
+type memoryInflight struct {
+	msg      QueueMessage
+	deadline time.Time
+}
+
+func newMemoryJobQueue(visibility time.Duration) *memoryJobQueue {
+	return &memoryJobQueue{
+		visibility: visibility,
+		inflight:   map[string]memoryInflight{},
+		wake:       make(chan struct{}),
+	}
+}
+
+func (q *memoryJobQueue) Enqueue(_ context.Context, msg QueueMessage) error {
+	q.mu.Lock()
+	q.push(msg)
+	q.mu.Unlock()
+	return nil
+}
+
+func (q *memoryJobQueue) EnqueueAt(_ context.Context, msg QueueMessage, at time.Time) error {
+	q.mu.Lock()
+	q.deferred = append(q.deferred, memoryDeferred{msg: msg, at: at})
+	q.mu.Unlock()
+	return nil
+}
+
+// push encola y despierta a los consumidores. Debe llamarse con q.mu tomado.
+func (q *memoryJobQueue) push(msg QueueMessage) {
+	p := priorityIndex(msg.Priority)
+	q.queues[p] = append(q.queues[p], msg)
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+func (q *memoryJobQueue) Dequeue(ctx context.Context) (Delivery, error) {
+	for {
+		q.mu.Lock()
+		q.requeueExpired()
+		q.releaseDeferred()
+		for p := range q.queues {
+			if len(q.queues[p]) == 0 {
+				continue
+			}
+			msg := q.queues[p][0]
+			q.queues[p] = q.queues[p][1:]
+			token := newID(8)
+			q.inflight[token] = memoryInflight{msg: msg, deadline: time.Now().Add(q.visibility)}
+			q.mu.Unlock()
+			return &memoryDelivery{q: q, msg: msg, token: token}, nil
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-time.After(time.Second): // revisar vencidos
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// requeueExpired reencola los mensajes sin Ack vencidos. Debe llamarse con q.mu tomado.
+func (q *memoryJobQueue) requeueExpired() {
+	now := time.Now()
+	for token, in := range q.inflight {
+		if now.After(in.deadline) {
+			delete(q.inflight, token)
+			p := priorityIndex(in.msg.Priority)
+			q.queues[p] = append(q.queues[p], in.msg)
+		}
+	}
+}
+
+// releaseDeferred encola los diferidos que ya vencieron. Debe llamarse con q.mu tomado.
+func (q *memoryJobQueue) releaseDeferred() {
+	now := time.Now()
+	pending := q.deferred[:0]
+	for _, d := range q.deferred {
+		if now.Before(d.at) {
+			pending = append(pending, d)
+			continue
+		}
+		p := priorityIndex(d.msg.Priority)
+		q.queues[p] = append(q.queues[p], d.msg)
+	}
+	q.deferred = pending
+}
+
+func (q *memoryJobQueue) Depth(_ context.Context) (map[string]int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := map[string]int{}
+	for i, name := range priorities {
+		out[name] = len(q.queues[i])
+	}
+	out["deferred"] = len(q.deferred)
+	return out, nil
+}
+
+func (q *memoryJobQueue) Ping(_ context.Context) error { return nil }
+
+type memoryDelivery struct {
+	q     *memoryJobQueue
+	msg   QueueMessage
+	token string
+}
+
+func (d *memoryDelivery) Message() QueueMessage { return d.msg }
+
+func (d *memoryDelivery) Ack(_ context.Context) error {
+	d.q.mu.Lock()
+	delete(d.q.inflight, d.token)
+	d.q.mu.Unlock()
+	return nil
+}
+
+func (d *memoryDelivery) Nack(_ context.Context) error {
+	d.q.mu.Lock()
+	defer d.q.mu.Unlock()
+	if _, ok := d.q.inflight[d.token]; ok {
+		delete(d.q.inflight, d.token)
+		d.q.push(d.msg)
+	}
+	return nil
+}
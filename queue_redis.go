@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisStreamPrefix = "ocr:jobs:"
+	redisJobPrefix    = "ocr:job:"
+	redisBatchPrefix  = "ocr:batch:"
+	redisDeferredKey  = "ocr:deferred"
+	redisGroup        = "ocr-workers"
+	redisPollInterval = 250 * time.Millisecond
+)
+
+// redisJobQueue implementa JobQueue con un Redis Stream por prioridad y un
+// consumer group compartido por todas las réplicas. Los mensajes sin XACK
+// por más del visibility timeout (p. ej. de un pod que murió) se reclaman
+// con XAUTOCLAIM.
+type redisJobQueue struct {
+	rdb        *redis.Client
+	consumer   string
+	visibility time.Duration
+}
+
+func newRedisJobQueue(ctx context.Context, rdb *redis.Client, visibility time.Duration) (*redisJobQueue, error) {
+	host, _ := os.Hostname()
+	q := &redisJobQueue{
+		rdb:        rdb,
+		consumer:   fmt.Sprintf("%s-%d", host, os.Getpid()),
+		visibility: visibility,
+	}
+	for _, p := range priorities {
+		err := rdb.XGroupCreateMkStream(ctx, redisStreamPrefix+p, redisGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("creando consumer group en %s: %w", redisStreamPrefix+p, err)
+		}
+	}
+	return q, nil
+}
+
+func (q *redisJobQueue) Enqueue(ctx context.Context, msg QueueMessage) error {
+	return q.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisStreamPrefix + msg.Priority,
+		Values: map[string]any{"job": msg.JobID},
+	}).Err()
+}
+
+// Los diferidos van a un sorted set con la hora de liberación como score;
+// el miembro es "prioridad|job".
+func (q *redisJobQueue) EnqueueAt(ctx context.Context, msg QueueMessage, at time.Time) error {
+	return q.rdb.ZAdd(ctx, redisDeferredKey, redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: msg.Priority + "|" + msg.JobID,
+	}).Err()
+}
+
+// releaseDeferredScript pasa los diferidos vencidos a su stream en una sola
+// operación, así dos réplicas no liberan el mismo job ni se pierde uno si la
+// réplica cae a mitad de camino.
+var releaseDeferredScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, m in ipairs(due) do
+	local sep = string.find(m, '|', 1, true)
+	redis.call('XADD', ARGV[2] .. string.sub(m, 1, sep - 1), '*', 'job', string.sub(m, sep + 1))
+	redis.call('ZREM', KEYS[1], m)
+end
+return #due
+`)
+
+func (q *redisJobQueue) Dequeue(ctx context.Context) (Delivery, error) {
+	for {
+		err := releaseDeferredScript.Run(ctx, q.rdb, []string{redisDeferredKey},
+			time.Now().UnixMilli(), redisStreamPrefix).Err()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		for _, p := range priorities {
+			stream := redisStreamPrefix + p
+
+			// Primero los mensajes abandonados por otros consumidores
+			claimed, _, err := q.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   stream,
+				Group:    redisGroup,
+				Consumer: q.consumer,
+				MinIdle:  q.visibility,
+				Start:    "0-0",
+				Count:    1,
+			}).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return nil, err
+			}
+			if len(claimed) > 0 {
+				return q.delivery(stream, p, claimed[0]), nil
+			}
+
+			res, err := q.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    redisGroup,
+				Consumer: q.consumer,
+				Streams:  []string{stream, ">"},
+				Count:    1,
+				Block:    -1,
+			}).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return nil, err
+			}
+			if len(res) > 0 && len(res[0].Messages) > 0 {
+				return q.delivery(stream, p, res[0].Messages[0]), nil
+			}
+		}
+
+		select {
+		case <-time.After(redisPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *redisJobQueue) delivery(stream, priority string, m redis.XMessage) *redisDelivery {
+	jobID, _ := m.Values["job"].(string)
+	return &redisDelivery{q: q, stream: stream, id: m.ID, msg: QueueMessage{JobID: jobID, Priority: priority}}
+}
+
+func (q *redisJobQueue) Depth(ctx context.Context) (map[string]int, error) {
+	out := map[string]int{}
+	for _, p := range priorities {
+		n, err := q.rdb.XLen(ctx, redisStreamPrefix+p).Result()
+		if err != nil {
+			return nil, err
+		}
+		out[p] = int(n)
+	}
+	n, err := q.rdb.ZCard(ctx, redisDeferredKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	out["deferred"] = int(n)
+	return out, nil
+}
+
+func (q *redisJobQueue) Ping(ctx context.Context) error {
+	return q.rdb.Ping(ctx).Err()
+}
+
+type redisDelivery struct {
+	q      *redisJobQueue
+	stream string
+	id     string
+	msg    QueueMessage
+}
+
+func (d *redisDelivery) Message() QueueMessage { return d.msg }
+
+func (d *redisDelivery) Ack(ctx context.Context) error {
+	_, err := d.q.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, d.stream, redisGroup, d.id)
+		pipe.XDel(ctx, d.stream, d.id)
+		return nil
+	})
+	return err
+}
+
+// Nack vuelve a encolar el job al final del stream y confirma la entrega actual.
+func (d *redisDelivery) Nack(ctx context.Context) error {
+	if err := d.q.Enqueue(ctx, d.msg); err != nil {
+		return err
+	}
+	return d.Ack(ctx)
+}
+
+// redisJobStore guarda cada job como JSON con TTL, cifrado si el cifrado
+// en reposo está habilitado: el resultado trae el texto.
+type redisJobStore struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// decodeJob descifra, si hace falta, y decodifica el job guardado bajo key.
+func decodeJob(ctx context.Context, key string, data []byte) (Job, error) {
+	var job Job
+	data, err := openRecord(ctx, key, data)
+	if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal(data, &job)
+	return job, err
+}
+
+// encodeJob codifica y, si corresponde, cifra el job para guardarlo bajo key.
+func encodeJob(ctx context.Context, key string, job Job) ([]byte, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	return sealRecord(ctx, key, data)
+}
+
+func (s *redisJobStore) Put(ctx context.Context, job Job) error {
+	data, err := encodeJob(ctx, redisJobPrefix+job.ID, job)
+	if err != nil {
+		return err
+	}
+	if job.BatchID == "" {
+		return s.rdb.Set(ctx, redisJobPrefix+job.ID, data, s.ttl).Err()
+	}
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisJobPrefix+job.ID, data, s.ttl)
+		pipe.RPush(ctx, redisBatchPrefix+job.BatchID, job.ID)
+		pipe.Expire(ctx, redisBatchPrefix+job.BatchID, s.ttl)
+		return nil
+	})
+	return err
+}
+
+// BatchJobs lee los ids de la lista del batch y omite los jobs vencidos.
+func (s *redisJobStore) BatchJobs(ctx context.Context, batchID string) ([]Job, error) {
+	ids, err := s.rdb.LRange(ctx, redisBatchPrefix+batchID, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisJobPrefix + id
+	}
+	return s.mget(ctx, keys)
+}
+
+// JobsByStatus recorre las claves de jobs con SCAN, de a 500.
+func (s *redisJobStore) JobsByStatus(ctx context.Context, statuses ...string) ([]Job, error) {
+	var out []Job
+	var cursor uint64
+	for {
+		keys, next, err := s.rdb.Scan(ctx, cursor, redisJobPrefix+"*", 500).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			jobs, err := s.mget(ctx, keys)
+			if err != nil {
+				return nil, err
+			}
+			for _, job := range jobs {
+				if slices.Contains(statuses, job.Status) {
+					out = append(out, job)
+				}
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return out, nil
+		}
+	}
+}
+
+// mget lee varios jobs y omite los que vencieron.
+func (s *redisJobStore) mget(ctx context.Context, keys []string) ([]Job, error) {
+	values, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		job, err := decodeJob(ctx, keys[i], []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *redisJobStore) Get(ctx context.Context, id string) (Job, bool, error) {
+	data, err := s.rdb.Get(ctx, redisJobPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	job, err := decodeJob(ctx, redisJobPrefix+id, data)
+	if err != nil {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// Update aplica fn con WATCH/MULTI para no pisar cambios concurrentes de
+// otra réplica (p. ej. una cancelación mientras el job termina).
+func (s *redisJobStore) Update(ctx context.Context, id string, fn func(*Job) error) (Job, error) {
+	key := redisJobPrefix + id
+	var out Job
+	for attempt := 0; attempt < 5; attempt++ {
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				return errJobNotFound
+			}
+			if err != nil {
+				return err
+			}
+			job, err := decodeJob(ctx, key, data)
+			if err != nil {
+				return err
+			}
+			if err := fn(&job); err != nil {
+				return err
+			}
+			updated, err := encodeJob(ctx, key, job)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, updated, s.ttl)
+				return nil
+			})
+			out = job
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return out, err
+		}
+	}
+	return Job{}, fmt.Errorf("job %s: demasiadas actualizaciones concurrentes", id)
+}
+
+func (s *redisJobStore) Ping(ctx context.Context) error {
+	return s.rdb.Ping(ctx).Err()
+}
+
+func (s *redisJobStore) rewrap(ctx context.Context) (int, error) {
+	return rewrapRedisKeys(ctx, s.rdb, redisJobPrefix+"*", func(key string) string { return key })
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkMemoryJobQueue mide un mensaje de punta a punta por la cola en
+// memoria: Enqueue, Dequeue y Ack.
+func BenchmarkMemoryJobQueue(b *testing.B) {
+	ctx := context.Background()
+	q := newMemoryJobQueue(time.Minute)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := q.Enqueue(ctx, QueueMessage{JobID: fmt.Sprint(i), Priority: priorities[i%3]}); err != nil {
+			b.Fatal(err)
+		}
+		d, err := q.Dequeue(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		d.Ack(ctx)
+	}
+}
+
+// BenchmarkMemoryJobQueueContended mide la cola con varios productores y
+// consumidores a la vez, como las réplicas de un mismo proceso: cada
+// goroutine encola un mensaje y saca uno, no necesariamente el suyo.
+func BenchmarkMemoryJobQueueContended(b *testing.B) {
+	ctx := context.Background()
+	q := newMemoryJobQueue(time.Minute)
+	var n atomic.Int64
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := n.Add(1)
+			if err := q.Enqueue(ctx, QueueMessage{JobID: fmt.Sprint(i), Priority: priorities[i%3]}); err != nil {
+				b.Error(err)
+				return
+			}
+			d, err := q.Dequeue(ctx)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			d.Ack(ctx)
+		}
+	})
+}
+
+// BenchmarkMemoryJobQueueDeferred mide Dequeue con diferidos pendientes,
+// que se revisan en cada llamada.
+func BenchmarkMemoryJobQueueDeferred(b *testing.B) {
+	ctx := context.Background()
+	q := newMemoryJobQueue(time.Minute)
+	later := time.Now().Add(time.Hour)
+	for i := range 1000 {
+		q.EnqueueAt(ctx, QueueMessage{JobID: fmt.Sprint("deferred-", i), Priority: priorityNormal}, later)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Enqueue(ctx, QueueMessage{JobID: fmt.Sprint(i), Priority: priorityNormal})
+		d, err := q.Dequeue(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		d.Ack(ctx)
+	}
+}
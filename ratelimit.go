@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter es un token bucket por cliente, en memoria de la réplica: cada
+// cliente puede hacer hasta burst requests seguidas y recupera una cada
+// per/burst.
+type rateLimiter struct {
+	mu       sync.Mutex
+	burst    float64
+	interval time.Duration // tiempo para recuperar una request
+	buckets  map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(burst int, per time.Duration) *rateLimiter {
+	return &rateLimiter{
+		burst:    float64(burst),
+		interval: per / time.Duration(burst),
+		buckets:  map[string]*tokenBucket{},
+	}
+}
+
+// allow consume una request del cliente. Si no le queda ninguna devuelve
+// false y cuánto falta para la próxima.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep descarta los buckets que ya se llenaron: equivalen a uno nuevo.
+func (l *rateLimiter) sweep(interval time.Duration) {
+	full := time.Duration(l.burst) * l.interval
+	for range time.Tick(interval) {
+		now := time.Now()
+		l.mu.Lock()
+		for client, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, client)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// clientIP es la IP remota o, detrás de un proxy confiable
+// (trustForwarded), la última de X-Forwarded-For, la que agrega el proxy.
+func clientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// retryAfter escribe el header Retry-After en segundos enteros.
+func retryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(d.Seconds())))))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// maxReexportJobs limita los jobs por request de reexportación.
+const maxReexportJobs = 1000
+
+// ReexportRequest elige los jobs a reexportar: por id, por batch o ambos.
+type ReexportRequest struct {
+	JobIDs  []string `json:"job_ids"`
+	BatchID string   `json:"batch_id"`
+}
+
+// ReexportSummary informa el resultado de cada job: reexported, failed
+// (el archivado volvió a fallar) o skipped (no estaba completed_unexported).
+type ReexportSummary struct {
+	Reexported int              `json:"reexported"`
+	Failed     int              `json:"failed"`
+	Skipped    int              `json:"skipped"`
+	Jobs       []ReexportResult `json:"jobs"`
+}
+
+type ReexportResult struct {
+	JobState
+	Error string `json:"error,omitempty"`
+}
+
+// reexportJob reintenta el archivado de un job completed_unexported. Si
+// sale bien el job pasa a completed y el resultado guardado de la key se
+// actualiza, salvo que ya lo haya reemplazado un procesamiento posterior.
+func reexportJob(ctx context.Context, job Job) (Job, error) {
+	resp := *job.Result
+	resp.ExportError = ""
+	archive, archiveErr := archiveResult(ctx, job.Item, &resp, nil)
+
+	job, err := jobStore.Update(ctx, job.ID, func(j *Job) error {
+		if j.Status != jobUnexported {
+			return nil
+		}
+		result := *j.Result
+		j.Result = &result
+		if archiveErr != nil {
+			result.ExportError = archiveErr.Error()
+			j.UpdatedAt = time.Now().UTC()
+			return nil
+		}
+		result.ExportError = ""
+		result.Archive = archive
+		j.setStatus(jobCompleted, "reexportado")
+		return nil
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	if archiveErr != nil {
+		return job, archiveErr
+	}
+
+	err = results.Update(job.tenant(), job.Item.Key, func(r *StoredResult) error {
+		if r.Result.ExportError != "" && !r.CreatedAt.After(job.UpdatedAt) {
+			r.Result.ExportError = ""
+			r.Result.Archive = archive
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errResultNotFound) {
+		slog.Error("stored result not updated after reexport", "job_id", job.ID, "key", job.Item.Key, "error", err)
+	}
+	return job, nil
+}
+
+// POST /ocr/jobs/reexport -> reintenta el archivado de los jobs
+// completed_unexported indicados por job_ids y/o batch_id
+func handleReexport(w http.ResponseWriter, r *http.Request) {
+	if archiveStore == nil {
+		writeProblem(w, r, newProblem(CodeConflict, "El archivado está deshabilitado (OCR_ARCHIVE_URL)"))
+		return
+	}
+	var in ReexportRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || (len(in.JobIDs) == 0 && in.BatchID == "") {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera {job_ids: [...]} o {batch_id}"))
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+
+	var jobs []Job
+	if in.BatchID != "" {
+		batch, err := tenantBatchJobs(ctx, in.BatchID)
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+			return
+		}
+		jobs = append(jobs, batch...)
+	}
+	out := ReexportSummary{Jobs: []ReexportResult{}}
+	for _, id := range in.JobIDs {
+		job, ok, err := tenantJob(ctx, id)
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+			return
+		}
+		if !ok {
+			out.Skipped++
+			out.Jobs = append(out.Jobs, ReexportResult{JobState: JobState{ID: id}, Error: errJobNotFound.Error()})
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) > maxReexportJobs {
+		writeProblem(w, r, newProblem(CodePayloadTooLarge, fmt.Sprintf("Se pueden reexportar hasta %d jobs por request", maxReexportJobs)))
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, job := range jobs {
+		if seen[job.ID] {
+			continue
+		}
+		seen[job.ID] = true
+		if job.Status != jobUnexported {
+			out.Skipped++
+			out.Jobs = append(out.Jobs, ReexportResult{JobState: JobState{ID: job.ID, Key: job.Item.Key, Status: job.Status}})
+			continue
+		}
+		updated, err := reexportJob(ctx, job)
+		res := ReexportResult{JobState: JobState{ID: job.ID, Key: job.Item.Key, Status: updated.Status}}
+		if err != nil {
+			out.Failed++
+			res.Error = err.Error()
+		} else {
+			out.Reexported++
+		}
+		out.Jobs = append(out.Jobs, res)
+	}
+	addLogAttrs(r.Context(), slog.Int("reexported", out.Reexported), slog.Int("reexport_failed", out.Failed))
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Recarga en caliente de la configuración con SIGHUP, POST /admin/reload o,
+// con OCR_CONFIG_WATCH_INTERVAL, al cambiar alguno de los archivos. Se
+// vuelven a leer las variables de entorno (con las de OCR_ENV_FILE, ver
+// envfile.go) y los archivos, y se valida todo antes de aplicar nada: si
+// algo tiene errores sigue la configuración anterior entera. Los requests
+// en curso terminan con la configuración con que empezaron.
+//
+// Se recargan:
+//
+//   - los archivos de plantillas, presets, tenants, PII y middleware
+//     (OCR_TEMPLATES_FILE, OCR_PRESETS_FILE, OCR_TENANTS_FILE, OCR_PII_FILE,
+//     OCR_MIDDLEWARE_FILE), incluidas sus rutas;
+//   - los límites de los requests (OCR_MAX_*, OCR_ALLOWED_URL_SCHEMES...);
+//   - los motores: primario, fallback, timeout, reintentos, circuit breaker,
+//     batching y simulación de los mocks. Si cambian, los circuit breakers
+//     empiezan cerrados;
+//   - el timeout de las rutas (OCR_ROUTE_TIMEOUT), el de /ocr/ws,
+//     OCR_CONTRACT_VALIDATION y los límites de admisión (OCR_ADMISSION_*).
+//
+// El resto se aplica solo al arrancar y la recarga informa
+// restart_required si cambió: puerto, TLS y servidor HTTP, modo, cola,
+// archivado, caché de originales, cifrado, /admin, error tracking, espera
+// de dependencias, clave de exports, aliases de OCR_COMPAT_FILE, raíces de
+// firmas, economía, demo, credenciales de los motores de nube, calidad,
+// workers (se cambian con /admin/pool), resultados en memoria,
+// continuaciones, región, nivel de log, OCR_CONFIG_WATCH_INTERVAL y
+// OCR_ENV_FILE.
+
+// configValue es una configuración que se reemplaza entera al recargar,
+// sin cortar a quienes la están leyendo.
+type configValue[T any] struct {
+	p atomic.Pointer[T]
+}
+
+func newConfigValue[T any](v T) *configValue[T] {
+	c := &configValue[T]{}
+	c.store(v)
+	return c
+}
+
+func (c *configValue[T]) load() T {
+	return *c.p.Load()
+}
+
+func (c *configValue[T]) store(v T) {
+	c.p.Store(&v)
+}
+
+var configReloadsTotal = newCounterVec("ocr_config_reloads_total", "Recargas de los archivos de configuración, por resultado.", "result")
+
+// reloadMu serializa las recargas: SIGHUP, /admin/reload y el watcher.
+var reloadMu sync.Mutex
+
+// liveConfig es la configuración vigente: la del arranque con las partes
+// recargables de la última recarga.
+var liveConfig = newConfigValue(&Config{})
+
+// ReloadResult informa qué archivos se recargaron.
+type ReloadResult struct {
+	Files      map[string]string `json:"files"` // variable -> archivo
+	ReloadedAt time.Time         `json:"reloaded_at"`
+	// RestartRequired indica que cambiaron variables que se aplican solo
+	// al arrancar.
+	RestartRequired bool `json:"restart_required"`
+}
+
+// configFiles son los archivos recargables configurados, por variable.
+func configFiles(cfg *Config) map[string]string {
+	files := map[string]string{}
+	for name, path := range map[string]string{
+		"OCR_TEMPLATES_FILE":  cfg.TemplatesFile,
+		"OCR_PRESETS_FILE":    cfg.PresetsFile,
+		"OCR_TENANTS_FILE":    cfg.TenantsFile,
+		"OCR_PII_FILE":        cfg.PIIFile,
+		"OCR_MIDDLEWARE_FILE": cfg.MiddlewareFile,
+		"OCR_ENV_FILE":        cfg.EnvFile,
+	} {
+		if path != "" {
+			files[name] = path
+		}
+	}
+	return files
+}
+
+// reloadConfig vuelve a leer la configuración y, si es válida, la aplica.
+func reloadConfig() (ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	res, err := reloadFiles()
+	if err != nil {
+		configReloadsTotal.Inc("error")
+		return res, err
+	}
+	configReloadsTotal.Inc("ok")
+	return res, nil
+}
+
+func reloadFiles() (res ReloadResult, err error) {
+	prev := liveConfig.load()
+	res = ReloadResult{Files: configFiles(prev), ReloadedAt: time.Now().UTC()}
+	// Las variables de OCR_ENV_FILE quedan aplicadas solo si todo es válido
+	if prev.EnvFile != "" {
+		vars, ferr := readEnvFile(prev.EnvFile)
+		if ferr != nil {
+			return res, ferr
+		}
+		previous := setEnvFile(vars)
+		defer func() {
+			if err != nil {
+				setEnvFile(previous)
+			}
+		}()
+	}
+	loaded, err := loadConfig()
+	if err != nil {
+		return res, err
+	}
+	cfg := reloadable(prev, loaded)
+	res.Files = configFiles(cfg)
+	res.RestartRequired = !reflect.DeepEqual(*cfg, *loaded)
+
+	pii := builtinPIIDetectors
+	if cfg.PIIFile != "" {
+		if pii, err = loadPIIDetectors(cfg.PIIFile); err != nil {
+			return res, err
+		}
+	}
+	tr := &tenantRegistry{}
+	if cfg.TenantsFile != "" {
+		if tr, err = loadTenants(cfg.TenantsFile); err != nil {
+			return res, err
+		}
+	}
+	tf := TemplateFile{}
+	if cfg.TemplatesFile != "" {
+		if tf, err = loadTemplates(cfg.TemplatesFile); err != nil {
+			return res, err
+		}
+	}
+	// Sin cambios se siguen usando los motores vigentes, con el estado de
+	// sus circuit breakers
+	set := activeEngineSet.load()
+	if !reflect.DeepEqual(cfg.Engine, prev.Engine) {
+		if set, err = buildEngines(cfg.Engine); err != nil {
+			return res, err
+		}
+	}
+	// Los presets se validan contra las plantillas y los motores nuevos
+	pf := PresetFile{}
+	if cfg.PresetsFile != "" {
+		if pf, err = loadPresets(cfg.PresetsFile, cfg.Limits, requestConfig{templates: tf, engines: set}); err != nil {
+			return res, err
+		}
+	}
+	router, c, err := buildRouter(cfg)
+	if err != nil {
+		return res, err
+	}
+
+	templates.store(tf)
+	presets.store(pf)
+	tenants.store(tr)
+	piiDetectors.store(pii)
+	applyEngines(cfg.Engine, set)
+	setupAdmission(cfg.Admission)
+	contract.store(c)
+	apiRouter.store(router)
+	liveConfig.store(cfg)
+	return res, nil
+}
+
+// reloadable devuelve prev con las partes recargables de loaded.
+func reloadable(prev, loaded *Config) *Config {
+	cfg := *prev
+	cfg.Limits = loaded.Limits
+	cfg.Engine = loaded.Engine
+	cfg.Engine.Cloud = prev.Engine.Cloud
+	cfg.RouteTimeout = loaded.RouteTimeout
+	cfg.WebSocket = loaded.WebSocket
+	cfg.Admission = loaded.Admission
+	cfg.ContractValidation = loaded.ContractValidation
+	cfg.TemplatesFile = loaded.TemplatesFile
+	cfg.PresetsFile = loaded.PresetsFile
+	cfg.TenantsFile = loaded.TenantsFile
+	cfg.PIIFile = loaded.PIIFile
+	cfg.MiddlewareFile = loaded.MiddlewareFile
+	return &cfg
+}
+
+// logReload escribe el resultado de una recarga.
+func logReload(trigger string, res ReloadResult, err error) {
+	if err != nil {
+		slog.Error("configuration not reloaded, keeping the previous one", "trigger", trigger, "error", err)
+		return
+	}
+	slog.Info("configuration reloaded", "trigger", trigger, "files", len(res.Files))
+	if res.RestartRequired {
+		slog.Warn("startup-only settings changed, restart to apply them", "trigger", trigger)
+	}
+}
+
+// watchConfigReload recarga con cada SIGHUP y, si interval no es cero,
+// cuando cambia la fecha o el tamaño de alguno de los archivos.
+func watchConfigReload(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.Tick(interval)
+	}
+	seen := fileVersions(liveConfig.load())
+	for {
+		trigger := "sighup"
+		select {
+		case <-hup:
+		case <-tick:
+			current := fileVersions(liveConfig.load())
+			if current == seen {
+				continue
+			}
+			seen, trigger = current, "watch"
+		}
+		res, err := reloadConfig()
+		logReload(trigger, res, err)
+		// Una recarga puede cambiar qué archivos se vigilan
+		seen = fileVersions(liveConfig.load())
+	}
+}
+
+// fileVersions resume la fecha y el tamaño de los archivos recargables. Un
+// archivo que no se puede leer cuenta como cambiado, y la recarga informa
+// el error.
+func fileVersions(cfg *Config) string {
+	files := configFiles(cfg)
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		fi, err := os.Stat(files[name])
+		if err != nil {
+			fmt.Fprintf(&b, "%s:%v;", files[name], errors.Unwrap(err))
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", files[name], fi.ModTime().UnixNano(), fi.Size())
+	}
+	return b.String()
+}
+
+// POST /admin/reload -> recarga la configuración de esta réplica; 400 con
+// el error si algo no es válido
+func handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	res, err := reloadConfig()
+	logReload("admin", res, err)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "Configuración no recargada, sigue la anterior: "+err.Error()))
+		return
+	}
+	auditAdmin(r, "config.reload", fmt.Sprintf("files=%d restart_required=%t", len(res.Files), res.RestartRequired))
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// errEngineUnavailable marca los errores transitorios de un motor (caída,
+// sobrecarga, error de red), que vale la pena reintentar.
+var errEngineUnavailable = errors.New("motor OCR no disponible")
+
+var (
+	engineCallsTotal   = newCounterVec("ocr_engine_calls_total", "Llamadas a motores OCR por resultado.", "engine", "outcome")
+	engineRetriesTotal = newCounterVec("ocr_engine_retries_total", "Reintentos de llamadas a motores OCR.", "engine")
+)
+
+// Estados del circuit breaker.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker abre el circuito tras maxFailures fallas consecutivas y
+// rechaza llamadas durante cooldown; luego deja pasar una llamada de prueba.
+type circuitBreaker struct {
+	maxFailures int
+	cooldown    time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(maxFailures int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{maxFailures: maxFailures, cooldown: cooldown, state: breakerClosed}
+}
+
+// allow indica si se puede llamar al motor.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.maxFailures {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release libera el turno de prueba sin registrar resultado (p. ej. si la
+// request se canceló antes de que el motor respondiera).
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// BreakerStatus es el estado del breaker expuesto en /health.
+type BreakerStatus struct {
+	State    string `json:"state"`
+	Failures int    `json:"consecutive_failures"`
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	if state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		state = breakerHalfOpen
+	}
+	return BreakerStatus{State: state, Failures: b.failures}
+}
+
+// resilientEngine envuelve un OCREngine con reintentos con backoff
+// exponencial para errores transitorios y un circuit breaker.
+type resilientEngine struct {
+	OCREngine
+	retries   int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	breaker   *circuitBreaker
+}
+
+func newResilientEngine(inner OCREngine, cfg ResilienceConfig) *resilientEngine {
+	return &resilientEngine{
+		OCREngine: inner,
+		retries:   cfg.Retries,
+		baseDelay: cfg.RetryBaseDelay,
+		maxDelay:  cfg.RetryMaxDelay,
+		breaker:   newCircuitBreaker(cfg.BreakerFailures, cfg.BreakerCooldown),
+	}
+}
+
+func (e *resilientEngine) Recognize(ctx context.Context, p Page) (Recognition, error) {
+	name := e.Name()
+	for attempt := 0; ; attempt++ {
+		if !e.breaker.allow() {
+			engineCallsTotal.Inc(name, "rejected")
+			return Recognition{}, &codedError{CodeEngineUnavailable,
+				fmt.Errorf("circuit breaker abierto para el motor %s", name)}
+		}
+
+		rec, err := e.OCREngine.Recognize(ctx, p)
+		switch {
+		case err == nil:
+			e.breaker.record(true)
+			engineCallsTotal.Inc(name, "success")
+			return rec, nil
+		case ctx.Err() != nil:
+			e.breaker.release()
+			engineCallsTotal.Inc(name, "cancelled")
+			return Recognition{}, err
+		case !errors.Is(err, errEngineUnavailable):
+			e.breaker.record(true) // el motor respondió: no es una caída
+			engineCallsTotal.Inc(name, "error")
+			return Recognition{}, err
+		}
+
+		e.breaker.record(false)
+		engineCallsTotal.Inc(name, "unavailable")
+		if attempt >= e.retries {
+			return Recognition{}, &codedError{CodeEngineUnavailable, err}
+		}
+
+		engineRetriesTotal.Inc(name)
+		select {
+		case <-time.After(e.backoff(attempt)):
+		case <-ctx.Done():
+			return Recognition{}, ctx.Err()
+		}
+	}
+}
+
+// backoff devuelve la espera antes del reintento attempt+1: exponencial
+// con "full jitter" y tope en maxDelay.
+func (e *resilientEngine) backoff(attempt int) time.Duration {
+	d := e.baseDelay << attempt
+	if d <= 0 || d > e.maxDelay {
+		d = e.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
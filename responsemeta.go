@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version se fija al compilar: go build -ldflags "-X main.version=1.4.0".
+// Sin ella se informa el commit del build.
+var version = "dev"
+
+// region es la región de procesamiento (OCR_REGION) que informa meta.
+var region string
+
+// serverVersion devuelve version o, si no se fijó, el commit del build.
+var serverVersion = sync.OnceValue(func() string {
+	if version != "dev" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			return version + "+" + s.Value[:12]
+		}
+	}
+	return version
+})
+
+// ResponseMeta es el bloque meta que se agrega a las respuestas JSON de los
+// tenants con response_metadata, para conciliar SLAs con los logs del
+// cliente.
+type ResponseMeta struct {
+	RequestID     string `json:"request_id"`
+	ServerVersion string `json:"server_version"`
+	Region        string `json:"region,omitempty"`
+	// Engines son los motores que procesaron los ítems del request.
+	Engines []string       `json:"engines,omitempty"`
+	Timing  ResponseTiming `json:"timing"`
+}
+
+// ResponseTiming son los tiempos del request. QueueMs es la espera más
+// larga de un ítem en la cola y ProcessingMs suma el procesamiento de todos
+// los ítems, así que en un batch puede superar a DurationMs.
+type ResponseTiming struct {
+	ReceivedAt   time.Time `json:"received_at"`
+	DurationMs   int64     `json:"duration_ms"`
+	QueueMs      int64     `json:"queue_ms"`
+	ProcessingMs int64     `json:"processing_ms"`
+}
+
+// responseMetaLog junta los datos de los ítems que procesa el request.
+type responseMetaLog struct {
+	received time.Time
+
+	mu         sync.Mutex
+	engines    []string
+	queue      time.Duration
+	processing time.Duration
+}
+
+type responseMetaKey struct{}
+
+// noteQueueWait registra la espera de un ítem en la cola del pool.
+func noteQueueWait(ctx context.Context, d time.Duration) {
+	if m, ok := ctx.Value(responseMetaKey{}).(*responseMetaLog); ok {
+		m.mu.Lock()
+		m.queue = max(m.queue, d)
+		m.mu.Unlock()
+	}
+}
+
+// noteProcessed registra los motores y el tiempo de procesamiento de un
+// ítem.
+func noteProcessed(ctx context.Context, engines []string, d time.Duration) {
+	m, ok := ctx.Value(responseMetaKey{}).(*responseMetaLog)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.processing += d
+	for _, e := range engines {
+		if !slices.Contains(m.engines, e) {
+			m.engines = append(m.engines, e)
+		}
+	}
+}
+
+// pageEngines devuelve los motores que produjeron las páginas con texto.
+func pageEngines(pages []PageResult) []string {
+	var engines []string
+	for _, p := range pages {
+		if !p.Blank && p.Engine != "" && !slices.Contains(engines, p.Engine) {
+			engines = append(engines, p.Engine)
+		}
+	}
+	return engines
+}
+
+// resultEngines son los motores de un resultado; sin las páginas, "mixed"
+// no dice cuáles fueron.
+func resultEngines(resp *APIResponse) []string {
+	switch {
+	case resp == nil:
+		return nil
+	case len(resp.Pages) > 0:
+		return pageEngines(resp.Pages)
+	case resp.Engine != "" && resp.Engine != "mixed":
+		return []string{resp.Engine}
+	}
+	return nil
+}
+
+// noteJob registra un job terminado de un batch sincrónico. Lo puede haber
+// procesado otra réplica, así que los tiempos salen de su historial: la
+// espera hasta el primer intento y la duración del último.
+func noteJob(ctx context.Context, job Job) {
+	var first, last, done time.Time
+	for _, t := range job.History {
+		switch {
+		case t.Status == jobRunning:
+			if first.IsZero() {
+				first = t.At
+			}
+			last = t.At
+		case !last.IsZero():
+			done = t.At
+		}
+	}
+	if !first.IsZero() {
+		noteQueueWait(ctx, first.Sub(job.CreatedAt))
+	}
+	if !done.IsZero() {
+		noteProcessed(ctx, resultEngines(job.Result), done.Sub(last))
+	}
+}
+
+func (m *responseMetaLog) meta(ctx context.Context) ResponseMeta {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return ResponseMeta{
+		RequestID:     requestIDFrom(ctx),
+		ServerVersion: serverVersion(),
+		Region:        region,
+		Engines:       slices.Clone(m.engines),
+		Timing: ResponseTiming{
+			ReceivedAt:   m.received.UTC(),
+			DurationMs:   time.Since(m.received).Milliseconds(),
+			QueueMs:      m.queue.Milliseconds(),
+			ProcessingMs: m.processing.Milliseconds(),
+		},
+	}
+}
+
+// responseMetadata agrega meta a las respuestas JSON (objetos, incluidos
+// los problems) de los tenants con response_metadata. El resto de las
+// respuestas (texto, hOCR, NDJSON, WebSocket) pasan sin cambios.
+func responseMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tenants.load().get(tenantFrom(r.Context())).ResponseMetadata || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m := &responseMetaLog{received: time.Now()}
+		rec := &metaRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), responseMetaKey{}, m)))
+		if !rec.buffering {
+			return
+		}
+		body := rec.body.Bytes()
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' && trimmed[len(trimmed)-1] == '}' {
+			if meta, err := json.Marshal(m.meta(r.Context())); err == nil {
+				sep := ","
+				if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) == 0 {
+					sep = ""
+				}
+				body = slices.Concat(trimmed[:len(trimmed)-1], []byte(sep+`"meta":`), meta, []byte("}\n"))
+			}
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rec.status)
+		w.Write(body)
+	})
+}
+
+// metaRecorder retiene las respuestas JSON para agregarles meta; las demás
+// las escribe directamente.
+type metaRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buffering   bool
+	body        bytes.Buffer
+}
+
+func (rec *metaRecorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader, rec.status = true, status
+	mediaType, _, _ := strings.Cut(rec.Header().Get("Content-Type"), ";")
+	switch mediaType {
+	case "application/json", "application/problem+json":
+		rec.buffering = true
+	default:
+		rec.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (rec *metaRecorder) Write(p []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.buffering {
+		return rec.body.Write(p)
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *metaRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok && !rec.buffering {
+		f.Flush()
+	}
+}
+
+func (rec *metaRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// StoredResult es el resultado guardado de un ítem junto con sus anotaciones.
+type StoredResult struct {
+	Tenant      string       `json:"tenant"`
+	Key         string       `json:"key"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Version     int          `json:"version,omitempty"`
+	Result      APIResponse  `json:"result"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// ResultStore guarda el último resultado de cada key, por tenant: la misma
+// key en dos tenants son resultados distintos.
+type ResultStore interface {
+	// Save guarda r según mode (on_conflict) si la key ya tiene resultado
+	// y devuelve lo guardado, con su número de versión.
+	Save(r StoredResult, mode string) (StoredResult, error)
+	Get(tenant, key string) (StoredResult, bool, error)
+	// Versions devuelve las versiones anteriores de la key conservadas con
+	// on_conflict=version y la actual, de la más antigua a la más reciente.
+	Versions(tenant, key string) ([]StoredResult, error)
+	// Update aplica fn al resultado guardado bajo key de forma atómica.
+	Update(tenant, key string, fn func(*StoredResult) error) error
+	// List devuelve los resultados del tenant guardados en [from, to), del
+	// más antiguo al más reciente.
+	List(tenant string, from, to time.Time) ([]StoredResult, error)
+	// Search devuelve los resultados del tenant que cumplen filter, sin
+	// orden.
+	Search(tenant string, filter ResultFilter) ([]ResultMatch, error)
+}
+
+// resultID identifica un resultado en el store. Los ids de tenant no pueden
+// contener \x00, así que dos pares distintos no colisionan.
+func resultID(tenant, key string) string {
+	return tenant + "\x00" + key
+}
+
+// errResultNotFound lo devuelve Update cuando la key no existe.
+var errResultNotFound = &codedError{CodeNotFound, errors.New("no hay un resultado guardado para esa key")}
+
+// memoryResultStore guarda hasta max resultados en memoria, descartando los
+// más antiguos, con un índice de su texto para Search.
+type memoryResultStore struct {
+	max int
+
+	mu       sync.Mutex
+	items    map[string]StoredResult
+	versions map[string][]StoredResult
+	order    []string
+	index    *resultIndex
+}
+
+func newMemoryResultStore(max int) *memoryResultStore {
+	return &memoryResultStore{max: max, items: map[string]StoredResult{}, versions: map[string][]StoredResult{}, index: newResultIndex()}
+}
+
+func (s *memoryResultStore) Save(r StoredResult, mode string) (StoredResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := resultID(r.Tenant, r.Key)
+	prev, exists := s.items[id]
+	version, keep, err := resolveConflict(prev, exists, mode)
+	if err != nil {
+		return StoredResult{}, err
+	}
+	r.Version = version
+	if !exists {
+		s.order = append(s.order, id)
+	}
+	if keep {
+		s.versions[id] = append(s.versions[id], prev)
+		if n := len(s.versions[id]); n > maxResultVersions {
+			s.versions[id] = s.versions[id][n-maxResultVersions:]
+		}
+	}
+	s.items[id] = r
+	s.index.add(id, r.Result.Body)
+	for len(s.order) > s.max {
+		delete(s.items, s.order[0])
+		delete(s.versions, s.order[0])
+		s.index.remove(s.order[0])
+		s.order = s.order[1:]
+	}
+	return r, nil
+}
+
+func (s *memoryResultStore) Get(tenant, key string) (StoredResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.items[resultID(tenant, key)]
+	return r, ok, nil
+}
+
+func (s *memoryResultStore) Versions(tenant, key string) ([]StoredResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := resultID(tenant, key)
+	r, ok := s.items[id]
+	if !ok {
+		return nil, nil
+	}
+	return append(slices.Clone(s.versions[id]), r), nil
+}
+
+func (s *memoryResultStore) Update(tenant, key string, fn func(*StoredResult) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := resultID(tenant, key)
+	r, ok := s.items[id]
+	if !ok {
+		return errResultNotFound
+	}
+	r.Annotations = slices.Clone(r.Annotations)
+	if err := fn(&r); err != nil {
+		return err
+	}
+	s.items[id] = r
+	return nil
+}
+
+func (s *memoryResultStore) List(tenant string, from, to time.Time) ([]StoredResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []StoredResult
+	for _, id := range s.order {
+		r := s.items[id]
+		if r.Tenant == tenant && !r.CreatedAt.Before(from) && r.CreatedAt.Before(to) {
+			out = append(out, r)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b StoredResult) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return out, nil
+}
+
+func (s *memoryResultStore) Search(tenant string, filter ResultFilter) ([]ResultMatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	candidate := func(id string) bool {
+		r := s.items[id]
+		return r.Tenant == tenant && filter.matches(r)
+	}
+	var out []ResultMatch
+	if filter.Query.empty() {
+		for _, id := range s.order {
+			if candidate(id) {
+				out = append(out, ResultMatch{StoredResult: s.items[id]})
+			}
+		}
+		return out, nil
+	}
+	for id, score := range s.index.match(filter.Query, candidate) {
+		out = append(out, ResultMatch{StoredResult: s.items[id], Score: score})
+	}
+	return out, nil
+}
+
+var results ResultStore
+
+// saveResult guarda el resultado de un ítem procesado para el tenant de ctx,
+// con mode (on_conflict) si la key ya tiene resultado.
+func saveResult(ctx context.Context, resp *APIResponse, mode string) error {
+	_, err := results.Save(StoredResult{
+		Tenant:      tenantFrom(ctx),
+		Key:         resp.Key,
+		CreatedAt:   time.Now().UTC(),
+		Result:      *resp,
+		Annotations: []Annotation{},
+	}, mode)
+	return err
+}
+
+// requestedResult busca el resultado de la key de la ruta, o la versión de
+// ?version=. Si no está, o falla el store, escribe el problem.
+func requestedResult(w http.ResponseWriter, r *http.Request) (StoredResult, bool) {
+	tenant, key := tenantFrom(r.Context()), chi.URLParam(r, "key")
+	var res StoredResult
+	var ok bool
+	if r.URL.Query().Has("version") {
+		var problem *Problem
+		if res, ok, problem = resultVersion(r, tenant, key); problem != nil {
+			writeProblem(w, r, *problem)
+			return StoredResult{}, false
+		}
+	} else {
+		var err error
+		if res, ok, err = results.Get(tenant, key); err != nil {
+			writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+			return StoredResult{}, false
+		}
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, "No hay un resultado guardado para esa key"))
+	}
+	return res, ok
+}
+
+// GET /ocr/results/{key} -> resultado guardado con sus anotaciones
+func handleGetResult(w http.ResponseWriter, r *http.Request) {
+	format, problem := negotiateFormat(r)
+	if problem != nil {
+		writeProblem(w, r, *problem)
+		return
+	}
+	res, ok := requestedResult(w, r)
+	if !ok {
+		return
+	}
+	if format != formatJSON {
+		writeResult(w, format, &res.Result)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
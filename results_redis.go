@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisResultPrefix      = "ocr:result:"
+	redisResultIndexPrefix = "ocr:results:"
+	redisVersionsPrefix    = "ocr:result-versions:"
+	redisResultTimeout     = 5 * time.Second
+)
+
+// redisResultStore guarda cada resultado como JSON en ocr:result:{tenant}:{key}
+// y un sorted set por tenant con las keys por fecha de proceso, así todas
+// las réplicas (y las de solo lectura, contra una réplica de Redis) sirven
+// los mismos resultados. Conserva los últimos max de cada tenant. El índice
+// de búsqueda está en search_redis.go.
+type redisResultStore struct {
+	rdb *redis.Client
+	max int
+
+	searchMu sync.Mutex
+	termKey  []byte // clave HMAC del índice, ver searchKey
+}
+
+func redisResultKey(tenant, key string) string {
+	return redisResultPrefix + tenant + ":" + key
+}
+
+// redisVersionsKey es la lista de versiones anteriores de la key, de la más
+// antigua a la más reciente.
+func redisVersionsKey(tenant, key string) string {
+	return redisVersionsPrefix + tenant + ":" + key
+}
+
+// decodeResult descifra, si hace falta, y decodifica el resultado guardado
+// bajo id.
+func decodeResult(ctx context.Context, id string, data []byte) (StoredResult, error) {
+	var r StoredResult
+	data, err := openRecord(ctx, id, data)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(data, &r)
+	return r, err
+}
+
+// encodeResult codifica y, si el cifrado está habilitado, cifra r para
+// guardarlo bajo id.
+func encodeResult(ctx context.Context, id string, r StoredResult) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return sealRecord(ctx, id, data)
+}
+
+// Save resuelve el conflicto con el resultado guardado bajo WATCH,
+// reintentando si otra réplica guardó la misma key en el medio.
+func (s *redisResultStore) Save(r StoredResult, mode string) (StoredResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisResultTimeout)
+	defer cancel()
+	id, versions := redisResultKey(r.Tenant, r.Key), redisVersionsKey(r.Tenant, r.Key)
+	index := redisResultIndexPrefix + r.Tenant
+	termKey, err := s.searchKey(ctx)
+	if err != nil {
+		return StoredResult{}, err
+	}
+	var card *redis.IntCmd
+	err = redis.TxFailedErr
+	for i := 0; i < 5 && errors.Is(err, redis.TxFailedErr); i++ {
+		err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			prevData, err := tx.Get(ctx, id).Bytes()
+			exists := err == nil
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			var prev StoredResult
+			if exists {
+				if prev, err = decodeResult(ctx, id, prevData); err != nil {
+					return err
+				}
+			}
+			version, keep, err := resolveConflict(prev, exists, mode)
+			if err != nil {
+				return err
+			}
+			r.Version = version
+			data, err := encodeResult(ctx, id, r)
+			if err != nil {
+				return err
+			}
+			terms, err := indexedTerms(ctx, tx, r.Tenant, r.Key)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, id, data, 0)
+				if keep {
+					pipe.RPush(ctx, versions, prevData)
+					pipe.LTrim(ctx, versions, -maxResultVersions, -1)
+				}
+				pipe.ZAdd(ctx, index, redis.Z{Score: float64(r.CreatedAt.UnixMilli()), Member: r.Key})
+				unindexResult(ctx, pipe, r.Tenant, r.Key, terms)
+				indexResult(ctx, pipe, r.Tenant, r.Key, r.Result.Body, termKey)
+				card = pipe.ZCard(ctx, index)
+				return nil
+			})
+			return err
+		}, id, redisSearchDocKey(r.Tenant, r.Key))
+	}
+	if err != nil {
+		return StoredResult{}, err
+	}
+	if card.Val() <= int64(s.max) {
+		return r, nil
+	}
+
+	// Los que sobran se descartan aparte: si dos réplicas recortan a la vez,
+	// a lo sumo borran el mismo resultado dos veces
+	old, err := s.rdb.ZRange(ctx, index, 0, card.Val()-int64(s.max)-1).Result()
+	if err != nil || len(old) == 0 {
+		return r, err
+	}
+	docs := make([]string, len(old))
+	for i, key := range old {
+		docs[i] = redisSearchDocKey(r.Tenant, key)
+	}
+	err = redis.TxFailedErr
+	for i := 0; i < 5 && errors.Is(err, redis.TxFailedErr); i++ {
+		err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			terms := make([][]string, len(old))
+			for i, key := range old {
+				var err error
+				if terms[i], err = indexedTerms(ctx, tx, r.Tenant, key); err != nil {
+					return err
+				}
+			}
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, key := range old {
+					pipe.Del(ctx, redisResultKey(r.Tenant, key), redisVersionsKey(r.Tenant, key))
+					pipe.ZRem(ctx, index, key)
+					unindexResult(ctx, pipe, r.Tenant, key, terms[i])
+				}
+				return nil
+			})
+			return err
+		}, docs...)
+	}
+	return r, err
+}
+
+func (s *redisResultStore) Get(tenant, key string) (StoredResult, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisResultTimeout)
+	defer cancel()
+	id := redisResultKey(tenant, key)
+	data, err := s.rdb.Get(ctx, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return StoredResult{}, false, nil
+	}
+	if err != nil {
+		return StoredResult{}, false, err
+	}
+	r, err := decodeResult(ctx, id, data)
+	if err != nil {
+		return StoredResult{}, false, err
+	}
+	return r, true, nil
+}
+
+func (s *redisResultStore) Versions(tenant, key string) ([]StoredResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisResultTimeout)
+	defer cancel()
+	id := redisResultKey(tenant, key)
+	var current *redis.StringCmd
+	var previous *redis.StringSliceCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		current = pipe.Get(ctx, id)
+		previous = pipe.LRange(ctx, redisVersionsKey(tenant, key), 0, -1)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]StoredResult, 0, len(previous.Val())+1)
+	// Las versiones anteriores se cifraron con el id de la key, como la actual
+	for _, data := range append(previous.Val(), current.Val()) {
+		r, err := decodeResult(ctx, id, []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// Update relee y reescribe el resultado con WATCH, reintentando si otra
+// réplica lo cambió en el medio.
+func (s *redisResultStore) Update(tenant, key string, fn func(*StoredResult) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisResultTimeout)
+	defer cancel()
+	id := redisResultKey(tenant, key)
+	for range 5 {
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.Get(ctx, id).Bytes()
+			if errors.Is(err, redis.Nil) {
+				return errResultNotFound
+			}
+			if err != nil {
+				return err
+			}
+			r, err := decodeResult(ctx, id, data)
+			if err != nil {
+				return err
+			}
+			if err := fn(&r); err != nil {
+				return err
+			}
+			if data, err = encodeResult(ctx, id, r); err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, id, data, redis.KeepTTL)
+				return nil
+			})
+			return err
+		}, id)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return redis.TxFailedErr
+}
+
+func (s *redisResultStore) List(tenant string, from, to time.Time) ([]StoredResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisResultTimeout)
+	defer cancel()
+	return s.list(ctx, tenant, from, to)
+}
+
+// list lee los resultados del tenant en [from, to), del más antiguo al más
+// reciente; un límite cero no acota.
+func (s *redisResultStore) list(ctx context.Context, tenant string, from, to time.Time) ([]StoredResult, error) {
+	min, max := "-inf", "+inf"
+	if !from.IsZero() {
+		min = strconv.FormatInt(from.UnixMilli(), 10)
+	}
+	if !to.IsZero() {
+		max = "(" + strconv.FormatInt(to.UnixMilli(), 10)
+	}
+	keys, err := s.rdb.ZRangeByScore(ctx, redisResultIndexPrefix+tenant, &redis.ZRangeBy{Min: min, Max: max}).Result()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = redisResultKey(tenant, key)
+	}
+	values, err := s.rdb.MGet(ctx, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]StoredResult, 0, len(values))
+	for i, v := range values {
+		// Puede haberse recortado entre las dos lecturas
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		data, err := openRecord(ctx, ids[i], []byte(raw))
+		if err != nil {
+			return nil, err
+		}
+		var r StoredResult
+		if json.Unmarshal(data, &r) == nil {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// rewrap vuelve a cifrar los resultados y sus versiones anteriores, que se
+// cifraron con el id del resultado, y la clave del índice de búsqueda.
+func (s *redisResultStore) rewrap(ctx context.Context) (int, error) {
+	n, err := rewrapRedisKeys(ctx, s.rdb, redisResultPrefix+"*", func(key string) string { return key })
+	if err != nil {
+		return n, err
+	}
+	searchKey, err := rewrapRedisKeys(ctx, s.rdb, redisSearchKeyKey, func(key string) string { return key })
+	if n += searchKey; err != nil {
+		return n, err
+	}
+	versions, err := rewrapRedisKeys(ctx, s.rdb, redisVersionsPrefix+"*", func(key string) string {
+		return redisResultPrefix + strings.TrimPrefix(key, redisVersionsPrefix)
+	})
+	return n + versions, err
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Rollups por hora y por día (UTC) de cada tenant y motor: volumen, fallas,
+// latencia y confianza. Los registra el pool junto con el uso y duran más
+// que la retención de Prometheus, para los reportes históricos. La latencia
+// se guarda como histograma, así los rollups de varias réplicas se suman y
+// los percentiles salen de los buckets.
+
+const (
+	rollupHour = "hour"
+	rollupDay  = "day"
+)
+
+var rollupGranularities = []string{rollupHour, rollupDay}
+
+// Retención de cada granularidad; también es el rango máximo de una
+// consulta.
+var rollupRetention = map[string]time.Duration{
+	rollupHour: 31 * 24 * time.Hour,
+	rollupDay:  730 * 24 * time.Hour,
+}
+
+var rollupPeriodLayout = map[string]string{
+	rollupHour: "2006-01-02T15",
+	rollupDay:  time.DateOnly,
+}
+
+const redisRollupPrefix = "ocr:rollups:"
+
+// latencyBuckets son los límites superiores (ms) del histograma de latencia;
+// el último bucket no tiene límite.
+var latencyBuckets = []int64{50, 100, 250, 500, 1000, 2000, 3000, 5000, 7500, 10000, 15000, 30000, 60000, 120000}
+
+// rollupSeries identifica una serie de rollups.
+type rollupSeries struct {
+	tenant string
+	engine string
+}
+
+// rollupCounts son los acumulados de una serie en un período.
+type rollupCounts struct {
+	documents     int64
+	failed        int64
+	latencySumMs  int64
+	latency       []int64 // un contador por bucket de latencyBuckets, más el abierto
+	confidenceSum float64
+	confidenceN   int64
+}
+
+func newRollupCounts() *rollupCounts {
+	return &rollupCounts{latency: make([]int64, len(latencyBuckets)+1)}
+}
+
+func (c *rollupCounts) add(o *rollupCounts) {
+	c.documents += o.documents
+	c.failed += o.failed
+	c.latencySumMs += o.latencySumMs
+	for i, n := range o.latency {
+		c.latency[i] += n
+	}
+	c.confidenceSum += o.confidenceSum
+	c.confidenceN += o.confidenceN
+}
+
+// rollupEntry son los acumulados de una serie en un período.
+type rollupEntry struct {
+	period string
+	series rollupSeries
+	counts *rollupCounts
+}
+
+// RollupStore acumula los rollups, compartidos entre réplicas cuando el
+// backend lo permite.
+type RollupStore interface {
+	// Add suma counts a la hora y al día de at.
+	Add(ctx context.Context, at time.Time, s rollupSeries, counts *rollupCounts) error
+	// Periods devuelve los rollups de todas las series en esos períodos.
+	Periods(ctx context.Context, granularity string, periods []string) ([]rollupEntry, error)
+}
+
+var rollupStore RollupStore
+
+func rollupPeriod(granularity string, t time.Time) string {
+	return t.UTC().Format(rollupPeriodLayout[granularity])
+}
+
+// recordRollup registra un ítem procesado por el pool. El motor es el que
+// produjo el resultado (mixed si intervino más de uno) o, si falló, el
+// pedido.
+func recordRollup(tenant string, req OCRRequest, resp *APIResponse, latency time.Duration) {
+	c := newRollupCounts()
+	c.documents = 1
+	c.latencySumMs = latency.Milliseconds()
+	i, _ := slices.BinarySearch(latencyBuckets, latency.Milliseconds())
+	c.latency[i] = 1
+	engine := req.Engine
+	if resp == nil || resp.ErrorCode != "" {
+		c.failed = 1
+	} else {
+		engine = resp.Engine
+		c.confidenceSum, c.confidenceN = resp.Confidence, 1
+	}
+	if engine == "" {
+		engine = primaryEngine().Name()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rollupStore.Add(ctx, time.Now(), rollupSeries{tenant, engine}, c); err != nil {
+		slog.Error("rollup not recorded", "tenant", tenant, "engine", engine, "error", err)
+	}
+}
+
+// memoryRollupStore guarda los rollups en memoria y descarta los períodos
+// vencidos.
+type memoryRollupStore struct {
+	mu      sync.Mutex
+	periods map[string]map[string]map[rollupSeries]*rollupCounts // granularidad -> período -> serie
+}
+
+func newMemoryRollupStore() *memoryRollupStore {
+	s := &memoryRollupStore{periods: map[string]map[string]map[rollupSeries]*rollupCounts{}}
+	for _, g := range rollupGranularities {
+		s.periods[g] = map[string]map[rollupSeries]*rollupCounts{}
+	}
+	return s
+}
+
+func (s *memoryRollupStore) Add(_ context.Context, at time.Time, series rollupSeries, counts *rollupCounts) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range rollupGranularities {
+		period := rollupPeriod(g, at)
+		periods := s.periods[g]
+		if periods[period] == nil {
+			periods[period] = map[rollupSeries]*rollupCounts{}
+			oldest := rollupPeriod(g, time.Now().Add(-rollupRetention[g]))
+			for p := range periods {
+				if p < oldest {
+					delete(periods, p)
+				}
+			}
+		}
+		c := periods[period][series]
+		if c == nil {
+			c = newRollupCounts()
+			periods[period][series] = c
+		}
+		c.add(counts)
+	}
+	return nil
+}
+
+func (s *memoryRollupStore) Periods(_ context.Context, granularity string, periods []string) ([]rollupEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []rollupEntry
+	for _, period := range periods {
+		for series, c := range s.periods[granularity][period] {
+			counts := newRollupCounts()
+			counts.add(c)
+			out = append(out, rollupEntry{period, series, counts})
+		}
+	}
+	return out, nil
+}
+
+// redisRollupStore guarda un hash por granularidad y período con los campos
+// {tenant}|{engine}|{contador}.
+type redisRollupStore struct {
+	rdb *redis.Client
+}
+
+func (s *redisRollupStore) Add(ctx context.Context, at time.Time, series rollupSeries, counts *rollupCounts) error {
+	field := func(name string) string { return series.tenant + "|" + series.engine + "|" + name }
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, g := range rollupGranularities {
+			key := redisRollupPrefix + g + ":" + rollupPeriod(g, at)
+			pipe.HIncrBy(ctx, key, field("documents"), counts.documents)
+			pipe.HIncrBy(ctx, key, field("failed"), counts.failed)
+			pipe.HIncrBy(ctx, key, field("latency_sum_ms"), counts.latencySumMs)
+			for i, n := range counts.latency {
+				if n > 0 {
+					pipe.HIncrBy(ctx, key, field("latency_"+strconv.Itoa(i)), n)
+				}
+			}
+			if counts.confidenceN > 0 {
+				pipe.HIncrByFloat(ctx, key, field("confidence_sum"), counts.confidenceSum)
+				pipe.HIncrBy(ctx, key, field("confidence_n"), counts.confidenceN)
+			}
+			pipe.Expire(ctx, key, rollupRetention[g])
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisRollupStore) Periods(ctx context.Context, granularity string, periods []string) ([]rollupEntry, error) {
+	cmds := make([]*redis.MapStringStringCmd, len(periods))
+	_, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, period := range periods {
+			cmds[i] = pipe.HGetAll(ctx, redisRollupPrefix+granularity+":"+period)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out []rollupEntry
+	for i, cmd := range cmds {
+		series := map[rollupSeries]*rollupCounts{}
+		for field, v := range cmd.Val() {
+			// El tenant puede tener "|"; el motor y el contador no
+			rest, name, _ := cutLast(field, "|")
+			tenant, engine, ok := cutLast(rest, "|")
+			if !ok {
+				continue
+			}
+			key := rollupSeries{tenant, engine}
+			c := series[key]
+			if c == nil {
+				c = newRollupCounts()
+				series[key] = c
+			}
+			n, _ := strconv.ParseInt(v, 10, 64)
+			switch name {
+			case "documents":
+				c.documents = n
+			case "failed":
+				c.failed = n
+			case "latency_sum_ms":
+				c.latencySumMs = n
+			case "confidence_sum":
+				c.confidenceSum, _ = strconv.ParseFloat(v, 64)
+			case "confidence_n":
+				c.confidenceN = n
+			default:
+				if b, err := strconv.Atoi(strings.TrimPrefix(name, "latency_")); err == nil && b >= 0 && b < len(c.latency) {
+					c.latency[b] = n
+				}
+			}
+		}
+		for key, c := range series {
+			out = append(out, rollupEntry{periods[i], key, c})
+		}
+	}
+	return out, nil
+}
+
+// cutLast corta s en la última aparición de sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Rollup son los acumulados de un tenant y un motor en una hora o un día.
+type Rollup struct {
+	Period    time.Time `json:"period"`
+	Tenant    string    `json:"tenant"`
+	Engine    string    `json:"engine"`
+	Documents int64     `json:"documents"`
+	Failed    int64     `json:"failed"`
+	ErrorRate float64   `json:"error_rate"`
+	// LatencyMs es la latencia de punta a punta de los ítems, desde que
+	// entran al pool; los percentiles son aproximados por los buckets.
+	LatencyMs LatencySummary `json:"latency_ms"`
+	// AvgConfidence es la confianza media de los ítems sin error.
+	AvgConfidence float64 `json:"avg_confidence,omitempty"`
+}
+
+// LatencySummary resume el histograma de latencia de un rollup.
+type LatencySummary struct {
+	Mean int64 `json:"mean"`
+	P50  int64 `json:"p50"`
+	P90  int64 `json:"p90"`
+	P99  int64 `json:"p99"`
+}
+
+// RollupReport es la respuesta de /usage/rollups y /admin/rollups.
+type RollupReport struct {
+	Granularity string   `json:"granularity"`
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Rollups     []Rollup `json:"rollups"`
+}
+
+func (e rollupEntry) rollup(granularity string) Rollup {
+	period, _ := time.Parse(rollupPeriodLayout[granularity], e.period)
+	c := e.counts
+	r := Rollup{
+		Period:    period,
+		Tenant:    e.series.tenant,
+		Engine:    e.series.engine,
+		Documents: c.documents,
+		Failed:    c.failed,
+	}
+	if c.documents > 0 {
+		r.ErrorRate = roundConfidence(float64(c.failed) / float64(c.documents))
+		r.LatencyMs = LatencySummary{
+			Mean: c.latencySumMs / c.documents,
+			P50:  latencyPercentile(c.latency, 0.5),
+			P90:  latencyPercentile(c.latency, 0.9),
+			P99:  latencyPercentile(c.latency, 0.99),
+		}
+	}
+	if c.confidenceN > 0 {
+		r.AvgConfidence = roundConfidence(c.confidenceSum / float64(c.confidenceN))
+	}
+	return r
+}
+
+// latencyPercentile interpola el percentil q dentro de su bucket, como
+// histogram_quantile de Prometheus. En el bucket abierto devuelve su límite
+// inferior.
+func latencyPercentile(counts []int64, q float64) int64 {
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	rank := q * float64(total)
+	var seen int64
+	for i, n := range counts {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		var lower int64
+		if i > 0 {
+			lower = latencyBuckets[i-1]
+		}
+		if i == len(latencyBuckets) {
+			return lower
+		}
+		return lower + int64(float64(latencyBuckets[i]-lower)*(rank-float64(seen))/float64(n))
+	}
+	return 0
+}
+
+// writeRollups responde los rollups pedidos, filtrados por tenant si tenant
+// no es vacío. ?granularity= es hour o day (default) y ?engine= filtra por
+// motor.
+func writeRollups(w http.ResponseWriter, r *http.Request, tenant string) {
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = rollupDay
+	}
+	if !slices.Contains(rollupGranularities, granularity) {
+		p := newProblem(CodeInvalidInput, "Parámetros inválidos")
+		p.InvalidParams = []InvalidParam{{Name: "granularity", Reason: "debe ser hour o day"}}
+		writeProblem(w, r, p)
+		return
+	}
+	days, problem := dayRange(r, rollupRetention[granularity])
+	if problem != nil {
+		writeProblem(w, r, *problem)
+		return
+	}
+	periods := days
+	if granularity == rollupHour {
+		periods = nil
+		for _, day := range days {
+			start, _ := time.Parse(time.DateOnly, day)
+			for h := range 24 {
+				periods = append(periods, rollupPeriod(rollupHour, start.Add(time.Duration(h)*time.Hour)))
+			}
+		}
+	}
+	entries, err := rollupStore.Periods(r.Context(), granularity, periods)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	engine := r.URL.Query().Get("engine")
+	out := RollupReport{Granularity: granularity, From: days[0], To: days[len(days)-1], Rollups: []Rollup{}}
+	for _, e := range entries {
+		if (tenant == "" || e.series.tenant == tenant) && (engine == "" || e.series.engine == engine) {
+			out.Rollups = append(out.Rollups, e.rollup(granularity))
+		}
+	}
+	slices.SortFunc(out.Rollups, func(a, b Rollup) int {
+		if c := a.Period.Compare(b.Period); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Tenant, b.Tenant); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Engine, b.Engine)
+	})
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /usage/rollups -> volumen, latencia, errores y confianza del tenant
+// por hora o día y motor
+func handleRollups(w http.ResponseWriter, r *http.Request) {
+	writeRollups(w, r, tenantFrom(r.Context()))
+}
+
+// GET /admin/rollups -> rollups de todos los tenants; ?tenant= filtra
+func handleAdminRollups(w http.ResponseWriter, r *http.Request) {
+	writeRollups(w, r, r.URL.Query().Get("tenant"))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Operaciones de runbook en /admin. Cada acción que cambia estado queda en
+// el log de auditoría de la réplica (GET /admin/audit) y en el log.
+
+// maxAdminAudit limita las acciones que conserva el log de auditoría.
+const maxAdminAudit = 1000
+
+// auditLog guarda las últimas acciones de administración de esta réplica.
+type auditLog struct {
+	mu      sync.Mutex
+	max     int
+	entries []AuditEntry
+}
+
+var adminAudit = &auditLog{max: maxAdminAudit}
+
+func (l *auditLog) add(e AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+	if len(l.entries) > l.max {
+		l.entries = slices.Clone(l.entries[len(l.entries)-l.max:])
+	}
+}
+
+func (l *auditLog) list() []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.entries)
+}
+
+// auditAdmin registra una acción de administración.
+func auditAdmin(r *http.Request, action, detail string) {
+	e := AuditEntry{Time: time.Now().UTC(), Action: "admin." + action, Actor: adminActor(r), Detail: detail}
+	adminAudit.add(e)
+	loggerFrom(r.Context()).Info("admin action", "action", e.Action, "actor", e.Actor, "detail", e.Detail)
+}
+
+// adminActor identifica a quien ejecuta la acción. El token de /admin es
+// compartido, así que se usa el header X-Operator o, sin él, la IP remota.
+func adminActor(r *http.Request) string {
+	if op := r.Header.Get("X-Operator"); op != "" {
+		return op
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// GET /admin/audit -> acciones de administración de esta réplica
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"entries": adminAudit.list()})
+}
+
+// RequeueRequest elige los jobs trabados: queued o running sin cambios hace
+// más de older_than_minutes. Con dry_run solo los lista.
+type RequeueRequest struct {
+	OlderThanMinutes int  `json:"older_than_minutes"`
+	DryRun           bool `json:"dry_run"`
+}
+
+type RequeueSummary struct {
+	OlderThanMinutes int        `json:"older_than_minutes"`
+	DryRun           bool       `json:"dry_run,omitempty"`
+	Requeued         int        `json:"requeued"`
+	Jobs             []JobState `json:"jobs"`
+}
+
+// POST /admin/jobs/requeue-stuck -> reencola los jobs trabados, p. ej. los
+// de una réplica que cayó sin que la cola los reentregara
+func handleAdminRequeueStuck(w http.ResponseWriter, r *http.Request) {
+	// Un job en proceso termina o se reentrega antes del visibility timeout;
+	// con un umbral menor se podría reencolar uno que sigue corriendo
+	minMinutes := int(math.Ceil(visibilityTimeout.Minutes()))
+	var in RequeueRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.OlderThanMinutes < minMinutes {
+		p := newProblem(CodeInvalidInput, "Se espera {older_than_minutes}")
+		p.InvalidParams = []InvalidParam{{
+			Name:   "older_than_minutes",
+			Reason: fmt.Sprintf("debe ser un entero de al menos %d (OCR_QUEUE_VISIBILITY_TIMEOUT)", minMinutes),
+		}}
+		writeProblem(w, r, p)
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	cutoff := time.Now().UTC().Add(-time.Duration(in.OlderThanMinutes) * time.Minute)
+
+	jobs, err := jobStore.JobsByStatus(ctx, jobQueued, jobRunning)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	slices.SortFunc(jobs, func(a, b Job) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	out := RequeueSummary{OlderThanMinutes: in.OlderThanMinutes, DryRun: in.DryRun, Jobs: []JobState{}}
+	for _, job := range jobs {
+		if !stuckSince(job, cutoff) {
+			continue
+		}
+		if in.DryRun {
+			out.Jobs = append(out.Jobs, JobState{ID: job.ID, Key: job.Item.Key, Status: job.Status})
+			continue
+		}
+		updated, requeued, err := requeueStuckJob(ctx, job.ID, cutoff)
+		if err != nil {
+			writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+			return
+		}
+		if requeued {
+			out.Requeued++
+			out.Jobs = append(out.Jobs, JobState{ID: updated.ID, Key: updated.Item.Key, Status: updated.Status})
+		}
+	}
+	if !in.DryRun {
+		auditAdmin(r, "jobs.requeue_stuck", fmt.Sprintf("older_than_minutes=%d requeued=%d", in.OlderThanMinutes, out.Requeued))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// stuckSince indica si el job está queued o running sin cambios desde antes
+// de cutoff. Un job economy no está trabado mientras espera su ventana.
+func stuckSince(job Job, cutoff time.Time) bool {
+	if job.Status != jobQueued && job.Status != jobRunning {
+		return false
+	}
+	if job.ScheduledFor != nil && !job.ScheduledFor.Before(cutoff) {
+		return false
+	}
+	return job.UpdatedAt.Before(cutoff)
+}
+
+// requeueStuckJob vuelve el job a queued y lo encola de nuevo, si sigue
+// trabado al momento de actualizarlo.
+func requeueStuckJob(ctx context.Context, id string, cutoff time.Time) (Job, bool, error) {
+	var msg QueueMessage
+	requeued := false
+	job, err := jobStore.Update(ctx, id, func(j *Job) error {
+		if !stuckSince(*j, cutoff) {
+			return nil
+		}
+		reason := fmt.Sprintf("reencolado por un operador: %s desde %s", j.Status, j.UpdatedAt.Format(time.RFC3339))
+		j.ScheduledFor = nil
+		j.setStatus(jobQueued, reason)
+		msg = QueueMessage{JobID: j.ID, Priority: j.Item.Priority}
+		requeued = true
+		return nil
+	})
+	if err != nil || !requeued {
+		return job, false, err
+	}
+	if err := enqueueJob(ctx, job, msg); err != nil {
+		return job, false, err
+	}
+	return job, true, nil
+}
+
+// WebhookSecretRotation es la respuesta de la rotación: el secreto nuevo
+// se devuelve solo esta vez.
+type WebhookSecretRotation struct {
+	ID            string    `json:"id"`
+	Tenant        string    `json:"tenant"`
+	WebhookSecret string    `json:"webhook_secret"`
+	RotatedAt     time.Time `json:"rotated_at"`
+}
+
+// POST /admin/schedules/{id}/rotate-webhook-secret -> reemplaza el
+// webhook_secret del schedule por uno aleatorio; los webhooks siguientes se
+// firman con el nuevo
+func handleAdminRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	all, err := scheduleStore.All(ctx)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	i := slices.IndexFunc(all, func(s Schedule) bool { return s.ID == chi.URLParam(r, "id") })
+	if i < 0 {
+		writeProblem(w, r, newProblem(CodeNotFound, "No existe un schedule con ese id"))
+		return
+	}
+	secret := newID(32)
+	s, err := scheduleStore.Update(ctx, all[i].Tenant, all[i].ID, func(cur *Schedule) error {
+		if cur.WebhookURL == "" {
+			return &codedError{CodeConflict, errors.New("el schedule no tiene webhook_url")}
+		}
+		cur.WebhookSecret = secret
+		return nil
+	})
+	if err != nil {
+		writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+		return
+	}
+	auditAdmin(r, "schedule.rotate_webhook_secret", fmt.Sprintf("schedule=%s tenant=%s", s.ID, s.Tenant))
+	writeJSON(w, http.StatusOK, WebhookSecretRotation{ID: s.ID, Tenant: s.Tenant, WebhookSecret: secret, RotatedAt: time.Now().UTC()})
+}
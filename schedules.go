@@ -0,0 +1,762 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Schedules: ingesta recurrente de batches. El tenant registra una
+// expresión cron y la URL de un manifiesto con los ítems (CSV, JSONL o
+// JSON); en cada ejecución el servicio descarga el manifiesto, lo encola
+// como un batch y, cuando termina, envía los resultados al webhook. Todas
+// las réplicas revisan los schedules, pero cada ejecución la toma una sola.
+
+const (
+	redisSchedulesKey     = "ocr:schedules"
+	maxSchedulesPerTenant = 20
+	schedulerTick         = 15 * time.Second
+	manifestTimeout       = 30 * time.Second
+	webhookTimeout        = 10 * time.Second
+	webhookAttempts       = 3
+)
+
+// Estados de una ejecución.
+const (
+	runRunning   = "running"
+	runCompleted = "completed"
+	runFailed    = "failed"
+)
+
+var manifestFormats = []string{"csv", "jsonl", "json"}
+
+var scheduleRunsTotal = newCounterVec("ocr_schedule_runs_total", "Ejecuciones de schedules por resultado.", "status")
+
+// Schedule es una ingesta recurrente de un tenant.
+type Schedule struct {
+	ID          string `json:"id"`
+	Tenant      string `json:"tenant"`
+	Cron        string `json:"cron"`
+	Timezone    string `json:"timezone,omitempty"` // default UTC
+	ManifestURL string `json:"manifest_url"`
+	// Format es csv, jsonl o json; por defecto se deduce del Content-Type
+	// o de la extensión del manifiesto.
+	Format      string `json:"format,omitempty"`
+	Preset      string `json:"preset,omitempty"` // para los ítems que no eligen uno
+	Deduplicate *bool  `json:"deduplicate,omitempty"`
+	WebhookURL  string `json:"webhook_url,omitempty"`
+	// WebhookSecret firma el webhook; no se devuelve.
+	WebhookSecret string       `json:"webhook_secret,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	NextRun       time.Time    `json:"next_run"`
+	LastRun       *ScheduleRun `json:"last_run,omitempty"`
+}
+
+// ScheduleRun es una ejecución de un schedule.
+type ScheduleRun struct {
+	ScheduledFor  time.Time      `json:"scheduled_for"`
+	StartedAt     time.Time      `json:"started_at"`
+	FinishedAt    *time.Time     `json:"finished_at,omitempty"`
+	Status        string         `json:"status"` // running | completed | failed
+	BatchID       string         `json:"batch_id,omitempty"`
+	Items         int            `json:"items,omitempty"`
+	Counts        map[string]int `json:"counts,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	WebhookStatus int            `json:"webhook_status,omitempty"`
+	WebhookError  string         `json:"webhook_error,omitempty"`
+}
+
+// ScheduleWebhook es el body que recibe webhook_url al terminar una
+// ejecución.
+type ScheduleWebhook struct {
+	ScheduleID string        `json:"schedule_id"`
+	Tenant     string        `json:"tenant"`
+	Run        ScheduleRun   `json:"run"`
+	Results    []BatchResult `json:"results"`
+}
+
+// nextAfter es la próxima ejecución posterior a t, en UTC.
+func (s Schedule) nextAfter(t time.Time) time.Time {
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}
+	}
+	return spec.next(t.In(loc)).UTC()
+}
+
+func (s Schedule) validate(limits LimitsConfig) []InvalidParam {
+	var invalid []InvalidParam
+	if _, err := parseCron(s.Cron); err != nil {
+		invalid = append(invalid, InvalidParam{Name: "cron", Reason: err.Error()})
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		invalid = append(invalid, InvalidParam{Name: "timezone", Reason: "zona horaria desconocida"})
+	}
+	if s.ManifestURL == "" {
+		invalid = append(invalid, InvalidParam{Name: "manifest_url", Reason: "requerido"})
+	} else if reason := checkURL(s.ManifestURL, limits); reason != "" {
+		invalid = append(invalid, InvalidParam{Name: "manifest_url", Reason: reason})
+	}
+	if s.Format != "" && !slices.Contains(manifestFormats, s.Format) {
+		invalid = append(invalid, InvalidParam{Name: "format", Reason: "debe ser uno de: " + strings.Join(manifestFormats, ", ")})
+	}
+	if s.Preset != "" && presets.load().Presets[s.Preset] == nil {
+		invalid = append(invalid, InvalidParam{Name: "preset", Reason: fmt.Sprintf("preset %q inexistente", s.Preset)})
+	}
+	if reason := checkURL(s.WebhookURL, limits); reason != "" {
+		invalid = append(invalid, InvalidParam{Name: "webhook_url", Reason: reason})
+	}
+	if s.WebhookSecret != "" && s.WebhookURL == "" {
+		invalid = append(invalid, InvalidParam{Name: "webhook_secret", Reason: "requiere webhook_url"})
+	}
+	return invalid
+}
+
+// ScheduleStore guarda los schedules de todos los tenants, compartidos entre
+// réplicas cuando el backend lo permite.
+type ScheduleStore interface {
+	Put(ctx context.Context, s Schedule) error
+	Get(ctx context.Context, tenant, id string) (Schedule, bool, error)
+	List(ctx context.Context, tenant string) ([]Schedule, error)
+	// All devuelve los schedules de todos los tenants.
+	All(ctx context.Context) ([]Schedule, error)
+	Delete(ctx context.Context, tenant, id string) (bool, error)
+	// Update aplica fn al schedule de forma atómica.
+	Update(ctx context.Context, tenant, id string, fn func(*Schedule) error) (Schedule, error)
+}
+
+var scheduleStore ScheduleStore
+
+var errScheduleNotFound = &codedError{CodeNotFound, errors.New("schedule inexistente")}
+
+// scheduleField identifica un schedule en el store; los ids de tenant no
+// contienen ":".
+func scheduleField(tenant, id string) string {
+	return tenant + ":" + id
+}
+
+type memoryScheduleStore struct {
+	mu        sync.Mutex
+	schedules map[string]Schedule
+}
+
+func newMemoryScheduleStore() *memoryScheduleStore {
+	return &memoryScheduleStore{schedules: map[string]Schedule{}}
+}
+
+func (m *memoryScheduleStore) Put(_ context.Context, s Schedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schedules[scheduleField(s.Tenant, s.ID)] = s
+	return nil
+}
+
+func (m *memoryScheduleStore) Get(_ context.Context, tenant, id string) (Schedule, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.schedules[scheduleField(tenant, id)]
+	return s, ok, nil
+}
+
+func (m *memoryScheduleStore) List(_ context.Context, tenant string) ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Schedule
+	for _, s := range m.schedules {
+		if s.Tenant == tenant {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryScheduleStore) All(_ context.Context) ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Schedule
+	for _, s := range m.schedules {
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (m *memoryScheduleStore) Delete(_ context.Context, tenant, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.schedules[scheduleField(tenant, id)]
+	delete(m.schedules, scheduleField(tenant, id))
+	return ok, nil
+}
+
+func (m *memoryScheduleStore) Update(_ context.Context, tenant, id string, fn func(*Schedule) error) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.schedules[scheduleField(tenant, id)]
+	if !ok {
+		return Schedule{}, errScheduleNotFound
+	}
+	if err := fn(&s); err != nil {
+		return Schedule{}, err
+	}
+	m.schedules[scheduleField(tenant, id)] = s
+	return s, nil
+}
+
+// redisScheduleStore guarda un único hash con un campo JSON por schedule,
+// así el scheduler los recorre con una sola lectura.
+type redisScheduleStore struct {
+	rdb *redis.Client
+}
+
+func (r *redisScheduleStore) Put(ctx context.Context, s Schedule) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return r.rdb.HSet(ctx, redisSchedulesKey, scheduleField(s.Tenant, s.ID), data).Err()
+}
+
+func (r *redisScheduleStore) Get(ctx context.Context, tenant, id string) (Schedule, bool, error) {
+	var s Schedule
+	data, err := r.rdb.HGet(ctx, redisSchedulesKey, scheduleField(tenant, id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	return s, true, json.Unmarshal(data, &s)
+}
+
+func (r *redisScheduleStore) List(ctx context.Context, tenant string) ([]Schedule, error) {
+	all, err := r.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Schedule
+	for _, s := range all {
+		if s.Tenant == tenant {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (r *redisScheduleStore) All(ctx context.Context) ([]Schedule, error) {
+	fields, err := r.rdb.HGetAll(ctx, redisSchedulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	var out []Schedule
+	for _, data := range fields {
+		var s Schedule
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (r *redisScheduleStore) Delete(ctx context.Context, tenant, id string) (bool, error) {
+	n, err := r.rdb.HDel(ctx, redisSchedulesKey, scheduleField(tenant, id)).Result()
+	return n > 0, err
+}
+
+func (r *redisScheduleStore) Update(ctx context.Context, tenant, id string, fn func(*Schedule) error) (Schedule, error) {
+	field := scheduleField(tenant, id)
+	var out Schedule
+	for attempt := 0; attempt < 5; attempt++ {
+		err := r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.HGet(ctx, redisSchedulesKey, field).Bytes()
+			if errors.Is(err, redis.Nil) {
+				return errScheduleNotFound
+			}
+			if err != nil {
+				return err
+			}
+			var s Schedule
+			if err := json.Unmarshal(data, &s); err != nil {
+				return err
+			}
+			if err := fn(&s); err != nil {
+				return err
+			}
+			updated, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, redisSchedulesKey, field, updated)
+				return nil
+			})
+			out = s
+			return err
+		}, redisSchedulesKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return out, err
+		}
+	}
+	return Schedule{}, fmt.Errorf("schedule %s: demasiadas actualizaciones concurrentes", id)
+}
+
+// startScheduler revisa cada schedulerTick los schedules vencidos y corre
+// cada uno en su goroutine. Si el servicio estuvo caído, las ejecuciones
+// perdidas se recuperan con una sola. Usa los límites vigentes en cada
+// revisión.
+func startScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(schedulerTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				runDueSchedules(ctx, liveConfig.load().Limits)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func runDueSchedules(ctx context.Context, limits LimitsConfig) {
+	all, err := scheduleStore.All(ctx)
+	if err != nil {
+		slog.Error("schedules not loaded", "error", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, s := range all {
+		if s.NextRun.After(now) {
+			continue
+		}
+		claimed, ok, err := claimRun(ctx, s, now)
+		if err != nil {
+			slog.Error("schedule run not claimed", "schedule_id", s.ID, "tenant", s.Tenant, "error", err)
+			continue
+		}
+		if ok {
+			go runSchedule(ctx, claimed, limits)
+		}
+	}
+}
+
+// claimRun toma la ejecución prevista en s.NextRun y programa la
+// siguiente. ok es false si otra réplica ya la tomó o si la ejecución
+// anterior sigue en curso; en ese caso se saltea, para no procesar dos
+// veces el mismo manifiesto.
+func claimRun(ctx context.Context, s Schedule, now time.Time) (claimed Schedule, ok bool, err error) {
+	skipped := false
+	claimed, err = scheduleStore.Update(ctx, s.Tenant, s.ID, func(cur *Schedule) error {
+		if !cur.NextRun.Equal(s.NextRun) {
+			return nil
+		}
+		cur.NextRun = cur.nextAfter(now)
+		if cur.LastRun.active(now) {
+			skipped = true
+			return nil
+		}
+		ok = true
+		cur.LastRun = &ScheduleRun{ScheduledFor: s.NextRun, StartedAt: now, Status: runRunning}
+		return nil
+	})
+	if skipped {
+		slog.Warn("schedule run skipped, previous run still active", "schedule_id", s.ID, "tenant", s.Tenant)
+	}
+	return claimed, ok, err
+}
+
+// active indica si la ejecución sigue en curso. Una que lleva más de
+// jobTTL es de una réplica que se cayó y no cuenta.
+func (r *ScheduleRun) active(now time.Time) bool {
+	return r != nil && r.Status == runRunning && now.Sub(r.StartedAt) < jobTTL
+}
+
+// runSchedule corre la ejecución registrada en s.LastRun: encola el batch
+// del manifiesto, espera a que termine (como mucho jobTTL, después los jobs
+// vencen) y envía el webhook.
+func runSchedule(ctx context.Context, s Schedule, limits LimitsConfig) {
+	ctx = withTenant(ctx, s.Tenant)
+	logger := slog.With("schedule_id", s.ID, "tenant", s.Tenant)
+	run := *s.LastRun
+	status, jobs, err := startScheduledBatch(ctx, s, limits)
+	if err == nil {
+		run.BatchID, run.Items = status.BatchID, len(status.Jobs)
+		recordRun(ctx, s, run)
+		logger.Info("schedule batch submitted", "batch_id", run.BatchID, "items", run.Items)
+
+		ids := make([]string, len(jobs))
+		for i, j := range jobs {
+			ids[i] = j.ID
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, jobTTL)
+		jobs = waitJobs(waitCtx, ids, nil)
+		cancel()
+		run.Counts = map[string]int{}
+		unfinished := 0
+		for _, j := range jobs {
+			run.Counts[j.Status]++
+			if !j.finished() {
+				unfinished++
+			}
+		}
+		if unfinished > 0 {
+			err = fmt.Errorf("%d jobs sin terminar después de %s", unfinished, jobTTL)
+		}
+	}
+	finished := time.Now().UTC()
+	run.FinishedAt = &finished
+	run.Status = runCompleted
+	if err != nil {
+		run.Status, run.Error = runFailed, err.Error()
+	}
+	scheduleRunsTotal.Inc(run.Status)
+
+	if s.WebhookURL != "" {
+		var results []BatchResult
+		if run.BatchID != "" {
+			results = batchResults(jobs)
+		}
+		run.WebhookStatus, err = sendScheduleWebhook(ctx, s, ScheduleWebhook{ScheduleID: s.ID, Tenant: s.Tenant, Run: run, Results: results})
+		if err != nil {
+			run.WebhookError = err.Error()
+			logger.Warn("schedule webhook failed", "error", err)
+		}
+	}
+	recordRun(ctx, s, run)
+	logger.Info("schedule run finished", "status", run.Status, "batch_id", run.BatchID, "error", run.Error)
+}
+
+// recordRun guarda el estado de la ejecución, salvo que el schedule se haya
+// borrado o ya haya empezado otra.
+func recordRun(ctx context.Context, s Schedule, run ScheduleRun) {
+	_, err := scheduleStore.Update(ctx, s.Tenant, s.ID, func(cur *Schedule) error {
+		if cur.LastRun != nil && cur.LastRun.StartedAt.Equal(run.StartedAt) {
+			cur.LastRun = &run
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errScheduleNotFound) {
+		slog.Error("schedule run not recorded", "schedule_id", s.ID, "tenant", s.Tenant, "error", err)
+	}
+}
+
+// startScheduledBatch descarga el manifiesto y lo encola como un batch, con
+// las mismas validaciones y cuota que POST /ocr/batches.
+func startScheduledBatch(ctx context.Context, s Schedule, limits LimitsConfig) (BatchStatus, []Job, error) {
+	data, contentType, err := fetchManifest(ctx, s.ManifestURL, limits.MaxBodyBytes)
+	if err != nil {
+		return BatchStatus{}, nil, fmt.Errorf("descargando el manifiesto: %w", err)
+	}
+	in, err := manifestBatch(s, data, contentType, limits)
+	if err != nil {
+		return BatchStatus{}, nil, err
+	}
+	if err := checkQuota(ctx, max(1, uniqueItems(in.Items, in.dedup()))); err != nil {
+		return BatchStatus{}, nil, err
+	}
+	return submitBatch(ctx, in)
+}
+
+func fetchManifest(ctx context.Context, rawURL string, maxBytes int64) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", fmt.Errorf("el manifiesto supera el máximo de %d bytes", maxBytes)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// manifestBatch convierte el manifiesto en un batch validado.
+func manifestBatch(s Schedule, data []byte, contentType string, limits LimitsConfig) (BatchOCRRequest, error) {
+	in, invalid, err := parseManifest(s, data, contentType)
+	if err != nil {
+		return in, err
+	}
+	return in, checkManifest(in, invalid, limits)
+}
+
+// parseManifest lee el manifiesto y le aplica los presets, sin validar los
+// ítems. El formato es el del schedule o, si no lo fija, el que indican el
+// Content-Type o la extensión; JSON acepta el envelope {items: [...]} o
+// directamente la lista.
+func parseManifest(s Schedule, data []byte, contentType string) (BatchOCRRequest, []InvalidParam, error) {
+	var in BatchOCRRequest
+	format := s.Format
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		u, _ := url.Parse(s.ManifestURL)
+		switch ext := strings.ToLower(path.Ext(u.Path)); {
+		case slices.Contains(csvMediaTypes, mediaType) || ext == ".csv":
+			format = "csv"
+		case slices.Contains(jsonlMediaTypes, mediaType) || ext == ".jsonl" || ext == ".ndjson":
+			format = "jsonl"
+		default:
+			format = "json"
+		}
+	}
+	body := data
+	if format != "json" {
+		mediaType := csvMediaTypes[0]
+		if format == "jsonl" {
+			mediaType = jsonlMediaTypes[0]
+		}
+		var err error
+		if body, err = batchEnvelope(mediaType, data, true); err != nil {
+			return in, nil, fmt.Errorf("manifiesto inválido: %w", err)
+		}
+	}
+
+	var env map[string]json.RawMessage
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		env = map[string]json.RawMessage{"items": trimmed}
+	} else if err := json.Unmarshal(body, &env); err != nil {
+		return in, nil, fmt.Errorf("manifiesto inválido: %w", err)
+	}
+	if _, ok := env["preset"]; !ok && s.Preset != "" {
+		env["preset"], _ = json.Marshal(s.Preset)
+	}
+	if s.Deduplicate != nil {
+		env["deduplicate"], _ = json.Marshal(*s.Deduplicate)
+	}
+	body, _ = json.Marshal(env)
+
+	body, invalid, err := presets.load().resolvePresets(body)
+	if err != nil {
+		return in, nil, fmt.Errorf("manifiesto inválido: %w", err)
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return in, nil, fmt.Errorf("manifiesto inválido: %w", err)
+	}
+	return in, invalid, nil
+}
+
+// checkManifest valida los ítems del manifiesto; invalid trae los errores
+// de los presets.
+func checkManifest(in BatchOCRRequest, invalid []InvalidParam, limits LimitsConfig) error {
+	switch {
+	case len(in.Items) == 0:
+		return errors.New("el manifiesto no tiene ítems")
+	case len(in.Items) > limits.MaxBatchItems:
+		return fmt.Errorf("el manifiesto tiene %d ítems; el máximo es %d", len(in.Items), limits.MaxBatchItems)
+	}
+	for i, item := range in.Items {
+		prefix := fmt.Sprintf("items[%d].", i)
+		if item.Key == "" || item.URL == "" {
+			invalid = append(invalid, InvalidParam{Name: strings.TrimSuffix(prefix, "."), Reason: "key y url son requeridos"})
+		}
+		invalid = append(invalid, item.validate(prefix, limits)...)
+	}
+	if len(invalid) > 0 {
+		reasons := make([]string, 0, 3)
+		for _, p := range invalid[:min(len(invalid), 3)] {
+			reasons = append(reasons, p.Name+": "+p.Reason)
+		}
+		if len(invalid) > 3 {
+			reasons = append(reasons, fmt.Sprintf("y %d más", len(invalid)-3))
+		}
+		return errors.New("manifiesto inválido: " + strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// sendScheduleWebhook envía el resultado de la ejecución, con hasta
+// webhookAttempts intentos ante errores de red, 429 o 5xx. Con
+// webhook_secret el body va firmado con HMAC-SHA256 en X-OCR-Signature.
+func sendScheduleWebhook(ctx context.Context, s Schedule, payload ScheduleWebhook) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	var status int
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if s.WebhookSecret != "" {
+			mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+			mac.Write(body)
+			req.Header.Set("X-OCR-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			status = resp.StatusCode
+			if status < 300 {
+				return status, nil
+			}
+			err = fmt.Errorf("POST %s: %s", s.WebhookURL, resp.Status)
+			if status != http.StatusTooManyRequests && status < 500 {
+				return status, err
+			}
+		}
+		if attempt == webhookAttempts {
+			return status, err
+		}
+		select {
+		case <-time.After(time.Duration(attempt*attempt) * time.Second):
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+	}
+}
+
+// POST /schedules -> crea un schedule del tenant
+func handleCreateSchedule(limits LimitsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var s Schedule
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+			return
+		}
+		if s.Timezone == "" {
+			s.Timezone = "UTC"
+		}
+		if invalid := s.validate(limits); len(invalid) > 0 {
+			p := newProblem(CodeInvalidInput, "El schedule contiene campos inválidos")
+			p.InvalidParams = invalid
+			writeProblem(w, r, p)
+			return
+		}
+
+		ctx := r.Context()
+		existing, err := scheduleStore.List(ctx, tenantFrom(ctx))
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+			return
+		}
+		if len(existing) >= maxSchedulesPerTenant {
+			writeProblem(w, r, newProblem(CodeConflict, fmt.Sprintf("El tenant ya tiene el máximo de %d schedules", maxSchedulesPerTenant)))
+			return
+		}
+		now := time.Now().UTC()
+		s.ID, s.Tenant, s.CreatedAt, s.LastRun = newID(12), tenantFrom(ctx), now, nil
+		s.NextRun = s.nextAfter(now)
+		if err := scheduleStore.Put(ctx, s); err != nil {
+			writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+			return
+		}
+		addLogAttrs(ctx, slog.String("schedule_id", s.ID))
+		w.Header().Set("Location", "/schedules/"+s.ID)
+		s.WebhookSecret = ""
+		writeJSON(w, http.StatusCreated, s)
+	}
+}
+
+// GET /schedules -> schedules del tenant, del más antiguo al más nuevo
+func handleListSchedules(w http.ResponseWriter, r *http.Request) {
+	list, err := scheduleStore.List(r.Context(), tenantFrom(r.Context()))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	slices.SortFunc(list, func(a, b Schedule) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	for i := range list {
+		list[i].WebhookSecret = ""
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": append([]Schedule{}, list...)})
+}
+
+// getSchedule devuelve el schedule de la URL o escribe el problem.
+func getSchedule(w http.ResponseWriter, r *http.Request) (Schedule, bool) {
+	s, ok, err := scheduleStore.Get(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return s, false
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, "No existe un schedule con ese id"))
+		return s, false
+	}
+	s.WebhookSecret = ""
+	return s, true
+}
+
+// GET /schedules/{id} -> schedule con su próxima y su última ejecución
+func handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	if s, ok := getSchedule(w, r); ok {
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// DELETE /schedules/{id} -> 204; la ejecución en curso termina igual
+func handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	ok, err := scheduleStore.Delete(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	if !ok {
+		writeProblem(w, r, newProblem(CodeNotFound, "No existe un schedule con ese id"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /schedules/{id}/run -> 202; corre el schedule ahora, sin cambiar la
+// próxima ejecución programada
+func handleRunSchedule(limits LimitsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := getSchedule(w, r)
+		if !ok {
+			return
+		}
+		now := time.Now().UTC()
+		ctx := context.WithoutCancel(r.Context())
+		s, err := scheduleStore.Update(ctx, s.Tenant, s.ID, func(cur *Schedule) error {
+			if cur.LastRun.active(now) {
+				return &codedError{CodeConflict, errors.New("el schedule ya tiene una ejecución en curso")}
+			}
+			cur.LastRun = &ScheduleRun{ScheduledFor: now, StartedAt: now, Status: runRunning}
+			return nil
+		})
+		if err != nil {
+			writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+			return
+		}
+		go runSchedule(ctx, s, limits)
+		s.WebhookSecret = ""
+		writeJSON(w, http.StatusAccepted, s)
+	}
+}
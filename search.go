@@ -0,0 +1,432 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Búsqueda de texto completo sobre los resultados guardados. El store
+// mantiene un índice invertido del full_text de cada resultado; la consulta
+// sigue la sintaxis de FTS5: palabras (todas deben aparecer), "frases
+// exactas" y prefijos (fact*). Se ignoran mayúsculas y tildes.
+
+const (
+	maxSearchQuery = 200
+	searchSnippet  = 160 // runas alrededor de la primera coincidencia
+)
+
+// Orden de los resultados de GET /ocr/results.
+const (
+	sortRelevance = "relevance"
+	sortNewest    = "-created_at"
+	sortOldest    = "created_at"
+	sortKey       = "key"
+)
+
+var searchSorts = []string{sortRelevance, sortNewest, sortOldest, sortKey}
+
+var accentFolder = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u",
+	"à", "a", "â", "a", "ã", "a", "ê", "e", "ô", "o", "õ", "o", "ç", "c",
+)
+
+// searchTerms divide el texto en términos del índice: palabras y números en
+// minúsculas y sin tildes. La ñ se conserva.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(accentFolder.Replace(strings.ToLower(text)), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// SearchQuery es una consulta ya interpretada: cada término es una palabra,
+// un prefijo o una frase.
+type SearchQuery struct {
+	terms   []string
+	prefix  []string
+	phrases [][]string
+}
+
+func (q SearchQuery) empty() bool {
+	return len(q.terms) == 0 && len(q.prefix) == 0 && len(q.phrases) == 0
+}
+
+// parseSearchQuery interpreta ?query=. Las comillas sin cerrar llegan hasta
+// el final.
+func parseSearchQuery(raw string) SearchQuery {
+	var q SearchQuery
+	for i, part := range strings.Split(raw, `"`) {
+		if i%2 == 1 {
+			switch words := searchTerms(part); len(words) {
+			case 0:
+			case 1:
+				q.terms = append(q.terms, words[0])
+			default:
+				q.phrases = append(q.phrases, words)
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			words := searchTerms(field)
+			if strings.HasSuffix(field, "*") && len(words) > 0 {
+				q.terms = append(q.terms, words[:len(words)-1]...)
+				q.prefix = append(q.prefix, words[len(words)-1])
+				continue
+			}
+			if len(words) > 1 {
+				// 12.345 o AB-1234 se buscan como frase, igual que FTS5
+				q.phrases = append(q.phrases, words)
+				continue
+			}
+			q.terms = append(q.terms, words...)
+		}
+	}
+	return q
+}
+
+// resultIndex es un índice invertido de los resultados de un store: por
+// término, las veces que aparece en cada resultado.
+type resultIndex struct {
+	postings map[string]map[string]int
+	docs     map[string][]string // términos de cada resultado, en orden
+	// total es la cantidad de resultados del store cuando docs tiene solo
+	// los candidatos de una búsqueda; cero es len(docs).
+	total int
+}
+
+func newResultIndex() *resultIndex {
+	return &resultIndex{postings: map[string]map[string]int{}, docs: map[string][]string{}}
+}
+
+func (ix *resultIndex) add(id, text string) {
+	ix.remove(id)
+	terms := searchTerms(text)
+	ix.docs[id] = terms
+	for _, t := range terms {
+		if ix.postings[t] == nil {
+			ix.postings[t] = map[string]int{}
+		}
+		ix.postings[t][id]++
+	}
+}
+
+func (ix *resultIndex) remove(id string) {
+	for _, t := range ix.docs[id] {
+		delete(ix.postings[t], id)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	delete(ix.docs, id)
+}
+
+// match devuelve los resultados que cumplen la consulta con su puntaje
+// tf-idf. candidate filtra los ids antes de puntuar.
+func (ix *resultIndex) match(q SearchQuery, candidate func(id string) bool) map[string]float64 {
+	groups := ix.groups(q)
+	scores := map[string]float64{}
+	n := float64(len(ix.docs))
+	if ix.total > 0 {
+		n = float64(ix.total)
+	}
+	for i, group := range groups {
+		matched := map[string]float64{}
+		for _, t := range group {
+			idf := math.Log(1 + n/float64(len(ix.postings[t])))
+			for id, tf := range ix.postings[t] {
+				if i > 0 {
+					if _, ok := scores[id]; !ok {
+						continue
+					}
+				} else if !candidate(id) {
+					continue
+				}
+				matched[id] += float64(tf) / float64(len(ix.docs[id])) * idf
+			}
+		}
+		for id := range scores {
+			if _, ok := matched[id]; !ok {
+				delete(scores, id)
+			}
+		}
+		for id, s := range matched {
+			scores[id] += s
+		}
+		if len(scores) == 0 {
+			return scores
+		}
+	}
+	for id := range scores {
+		for _, phrase := range q.phrases {
+			if !containsPhrase(ix.docs[id], phrase) {
+				delete(scores, id)
+				break
+			}
+		}
+	}
+	return scores
+}
+
+// groups arma un grupo por término de la consulta: un resultado tiene que
+// tener al menos uno de los términos de cada grupo en el índice.
+func (ix *resultIndex) groups(q SearchQuery) [][]string {
+	var groups [][]string
+	for _, t := range q.terms {
+		groups = append(groups, []string{t})
+	}
+	for _, phrase := range q.phrases {
+		for _, t := range phrase {
+			groups = append(groups, []string{t})
+		}
+	}
+	for _, p := range q.prefix {
+		var expanded []string
+		for t := range ix.postings {
+			if strings.HasPrefix(t, p) {
+				expanded = append(expanded, t)
+			}
+		}
+		groups = append(groups, expanded)
+	}
+	return groups
+}
+
+// hasPrefixes indica si terms tiene algún término con cada uno de los
+// prefijos.
+func hasPrefixes(terms, prefixes []string) bool {
+	for _, p := range prefixes {
+		if !slices.ContainsFunc(terms, func(t string) bool { return strings.HasPrefix(t, p) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPhrase(terms, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(terms); i++ {
+		if slices.Equal(terms[i:i+len(phrase)], phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResultFilter son los filtros de una búsqueda. Sin consulta devuelve todos
+// los resultados que cumplen los demás filtros.
+type ResultFilter struct {
+	Query        SearchQuery
+	DocumentType string
+	From, To     time.Time         // [From, To); cero es sin límite
+	Metadata     map[string]string // pares que tiene que tener la metadata
+}
+
+// ResultMatch es un resultado que cumple la búsqueda, con su puntaje.
+type ResultMatch struct {
+	StoredResult
+	Score float64
+}
+
+func (f ResultFilter) matches(r StoredResult) bool {
+	if !f.From.IsZero() && r.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.CreatedAt.Before(f.To) {
+		return false
+	}
+	if !matchesMetadata(r.Result.Metadata, f.Metadata) {
+		return false
+	}
+	return f.DocumentType == "" || slices.Contains(resultDocumentTypes(r.Result), f.DocumentType)
+}
+
+// resultDocumentTypes son los tipos de los documentos del resultado: los de
+// split_documents o, sin ellos, el del texto completo.
+func resultDocumentTypes(resp APIResponse) []string {
+	if len(resp.Documents) == 0 {
+		return []string{classifyText(resp.Body)}
+	}
+	var types []string
+	for _, d := range resp.Documents {
+		if !slices.Contains(types, d.DocumentType) {
+			types = append(types, d.DocumentType)
+		}
+	}
+	return types
+}
+
+// ResultSearchPage es una página de GET /ocr/results.
+type ResultSearchPage struct {
+	Query      string      `json:"query,omitempty"`
+	Sort       string      `json:"sort"`
+	Total      int         `json:"total"`
+	Offset     int         `json:"offset"`
+	Limit      int         `json:"limit"`
+	NextOffset *int        `json:"next_offset,omitempty"`
+	Results    []ResultHit `json:"results"`
+}
+
+// ResultHit es un resultado encontrado. Snippet es el texto alrededor de la
+// primera coincidencia; el resultado completo está en
+// /ocr/results/{key}.
+type ResultHit struct {
+	Key           string            `json:"key"`
+	CreatedAt     time.Time         `json:"created_at"`
+	DocumentTypes []string          `json:"document_types"`
+	Confidence    float64           `json:"confidence,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Score         float64           `json:"score,omitempty"`
+	Snippet       string            `json:"snippet"`
+}
+
+// snippet recorta el texto alrededor de la primera aparición de un término
+// de la consulta.
+func snippet(text string, q SearchQuery) string {
+	words := strings.Fields(text)
+	first := 0
+	var want []string
+	want = append(want, q.terms...)
+	for _, p := range q.phrases {
+		want = append(want, p[0])
+	}
+find:
+	for i, w := range words {
+		for _, t := range searchTerms(w) {
+			if slices.Contains(want, t) || slices.ContainsFunc(q.prefix, func(p string) bool { return strings.HasPrefix(t, p) }) {
+				first = i
+				break find
+			}
+		}
+	}
+	start := max(0, first-5)
+	s := []rune(strings.Join(words[start:], " "))
+	out := string(s[:min(len(s), searchSnippet)])
+	if start > 0 {
+		out = "…" + out
+	}
+	if len(s) > searchSnippet {
+		out += "…"
+	}
+	return out
+}
+
+// GET /ocr/results?query=&document_type=&from=&to=&metadata.<clave>=&sort=&offset=&limit=
+// -> búsqueda de texto completo en los resultados guardados del tenant
+func handleSearchResults(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var invalid []InvalidParam
+	raw := q.Get("query")
+	if len([]rune(raw)) > maxSearchQuery {
+		invalid = append(invalid, InvalidParam{Name: "query", Reason: fmt.Sprintf("debe tener hasta %d caracteres", maxSearchQuery)})
+	}
+	filter := ResultFilter{Query: parseSearchQuery(raw), DocumentType: q.Get("document_type")}
+	if raw != "" && filter.Query.empty() {
+		invalid = append(invalid, InvalidParam{Name: "query", Reason: "debe tener al menos una palabra"})
+	}
+	if filter.DocumentType != "" && !slices.Contains(documentTypeNames(), filter.DocumentType) {
+		invalid = append(invalid, InvalidParam{Name: "document_type", Reason: "debe ser uno de: " + strings.Join(documentTypeNames(), ", ")})
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+		days int
+	}{{"from", &filter.From, 0}, {"to", &filter.To, 1}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				invalid = append(invalid, InvalidParam{Name: p.name, Reason: "debe ser una fecha YYYY-MM-DD"})
+				continue
+			}
+			*p.dst = t.AddDate(0, 0, p.days)
+		}
+	}
+	md, mdInvalid := metadataFilter(q)
+	filter.Metadata = md
+	invalid = append(invalid, mdInvalid...)
+	sortBy := q.Get("sort")
+	switch {
+	case sortBy == "" && raw != "":
+		sortBy = sortRelevance
+	case sortBy == "":
+		sortBy = sortNewest
+	case !slices.Contains(searchSorts, sortBy):
+		invalid = append(invalid, InvalidParam{Name: "sort", Reason: "debe ser uno de: " + strings.Join(searchSorts, ", ")})
+	case sortBy == sortRelevance && raw == "":
+		invalid = append(invalid, InvalidParam{Name: "sort", Reason: "relevance requiere query"})
+	}
+	offset, limit := 0, defaultResultsLimit
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			invalid = append(invalid, InvalidParam{Name: "offset", Reason: "debe ser un entero mayor o igual a 0"})
+		}
+		offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxResultsLimit {
+			invalid = append(invalid, InvalidParam{Name: "limit", Reason: fmt.Sprintf("debe ser un entero entre 1 y %d", maxResultsLimit)})
+		}
+		limit = n
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "Parámetros de búsqueda inválidos")
+		p.InvalidParams = invalid
+		writeProblem(w, r, p)
+		return
+	}
+
+	matches, err := results.Search(tenantFrom(r.Context()), filter)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+		return
+	}
+	slices.SortStableFunc(matches, func(a, b ResultMatch) int {
+		switch sortBy {
+		case sortRelevance:
+			if c := cmp.Compare(b.Score, a.Score); c != 0 {
+				return c
+			}
+			return b.CreatedAt.Compare(a.CreatedAt)
+		case sortOldest:
+			return a.CreatedAt.Compare(b.CreatedAt)
+		case sortKey:
+			return strings.Compare(a.Key, b.Key)
+		}
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	out := ResultSearchPage{Query: raw, Sort: sortBy, Total: len(matches), Offset: offset, Limit: limit, Results: []ResultHit{}}
+	if offset < len(matches) {
+		end := min(offset+limit, len(matches))
+		for _, m := range matches[offset:end] {
+			out.Results = append(out.Results, ResultHit{
+				Key:           m.Key,
+				CreatedAt:     m.CreatedAt,
+				DocumentTypes: resultDocumentTypes(m.Result),
+				Confidence:    m.Result.Confidence,
+				Metadata:      m.Result.Metadata,
+				Score:         math.Round(m.Score*1000) / 1000,
+				Snippet:       snippet(m.Result.Body, filter.Query),
+			})
+		}
+		if end < len(matches) {
+			out.NextOffset = &end
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// documentTypeNames son los valores de document_type, unknown incluido.
+func documentTypeNames() []string {
+	names := make([]string, 0, len(documentTypes)+1)
+	for _, t := range documentTypes {
+		names = append(names, t.docType)
+	}
+	return append(names, "unknown")
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Índice de búsqueda de redisResultStore: se actualiza en la misma
+// transacción que guarda o descarta el resultado, así que una búsqueda lee
+// solo los postings de sus términos y los resultados candidatos, no todos
+// los del tenant. Por tenant guarda:
+//
+//   - ocr:search:{tenant}:t:{término}: hash key -> apariciones del término
+//   - ocr:search:{tenant}:d:{key}: set con los términos del resultado, para
+//     sacarlo del índice
+//   - ocr:search:{tenant}:docs: hash key -> cantidad de términos
+//   - ocr:search:{tenant}:terms: sorted set con los términos, para expandir
+//     los prefijos con ZRANGEBYLEX
+//
+// Con el cifrado en reposo habilitado los términos no se guardan en claro:
+// en su lugar van digests HMAC-SHA256 del tenant y el término, y de cada
+// prefijo de hasta searchPrefixMax runas, para buscar por prefijo sin el
+// diccionario, que no se guarda. La clave HMAC está en ocr:search-key,
+// cifrada con el sobre. Las frases se verifican con el texto de los
+// candidatos.
+
+const (
+	redisSearchPrefix = "ocr:search:"
+	// redisSearchBuiltKey indica que el índice ya tiene los resultados
+	// guardados antes de que existiera, y con qué términos: "1" en claro o
+	// "hmac" con digests.
+	redisSearchBuiltKey = "ocr:search-built"
+	redisSearchKeyKey   = "ocr:search-key"
+	// searchPrefixMax acota los prefijos indexados con digests; uno más
+	// largo busca por sus primeras searchPrefixMax runas y se verifica con
+	// el texto.
+	searchPrefixMax = 12
+)
+
+func redisSearchTermKey(tenant, term string) string {
+	return redisSearchPrefix + tenant + ":t:" + term
+}
+
+func redisSearchDocKey(tenant, key string) string {
+	return redisSearchPrefix + tenant + ":d:" + key
+}
+
+func redisSearchDocsKey(tenant string) string {
+	return redisSearchPrefix + tenant + ":docs"
+}
+
+func redisSearchTermsKey(tenant string) string {
+	return redisSearchPrefix + tenant + ":terms"
+}
+
+// searchMode es el valor de redisSearchBuiltKey para los términos que
+// guarda esta réplica.
+func searchMode() string {
+	if encryption != nil {
+		return "hmac"
+	}
+	return "1"
+}
+
+// searchKey es la clave HMAC de los términos, o nil sin cifrado en reposo.
+// La primera réplica que la necesita la genera; las demás leen la suya.
+func (s *redisResultStore) searchKey(ctx context.Context) ([]byte, error) {
+	if encryption == nil {
+		return nil, nil
+	}
+	s.searchMu.Lock()
+	defer s.searchMu.Unlock()
+	if s.termKey != nil {
+		return s.termKey, nil
+	}
+	data, err := s.rdb.Get(ctx, redisSearchKeyKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		key := make([]byte, 32)
+		rand.Read(key)
+		var sealed []byte
+		if sealed, err = encryption.seal(ctx, redisSearchKeyKey, key); err != nil {
+			return nil, err
+		}
+		if err = s.rdb.SetNX(ctx, redisSearchKeyKey, sealed, 0).Err(); err != nil {
+			return nil, err
+		}
+		data, err = s.rdb.Get(ctx, redisSearchKeyKey).Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.termKey, err = openRecord(ctx, redisSearchKeyKey, data); err != nil {
+		return nil, fmt.Errorf("clave del índice de búsqueda: %w", err)
+	}
+	return s.termKey, nil
+}
+
+// termDigest es el nombre en el índice del término (kind "t") o del
+// prefijo (kind "p") con la clave HMAC key; sin clave, el término mismo.
+func termDigest(key []byte, tenant, kind, term string) string {
+	if key == nil {
+		return term
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(kind + "\x00" + tenant + "\x00" + term))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// unindexScript saca un resultado del índice, y del diccionario los
+// términos que ya no tiene ningún resultado. Va en un script para que otra
+// réplica no agregue el término entre el HLEN y el ZREM. KEYS son el set
+// del resultado, el hash de docs, el diccionario y los postings de sus
+// términos, que van en ARGV después de la key.
+var unindexScript = redis.NewScript(`
+for i = 4, #KEYS do
+	redis.call('HDEL', KEYS[i], ARGV[1])
+	if redis.call('HLEN', KEYS[i]) == 0 then
+		redis.call('ZREM', KEYS[3], ARGV[i - 2])
+	end
+end
+redis.call('DEL', KEYS[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return #KEYS - 3
+`)
+
+// indexedTerms lee los términos con que está indexado el resultado. Se
+// llama con su set bajo WATCH, para que no cambie antes de unindexResult.
+func indexedTerms(ctx context.Context, tx *redis.Tx, tenant, key string) ([]string, error) {
+	return tx.SMembers(ctx, redisSearchDocKey(tenant, key)).Result()
+}
+
+// unindexResult agrega a pipe la baja del resultado del índice; terms son
+// los de indexedTerms. Se usa Eval y no EvalSha porque dentro de MULTI un
+// NOSCRIPT no se puede reintentar.
+func unindexResult(ctx context.Context, pipe redis.Pipeliner, tenant, key string, terms []string) {
+	keys := []string{redisSearchDocKey(tenant, key), redisSearchDocsKey(tenant), redisSearchTermsKey(tenant)}
+	args := []any{key}
+	for _, t := range terms {
+		keys = append(keys, redisSearchTermKey(tenant, t))
+		args = append(args, t)
+	}
+	unindexScript.Eval(ctx, pipe, keys, args...)
+}
+
+// indexResult agrega a pipe el alta del texto del resultado en el índice,
+// con digests si termKey no es nil. Tiene que ir después de unindexResult.
+func indexResult(ctx context.Context, pipe redis.Pipeliner, tenant, key, text string, termKey []byte) {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return
+	}
+	tf := map[string]int{}
+	for _, t := range terms {
+		tf[termDigest(termKey, tenant, "t", t)]++
+		if termKey == nil {
+			continue
+		}
+		runes := []rune(t)
+		for n := 1; n <= min(len(runes), searchPrefixMax); n++ {
+			tf[termDigest(termKey, tenant, "p", string(runes[:n]))]++
+		}
+	}
+	members := make([]any, 0, len(tf))
+	dict := make([]redis.Z, 0, len(tf))
+	for t, n := range tf {
+		pipe.HSet(ctx, redisSearchTermKey(tenant, t), key, n)
+		members = append(members, t)
+		dict = append(dict, redis.Z{Member: t})
+	}
+	pipe.SAdd(ctx, redisSearchDocKey(tenant, key), members...)
+	pipe.HSet(ctx, redisSearchDocsKey(tenant), key, len(terms))
+	if termKey == nil {
+		pipe.ZAdd(ctx, redisSearchTermsKey(tenant), dict...)
+	}
+}
+
+// Search busca con el índice los resultados que tienen todos los términos
+// de la consulta y lee solo esos. Sin consulta lee los del rango de fechas.
+func (s *redisResultStore) Search(tenant string, filter ResultFilter) ([]ResultMatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisResultTimeout)
+	defer cancel()
+	var out []ResultMatch
+	if filter.Query.empty() {
+		res, err := s.list(ctx, tenant, filter.From, filter.To)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range res {
+			if filter.matches(r) {
+				out = append(out, ResultMatch{StoredResult: r})
+			}
+		}
+		return out, nil
+	}
+
+	termKey, err := s.searchKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ix, err := s.postings(ctx, tenant, filter.Query, termKey)
+	if err != nil || ix == nil {
+		return nil, err
+	}
+	keys := candidates(ix, filter.Query)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = redisResultKey(tenant, key)
+	}
+	values, err := s.rdb.MGet(ctx, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]StoredResult, len(keys))
+	for i, v := range values {
+		// Puede haberse recortado después de leer el índice
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		r, err := decodeResult(ctx, ids[i], []byte(raw))
+		if err != nil {
+			return nil, err
+		}
+		terms := searchTerms(r.Result.Body)
+		// Con digests, un prefijo largo trae también los que solo comparten
+		// sus primeras searchPrefixMax runas
+		if filter.matches(r) && hasPrefixes(terms, filter.Query.prefix) {
+			byKey[r.Key] = r
+			ix.docs[r.Key] = terms
+		}
+	}
+	for key, score := range ix.match(filter.Query, func(key string) bool { _, ok := byKey[key]; return ok }) {
+		out = append(out, ResultMatch{StoredResult: byKey[key], Score: score})
+	}
+	return out, nil
+}
+
+// postings lee del índice los postings de los términos de la consulta,
+// con los prefijos expandidos, y la cantidad de resultados del tenant. Los
+// docs del índice los completa Search con los candidatos. nil si el tenant
+// no tiene resultados indexados. Con digests cada prefijo tiene su posting,
+// que queda bajo el prefijo mismo.
+func (s *redisResultStore) postings(ctx context.Context, tenant string, q SearchQuery, termKey []byte) (*resultIndex, error) {
+	terms := append([]string{}, q.terms...)
+	for _, phrase := range q.phrases {
+		terms = append(terms, phrase...)
+	}
+	names := make([]string, len(terms))
+	for i, t := range terms {
+		names[i] = termDigest(termKey, tenant, "t", t)
+	}
+	for _, p := range q.prefix {
+		if termKey != nil {
+			runes := []rune(p)
+			terms = append(terms, p)
+			names = append(names, termDigest(termKey, tenant, "p", string(runes[:min(len(runes), searchPrefixMax)])))
+			continue
+		}
+		expanded, err := s.rdb.ZRangeByLex(ctx, redisSearchTermsKey(tenant), &redis.ZRangeBy{Min: "[" + p, Max: "[" + p + "\xff"}).Result()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, expanded...)
+		names = append(names, expanded...)
+	}
+	var total *redis.IntCmd
+	lists := make([]*redis.MapStringStringCmd, len(terms))
+	_, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.HLen(ctx, redisSearchDocsKey(tenant))
+		for i, name := range names {
+			lists[i] = pipe.HGetAll(ctx, redisSearchTermKey(tenant, name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if total.Val() == 0 {
+		return nil, nil
+	}
+	ix := newResultIndex()
+	ix.total = int(total.Val())
+	for i, t := range terms {
+		// Un prefijo igual a un término de la consulta se cumple con el
+		// posting del término
+		if _, ok := ix.postings[t]; ok || len(lists[i].Val()) == 0 {
+			continue
+		}
+		ix.postings[t] = map[string]int{}
+		for key, n := range lists[i].Val() {
+			ix.postings[t][key], _ = strconv.Atoi(n)
+		}
+	}
+	return ix, nil
+}
+
+// candidates son las keys que tienen todos los términos de la consulta, o
+// algún término de cada prefijo, según los postings.
+func candidates(ix *resultIndex, q SearchQuery) []string {
+	var keys map[string]bool
+	for _, group := range ix.groups(q) {
+		matched := map[string]bool{}
+		for _, t := range group {
+			for key := range ix.postings[t] {
+				if keys == nil || keys[key] {
+					matched[key] = true
+				}
+			}
+		}
+		keys = matched
+		if len(keys) == 0 {
+			return nil
+		}
+	}
+	out := make([]string, 0, len(keys))
+	for key := range keys {
+		out = append(out, key)
+	}
+	return out
+}
+
+// buildSearchIndex indexa los resultados guardados antes de que existiera
+// el índice, o con los términos en claro si después se habilitó el cifrado
+// (o al revés). Corre una vez por Redis y modo, en segundo plano al
+// arrancar; hasta que termina, la búsqueda no encuentra esos resultados.
+func (s *redisResultStore) buildSearchIndex(ctx context.Context) {
+	built, err := s.rdb.Get(ctx, redisSearchBuiltKey).Result()
+	if (err != nil && !errors.Is(err, redis.Nil)) || built == searchMode() {
+		return
+	}
+	n := 0
+	iter := s.rdb.Scan(ctx, 0, redisResultIndexPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		tenant := strings.TrimPrefix(iter.Val(), redisResultIndexPrefix)
+		keys, err := s.rdb.ZRange(ctx, iter.Val(), 0, -1).Result()
+		if err != nil {
+			slog.Error("search index not built", "tenant", tenant, "error", err)
+			return
+		}
+		for _, key := range keys {
+			if err := s.reindex(ctx, tenant, key); err != nil {
+				slog.Error("search index not built", "tenant", tenant, "key", key, "error", err)
+				return
+			}
+			n++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		slog.Error("search index not built", "error", err)
+		return
+	}
+	s.rdb.Set(ctx, redisSearchBuiltKey, searchMode(), 0)
+	slog.Info("search index built", "results", n)
+}
+
+// reindex vuelve a indexar el resultado bajo WATCH. Si otra réplica lo
+// guardó en el medio, ya lo indexó ella.
+func (s *redisResultStore) reindex(ctx context.Context, tenant, key string) error {
+	termKey, err := s.searchKey(ctx)
+	if err != nil {
+		return err
+	}
+	id := redisResultKey(tenant, key)
+	err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, id).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r, err := decodeResult(ctx, id, data)
+		if err != nil {
+			return err
+		}
+		terms, err := indexedTerms(ctx, tx, tenant, key)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			unindexResult(ctx, pipe, tenant, key, terms)
+			indexResult(ctx, pipe, tenant, key, r.Result.Body, termKey)
+			return nil
+		})
+		return err
+	}, id, redisSearchDocKey(tenant, key))
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Modos de OCR_MODE. Una instancia reader solo sirve lecturas (resultados,
+// búsqueda, jobs y exports) desde Redis, que puede ser una réplica: no
+// consume la cola, no corre el scheduler ni procesa documentos. Una worker
+// es lo contrario: consume la cola y corre los schedules sin exponer la
+// API, solo health y métricas.
+const (
+	serveFull   = "full"
+	serveReader = "reader"
+	serveWorker = "worker"
+)
+
+var serveModes = []string{serveFull, serveReader, serveWorker}
+
+// serveMode es el OCR_MODE de la instancia.
+var serveMode = serveFull
+
+// rejectWrites responde 405 a todo lo que no sea una lectura, incluido el
+// upgrade de /ocr/ws.
+func rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeProblem(w, r, newProblem(CodeMethodNotAllowed, "esta instancia solo sirve lecturas: "+r.Method+" "+r.URL.Path+" va a una instancia con OCR_MODE=full"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
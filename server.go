@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Servidor HTTP: timeouts de lectura y escritura, tamaño de los headers y
+// keep-alive de OCR_HTTP_*, para que un cliente lento (slowloris) no retenga
+// conexiones, y opcionalmente un socket Unix además del puerto TCP, para
+// los sidecars de la misma máquina o pod.
+
+// newServer arma el servidor de h con los límites de cfg.
+func newServer(h http.Handler, tlsConfig *tls.Config, cfg ServerConfig) *http.Server {
+	srv := &http.Server{
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		// Los handshakes fallidos (p. ej. sin certificado de cliente) van al
+		// log JSON en lugar de stderr
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	srv.SetKeepAlivesEnabled(cfg.KeepAlives)
+	return srv
+}
+
+// listenAndServe atiende en addr, con TLS si tlsConfig no es nil.
+func listenAndServe(addr string, h http.Handler, tlsConfig *tls.Config, cfg ServerConfig) error {
+	lc := net.ListenConfig{KeepAlive: cfg.TCPKeepAlive}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := newServer(h, tlsConfig, cfg)
+	if tlsConfig == nil {
+		return srv.Serve(ln)
+	}
+	return srv.ServeTLS(ln, "", "")
+}
+
+// listenUnix atiende en el socket Unix path, sin TLS: solo llegan los
+// procesos de la máquina con permiso sobre el archivo. Un socket que quedó
+// de una ejecución anterior se reemplaza; cualquier otro archivo no.
+func listenUnix(path string, mode fs.FileMode, h http.Handler, cfg ServerConfig) error {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return fmt.Errorf("%s existe y no es un socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return err
+	}
+	return newServer(h, nil, cfg).Serve(ln)
+}
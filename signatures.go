@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Verificación de firmas digitales de los PDF de entrada: con
+// verify_signatures el servicio descarga el original y verifica cada firma
+// (/ByteRange y /Contents con un CMS/PKCS#7 detached): que el documento no
+// cambió en los rangos firmados, que la firma corresponde al certificado y
+// que el certificado encadena con una raíz confiable a la fecha de firma.
+// Las raíces son las del sistema o las de OCR_SIGNATURE_ROOTS_FILE.
+
+// Estados de una firma.
+const (
+	signatureValid       = "valid"       // íntegra y con certificado confiable
+	signatureUntrusted   = "untrusted"   // íntegra, pero el certificado no encadena con una raíz confiable
+	signatureInvalid     = "invalid"     // el documento cambió o la firma no corresponde al certificado
+	signatureUnsupported = "unsupported" // formato que no se puede verificar
+)
+
+// signatureRoots son las raíces de OCR_SIGNATURE_ROOTS_FILE; nil usa las del
+// sistema.
+var signatureRoots *x509.CertPool
+
+// PDFSignature es una firma de un PDF de entrada. SigningTime es la hora que
+// declara el firmante (atributo firmado o /M), no un sello de tiempo.
+type PDFSignature struct {
+	Status       string     `json:"status"`
+	Detail       string     `json:"detail,omitempty"`
+	Signer       string     `json:"signer,omitempty"` // CN del certificado, o el subject completo
+	Subject      string     `json:"subject,omitempty"`
+	Issuer       string     `json:"issuer,omitempty"`
+	SerialNumber string     `json:"serial_number,omitempty"`
+	SigningTime  *time.Time `json:"signing_time,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	SubFilter    string     `json:"sub_filter,omitempty"`
+	// CoversWholeDocument es false si el archivo tiene revisiones posteriores
+	// a la firma (otras firmas, anotaciones o cambios).
+	CoversWholeDocument bool `json:"covers_whole_document"`
+}
+
+// loadSignatureRoots lee un bundle PEM de certificados raíz.
+func loadSignatureRoots(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no contiene certificados PEM", path)
+	}
+	return pool, nil
+}
+
+// verifySignatures verifica las firmas del original del documento. Los
+// originales que no son PDF no tienen firmas.
+func verifySignatures(ctx context.Context, doc *Document) ([]PDFSignature, error) {
+	found := []PDFSignature{}
+	if doc.source == nil || !doc.source.multiPage {
+		return found, nil
+	}
+	data, _, err := doc.source.original(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return found, nil
+	}
+	for _, m := range pdfByteRangePattern.FindAllSubmatchIndex(data, -1) {
+		found = append(found, verifyPDFSignature(data, m))
+	}
+	return found, nil
+}
+
+var (
+	pdfByteRangePattern = regexp.MustCompile(`/ByteRange\s*\[\s*(\d+)\s+(\d+)\s+(\d+)\s+(\d+)\s*\]`)
+	pdfSubFilterPattern = regexp.MustCompile(`/SubFilter\s*/([A-Za-z0-9.#_-]+)`)
+	pdfReasonPattern    = regexp.MustCompile(`/Reason\s*(\((?:[^()\\]|\\.)*\))`)
+	pdfLocationPattern  = regexp.MustCompile(`/Location\s*(\((?:[^()\\]|\\.)*\))`)
+	pdfDatePattern      = regexp.MustCompile(`/M\s*(\((?:[^()\\]|\\.)*\))`)
+)
+
+// verifyPDFSignature verifica la firma cuyo /ByteRange ubica m. El hueco
+// entre los dos rangos es el /Contents con el CMS en hexadecimal.
+func verifyPDFSignature(data []byte, m []int) PDFSignature {
+	var r [4]int
+	for i := range r {
+		r[i], _ = strconv.Atoi(string(data[m[2+2*i]:m[3+2*i]]))
+	}
+	sig := PDFSignature{Status: signatureUnsupported}
+	dict := pdfObjectAround(data, m[0])
+	if sm := pdfSubFilterPattern.FindSubmatch(dict); sm != nil {
+		sig.SubFilter = string(sm[1])
+	}
+	if sm := pdfReasonPattern.FindSubmatch(dict); sm != nil {
+		sig.Reason = pdfString(sm[1])
+	}
+	if sm := pdfLocationPattern.FindSubmatch(dict); sm != nil {
+		sig.Location = pdfString(sm[1])
+	}
+	if sm := pdfDatePattern.FindSubmatch(dict); sm != nil {
+		if t, ok := pdfDate(pdfString(sm[1])); ok {
+			sig.SigningTime = &t
+		}
+	}
+
+	start2, end2 := r[2], r[2]+r[3]
+	if r[0] != 0 || r[1] <= 0 || start2 <= r[1] || end2 > len(data) {
+		sig.Detail = "/ByteRange fuera del archivo"
+		return sig
+	}
+	sig.CoversWholeDocument = end2 == len(data)
+	contents := bytes.TrimSpace(data[r[1]:start2])
+	if len(contents) < 2 || contents[0] != '<' || contents[len(contents)-1] != '>' {
+		sig.Detail = "/Contents no está entre los rangos firmados"
+		return sig
+	}
+	der, err := hex.DecodeString(string(bytes.Join(bytes.Fields(contents[1:len(contents)-1]), nil)))
+	if err != nil {
+		sig.Detail = "/Contents no es hexadecimal"
+		return sig
+	}
+	if sig.SubFilter == "adbe.x509.rsa_sha1" {
+		sig.Detail = "adbe.x509.rsa_sha1 no está soportado"
+		return sig
+	}
+	signed := make([]byte, 0, r[1]+r[3])
+	signed = append(append(signed, data[:r[1]]...), data[start2:end2]...)
+	verifyCMS(der, signed, &sig)
+	return sig
+}
+
+// pdfObjectAround devuelve el objeto que contiene la posición pos, para
+// leer los demás campos del diccionario de la firma.
+func pdfObjectAround(data []byte, pos int) []byte {
+	start := bytes.LastIndex(data[:pos], []byte("obj"))
+	if start < 0 {
+		start = 0
+	}
+	end := bytes.Index(data[pos:], []byte("endobj"))
+	if end < 0 {
+		return data[start:]
+	}
+	return data[start : pos+end]
+}
+
+// OIDs de CMS (RFC 5652) y de los algoritmos soportados.
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAPSS        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+	digestAlgorithms = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+)
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContent     cmsContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// verifyCMS verifica el SignedData der sobre content y completa sig.
+func verifyCMS(der, content []byte, sig *PDFSignature) {
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil || !ci.ContentType.Equal(oidSignedData) {
+		sig.Detail = "/Contents no es un CMS SignedData"
+		return
+	}
+	var sd cmsSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		sig.Detail = "SignedData inválido: " + err.Error()
+		return
+	}
+	if len(sd.SignerInfos) != 1 {
+		sig.Detail = fmt.Sprintf("se esperaba un firmante, hay %d", len(sd.SignerInfos))
+		return
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		sig.Detail = "certificados inválidos: " + err.Error()
+		return
+	}
+	si := sd.SignerInfos[0]
+	cert := signerCertificate(si.SID, certs)
+	if cert == nil {
+		sig.Detail = "el CMS no incluye el certificado del firmante"
+		return
+	}
+	sig.Subject = cert.Subject.String()
+	sig.Signer = cert.Subject.CommonName
+	if sig.Signer == "" {
+		sig.Signer = sig.Subject
+	}
+	sig.Issuer = cert.Issuer.String()
+	sig.SerialNumber = cert.SerialNumber.Text(16)
+
+	hash, ok := digestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		sig.Detail = "algoritmo de digest no soportado: " + si.DigestAlgorithm.Algorithm.String()
+		return
+	}
+	// adbe.pkcs7.sha1 firma el SHA-1 de los rangos, encapsulado en el CMS
+	if len(sd.EncapContent.Content.Bytes) > 0 {
+		var econtent []byte
+		if _, err := asn1.Unmarshal(sd.EncapContent.Content.Bytes, &econtent); err != nil {
+			sig.Detail = "contenido encapsulado inválido"
+			return
+		}
+		h := crypto.SHA1.New()
+		h.Write(content)
+		if !bytes.Equal(econtent, h.Sum(nil)) {
+			sig.Status, sig.Detail = signatureInvalid, "el documento cambió después de firmarse"
+			return
+		}
+		content = econtent
+	}
+
+	signedMessage := content
+	if len(si.SignedAttrs.FullBytes) > 0 {
+		// Se firma el DER de los atributos con el tag SET, no el [0] implícito
+		signedMessage = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+		var attrs []cmsAttribute
+		if _, err := asn1.UnmarshalWithParams(signedMessage, &attrs, "set"); err != nil {
+			sig.Detail = "atributos firmados inválidos"
+			return
+		}
+		var digest []byte
+		for _, a := range attrs {
+			switch {
+			case a.Type.Equal(oidMessageDigest):
+				asn1.Unmarshal(a.Values.Bytes, &digest)
+			case a.Type.Equal(oidSigningTime):
+				var t time.Time
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &t); err == nil {
+					t = t.UTC()
+					sig.SigningTime = &t
+				}
+			}
+		}
+		h := hash.New()
+		h.Write(content)
+		if digest == nil || !bytes.Equal(digest, h.Sum(nil)) {
+			sig.Status, sig.Detail = signatureInvalid, "el documento cambió después de firmarse"
+			return
+		}
+	}
+
+	if si.SignatureAlgorithm.Algorithm.Equal(oidRSAPSS) {
+		sig.Detail = "RSASSA-PSS no está soportado"
+		return
+	}
+	if err := checkSignature(cert.PublicKey, hash, signedMessage, si.Signature); err != nil {
+		sig.Status, sig.Detail = signatureInvalid, err.Error()
+		return
+	}
+
+	at := time.Now()
+	if sig.SigningTime != nil {
+		at = *sig.SigningTime
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         signatureRoots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		sig.Status, sig.Detail = signatureUntrusted, "certificado no confiable: "+err.Error()
+		return
+	}
+	sig.Status = signatureValid
+}
+
+// signerCertificate busca el certificado que identifica sid: emisor y serie,
+// o subject key identifier.
+func signerCertificate(sid asn1.RawValue, certs []*x509.Certificate) *x509.Certificate {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, sid.Bytes) {
+				return c
+			}
+		}
+		return nil
+	}
+	var ias cmsIssuerAndSerial
+	if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+		return nil
+	}
+	for _, c := range certs {
+		if c.SerialNumber.Cmp(ias.Serial) == 0 && bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) {
+			return c
+		}
+	}
+	return nil
+}
+
+func checkSignature(pub crypto.PublicKey, hash crypto.Hash, message, signature []byte) error {
+	h := hash.New()
+	h.Write(message)
+	digest := h.Sum(nil)
+	var ok bool
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(pub, digest, signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, message, signature)
+	default:
+		return fmt.Errorf("clave pública %T no soportada", pub)
+	}
+	if !ok {
+		return errors.New("la firma no corresponde al certificado")
+	}
+	return nil
+}
+
+// pdfString decodifica un string literal de PDF, "(...)": escapes y
+// UTF-16BE con BOM. Sin BOM es PDFDocEncoding, que para los caracteres
+// latinos coincide con Latin-1.
+func pdfString(lit []byte) string {
+	lit = lit[1 : len(lit)-1]
+	var b []byte
+	for i := 0; i < len(lit); i++ {
+		c := lit[i]
+		if c != '\\' || i+1 == len(lit) {
+			b = append(b, c)
+			continue
+		}
+		i++
+		switch c = lit[i]; c {
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(lit) && j < i+3 && lit[j] >= '0' && lit[j] <= '7' {
+				j++
+			}
+			n, _ := strconv.ParseUint(string(lit[i:j]), 8, 8)
+			b = append(b, byte(n))
+			i = j - 1
+		default:
+			b = append(b, c)
+		}
+	}
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		u := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(u))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// pdfDate interpreta una fecha de PDF: D:AAAAMMDDHHmmSS con zona opcional
+// (Z, +HH'mm' o -HH'mm').
+func pdfDate(s string) (time.Time, bool) {
+	s = strings.TrimPrefix(s, "D:")
+	if len(s) < 14 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102150405", s[:14])
+	if err != nil {
+		return time.Time{}, false
+	}
+	zone := strings.ReplaceAll(s[14:], "'", "")
+	if len(zone) == 5 && (zone[0] == '+' || zone[0] == '-') {
+		h, _ := strconv.Atoi(zone[1:3])
+		m, _ := strconv.Atoi(zone[3:5])
+		offset := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+		if zone[0] == '+' {
+			offset = -offset
+		}
+		t = t.Add(offset)
+	}
+	return t.UTC(), true
+}
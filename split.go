@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DocumentResult es uno de los documentos detectados dentro de un escaneo.
+type DocumentResult struct {
+	Index        int    `json:"index"`
+	DocumentType string `json:"document_type"`
+	PageStart    int    `json:"page_start"`
+	PageEnd      int    `json:"page_end"`
+	Body         string `json:"full_text"`
+}
+
+var documentTypes = []struct {
+	keyword string
+	docType string
+}{
+	{"pasaporte", "passport"},
+	{"licencia de conducir", "drivers_license"},
+	{"documento de identificación", "id_card"},
+	{"factura", "invoice"},
+	{"certificado de nacimiento", "birth_certificate"},
+	{"contrato", "contract"},
+	{"recibo", "receipt"},
+	{"diploma", "diploma"},
+	{"tarjeta de crédito", "credit_card"},
+	{"boleta de servicios", "utility_bill"},
+}
+
+// classifyText devuelve el tipo de documento según palabras clave del texto.
+func classifyText(text string) string {
+	lower := strings.ToLower(text)
+	for _, t := range documentTypes {
+		if strings.Contains(lower, t.keyword) {
+			return t.docType
+		}
+	}
+	return "unknown"
+}
+
+var firstPageMarker = regexp.MustCompile(`(?i)p[áa]gina\s+1\s+de\s+\d+`)
+
+// splitDocuments agrupa páginas consecutivas en documentos. Una página abre
+// un documento nuevo si lleva el marcador "Página 1 de n" o si su tipo
+// difiere del documento en curso. Las páginas en blanco no abren ni cortan
+// documentos. El texto de cada documento lo completa fillDocumentText.
+func splitDocuments(pages []PageResult) []DocumentResult {
+	var docs []DocumentResult
+	for _, p := range pages {
+		if p.Blank {
+			continue
+		}
+		docType := classifyText(p.Text)
+		if len(docs) == 0 || firstPageMarker.MatchString(p.Text) ||
+			(docType != "unknown" && docType != docs[len(docs)-1].DocumentType) {
+			docs = append(docs, DocumentResult{
+				Index:        len(docs),
+				DocumentType: docType,
+				PageStart:    p.Number,
+			})
+		}
+		cur := &docs[len(docs)-1]
+		if cur.DocumentType == "unknown" {
+			cur.DocumentType = docType
+		}
+		cur.PageEnd = p.Number
+	}
+	return docs
+}
+
+// fillDocumentText arma el texto de cada documento a partir de las páginas
+// ya procesadas por assembleText.
+func fillDocumentText(docs []DocumentResult, pages []PageResult, sep string) {
+	for i := range docs {
+		var in []PageResult
+		for _, p := range pages {
+			if p.Number >= docs[i].PageStart && p.Number <= docs[i].PageEnd {
+				in = append(in, p)
+			}
+		}
+		docs[i].Body = joinPages(in, sep)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Espera de dependencias al arrancar: antes de escuchar, el servicio espera
+// que Redis, el archivado y los motores respondan, reintentando con backoff
+// hasta OCR_STARTUP_TIMEOUT. Un pod que arranca antes que sus dependencias
+// no entra en crash loop ni queda ready respondiendo errores; si el plazo
+// vence, termina indicando qué dependencia no respondió.
+
+const startupBaseBackoff = 250 * time.Millisecond
+
+// startupWait es el plazo de arranque; lo arma setupStartup.
+var startupWait struct {
+	timeout    time.Duration
+	deadline   time.Time
+	maxBackoff time.Duration
+}
+
+func setupStartup(cfg StartupConfig) {
+	startupWait.timeout = cfg.Timeout
+	startupWait.deadline = time.Now().Add(cfg.Timeout)
+	startupWait.maxBackoff = cfg.MaxBackoff
+}
+
+// waitForDependency reintenta check hasta que responde o vence el plazo de
+// arranque. Cada intento se acota a readinessTimeout.
+func waitForDependency(ctx context.Context, name string, check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithDeadline(ctx, startupWait.deadline)
+	defer cancel()
+	var last error
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, readinessTimeout)
+		err := check(attemptCtx)
+		cancelAttempt()
+		if err == nil {
+			if attempt > 0 {
+				slog.Info("dependency available", "dependency", name, "attempts", attempt+1)
+			}
+			return nil
+		}
+		// Un intento cortado por el plazo no dice por qué falla la dependencia
+		if last == nil || ctx.Err() == nil {
+			last = err
+		}
+
+		wait := startupBackoff(attempt)
+		slog.Warn("dependency not available, retrying", "dependency", name,
+			"attempt", attempt+1, "retry_in_ms", wait.Milliseconds(), "error", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("%s no respondió en %s: %w", name, startupWait.timeout, last)
+		}
+	}
+}
+
+// waitForDependencies espera en paralelo todas las dependencias de
+// /health/ready.
+func waitForDependencies(ctx context.Context) error {
+	checks := allReadinessChecks()
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = waitForDependency(ctx, c.name, c.check)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// startupBackoff es exponencial con "full jitter", como el de los motores,
+// así las réplicas que arrancan juntas no reintentan a la vez.
+func startupBackoff(attempt int) time.Duration {
+	d := startupBaseBackoff << attempt
+	if d <= 0 || d > startupWait.maxBackoff {
+		d = startupWait.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
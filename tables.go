@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// tableMinQuality es la calidad de página por debajo de la cual no se
+// distinguen las líneas de las tablas.
+const tableMinQuality = 0.5
+
+// Table es una tabla detectada en una página. Rows tiene una fila por
+// renglón y cada fila una celda por columna; las primeras HeaderRows filas
+// son los encabezados.
+type Table struct {
+	Page        int           `json:"page"`
+	BoundingBox BoundingBox   `json:"bbox"`
+	Columns     int           `json:"columns"`
+	HeaderRows  int           `json:"header_rows"`
+	Confidence  float64       `json:"confidence"`
+	Rows        [][]TableCell `json:"rows"`
+}
+
+// TableCell es una celda con su texto, vacío si la celda está vacía.
+type TableCell struct {
+	Text        string      `json:"text"`
+	BoundingBox BoundingBox `json:"bbox"`
+	Confidence  float64     `json:"confidence"`
+}
+
+// tableLayout es la tabla que trae un tipo de documento: los encabezados y
+// las filas de datos, la última con los totales.
+type tableLayout struct {
+	title   string
+	headers []string
+	rows    func(r *rand.Rand) [][]string
+}
+
+var tableLayouts = []tableLayout{
+	{"Factura comercial", []string{"Descripción", "Cantidad", "Precio unitario", "Importe"}, invoiceRows},
+	{"Recibo de pago mensual", []string{"Concepto", "Unidades", "Haberes", "Deducciones"}, payslipRows},
+	{"Boleta de servicios públicos", []string{"Concepto", "Consumo", "Importe"}, utilityRows},
+}
+
+var invoiceItems = []string{
+	"Servicio de mantenimiento", "Resma papel A4", "Tóner impresora", "Licencia anual software",
+	"Horas de consultoría", "Cable UTP cat. 6", "Monitor 24 pulgadas", "Soporte técnico mensual",
+}
+
+func invoiceRows(r *rand.Rand) [][]string {
+	var rows [][]string
+	total := 0
+	for _, i := range r.Perm(len(invoiceItems))[:r.IntN(5)+2] {
+		qty, price := r.IntN(20)+1, (r.IntN(5000)+50)*1000
+		total += qty * price
+		rows = append(rows, []string{invoiceItems[i], fmt.Sprint(qty), formatAmount(price), formatAmount(qty * price)})
+	}
+	return append(rows, []string{"Total", "", "", formatAmount(total)})
+}
+
+func payslipRows(r *rand.Rand) [][]string {
+	basic := (r.IntN(110000) + 40000) * 1000
+	seniority := r.IntN(20) + 1
+	earnings := [][]string{
+		{"Sueldo básico", "30 días", formatAmount(basic), ""},
+		{"Antigüedad", fmt.Sprintf("%d años", seniority), formatAmount(basic / 100 * seniority), ""},
+		{"Presentismo", "", formatAmount(basic / 12), ""},
+	}
+	gross := basic + basic/100*seniority + basic/12
+	if r.Float32() < 0.5 {
+		hours := r.IntN(16) + 1
+		overtime := basic / 200 * 3 / 2 * hours
+		gross += overtime
+		earnings = append(earnings, []string{"Horas extras 50%", fmt.Sprintf("%d hs", hours), formatAmount(overtime), ""})
+	}
+	deductions := 0
+	rows := earnings
+	for _, d := range []struct {
+		concept string
+		percent int
+	}{{"Jubilación", 11}, {"Obra social", 3}, {"Ley 19032", 3}} {
+		amount := gross / 100 * d.percent
+		deductions += amount
+		rows = append(rows, []string{d.concept, fmt.Sprintf("%d%%", d.percent), "", formatAmount(amount)})
+	}
+	return append(rows, []string{"Totales", "", formatAmount(gross), formatAmount(deductions)})
+}
+
+func utilityRows(r *rand.Rand) [][]string {
+	fixed := (r.IntN(5000) + 1000) * 100
+	kwh := r.IntN(600) + 80
+	consumption := kwh * (r.IntN(80) + 40) * 100
+	taxes := (fixed + consumption) / 100 * 27
+	return [][]string{
+		{"Cargo fijo", "", formatAmount(fixed)},
+		{"Cargo variable", fmt.Sprintf("%d kWh", kwh), formatAmount(consumption)},
+		{"Impuestos y tasas", "", formatAmount(taxes)},
+		{"Total a pagar", "", formatAmount(fixed + consumption + taxes)},
+	}
+}
+
+// formatAmount da formato de pesos a un importe en centavos: $ 12.345,67.
+func formatAmount(cents int) string {
+	units := fmt.Sprint(cents / 100)
+	var b strings.Builder
+	for i, c := range units {
+		if i > 0 && (len(units)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(c)
+	}
+	return fmt.Sprintf("$ %s,%02d", b.String(), cents%100)
+}
+
+// randomTable arma la tabla de la página según el tipo de documento, con
+// las celdas en una grilla: la primera columna es la más ancha.
+func randomTable(r *rand.Rand, p Page) (Table, bool) {
+	var layout *tableLayout
+	for i := range tableLayouts {
+		if strings.HasPrefix(p.title, tableLayouts[i].title) {
+			layout = &tableLayouts[i]
+		}
+	}
+	if layout == nil {
+		return Table{}, false
+	}
+
+	const margin, rowHeight = 150, 90
+	rows := append([][]string{layout.headers}, layout.rows(r)...)
+	width := pageWidth - 2*margin
+	first := width * 2 / 5
+	widths := []int{first}
+	for range len(layout.headers) - 1 {
+		widths = append(widths, (width-first)/(len(layout.headers)-1))
+	}
+	t := Table{
+		Page:        p.Number,
+		BoundingBox: BoundingBox{X: margin, Y: r.IntN(600) + 600, Width: width, Height: rowHeight * len(rows)},
+		Columns:     len(layout.headers),
+		HeaderRows:  1,
+		Confidence:  cellConfidence(r, p.quality),
+	}
+	for i, row := range rows {
+		x := t.BoundingBox.X
+		cells := make([]TableCell, len(row))
+		for j, text := range row {
+			cells[j] = TableCell{
+				Text:        text,
+				BoundingBox: BoundingBox{X: x, Y: t.BoundingBox.Y + i*rowHeight, Width: widths[j], Height: rowHeight},
+			}
+			if text != "" {
+				cells[j].Confidence = cellConfidence(r, p.quality)
+			}
+			x += widths[j]
+		}
+		t.Rows = append(t.Rows, cells)
+	}
+	return t, true
+}
+
+// cellConfidence es la confianza de una celda, que baja con la calidad de
+// la página.
+func cellConfidence(r *rand.Rand, quality float64) float64 {
+	return roundConfidence(min(quality*0.9+r.Float64()*0.1, 0.99))
+}
+
+// extractTables simula la detección de tablas sobre las páginas legibles.
+// Como la de códigos, corre aparte del motor OCR: no consume llamadas ni
+// pasa por el fallback.
+func extractTables(ctx context.Context, doc *Document) ([]Table, error) {
+	found := []Table{}
+	for _, p := range doc.Pages {
+		if isBlankPage(p) {
+			continue
+		}
+		mock := mockRand.load()
+		r := mock.source("tables", p.seed)
+		select {
+		case <-time.After(mock.engineLatency(r, 50*time.Millisecond, 150*time.Millisecond)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if p.quality < tableMinQuality {
+			continue
+		}
+		if t, ok := randomTable(r, p); ok {
+			found = append(found, t)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Caché de originales en disco: fetchOriginal guarda lo que descarga en
+// OCR_TEMP_DIR, hasta OCR_TEMP_MAX_BYTES, descartando los usados hace más
+// tiempo (LRU). Una URL ya descargada se revalida con If-None-Match o
+// If-Modified-Since y, si no cambió (304), se lee del disco: los motores de
+// nube, la verificación de firmas, el archivado y los exports de un mismo
+// documento no la descargan de nuevo. Sin ETag ni Last-Modified se descarga
+// siempre. Antes de escribir se verifica el espacio libre, para fallar con
+// INSUFFICIENT_STORAGE en lugar de con un error de E/S a mitad de la
+// descarga.
+
+// tempFileSuffix identifica los archivos del caché en OCR_TEMP_DIR.
+const tempFileSuffix = ".orig"
+
+var tempCacheTotal = newCounterVec("ocr_temp_cache_total", "Originales pedidos al caché en disco: hit (304), miss o evicted (descartados por espacio).", "result")
+
+var _ = newGaugeFunc("ocr_temp_dir_bytes", "Bytes de originales en el caché en disco (OCR_TEMP_DIR).",
+	nil, func(emit func(float64, ...string)) {
+		if c := originals; c != nil {
+			emit(float64(c.usage()))
+		}
+	})
+
+var _ = newGaugeFunc("ocr_temp_disk_free_bytes", "Espacio libre en el disco de OCR_TEMP_DIR.",
+	nil, func(emit func(float64, ...string)) {
+		if c := originals; c != nil {
+			if free, err := diskFree(c.dir); err == nil {
+				emit(float64(free))
+			}
+		}
+	})
+
+// tempEntry es un original en el caché.
+type tempEntry struct {
+	path         string
+	size         int64
+	contentType  string
+	etag         string
+	lastModified string
+	used         time.Time
+}
+
+// originalsCache es el caché de originales de OCR_TEMP_DIR, por URL.
+type originalsCache struct {
+	dir     string
+	max     int64
+	minFree int64
+
+	mu       sync.Mutex
+	entries  map[string]*tempEntry
+	size     int64 // bytes de entries más los reservados por descargas en curso
+	reserved int64
+}
+
+// originals es nil cuando el caché está deshabilitado (OCR_TEMP_MAX_BYTES=0):
+// los originales se descargan directo a memoria.
+var originals *originalsCache
+
+// setupTempDir crea OCR_TEMP_DIR y borra los originales que dejó una
+// ejecución anterior, que no están en el índice.
+func setupTempDir(cfg TempConfig) error {
+	if cfg.MaxBytes == 0 {
+		originals = nil
+		return nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return fmt.Errorf("OCR_TEMP_DIR: %w", err)
+	}
+	stale, _ := filepath.Glob(filepath.Join(cfg.Dir, "*"+tempFileSuffix))
+	for _, p := range stale {
+		os.Remove(p)
+	}
+	originals = &originalsCache{dir: cfg.Dir, max: cfg.MaxBytes, minFree: cfg.MinFreeBytes, entries: map[string]*tempEntry{}}
+	addReadinessCheck("temp_dir", originals.ping)
+	return nil
+}
+
+func (c *originalsCache) usage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size - c.reserved
+}
+
+// ping falla si el disco ya está por debajo del mínimo y el caché no tiene
+// nada que liberar.
+func (c *originalsCache) ping(context.Context) error {
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) > 0 {
+		return nil
+	}
+	return c.checkSpace(0)
+}
+
+// checkSpace falla si al escribir n bytes quedarían menos de minFree libres.
+func (c *originalsCache) checkSpace(n int64) error {
+	if c.minFree == 0 {
+		return nil
+	}
+	free, err := diskFree(c.dir)
+	if err != nil {
+		return err
+	}
+	if free-n < c.minFree {
+		return &codedError{CodeInsufficientStorage, fmt.Errorf("espacio en disco insuficiente en %s para un original de hasta %d bytes: quedan %d bytes libres y el mínimo es %d (OCR_TEMP_MIN_FREE_BYTES)", c.dir, n, free, c.minFree)}
+	}
+	return nil
+}
+
+// reserve hace lugar para n bytes, descartando los originales usados hace
+// más tiempo hasta no pasar max ni dejar el disco debajo de minFree.
+func (c *originalsCache) reserve(n int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		err := c.checkSpace(n)
+		if c.size+n <= c.max && err == nil {
+			c.size += n
+			c.reserved += n
+			return nil
+		}
+		if !c.evictOldest() {
+			if err == nil {
+				err = &codedError{CodeInsufficientStorage, fmt.Errorf("el caché de originales (%d bytes, OCR_TEMP_MAX_BYTES) está ocupado por descargas en curso", c.max)}
+			}
+			return err
+		}
+	}
+}
+
+// release devuelve una reserva de n bytes.
+func (c *originalsCache) release(n int64) {
+	c.mu.Lock()
+	c.size -= n
+	c.reserved -= n
+	c.mu.Unlock()
+}
+
+// evictOldest borra el original usado hace más tiempo; false si no queda
+// ninguno. Se llama con mu tomado.
+func (c *originalsCache) evictOldest() bool {
+	var oldest string
+	for u, e := range c.entries {
+		if oldest == "" || e.used.Before(c.entries[oldest].used) {
+			oldest = u
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	c.remove(oldest)
+	tempCacheTotal.Inc("evicted")
+	return true
+}
+
+// remove saca la URL del caché. Se llama con mu tomado.
+func (c *originalsCache) remove(rawURL string) {
+	e, ok := c.entries[rawURL]
+	if !ok {
+		return
+	}
+	delete(c.entries, rawURL)
+	c.size -= e.size
+	os.Remove(e.path)
+}
+
+// fetch descarga el original o, si no cambió desde la última descarga, lo
+// lee del disco.
+func (c *originalsCache) fetch(ctx context.Context, rawURL string) ([]byte, string, error) {
+	c.mu.Lock()
+	var cached tempEntry
+	if e, ok := c.entries[rawURL]; ok {
+		cached = *e
+	}
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	if cached.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.lastModified)
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached.path != "" {
+		if data, err := os.ReadFile(cached.path); err == nil {
+			c.touch(rawURL, cached.path)
+			tempCacheTotal.Inc("hit")
+			return data, cached.contentType, nil
+		}
+		// Se descartó entre la consulta y la lectura: se descarga entero
+		c.mu.Lock()
+		if e, ok := c.entries[rawURL]; ok && e.path == cached.path {
+			c.remove(rawURL)
+		}
+		c.mu.Unlock()
+		resp.Body.Close()
+		return c.fetch(ctx, rawURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	tempCacheTotal.Inc("miss")
+	return c.store(rawURL, resp)
+}
+
+// store guarda la respuesta en el caché y devuelve el original. Sin ETag
+// ni Last-Modified no se podría revalidar, así que se lee a memoria.
+func (c *originalsCache) store(rawURL string, resp *http.Response) ([]byte, string, error) {
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return readOriginal(resp)
+	}
+	if resp.ContentLength > maxOriginalBytes {
+		return nil, "", fmt.Errorf("original supera %d bytes", maxOriginalBytes)
+	}
+	// Sin Content-Length se reserva el máximo
+	need := resp.ContentLength
+	if need < 0 {
+		need = maxOriginalBytes
+	}
+	if err := c.reserve(need); err != nil {
+		return nil, "", err
+	}
+	defer c.release(need)
+
+	f, err := os.CreateTemp(c.dir, ".download-*")
+	if err != nil {
+		return nil, "", diskError(err)
+	}
+	defer os.Remove(f.Name()) // no existe más si se renombró
+	var buf bytes.Buffer
+	n, err := io.Copy(io.MultiWriter(f, &buf), io.LimitReader(resp.Body, maxOriginalBytes+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, "", diskError(err)
+	}
+	if n > maxOriginalBytes {
+		return nil, "", fmt.Errorf("original supera %d bytes", maxOriginalBytes)
+	}
+	data, contentType := buf.Bytes(), originalType(resp, buf.Bytes())
+
+	sum := sha256.Sum256([]byte(rawURL))
+	e := &tempEntry{
+		path:         filepath.Join(c.dir, hex.EncodeToString(sum[:])+tempFileSuffix),
+		size:         n,
+		contentType:  contentType,
+		etag:         etag,
+		lastModified: lastModified,
+		used:         time.Now(),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(rawURL)
+	if err := os.Rename(f.Name(), e.path); err != nil {
+		slog.Warn("original not cached", "error", err)
+		return data, contentType, nil
+	}
+	c.entries[rawURL] = e
+	c.size += n
+	return data, contentType, nil
+}
+
+// touch marca el original como recién usado.
+func (c *originalsCache) touch(rawURL, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[rawURL]; ok && e.path == path {
+		e.used = time.Now()
+	}
+}
+
+// diskError marca como INSUFFICIENT_STORAGE los errores de disco lleno.
+func diskError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return &codedError{CodeInsufficientStorage, fmt.Errorf("escribiendo el original en OCR_TEMP_DIR: %w", err)}
+	}
+	return err
+}
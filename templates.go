@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TemplateFile es el formato de OCR_TEMPLATES_FILE: plantillas de extracción
+// que el cliente elige con "template". Cada campo se busca en el texto con
+// pattern (el primer grupo es el valor) y se valida y normaliza según su tipo
+// y su locale; el locale del campo reemplaza al de la plantilla.
+//
+//	{
+//	  "templates": {
+//	    "factura_ar": {
+//	      "locale": "es-AR",
+//	      "fields": [
+//	        {"name": "fecha", "type": "date", "pattern": "Fecha:?\\s*(\\S+)", "required": true},
+//	        {"name": "total", "type": "number", "pattern": "Total:?\\s*\\$?\\s*([\\d.,]+)"},
+//	        {"name": "total_usd", "type": "number", "locale": "en-US", "pattern": "USD\\s*([\\d.,]+)"}
+//	      ]
+//	    }
+//	  }
+//	}
+type TemplateFile struct {
+	Templates map[string]*Template `json:"templates"`
+}
+
+type Template struct {
+	Locale string          `json:"locale"`
+	Fields []TemplateField `json:"fields"`
+}
+
+type TemplateField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"` // text (default) | number | date
+	Pattern  string `json:"pattern"`
+	Locale   string `json:"locale,omitempty"` // default: el de la plantilla
+	Required bool   `json:"required,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// ExtractedField es el valor de un campo de la plantilla: el texto capturado
+// y el valor normalizado (número, fecha ISO 8601 o texto), o el motivo por el
+// que no se pudo extraer.
+type ExtractedField struct {
+	Raw    string `json:"raw,omitempty"`
+	Value  any    `json:"value,omitempty"`
+	Locale string `json:"locale,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var fieldTypes = []string{"text", "number", "date"}
+
+// localeFormat es cómo se escriben números y fechas en un mercado.
+type localeFormat struct {
+	decimal     string
+	thousands   string
+	dateFormat  string   // para los mensajes, p. ej. dd/mm/aaaa
+	dateLayouts []string // layouts de time.Parse aceptados
+}
+
+var (
+	dmyLayouts = []string{"02/01/2006", "2/1/2006", "02-01-2006", "2-1-2006", "02.01.2006", "2.1.2006"}
+	mdyLayouts = []string{"01/02/2006", "1/2/2006", "01-02-2006", "1-2-2006"}
+)
+
+var locales = map[string]localeFormat{
+	"es-AR": {decimal: ",", thousands: ".", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"es-CL": {decimal: ",", thousands: ".", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"es-CO": {decimal: ",", thousands: ".", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"es-ES": {decimal: ",", thousands: ".", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"es-MX": {decimal: ".", thousands: ",", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"pt-BR": {decimal: ",", thousands: ".", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"de-DE": {decimal: ",", thousands: ".", dateFormat: "dd.mm.aaaa", dateLayouts: dmyLayouts},
+	"fr-FR": {decimal: ",", thousands: " ", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"en-GB": {decimal: ".", thousands: ",", dateFormat: "dd/mm/aaaa", dateLayouts: dmyLayouts},
+	"en-US": {decimal: ".", thousands: ",", dateFormat: "mm/dd/aaaa", dateLayouts: mdyLayouts},
+}
+
+// templates es la configuración cargada; vacía si no hay OCR_TEMPLATES_FILE.
+var templates = newConfigValue(TemplateFile{})
+
+// loadTemplates lee el archivo de plantillas y valida tipos, locales y
+// expresiones regulares.
+func loadTemplates(path string) (TemplateFile, error) {
+	var tf TemplateFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tf, err
+	}
+	if err := json.Unmarshal(data, &tf); err != nil {
+		return tf, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, t := range tf.Templates {
+		if _, ok := locales[t.Locale]; !ok {
+			return tf, fmt.Errorf("%s: %s: locale %q no soportado (soportados: %s)", path, name, t.Locale, strings.Join(localeNames(), ", "))
+		}
+		if len(t.Fields) == 0 {
+			return tf, fmt.Errorf("%s: %s: no define campos", path, name)
+		}
+		seen := map[string]bool{}
+		for i := range t.Fields {
+			f := &t.Fields[i]
+			where := fmt.Sprintf("%s: %s.fields[%d]", path, name, i)
+			if f.Name == "" || seen[f.Name] {
+				return tf, fmt.Errorf("%s: name vacío o repetido", where)
+			}
+			seen[f.Name] = true
+			if f.Type == "" {
+				f.Type = "text"
+			}
+			if !slices.Contains(fieldTypes, f.Type) {
+				return tf, fmt.Errorf("%s: type debe ser uno de: %s", where, strings.Join(fieldTypes, ", "))
+			}
+			if f.Locale == "" {
+				f.Locale = t.Locale
+			}
+			if _, ok := locales[f.Locale]; !ok {
+				return tf, fmt.Errorf("%s: locale %q no soportado", where, f.Locale)
+			}
+			if f.re, err = regexp.Compile(f.Pattern); err != nil {
+				return tf, fmt.Errorf("%s: pattern: %w", where, err)
+			}
+			if f.re.NumSubexp() < 1 {
+				return tf, fmt.Errorf("%s: pattern debe tener un grupo con el valor", where)
+			}
+		}
+	}
+	return tf, nil
+}
+
+func localeNames() []string {
+	return slices.Sorted(maps.Keys(locales))
+}
+
+// extractFields aplica la plantilla al texto. Los campos opcionales que no
+// aparecen se omiten; los requeridos y los inválidos informan el error.
+func (t *Template) extractFields(text string) map[string]ExtractedField {
+	out := map[string]ExtractedField{}
+	for _, f := range t.Fields {
+		m := f.re.FindStringSubmatch(text)
+		if m == nil {
+			if f.Required {
+				out[f.Name] = ExtractedField{Locale: f.Locale, Error: "no encontrado"}
+			}
+			continue
+		}
+		ef := ExtractedField{Raw: strings.TrimSpace(m[1]), Locale: f.Locale}
+		var err error
+		switch loc := locales[f.Locale]; f.Type {
+		case "number":
+			ef.Value, err = loc.parseNumber(ef.Raw)
+		case "date":
+			ef.Value, err = loc.parseDate(ef.Raw)
+		default:
+			ef.Value = ef.Raw
+		}
+		if err != nil {
+			ef.Value, ef.Error = nil, fmt.Sprintf("%s en %s", err, f.Locale)
+		}
+		out[f.Name] = ef
+	}
+	return out
+}
+
+// parseNumber interpreta un número con los separadores del locale. Los
+// separadores de miles son opcionales pero, si están, deben agrupar de a
+// tres dígitos: así "1.234" es mil doscientos treinta y cuatro en es-AR y
+// uno coma dos en en-US.
+func (l localeFormat) parseNumber(s string) (float64, error) {
+	invalid := fmt.Errorf("%q no es un número válido (decimal %q, miles %q)", s, l.decimal, l.thousands)
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], s[1:]
+	}
+	if l.thousands == " " {
+		// Los PDF suelen traer espacios no separables como separador
+		s = strings.NewReplacer("\u00a0", " ", "\u202f", " ").Replace(s)
+	}
+	intPart, frac, hasFrac := strings.Cut(s, l.decimal)
+	if hasFrac && !isDigits(frac) {
+		return 0, invalid
+	}
+	groups := strings.Split(intPart, l.thousands)
+	for i, g := range groups {
+		if !isDigits(g) || (len(groups) > 1 && (len(g) > 3 || (i > 0 && len(g) != 3))) {
+			return 0, invalid
+		}
+	}
+	n := sign + strings.Join(groups, "")
+	if hasFrac {
+		n += "." + frac
+	}
+	return strconv.ParseFloat(n, 64)
+}
+
+// parseDate interpreta una fecha en el orden del locale y la devuelve en
+// ISO 8601 (aaaa-mm-dd), que también se acepta tal cual.
+func (l localeFormat) parseDate(s string) (string, error) {
+	for _, layout := range append([]string{time.DateOnly}, l.dateLayouts...) {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d.Format(time.DateOnly), nil
+		}
+	}
+	return "", fmt.Errorf("%q no es una fecha válida (%s)", s, l.dateFormat)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// TemplateCatalog es la respuesta de GET /templates.
+type TemplateCatalog struct {
+	Templates map[string]*Template `json:"templates"`
+	Locales   []string             `json:"locales"`
+}
+
+// GET /templates -> plantillas de extracción y locales soportados
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	out := TemplateCatalog{templates.load().Templates, localeNames()}
+	if out.Templates == nil {
+		out.Templates = map[string]*Template{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
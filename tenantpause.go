@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Pausa de tenants desde /admin. Un tenant pausado no puede enviar trabajo:
+// lo que no es una lectura responde 403 FORBIDDEN, y sus jobs en cola se
+// difieren hasta que se reanuda. Las lecturas (resultados, jobs, /usage)
+// siguen respondiendo.
+
+const (
+	redisTenantPausesKey = "ocr:tenant-pauses"
+	// tenantPauseRecheck es cada cuánto se vuelve a probar un job diferido
+	// por la pausa de su tenant.
+	tenantPauseRecheck = 30 * time.Second
+)
+
+// TenantPause es la pausa de un tenant.
+type TenantPause struct {
+	Tenant   string    `json:"tenant"`
+	Reason   string    `json:"reason,omitempty"`
+	PausedAt time.Time `json:"paused_at"`
+	PausedBy string    `json:"paused_by"`
+}
+
+// TenantPauseStore guarda los tenants pausados, compartidos entre réplicas
+// con OCR_QUEUE_URL.
+type TenantPauseStore interface {
+	Pause(ctx context.Context, p TenantPause) error
+	// Resume quita la pausa; false si el tenant no estaba pausado.
+	Resume(ctx context.Context, tenant string) (bool, error)
+	Get(ctx context.Context, tenant string) (TenantPause, bool, error)
+	List(ctx context.Context) ([]TenantPause, error)
+}
+
+var tenantPauses TenantPauseStore = newMemoryTenantPauseStore()
+
+type memoryTenantPauseStore struct {
+	mu     sync.Mutex
+	paused map[string]TenantPause
+}
+
+func newMemoryTenantPauseStore() *memoryTenantPauseStore {
+	return &memoryTenantPauseStore{paused: map[string]TenantPause{}}
+}
+
+func (m *memoryTenantPauseStore) Pause(_ context.Context, p TenantPause) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paused[p.Tenant] = p
+	return nil
+}
+
+func (m *memoryTenantPauseStore) Resume(_ context.Context, tenant string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.paused[tenant]
+	delete(m.paused, tenant)
+	return ok, nil
+}
+
+func (m *memoryTenantPauseStore) Get(_ context.Context, tenant string) (TenantPause, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.paused[tenant]
+	return p, ok, nil
+}
+
+func (m *memoryTenantPauseStore) List(_ context.Context) ([]TenantPause, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]TenantPause, 0, len(m.paused))
+	for _, p := range m.paused {
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// redisTenantPauseStore guarda un hash con un campo JSON por tenant
+// pausado.
+type redisTenantPauseStore struct {
+	rdb *redis.Client
+}
+
+func (r *redisTenantPauseStore) Pause(ctx context.Context, p TenantPause) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return r.rdb.HSet(ctx, redisTenantPausesKey, p.Tenant, data).Err()
+}
+
+func (r *redisTenantPauseStore) Resume(ctx context.Context, tenant string) (bool, error) {
+	n, err := r.rdb.HDel(ctx, redisTenantPausesKey, tenant).Result()
+	return n > 0, err
+}
+
+func (r *redisTenantPauseStore) Get(ctx context.Context, tenant string) (TenantPause, bool, error) {
+	var p TenantPause
+	data, err := r.rdb.HGet(ctx, redisTenantPausesKey, tenant).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return p, false, nil
+	}
+	if err != nil {
+		return p, false, err
+	}
+	return p, true, json.Unmarshal(data, &p)
+}
+
+func (r *redisTenantPauseStore) List(ctx context.Context) ([]TenantPause, error) {
+	fields, err := r.rdb.HGetAll(ctx, redisTenantPausesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]TenantPause, 0, len(fields))
+	for _, data := range fields {
+		var p TenantPause
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// isRead indica si la request solo lee. /ocr/ws es un GET pero procesa
+// documentos.
+func isRead(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return !strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+	}
+	return false
+}
+
+// checkTenantPaused falla con FORBIDDEN si el tenant está pausado.
+func checkTenantPaused(ctx context.Context, tenant string) error {
+	p, paused, err := tenantPauses.Get(ctx, tenant)
+	if err != nil {
+		return &codedError{CodeQueueUnavailable, err}
+	}
+	if !paused {
+		return nil
+	}
+	msg := fmt.Sprintf("el tenant %s está pausado por un operador desde %s", tenant, p.PausedAt.Format(time.RFC3339))
+	if p.Reason != "" {
+		msg += ": " + p.Reason
+	}
+	return &codedError{CodeForbidden, errors.New(msg)}
+}
+
+// pauseReason es el motivo que queda en el historial de los jobs
+// diferidos por la pausa de su tenant.
+const pauseReason = "tenant pausado por un operador"
+
+// deferPausedJob difiere el job de la entrega si su tenant está pausado: lo
+// vuelve a encolar para dentro de tenantPauseRecheck y confirma la entrega.
+// Devuelve false si el job se tiene que procesar.
+func deferPausedJob(ctx context.Context, d Delivery) bool {
+	msg := d.Message()
+	job, ok, err := jobStore.Get(ctx, msg.JobID)
+	if err != nil || !ok || job.finished() {
+		return false // processJob resuelve cada caso
+	}
+	_, paused, err := tenantPauses.Get(ctx, job.tenant())
+	if err != nil {
+		slog.Error("tenant pause check failed", "job_id", msg.JobID, "error", err)
+		d.Nack(ctx)
+		return true
+	}
+	if !paused {
+		return false
+	}
+	at := time.Now().UTC().Add(tenantPauseRecheck)
+	_, err = jobStore.Update(ctx, msg.JobID, func(j *Job) error {
+		if j.finished() {
+			return nil
+		}
+		j.ScheduledFor = &at
+		if last := len(j.History) - 1; last < 0 || j.History[last].Reason != pauseReason {
+			j.setStatus(jobQueued, pauseReason)
+		}
+		return nil
+	})
+	if err == nil {
+		err = jobQueue.EnqueueAt(ctx, msg, at)
+	}
+	if err != nil {
+		slog.Error("paused tenant job not deferred", "job_id", msg.JobID, "error", err)
+		d.Nack(ctx)
+		return true
+	}
+	d.Ack(ctx)
+	return true
+}
+
+// PauseTenantRequest es el body opcional de POST /admin/tenants/{id}/pause.
+type PauseTenantRequest struct {
+	Reason string `json:"reason"`
+}
+
+// adminTenant devuelve el tenant de la URL o escribe el problem. Con
+// OCR_TENANTS_FILE tiene que ser uno de los configurados o default.
+func adminTenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if !tenantIDPattern.MatchString(id) {
+		writeProblem(w, r, newProblem(CodeInvalidInput, fmt.Sprintf("El id del tenant debe cumplir %s", tenantIDPattern)))
+		return "", false
+	}
+	if tr := tenants.load(); tr.byID != nil && id != defaultTenant && tr.byID[id].ID == "" {
+		writeProblem(w, r, newProblem(CodeNotFound, "No existe el tenant "+id))
+		return "", false
+	}
+	return id, true
+}
+
+// GET /admin/tenants/paused -> tenants pausados
+func handleAdminPausedTenants(w http.ResponseWriter, r *http.Request) {
+	list, err := tenantPauses.List(r.Context())
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	slices.SortFunc(list, func(a, b TenantPause) int { return strings.Compare(a.Tenant, b.Tenant) })
+	writeJSON(w, http.StatusOK, map[string]any{"tenants": list})
+}
+
+// POST /admin/tenants/{id}/pause -> pausa el tenant en todas las réplicas
+func handleAdminPauseTenant(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var in PauseTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && !errors.Is(err, io.EOF) {
+		writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+		return
+	}
+	p := TenantPause{Tenant: id, Reason: in.Reason, PausedAt: time.Now().UTC(), PausedBy: adminActor(r)}
+	if err := tenantPauses.Pause(r.Context(), p); err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	auditAdmin(r, "tenant.pause", fmt.Sprintf("tenant=%s reason=%q", id, in.Reason))
+	writeJSON(w, http.StatusOK, p)
+}
+
+// POST /admin/tenants/{id}/resume -> quita la pausa; los jobs diferidos se
+// procesan en la siguiente revisión
+func handleAdminResumeTenant(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminTenant(w, r)
+	if !ok {
+		return
+	}
+	resumed, err := tenantPauses.Resume(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	if !resumed {
+		writeProblem(w, r, newProblem(CodeConflict, "El tenant "+id+" no está pausado"))
+		return
+	}
+	auditAdmin(r, "tenant.resume", "tenant="+id)
+	writeJSON(w, http.StatusOK, map[string]any{"tenant": id, "paused": false})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Multi-tenancy: cada request pertenece a un tenant, identificado por su
+// API key (X-API-Key) o por X-Tenant-ID; sin ninguno de los dos es el
+// tenant default. El tenant acota la cuota diaria y la concurrencia, aísla
+// jobs y resultados y agrupa el uso que reporta /usage.
+
+const defaultTenant = "default"
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Tenant es la configuración de un tenant en OCR_TENANTS_FILE.
+type Tenant struct {
+	ID      string   `json:"id"`
+	APIKeys []string `json:"api_keys,omitempty"`
+	// AdminAPIKeys identifican al tenant como las api_keys y además
+	// permiten administrar su configuración (wordlists).
+	AdminAPIKeys   []string `json:"admin_api_keys,omitempty"`
+	DailyDocuments int      `json:"daily_documents,omitempty"` // 0 = sin cuota
+	MaxConcurrent  int      `json:"max_concurrent,omitempty"`  // por réplica; 0 = sin límite
+	// MinConfidence rechaza con REJECTED_LOW_CONFIDENCE los resultados de
+	// menor confianza; 0 no rechaza ninguno.
+	MinConfidence float64 `json:"min_confidence,omitempty"`
+	// ResponseMetadata agrega el bloque meta a sus respuestas JSON.
+	ResponseMetadata bool `json:"response_metadata,omitempty"`
+}
+
+// TenantFile es el formato de OCR_TENANTS_FILE.
+type TenantFile struct {
+	Tenants []Tenant `json:"tenants"`
+}
+
+// tenantRegistry resuelve el tenant de cada request. Sin OCR_TENANTS_FILE
+// acepta cualquier X-Tenant-ID válido, sin límites.
+type tenantRegistry struct {
+	byID  map[string]Tenant
+	byKey map[[32]byte]tenantAPIKey // sha256 de la API key
+}
+
+type tenantAPIKey struct {
+	tenant string
+	admin  bool
+}
+
+var tenants = newConfigValue(&tenantRegistry{})
+
+func loadTenants(path string) (*tenantRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file TenantFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	tr := &tenantRegistry{byID: map[string]Tenant{}, byKey: map[[32]byte]tenantAPIKey{}}
+	for i, t := range file.Tenants {
+		switch {
+		case !tenantIDPattern.MatchString(t.ID):
+			return nil, fmt.Errorf("%s: tenants[%d]: id debe cumplir %s", path, i, tenantIDPattern)
+		case tr.byID[t.ID].ID != "":
+			return nil, fmt.Errorf("%s: tenant %s repetido", path, t.ID)
+		case t.DailyDocuments < 0 || t.MaxConcurrent < 0:
+			return nil, fmt.Errorf("%s: tenant %s: los límites no pueden ser negativos", path, t.ID)
+		case t.MinConfidence < 0 || t.MinConfidence > 1:
+			return nil, fmt.Errorf("%s: tenant %s: min_confidence debe estar entre 0 y 1", path, t.ID)
+		}
+		for i, key := range slices.Concat(t.APIKeys, t.AdminAPIKeys) {
+			sum := sha256.Sum256([]byte(key))
+			if _, dup := tr.byKey[sum]; key == "" || dup {
+				return nil, fmt.Errorf("%s: tenant %s: API key vacía o repetida", path, t.ID)
+			}
+			tr.byKey[sum] = tenantAPIKey{tenant: t.ID, admin: i >= len(t.APIKeys)}
+		}
+		tr.byID[t.ID] = t
+	}
+	return tr, nil
+}
+
+// get devuelve la configuración del tenant; uno no configurado no tiene
+// límites.
+func (tr *tenantRegistry) get(id string) Tenant {
+	if t, ok := tr.byID[id]; ok {
+		return t
+	}
+	return Tenant{ID: id}
+}
+
+// keyed indica si el tenant requiere API key.
+func (t Tenant) keyed() bool {
+	return len(t.APIKeys) > 0 || len(t.AdminAPIKeys) > 0
+}
+
+// resolve identifica el tenant de la request y si la request lo puede
+// administrar: con una admin_api_key o, en un tenant sin keys, siempre. Un
+// tenant con keys solo se puede usar con una de ellas, incluido default si
+// está configurado.
+func (tr *tenantRegistry) resolve(r *http.Request) (id string, admin bool, err error) {
+	header := r.Header.Get("X-Tenant-ID")
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		k, ok := tr.byKey[sha256.Sum256([]byte(key))]
+		if !ok {
+			return "", false, errors.New("API key inválida")
+		}
+		if header != "" && header != k.tenant {
+			return "", false, errors.New("X-Tenant-ID no corresponde a la API key")
+		}
+		return k.tenant, k.admin, nil
+	}
+
+	id = header
+	if id == "" {
+		id = defaultTenant
+	}
+	if !tenantIDPattern.MatchString(id) {
+		return "", false, fmt.Errorf("X-Tenant-ID inválido: debe cumplir %s", tenantIDPattern)
+	}
+	if tr.byID == nil {
+		return id, true, nil
+	}
+	t, ok := tr.byID[id]
+	switch {
+	case !ok && id != defaultTenant:
+		return "", false, fmt.Errorf("tenant desconocido: %s", id)
+	case t.keyed():
+		return "", false, fmt.Errorf("el tenant %s requiere X-API-Key", id)
+	}
+	return id, true, nil
+}
+
+type tenantKey struct{}
+
+type tenantAdminKey struct{}
+
+func withTenant(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// tenantFrom devuelve el tenant del contexto o default.
+func tenantFrom(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantKey{}).(string); ok {
+		return id
+	}
+	return defaultTenant
+}
+
+// identifyTenant resuelve el tenant y lo deja en el contexto de la request.
+func identifyTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, admin, err := tenants.load().resolve(r)
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeUnauthorized, err.Error()))
+			return
+		}
+		addLogAttrs(r.Context(), slog.String("tenant", id))
+		if !isRead(r) {
+			if err := checkTenantPaused(r.Context(), id); err != nil {
+				writeProblem(w, r, newProblem(errorCodeOf(err, CodeInternal), err.Error()))
+				return
+			}
+		}
+		ctx := context.WithValue(withTenant(r.Context(), id), tenantAdminKey{}, admin)
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			ctx = withAPIKeyID(ctx, apiKeyID(key))
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireTenantAdmin rechaza con 403 FORBIDDEN las requests que no pueden
+// administrar su tenant.
+func requireTenantAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if admin, _ := r.Context().Value(tenantAdminKey{}).(bool); !admin {
+			writeProblem(w, r, newProblem(CodeForbidden, "Se requiere una admin_api_key del tenant"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkQuota falla con QUOTA_EXCEEDED si n documentos más superan la cuota
+// diaria del tenant. Se verifica al recibir el request, así que los
+// documentos que ya están en proceso pueden pasarla por poco.
+func checkQuota(ctx context.Context, n int) error {
+	t := tenants.load().get(tenantFrom(ctx))
+	if t.DailyDocuments == 0 {
+		return nil
+	}
+	used, err := usageStore.Documents(ctx, t.ID, usageDay(time.Now()))
+	if err != nil {
+		return &codedError{CodeQueueUnavailable, err}
+	}
+	if used+n > t.DailyDocuments {
+		return &codedError{CodeQuotaExceeded, fmt.Errorf("el tenant %s procesó %d de %d documentos hoy y el request agrega %d", t.ID, used, t.DailyDocuments, n)}
+	}
+	return nil
+}
+
+// tenant devuelve el tenant del job; los creados antes de multi-tenancy son
+// de default.
+func (j Job) tenant() string {
+	if j.Tenant == "" {
+		return defaultTenant
+	}
+	return j.Tenant
+}
+
+// tenantJob lee un job del tenant del contexto; los de otros tenants se
+// tratan como inexistentes.
+func tenantJob(ctx context.Context, id string) (Job, bool, error) {
+	job, ok, err := jobStore.Get(ctx, id)
+	if err != nil || !ok || job.tenant() != tenantFrom(ctx) {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// tenantBatchJobs es BatchJobs limitado al tenant del contexto.
+func tenantBatchJobs(ctx context.Context, batchID string) ([]Job, error) {
+	jobs, err := jobStore.BatchJobs(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	tenant := tenantFrom(ctx)
+	return slices.DeleteFunc(jobs, func(j Job) bool { return j.tenant() != tenant }), nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Límites de tiempo de un request y cómo se informan. Cada límite cancela el
+// contexto con un timeoutError como causa, así la respuesta 408 puede decir
+// cuál se alcanzó, cuánto tiempo había pasado y en qué etapa estaba el
+// procesamiento.
+
+const (
+	limitClient = "client" // X-Request-Timeout del cliente
+	limitRoute  = "route"  // OCR_ROUTE_TIMEOUT
+	limitEngine = "engine" // OCR_ENGINE_TIMEOUT, por llamada al motor
+	limitJob    = "job"    // OCR_JOB_TIMEOUT, por intento de un job
+)
+
+// Etapas del procesamiento de un ítem.
+const (
+	stageQueue    = "queue"
+	stageFetch    = "fetch"
+	stageEngine   = "engine"
+	stageBarcodes = "barcodes"
+	stageTables   = "tables"
+	stageArchive  = "archive"
+	// stageSignatures descarga el PDF y verifica sus firmas.
+	stageSignatures = "signatures"
+	// stageProcessing es un job de un batch en proceso en otra réplica o
+	// worker, del que no se conoce la etapa.
+	stageProcessing = "processing"
+)
+
+// TimeoutInfo explica un timeout: el límite alcanzado y su valor, el tiempo
+// transcurrido desde el inicio del request o job y la etapa en curso.
+type TimeoutInfo struct {
+	Limit     string `json:"limit"`
+	LimitMs   int64  `json:"limit_ms"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+	Stage     string `json:"stage,omitempty"`
+}
+
+func (t *TimeoutInfo) String() string {
+	s := fmt.Sprintf("%s; limit_ms=%d; elapsed_ms=%d", t.Limit, t.LimitMs, t.ElapsedMs)
+	if t.Stage != "" {
+		s += "; stage=" + t.Stage
+	}
+	return s
+}
+
+// Detail describe el timeout para el detail de la respuesta.
+func (t *TimeoutInfo) Detail() string {
+	names := map[string]string{
+		limitClient: "del cliente (X-Request-Timeout)",
+		limitRoute:  "de la ruta (OCR_ROUTE_TIMEOUT)",
+		limitEngine: "del motor (OCR_ENGINE_TIMEOUT)",
+		limitJob:    "del job (OCR_JOB_TIMEOUT)",
+	}
+	d := fmt.Sprintf("Se alcanzó el timeout %s de %s tras %s", names[t.Limit],
+		time.Duration(t.LimitMs)*time.Millisecond, time.Duration(t.ElapsedMs)*time.Millisecond)
+	if t.Stage != "" {
+		d += ", en la etapa " + t.Stage
+	}
+	return d
+}
+
+// timeoutError es la causa de un contexto cancelado por un límite.
+type timeoutError struct {
+	limit   string
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timeout %s de %s", e.limit, e.timeout)
+}
+
+// Is permite tratarlo como context.DeadlineExceeded, p. ej. en errorCodeOf.
+func (e *timeoutError) Is(target error) bool { return target == context.DeadlineExceeded }
+
+func withTimeoutLimit(ctx context.Context, limit string, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(ctx, d, &timeoutError{limit: limit, timeout: d})
+}
+
+// stageTracker registra el inicio del request o job y la etapa en curso.
+type stageTracker struct {
+	start time.Time
+
+	mu    sync.Mutex
+	stage string
+}
+
+type stageKey struct{}
+
+func withStageTracker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stageKey{}, &stageTracker{start: time.Now()})
+}
+
+// setStage marca la etapa en curso, si el contexto lleva un tracker.
+func setStage(ctx context.Context, stage string) {
+	if st, ok := ctx.Value(stageKey{}).(*stageTracker); ok {
+		st.mu.Lock()
+		st.stage = stage
+		st.mu.Unlock()
+	}
+}
+
+// timeoutInfo explica err si se debe a un límite, propio o del contexto.
+// stage reemplaza la etapa registrada si no es vacía.
+func timeoutInfo(ctx context.Context, err error, stage string) *TimeoutInfo {
+	var te *timeoutError
+	if !errors.As(err, &te) && !errors.As(context.Cause(ctx), &te) {
+		return nil
+	}
+	info := &TimeoutInfo{Limit: te.limit, LimitMs: te.timeout.Milliseconds(), Stage: stage}
+	if st, ok := ctx.Value(stageKey{}).(*stageTracker); ok {
+		info.ElapsedMs = time.Since(st.start).Milliseconds()
+		st.mu.Lock()
+		if info.Stage == "" {
+			info.Stage = st.stage
+		}
+		st.mu.Unlock()
+	}
+	return info
+}
+
+// recognizeWithTimeout llama al motor acotado por el timeout de los
+// motores. Si vence ese límite devuelve su causa en lugar de
+// context.DeadlineExceeded.
+func recognizeWithTimeout(ctx context.Context, e OCREngine, p Page) (Recognition, error) {
+	timeout := activeEngineSet.load().timeout
+	if timeout <= 0 {
+		return e.Recognize(ctx, p)
+	}
+	ectx, cancel := withTimeoutLimit(ctx, limitEngine, timeout)
+	defer cancel()
+	rec, err := e.Recognize(ectx, p)
+	if err != nil && ctx.Err() == nil && ectx.Err() != nil {
+		return rec, context.Cause(ectx)
+	}
+	return rec, err
+}
+
+// requestTimeout reemplaza a middleware.Timeout: acota el request por
+// route o por X-Request-Timeout si el cliente pide menos, y registra el
+// inicio para informar el tiempo transcurrido.
+func requestTimeout(route time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, d := limitRoute, route
+			if v := r.Header.Get("X-Request-Timeout"); v != "" {
+				client, err := parseClientTimeout(v)
+				if err != nil {
+					p := newProblem(CodeInvalidInput, "Header X-Request-Timeout inválido")
+					p.InvalidParams = []InvalidParam{{Name: "X-Request-Timeout", Reason: err.Error()}}
+					writeProblem(w, r, p)
+					return
+				}
+				if client < d {
+					limit, d = limitClient, client
+				}
+			}
+			ctx, cancel := withTimeoutLimit(withStageTracker(r.Context()), limit, d)
+			defer cancel()
+			ctx = context.WithValue(ctx, requestTimeoutKey{}, d)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+type requestTimeoutKey struct{}
+
+// requestTimeoutFrom devuelve el timeout que requestTimeout le puso al
+// request, o 0 si no pasó por él.
+func requestTimeoutFrom(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(requestTimeoutKey{}).(time.Duration)
+	return d
+}
+
+// parseClientTimeout acepta una duración ("2500ms", "5s") o segundos.
+func parseClientTimeout(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, serr := strconv.ParseFloat(v, 64)
+		if serr != nil {
+			return 0, errors.New("debe ser una duración (5s, 2500ms) o segundos")
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, errors.New("debe ser positivo")
+	}
+	return d, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS nativo del servidor, para desplegarlo sin un proxy delante: con
+// certificado y clave en disco o con certificados de Let's Encrypt
+// (autocert), y opcionalmente verificando certificados de cliente (mTLS).
+
+// tlsFiles sirve el certificado y las CAs de cliente leídos de disco; nil
+// sin TLS.
+var tlsFiles *certReloader
+
+var _ = newGaugeFunc("ocr_tls_cert_expiry_timestamp_seconds", "Vencimiento del certificado TLS leído de disco (Unix).",
+	nil, func(emit func(float64, ...string)) {
+		if tlsFiles == nil {
+			return
+		}
+		if cert := tlsFiles.cert.Load(); cert != nil && cert.Leaf != nil {
+			emit(float64(cert.Leaf.NotAfter.Unix()))
+		}
+	})
+
+// certReloader guarda el certificado y las CAs de cliente vigentes. Con
+// SIGHUP los vuelve a leer sin cortar las conexiones abiertas; si la lectura
+// falla sigue usando los anteriores.
+type certReloader struct {
+	cfg       TLSConfig
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+}
+
+func (cr *certReloader) reload() error {
+	var cert *tls.Certificate
+	if cr.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(cr.cfg.CertFile, cr.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if cr.cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cr.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s: no contiene certificados PEM", cr.cfg.ClientCAFile)
+		}
+	}
+	if cert != nil {
+		cr.cert.Store(cert)
+	}
+	if pool != nil {
+		cr.clientCAs.Store(pool)
+	}
+	return nil
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
+
+// watchReload recarga los archivos con cada SIGHUP.
+func (cr *certReloader) watchReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := cr.reload(); err != nil {
+			slog.Error("TLS certificates not reloaded", "error", err)
+			continue
+		}
+		attrs := []any{}
+		if cert := cr.cert.Load(); cert != nil && cert.Leaf != nil {
+			attrs = append(attrs, "not_after", cert.Leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+		slog.Info("TLS certificates reloaded", attrs...)
+	}
+}
+
+// newTLSConfig arma la configuración TLS del servidor y empieza a atender
+// SIGHUP.
+func newTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	cr := &certReloader{cfg: cfg}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{GetCertificate: cr.getCertificate}
+	if len(cfg.AutocertDomains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		base = m.TLSConfig()
+	}
+	base.MinVersion = tls.VersionTLS12
+
+	if cfg.ClientCAFile != "" {
+		clientAuth := tls.RequireAndVerifyClientCert
+		if cfg.ClientAuth == "optional" {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+		base.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			// El desafío tls-alpn-01 de Let's Encrypt no presenta certificado
+			if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+				return nil, nil
+			}
+			c := base.Clone()
+			c.ClientAuth = clientAuth
+			c.ClientCAs = cr.clientCAs.Load()
+			return c, nil
+		}
+	}
+
+	tlsFiles = cr
+	go cr.watchReload()
+	return base, nil
+}
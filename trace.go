@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TraceEvent es un paso del procesamiento de un ítem, con el motor y la
+// versión que intervinieron cuando corresponde.
+type TraceEvent struct {
+	Time          time.Time `json:"time"`
+	Stage         string    `json:"stage"`
+	Page          int       `json:"page,omitempty"`
+	Engine        string    `json:"engine,omitempty"`
+	EngineVersion string    `json:"engine_version,omitempty"`
+	Confidence    float64   `json:"confidence,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+}
+
+// processingTrace acumula los eventos de un procesamiento; las páginas se
+// reconocen en paralelo, así que el registro es concurrente.
+type processingTrace struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+type traceKey struct{}
+
+// withTrace devuelve un contexto que registra los eventos de traceEvent.
+func withTrace(ctx context.Context) (context.Context, *processingTrace) {
+	t := &processingTrace{}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// traceEvent registra ev si el contexto lleva una traza; si no, no hace nada.
+func traceEvent(ctx context.Context, ev TraceEvent) {
+	t, ok := ctx.Value(traceKey{}).(*processingTrace)
+	if !ok {
+		return
+	}
+	ev.Time = time.Now().UTC()
+	t.mu.Lock()
+	t.events = append(t.events, ev)
+	t.mu.Unlock()
+}
+
+func (t *processingTrace) Events() []TraceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceEvent(nil), t.events...)
+}
+
+// engineEvent arma el evento de una llamada a un motor.
+func engineEvent(stage string, page int, e OCREngine, rec Recognition) TraceEvent {
+	return TraceEvent{
+		Stage:         stage,
+		Page:          page,
+		Engine:        e.Name(),
+		EngineVersion: e.Version(),
+		Confidence:    rec.Confidence,
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Uso por tenant y día (UTC): documentos procesados y cuántos fallaron. Lo
+// registra el pool al terminar cada ítem y lo usan las cuotas y /usage.
+
+// usageRetention es cuánto se conserva el uso de cada día.
+const usageRetention = 90 * 24 * time.Hour
+
+const redisUsagePrefix = "ocr:usage:"
+
+// TenantUsage es el uso de un tenant en un día.
+type TenantUsage struct {
+	Tenant    string `json:"tenant"`
+	Date      string `json:"date"`
+	Documents int    `json:"documents"`
+	Failed    int    `json:"failed"`
+}
+
+// UsageStore acumula el uso, compartido entre réplicas cuando el backend lo
+// permite.
+type UsageStore interface {
+	Add(ctx context.Context, tenant, day string, documents, failed int) error
+	Documents(ctx context.Context, tenant, day string) (int, error)
+	// Days devuelve el uso de todos los tenants en esos días.
+	Days(ctx context.Context, days []string) ([]TenantUsage, error)
+}
+
+var usageStore UsageStore
+
+func usageDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// recordUsage registra un ítem procesado por el pool.
+func recordUsage(tenant string, resp *APIResponse) {
+	failed := 0
+	if resp == nil || resp.ErrorCode != "" {
+		failed = 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := usageStore.Add(ctx, tenant, usageDay(time.Now()), 1, failed); err != nil {
+		slog.Error("usage not recorded", "tenant", tenant, "error", err)
+	}
+}
+
+// memoryUsageStore guarda el uso en memoria y descarta los días vencidos.
+type memoryUsageStore struct {
+	mu   sync.Mutex
+	days map[string]map[string]*TenantUsage
+}
+
+func newMemoryUsageStore() *memoryUsageStore {
+	return &memoryUsageStore{days: map[string]map[string]*TenantUsage{}}
+}
+
+func (s *memoryUsageStore) Add(_ context.Context, tenant, day string, documents, failed int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.days[day] == nil {
+		s.days[day] = map[string]*TenantUsage{}
+		oldest := usageDay(time.Now().Add(-usageRetention))
+		for d := range s.days {
+			if d < oldest {
+				delete(s.days, d)
+			}
+		}
+	}
+	u := s.days[day][tenant]
+	if u == nil {
+		u = &TenantUsage{Tenant: tenant, Date: day}
+		s.days[day][tenant] = u
+	}
+	u.Documents += documents
+	u.Failed += failed
+	return nil
+}
+
+func (s *memoryUsageStore) Documents(_ context.Context, tenant, day string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u := s.days[day][tenant]; u != nil {
+		return u.Documents, nil
+	}
+	return 0, nil
+}
+
+func (s *memoryUsageStore) Days(_ context.Context, days []string) ([]TenantUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []TenantUsage
+	for _, day := range days {
+		for _, u := range s.days[day] {
+			out = append(out, *u)
+		}
+	}
+	return out, nil
+}
+
+// redisUsageStore guarda un hash por día con los campos {tenant} y
+// {tenant}:failed.
+type redisUsageStore struct {
+	rdb *redis.Client
+}
+
+func (s *redisUsageStore) Add(ctx context.Context, tenant, day string, documents, failed int) error {
+	key := redisUsagePrefix + day
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, key, tenant, int64(documents))
+		pipe.HIncrBy(ctx, key, tenant+":failed", int64(failed))
+		pipe.Expire(ctx, key, usageRetention)
+		return nil
+	})
+	return err
+}
+
+func (s *redisUsageStore) Documents(ctx context.Context, tenant, day string) (int, error) {
+	n, err := s.rdb.HGet(ctx, redisUsagePrefix+day, tenant).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *redisUsageStore) Days(ctx context.Context, days []string) ([]TenantUsage, error) {
+	var out []TenantUsage
+	for _, day := range days {
+		fields, err := s.rdb.HGetAll(ctx, redisUsagePrefix+day).Result()
+		if err != nil {
+			return nil, err
+		}
+		for field, v := range fields {
+			tenant, isFailed := strings.CutSuffix(field, ":failed")
+			if isFailed {
+				continue
+			}
+			u := TenantUsage{Tenant: tenant, Date: day}
+			u.Documents, _ = strconv.Atoi(v)
+			u.Failed, _ = strconv.Atoi(fields[tenant+":failed"])
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+// UsageReport es la respuesta de /usage.
+type UsageReport struct {
+	From  string        `json:"from"`
+	To    string        `json:"to"`
+	Usage []TenantUsage `json:"usage"`
+}
+
+// usageDays lee ?from= y ?to= (YYYY-MM-DD, inclusive). Por defecto son los
+// últimos 30 días.
+func usageDays(r *http.Request) ([]string, *Problem) {
+	return dayRange(r, usageRetention)
+}
+
+// dayRange es usageDays con un rango de hasta retention.
+func dayRange(r *http.Request, retention time.Duration) ([]string, *Problem) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -29)
+	var invalid []InvalidParam
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			invalid = append(invalid, InvalidParam{Name: p.name, Reason: "debe ser una fecha YYYY-MM-DD"})
+			continue
+		}
+		*p.dst = t
+	}
+	maxDays := int(retention / (24 * time.Hour))
+	if len(invalid) == 0 && (to.Before(from) || to.Sub(from) >= retention) {
+		invalid = append(invalid, InvalidParam{Name: "from", Reason: fmt.Sprintf("el rango debe ser de 1 a %d días", maxDays)})
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "Parámetros de fecha inválidos")
+		p.InvalidParams = invalid
+		return nil, &p
+	}
+	var days []string
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, usageDay(d))
+	}
+	return days, nil
+}
+
+// writeUsage responde el uso de los días pedidos, filtrado por tenant si
+// tenant no es vacío.
+func writeUsage(w http.ResponseWriter, r *http.Request, tenant string) {
+	days, problem := usageDays(r)
+	if problem != nil {
+		writeProblem(w, r, *problem)
+		return
+	}
+	usage, err := usageStore.Days(r.Context(), days)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	out := UsageReport{From: days[0], To: days[len(days)-1], Usage: []TenantUsage{}}
+	for _, u := range usage {
+		if tenant == "" || u.Tenant == tenant {
+			out.Usage = append(out.Usage, u)
+		}
+	}
+	sort.Slice(out.Usage, func(i, j int) bool {
+		a, b := out.Usage[i], out.Usage[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return a.Tenant < b.Tenant
+	})
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /usage -> documentos procesados por día del tenant de la request
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	writeUsage(w, r, tenantFrom(r.Context()))
+}
+
+// GET /admin/usage -> documentos procesados por tenant y día; ?tenant= filtra
+func handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	writeUsage(w, r, r.URL.Query().Get("tenant"))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// validateInput rechaza requests de OCR que exceden los límites configurados
+// antes de que lleguen al handler: tamaño del body, cantidad de ítems del
+// batch, largo de las URLs, esquemas permitidos y valores de las opciones.
+// También aplica los defaults y presets del servidor, así que el handler
+// recibe el request ya resuelto.
+func validateInput(limits LimitsConfig) func(http.Handler) http.Handler {
+	return validateRequest(limits, false)
+}
+
+// validateBatchInput es validateInput para batches: además del envelope
+// JSON acepta el body en CSV o JSONL, que convierte antes de validar.
+func validateBatchInput(limits LimitsConfig) func(http.Handler) http.Handler {
+	return validateRequest(limits, true)
+}
+
+func validateRequest(limits LimitsConfig, batch bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					writeProblem(w, r, newProblem(CodePayloadTooLarge,
+						fmt.Sprintf("El body supera el máximo de %d bytes", limits.MaxBodyBytes)))
+					return
+				}
+				writeProblem(w, r, newProblem(CodeInvalidInput, "No se pudo leer el body"))
+				return
+			}
+
+			body, err = batchEnvelope(r.Header.Get("Content-Type"), body, batch)
+			if err != nil {
+				writeProblem(w, r, newProblem(CodeInvalidInput, err.Error()))
+				return
+			}
+
+			body, invalid, err := presets.load().resolvePresets(body)
+			if err != nil {
+				writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+				return
+			}
+			if len(invalid) > 0 {
+				p := newProblem(CodeInvalidInput, "La request contiene campos inválidos")
+				p.InvalidParams = invalid
+				writeProblem(w, r, p)
+				return
+			}
+
+			var in struct {
+				OCRRequest
+				Items       []OCRRequest `json:"items"`
+				Deduplicate *bool        `json:"deduplicate"`
+			}
+			if err := json.Unmarshal(body, &in); err != nil {
+				writeProblem(w, r, newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+				return
+			}
+
+			if len(in.Items) > limits.MaxBatchItems {
+				writeProblem(w, r, newProblem(CodePayloadTooLarge,
+					fmt.Sprintf("El batch tiene %d ítems; el máximo es %d", len(in.Items), limits.MaxBatchItems)))
+				return
+			}
+
+			invalid = in.OCRRequest.validate("", limits)
+			for i, item := range in.Items {
+				invalid = append(invalid, item.validate(fmt.Sprintf("items[%d].", i), limits)...)
+			}
+			if len(invalid) > 0 {
+				p := newProblem(CodeInvalidInput, "La request contiene campos inválidos")
+				p.InvalidParams = invalid
+				writeProblem(w, r, p)
+				return
+			}
+
+			// on_conflict=reject: la key ocupada no se procesa
+			var conflicts []InvalidParam
+			if p := conflictParam(r.Context(), in.OCRRequest, ""); p != nil {
+				conflicts = append(conflicts, *p)
+			}
+			for i, item := range in.Items {
+				if p := conflictParam(r.Context(), item, fmt.Sprintf("items[%d].", i)); p != nil {
+					conflicts = append(conflicts, *p)
+				}
+			}
+			if len(conflicts) > 0 {
+				p := newProblem(CodeConflict, "Ya hay resultados guardados para esas keys")
+				p.InvalidParams = conflicts
+				writeProblem(w, r, p)
+				return
+			}
+
+			batchReq := BatchOCRRequest{Items: in.Items, Deduplicate: in.Deduplicate}
+			if err := checkQuota(r.Context(), max(1, uniqueItems(batchReq.Items, batchReq.dedup()))); err != nil {
+				writeProblem(w, r, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+
+// requestConfig es la configuración contra la que se valida un request: la
+// vigente o, al recargar, la nueva antes de aplicarla.
+type requestConfig struct {
+	templates TemplateFile
+	engines   engineSet
+}
+
+// currentRequestConfig devuelve las plantillas y los motores vigentes.
+func currentRequestConfig() requestConfig {
+	return requestConfig{templates: templates.load(), engines: activeEngineSet.load()}
+}
+
+// validate revisa la URL y las opciones del request. prefix antepone la
+// ruta del ítem dentro del batch a los nombres de campo.
+func (req OCRRequest) validate(prefix string, limits LimitsConfig) []InvalidParam {
+	return req.validateWith(prefix, limits, currentRequestConfig())
+}
+
+// validateWith es validate contra las plantillas y los motores de rc.
+func (req OCRRequest) validateWith(prefix string, limits LimitsConfig, rc requestConfig) []InvalidParam {
+	var invalid []InvalidParam
+	if reason := checkURL(req.URL, limits); reason != "" {
+		invalid = append(invalid, InvalidParam{Name: prefix + "url", Reason: reason})
+	}
+	if req.HeadersFooters != "" && req.HeadersFooters != headersFootersKeep && req.HeadersFooters != headersFootersStrip {
+		invalid = append(invalid, InvalidParam{Name: prefix + "headers_footers", Reason: "debe ser keep o strip"})
+	}
+	if req.Engine != "" && engines[req.Engine] == nil {
+		invalid = append(invalid, InvalidParam{Name: prefix + "engine", Reason: "debe ser uno de: " + strings.Join(engineNames(), ", ")})
+	}
+	if req.Language != "" {
+		if !languagePattern.MatchString(req.Language) {
+			invalid = append(invalid, InvalidParam{Name: prefix + "language", Reason: "debe ser un código ISO 639-1, p. ej. es"})
+		} else if e := rc.engines.engineFor(req.Engine); engineLanguages(e) != nil && !slices.Contains(engineLanguages(e), req.Language) {
+			invalid = append(invalid, InvalidParam{
+				Name:   prefix + "language",
+				Reason: fmt.Sprintf("el motor %s no reconoce %q (reconoce: %s)", e.Name(), req.Language, strings.Join(engineLanguages(e), ", ")),
+			})
+		}
+	}
+	if req.Mode != "" && !slices.Contains(recognitionModes, req.Mode) {
+		invalid = append(invalid, InvalidParam{Name: prefix + "mode", Reason: "debe ser printed, handwritten o mixed"})
+	} else if req.Mode == modeHandwritten || req.Mode == modeMixed {
+		if e := rc.engines.engineFor(req.Engine); req.Engine != "" && !engineHandwriting(e) {
+			invalid = append(invalid, InvalidParam{Name: prefix + "mode", Reason: fmt.Sprintf("el motor %s no reconoce manuscritos", e.Name())})
+		} else if len(rc.engines.handwritingChain(rc.engines.chain(e), req.Engine == "")) == 0 {
+			invalid = append(invalid, InvalidParam{Name: prefix + "mode", Reason: "ningún motor configurado reconoce manuscritos"})
+		}
+	}
+	if req.Template != "" && rc.templates.Templates[req.Template] == nil {
+		invalid = append(invalid, InvalidParam{Name: prefix + "template", Reason: fmt.Sprintf("plantilla %q inexistente", req.Template)})
+	}
+	if req.Priority != "" && !slices.Contains(priorities, req.Priority) {
+		invalid = append(invalid, InvalidParam{Name: prefix + "priority", Reason: "debe ser high, normal o low"})
+	}
+	if req.ProcessingClass != "" && !slices.Contains(processingClasses, req.ProcessingClass) {
+		invalid = append(invalid, InvalidParam{Name: prefix + "processing_class", Reason: "debe ser standard o economy"})
+	}
+	if req.OnConflict != "" && !slices.Contains(conflictModes, req.OnConflict) {
+		invalid = append(invalid, InvalidParam{Name: prefix + "on_conflict", Reason: "debe ser reject, replace o version"})
+	}
+	invalid = append(invalid, metadataParams(req.Metadata, prefix)...)
+	if req.MaxWaitMs < 0 {
+		invalid = append(invalid, InvalidParam{Name: prefix + "max_wait_ms", Reason: "debe ser positivo"})
+	}
+	if req.MaxTextBytes != 0 && req.MaxTextBytes < minTextBytes {
+		invalid = append(invalid, InvalidParam{
+			Name:   prefix + "max_text_bytes",
+			Reason: fmt.Sprintf("debe ser mayor o igual a %d", minTextBytes),
+		})
+	}
+	for i, r := range req.Remove {
+		if !slices.Contains(removeOptions, r) {
+			invalid = append(invalid, InvalidParam{
+				Name:   fmt.Sprintf("%sremove[%d]", prefix, i),
+				Reason: "debe ser uno de: " + strings.Join(removeOptions, ", "),
+			})
+		}
+	}
+	return invalid
+}
+
+// checkURL devuelve el motivo de rechazo de la URL o "" si es aceptable.
+// Las URLs vacías las reporta el handler como campo requerido.
+func checkURL(raw string, limits LimitsConfig) string {
+	if raw == "" {
+		return ""
+	}
+	if len(raw) > limits.MaxURLLength {
+		return fmt.Sprintf("supera el largo máximo de %d caracteres", limits.MaxURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "no es una URL válida"
+	}
+	if !slices.Contains(limits.AllowedSchemes, strings.ToLower(u.Scheme)) {
+		return fmt.Sprintf("esquema %q no permitido (permitidos: %s)", u.Scheme, strings.Join(limits.AllowedSchemes, ", "))
+	}
+	// file:// lo habilita la CLI para leer archivos locales
+	if u.Host == "" && !strings.EqualFold(u.Scheme, "file") {
+		return "falta el host"
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Qué hacer cuando un request reusa la key de un resultado guardado, según
+// OCRRequest.OnConflict.
+const (
+	conflictReject  = "reject"  // 409 CONFLICT, sin procesar
+	conflictReplace = "replace" // reemplaza el resultado (default)
+	conflictVersion = "version" // guarda una versión nueva y conserva las anteriores
+)
+
+var conflictModes = []string{conflictReject, conflictReplace, conflictVersion}
+
+// maxResultVersions limita las versiones anteriores que se conservan de
+// cada key; se descartan las más antiguas.
+const maxResultVersions = 20
+
+// errResultExists lo devuelve ResultStore.Save con on_conflict=reject.
+var errResultExists = &codedError{CodeConflict, errors.New("ya hay un resultado guardado para esa key (on_conflict=reject)")}
+
+// resolveConflict decide cómo guardar un resultado sobre prev, el guardado
+// bajo la misma key (si exists), según mode: devuelve la versión del nuevo
+// y si prev pasa a las versiones anteriores. Los resultados guardados antes
+// de las versiones no tienen número y cuentan como la 1.
+func resolveConflict(prev StoredResult, exists bool, mode string) (version int, keep bool, err error) {
+	switch {
+	case !exists:
+		return 1, false, nil
+	case mode == conflictReject:
+		return 0, false, errResultExists
+	case mode == conflictVersion:
+		return max(prev.Version, 1) + 1, true, nil
+	}
+	return max(prev.Version, 1), false, nil
+}
+
+// conflictParam rechaza de antemano un request con on_conflict=reject cuya
+// key ya tiene resultado, para no gastar el procesamiento. Si el store no
+// responde no lo rechaza: Save lo vuelve a verificar al guardar.
+func conflictParam(ctx context.Context, req OCRRequest, prefix string) *InvalidParam {
+	if req.OnConflict != conflictReject || req.Key == "" {
+		return nil
+	}
+	if _, ok, err := results.Get(tenantFrom(ctx), req.Key); err != nil || !ok {
+		return nil
+	}
+	return &InvalidParam{Name: prefix + "key", Reason: errResultExists.Error()}
+}
+
+// ResultVersion resume una versión del resultado de una key.
+type ResultVersion struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Confidence  float64   `json:"confidence,omitempty"`
+	Engine      string    `json:"engine,omitempty"`
+	Annotations int       `json:"annotations"`
+}
+
+// ResultVersions es la respuesta de /ocr/results/{key}/versions.
+type ResultVersions struct {
+	Key      string          `json:"key"`
+	Versions []ResultVersion `json:"versions"`
+}
+
+// GET /ocr/results/{key}/versions -> versiones guardadas de la key, de la
+// más reciente a la más antigua
+func handleResultVersions(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	versions, err := results.Versions(tenantFrom(r.Context()), key)
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeInternal, err.Error()))
+		return
+	}
+	if len(versions) == 0 {
+		writeProblem(w, r, newProblem(CodeNotFound, "No hay un resultado guardado para esa key"))
+		return
+	}
+	out := ResultVersions{Key: key, Versions: []ResultVersion{}}
+	for _, v := range slices.Backward(versions) {
+		out.Versions = append(out.Versions, ResultVersion{
+			Version:     max(v.Version, 1),
+			CreatedAt:   v.CreatedAt,
+			Confidence:  v.Result.Confidence,
+			Engine:      v.Result.Engine,
+			Annotations: len(v.Annotations),
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// resultVersion busca la versión pedida en ?version= entre las de la key.
+func resultVersion(r *http.Request, tenant, key string) (StoredResult, bool, *Problem) {
+	n, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || n < 1 {
+		p := newProblem(CodeInvalidInput, "Parámetros inválidos")
+		p.InvalidParams = []InvalidParam{{Name: "version", Reason: "debe ser un entero mayor o igual a 1"}}
+		return StoredResult{}, false, &p
+	}
+	versions, err := results.Versions(tenant, key)
+	if err != nil {
+		p := newProblem(CodeInternal, err.Error())
+		return StoredResult{}, false, &p
+	}
+	for _, v := range versions {
+		if max(v.Version, 1) == n {
+			return v, true, nil
+		}
+	}
+	return StoredResult{}, false, nil
+}
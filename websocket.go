@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Sesiones interactivas: GET /ocr/ws abre un WebSocket en el que el cliente
+// envía un request de OCR por mensaje de texto, con el mismo JSON que
+// POST /ocr, y recibe cada resultado apenas termina, no necesariamente en el
+// orden de envío. Cada conexión procesa hasta OCR_WS_MAX_IN_FLIGHT requests
+// a la vez; con ese máximo en curso deja de leer mensajes hasta que termine
+// alguno. Como en /ocr, las imágenes se envían por referencia (url).
+
+// Eventos de los mensajes del servidor.
+const (
+	wsEventResult = "result"
+	wsEventError  = "error"
+)
+
+var (
+	wsMessagesTotal = newCounterVec("ocr_ws_messages_total", "Mensajes recibidos por /ocr/ws por resultado.", "outcome")
+	wsSessions      atomic.Int64
+)
+
+var _ = newGaugeFunc("ocr_ws_sessions", "Sesiones abiertas en /ocr/ws.", nil, func(emit func(float64, ...string)) {
+	emit(float64(wsSessions.Load()))
+})
+
+// WSResult es el mensaje con el resultado de un request.
+type WSResult struct {
+	Event string `json:"event"` // result
+	APIResponse
+}
+
+// WSError es el mensaje con el error de un request, identificado por key,
+// o de un mensaje que no se pudo leer.
+type WSError struct {
+	Event string `json:"event"` // error
+	Problem
+}
+
+// wsFrame es un mensaje recibido; binary distingue los mensajes binarios,
+// que no se aceptan.
+type wsFrame struct {
+	binary bool
+	data   []byte
+}
+
+var wsFrames = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		data, err := json.Marshal(v)
+		return data, websocket.TextFrame, err
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		*v.(*wsFrame) = wsFrame{binary: payloadType == websocket.BinaryFrame, data: data}
+		return nil
+	},
+}
+
+// GET /ocr/ws -> upgrade a WebSocket; cada mensaje {key,url,...} recibe
+// {"event":"result",...} o {"event":"error",...}
+func handleOCRWebSocket(limits LimitsConfig, routeTimeout time.Duration, cfg WebSocketConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 1 || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			writeProblem(w, r, newProblem(CodeInvalidInput, "Se espera un upgrade a WebSocket sobre HTTP/1.1"))
+			return
+		}
+		server := websocket.Server{
+			// La autenticación es por header, que un navegador no envía en
+			// el upgrade: no hace falta verificar Origin.
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				ws.MaxPayloadBytes = int(limits.MaxBodyBytes)
+				// La sesión dura más que un request: sin esto, el
+				// OCR_HTTP_WRITE_TIMEOUT del upgrade cortaría los envíos
+				ws.SetWriteDeadline(time.Time{})
+				s := &wsSession{
+					ws:           ws,
+					r:            r,
+					limits:       limits,
+					routeTimeout: routeTimeout,
+					idleTimeout:  cfg.IdleTimeout,
+					slots:        make(chan struct{}, cfg.MaxInFlight),
+				}
+				s.serve()
+				ws.Close()
+			},
+		}
+		server.ServeHTTP(w, r)
+	}
+}
+
+type wsSession struct {
+	ws           *websocket.Conn
+	r            *http.Request
+	limits       LimitsConfig
+	routeTimeout time.Duration
+	idleTimeout  time.Duration
+	slots        chan struct{}
+
+	wg       sync.WaitGroup
+	messages atomic.Int64
+	failed   atomic.Int64
+}
+
+// serve lee mensajes hasta que el cliente cierra o la sesión queda ociosa.
+// Al terminar cancela los requests en curso.
+func (s *wsSession) serve() {
+	wsSessions.Add(1)
+	defer wsSessions.Add(-1)
+	// La sesión no tiene el timeout de la ruta: lo tiene cada request
+	ctx, cancel := context.WithCancel(context.WithoutCancel(s.r.Context()))
+	defer func() {
+		cancel()
+		s.wg.Wait()
+		addLogAttrs(s.r.Context(), slog.Int64("ws_messages", s.messages.Load()), slog.Int64("items_failed", s.failed.Load()))
+	}()
+
+	for {
+		s.slots <- struct{}{}
+		s.ws.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		var f wsFrame
+		err := wsFrames.Receive(s.ws, &f)
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			<-s.slots
+			if len(s.slots) > 0 {
+				continue // ociosa, pero con requests en curso
+			}
+			return
+		case errors.Is(err, websocket.ErrFrameTooLarge):
+			<-s.slots
+			s.messages.Add(1)
+			s.fail("", newProblem(CodePayloadTooLarge,
+				fmt.Sprintf("El mensaje supera el máximo de %d bytes", s.limits.MaxBodyBytes)))
+			continue
+		case err != nil:
+			return
+		}
+
+		s.messages.Add(1)
+		s.wg.Add(1)
+		go func() {
+			defer func() { <-s.slots; s.wg.Done() }()
+			s.process(ctx, f)
+		}()
+	}
+}
+
+// process valida el mensaje como lo hace /ocr y envía el resultado.
+func (s *wsSession) process(ctx context.Context, f wsFrame) {
+	if f.binary {
+		s.fail("", newProblem(CodeInvalidInput, "Las imágenes se envían por referencia: {key,url} en un mensaje de texto"))
+		return
+	}
+	body, invalid, err := presets.load().resolvePresets(f.data)
+	if err != nil {
+		s.fail("", newProblem(CodeInvalidInput, "JSON inválido: "+err.Error()))
+		return
+	}
+	var req OCRRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Key == "" || req.URL == "" {
+		s.fail(req.Key, newProblem(CodeInvalidInput, "Se espera {key,url}"))
+		return
+	}
+	invalid = append(invalid, req.validate("", s.limits)...)
+	if req.ProcessingClass == classEconomy {
+		invalid = append(invalid, economyOnlyAsync)
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "La request contiene campos inválidos")
+		p.InvalidParams = invalid
+		s.fail(req.Key, p)
+		return
+	}
+	if conflict := conflictParam(ctx, req, ""); conflict != nil {
+		p := newProblem(CodeConflict, "Ya hay un resultado guardado para esa key")
+		p.InvalidParams = []InvalidParam{*conflict}
+		s.fail(req.Key, p)
+		return
+	}
+	if err := checkQuota(ctx, 1); err != nil {
+		s.fail(req.Key, newProblem(errorCodeOf(err, CodeQueueUnavailable), err.Error()))
+		return
+	}
+
+	if req.Priority == "" {
+		req.Priority = priorityNormal
+	}
+	ctx, cancel := withTimeoutLimit(withStageTracker(ctx), limitRoute, s.routeTimeout)
+	defer cancel()
+	resp, _ := pool.run(ctx, req)
+	if resp.ErrorCode != "" {
+		p := newProblem(resp.ErrorCode, resp.Err)
+		p.Timeout, p.Quality = resp.Timeout, resp.Quality
+		if p.Rejection = resp.Rejection; p.Rejection != nil && req.IncludeRejectedText {
+			p.Result = resp
+		}
+		s.fail(req.Key, p)
+		return
+	}
+	wsMessagesTotal.Inc("ok")
+	wsFrames.Send(s.ws, WSResult{Event: wsEventResult, APIResponse: *resp})
+}
+
+func (s *wsSession) fail(key string, p Problem) {
+	p.Key = key
+	p.Instance = s.r.URL.Path
+	s.failed.Add(1)
+	wsMessagesTotal.Inc("error")
+	wsFrames.Send(s.ws, WSError{Event: wsEventError, Problem: p})
+}
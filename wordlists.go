@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Wordlists de cada tenant: términos propios (nombres de clientes, códigos de
+// SKU) que el administrador del tenant carga por API. Después del
+// reconocimiento, las palabras que el OCR leyó con uno o dos caracteres
+// distintos de una entrada se corrigen a la entrada.
+
+const (
+	redisWordlistsPrefix  = "ocr:wordlists:"
+	maxWordlistsPerTenant = 20
+	maxWordlistEntryRunes = 100
+)
+
+var (
+	wordlistNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	// wordPattern es una palabra o un código con separadores internos, como
+	// AB-1234 o S.A.
+	wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+(?:[._/-][\p{L}\p{N}]+)*`)
+)
+
+var wordlistCorrectionsTotal = newCounterVec("ocr_wordlist_corrections_total", "Palabras corregidas con los wordlists de los tenants.", "tenant")
+
+// Wordlist es una lista de términos de un tenant.
+type Wordlist struct {
+	Name      string    `json:"name"`
+	Count     int       `json:"count"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Entries   []string  `json:"entries,omitempty"`
+}
+
+// Correction es un término corregido con un wordlist.
+type Correction struct {
+	Page     int    `json:"page"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Wordlist string `json:"wordlist"`
+}
+
+// WordlistStore guarda los wordlists por tenant, compartidos entre réplicas
+// cuando el backend lo permite.
+type WordlistStore interface {
+	Put(ctx context.Context, tenant string, wl Wordlist) error
+	Get(ctx context.Context, tenant, name string) (Wordlist, bool, error)
+	// List devuelve los wordlists del tenant con sus entradas.
+	List(ctx context.Context, tenant string) ([]Wordlist, error)
+	Delete(ctx context.Context, tenant, name string) (bool, error)
+}
+
+var wordlistStore WordlistStore
+
+type memoryWordlistStore struct {
+	mu    sync.Mutex
+	lists map[string]map[string]Wordlist
+}
+
+func newMemoryWordlistStore() *memoryWordlistStore {
+	return &memoryWordlistStore{lists: map[string]map[string]Wordlist{}}
+}
+
+func (s *memoryWordlistStore) Put(_ context.Context, tenant string, wl Wordlist) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lists[tenant] == nil {
+		s.lists[tenant] = map[string]Wordlist{}
+	}
+	s.lists[tenant][wl.Name] = wl
+	return nil
+}
+
+func (s *memoryWordlistStore) Get(_ context.Context, tenant, name string) (Wordlist, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wl, ok := s.lists[tenant][name]
+	return wl, ok, nil
+}
+
+func (s *memoryWordlistStore) List(_ context.Context, tenant string) ([]Wordlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Collect(maps.Values(s.lists[tenant])), nil
+}
+
+func (s *memoryWordlistStore) Delete(_ context.Context, tenant, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.lists[tenant][name]
+	delete(s.lists[tenant], name)
+	return ok, nil
+}
+
+// redisWordlistStore guarda un hash por tenant con un campo JSON por
+// wordlist.
+type redisWordlistStore struct {
+	rdb *redis.Client
+}
+
+func (s *redisWordlistStore) Put(ctx context.Context, tenant string, wl Wordlist) error {
+	data, err := json.Marshal(wl)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, redisWordlistsPrefix+tenant, wl.Name, data).Err()
+}
+
+func (s *redisWordlistStore) Get(ctx context.Context, tenant, name string) (Wordlist, bool, error) {
+	var wl Wordlist
+	data, err := s.rdb.HGet(ctx, redisWordlistsPrefix+tenant, name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return wl, false, nil
+	}
+	if err != nil {
+		return wl, false, err
+	}
+	return wl, true, json.Unmarshal(data, &wl)
+}
+
+func (s *redisWordlistStore) List(ctx context.Context, tenant string) ([]Wordlist, error) {
+	fields, err := s.rdb.HGetAll(ctx, redisWordlistsPrefix+tenant).Result()
+	if err != nil {
+		return nil, err
+	}
+	var out []Wordlist
+	for _, data := range fields {
+		var wl Wordlist
+		if err := json.Unmarshal([]byte(data), &wl); err != nil {
+			return nil, err
+		}
+		out = append(out, wl)
+	}
+	return out, nil
+}
+
+func (s *redisWordlistStore) Delete(ctx context.Context, tenant, name string) (bool, error) {
+	n, err := s.rdb.HDel(ctx, redisWordlistsPrefix+tenant, name).Result()
+	return n > 0, err
+}
+
+// lexiconEntry es una entrada de un wordlist separada en palabras.
+type lexiconEntry struct {
+	text     string // la entrada sin puntuación exterior, como se escribe al corregir
+	key      string // ver lexiconKey
+	words    int
+	wordlist string
+}
+
+func newLexicon(lists []Wordlist) []lexiconEntry {
+	var lex []lexiconEntry
+	for _, wl := range lists {
+		for _, e := range wl.Entries {
+			idx := wordPattern.FindAllStringIndex(e, -1)
+			if len(idx) == 0 {
+				continue
+			}
+			text := e[idx[0][0]:idx[len(idx)-1][1]]
+			lex = append(lex, lexiconEntry{text: text, key: lexiconKey(text), words: len(idx), wordlist: wl.Name})
+		}
+	}
+	return lex
+}
+
+// ocrFold iguala los caracteres que el OCR suele confundir, como O y 0.
+var ocrFold = strings.NewReplacer("0", "o", "1", "l", "i", "l", "5", "s", "8", "b")
+
+// lexiconKey es la forma en que se comparan ventanas y entradas: las
+// palabras separadas por un espacio, en minúsculas y con ocrFold aplicado.
+func lexiconKey(s string) string {
+	return ocrFold.Replace(strings.ToLower(strings.Join(wordPattern.FindAllString(s, -1), " ")))
+}
+
+// maxEdits es cuántos caracteres pueden diferir para corregir a la entrada:
+// ninguno en las cortas, donde solo se corrigen mayúsculas.
+func maxEdits(key string) int {
+	switch n := utf8.RuneCountInString(key); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// correctPages corrige las páginas con los wordlists del tenant. Si no se
+// pueden leer sigue sin corregir: no son motivo para fallar el OCR.
+func correctPages(ctx context.Context, pages []PageResult) []Correction {
+	tenant := tenantFrom(ctx)
+	lists, err := wordlistStore.List(ctx, tenant)
+	if err != nil {
+		loggerFrom(ctx).Warn("wordlists not applied", "error", err)
+		traceEvent(ctx, TraceEvent{Stage: "wordlists_failed", Detail: err.Error()})
+		return nil
+	}
+	lex := newLexicon(lists)
+	if len(lex) == 0 {
+		return nil
+	}
+	var corrections []Correction
+	for i := range pages {
+		if pages[i].Blank {
+			continue
+		}
+		lines := strings.Split(pages[i].Text, "\n")
+		for j, line := range lines {
+			var fixed []Correction
+			lines[j], fixed = correctLine(line, lex)
+			for _, c := range fixed {
+				c.Page = pages[i].Number
+				corrections = append(corrections, c)
+			}
+		}
+		pages[i].Text = strings.Join(lines, "\n")
+	}
+	if len(corrections) > 0 {
+		wordlistCorrectionsTotal.Add(float64(len(corrections)), tenant)
+		traceEvent(ctx, TraceEvent{Stage: "wordlists", Detail: fmt.Sprintf("%d correcciones", len(corrections))})
+	}
+	return corrections
+}
+
+// correctLine recorre las palabras de la línea y, en cada posición, busca la
+// entrada más cercana con tantas palabras como la ventana. Una coincidencia
+// exacta se respeta; a igual distancia gana la entrada más larga.
+func correctLine(line string, lex []lexiconEntry) (string, []Correction) {
+	words := wordPattern.FindAllStringIndex(line, -1)
+	var b strings.Builder
+	var corrections []Correction
+	last := 0
+	for i := 0; i < len(words); {
+		var best *lexiconEntry
+		bestDist := -1
+		for k := range lex {
+			e := &lex[k]
+			end := i + e.words
+			if end > len(words) || !spacedWords(line, words[i:end]) {
+				continue
+			}
+			window := line[words[i][0]:words[end-1][1]]
+			if abs(utf8.RuneCountInString(window)-utf8.RuneCountInString(e.text)) > maxEdits(e.key) {
+				continue
+			}
+			d := editDistance(lexiconKey(window), e.key)
+			if window == e.text {
+				d = -1 // exacta: gana siempre y no se corrige
+			}
+			if d > maxEdits(e.key) {
+				continue
+			}
+			if best == nil || d < bestDist || (d == bestDist && e.words > best.words) {
+				best, bestDist = e, d
+			}
+		}
+		if best == nil {
+			i++
+			continue
+		}
+		end := i + best.words
+		if bestDist >= 0 {
+			from := line[words[i][0]:words[end-1][1]]
+			b.WriteString(line[last:words[i][0]])
+			b.WriteString(best.text)
+			last = words[end-1][1]
+			corrections = append(corrections, Correction{From: from, To: best.text, Wordlist: best.wordlist})
+		}
+		i = end
+	}
+	if last == 0 {
+		return line, nil
+	}
+	b.WriteString(line[last:])
+	return b.String(), corrections
+}
+
+// spacedWords indica si entre las palabras solo hay espacios.
+func spacedWords(line string, words [][]int) bool {
+	for j := 1; j < len(words); j++ {
+		if strings.Trim(line[words[j-1][1]:words[j][0]], " \t") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// editDistance es la distancia de Levenshtein entre a y b, por runas.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// readWordlistEntries lee el body de PUT /wordlists/{name}: JSON
+// {"entries": [...]} o text/plain con una entrada por línea. Descarta
+// entradas vacías y repetidas.
+func readWordlistEntries(w http.ResponseWriter, r *http.Request, limits LimitsConfig) ([]string, *Problem) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			p := newProblem(CodePayloadTooLarge, fmt.Sprintf("El body supera el máximo de %d bytes", limits.MaxBodyBytes))
+			return nil, &p
+		}
+		p := newProblem(CodeInvalidInput, "No se pudo leer el body")
+		return nil, &p
+	}
+
+	var raw []string
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/plain" {
+		raw = strings.Split(string(body), "\n")
+	} else {
+		var in struct {
+			Entries []string `json:"entries"`
+		}
+		if err := json.Unmarshal(body, &in); err != nil {
+			p := newProblem(CodeInvalidInput, "JSON inválido: "+err.Error())
+			return nil, &p
+		}
+		raw = in.Entries
+	}
+
+	var entries []string
+	var invalid []InvalidParam
+	seen := map[string]bool{}
+	for i, e := range raw {
+		e = strings.TrimSpace(e)
+		switch {
+		case e == "" || seen[e]:
+			continue
+		case utf8.RuneCountInString(e) > maxWordlistEntryRunes:
+			invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("entries[%d]", i), Reason: fmt.Sprintf("supera los %d caracteres", maxWordlistEntryRunes)})
+		case !wordPattern.MatchString(e):
+			invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("entries[%d]", i), Reason: "no contiene letras ni dígitos"})
+		}
+		seen[e] = true
+		entries = append(entries, e)
+	}
+	switch {
+	case len(entries) == 0:
+		invalid = append(invalid, InvalidParam{Name: "entries", Reason: "requerido, con al menos una entrada"})
+	case len(entries) > limits.MaxWordlistEntries:
+		invalid = append(invalid, InvalidParam{Name: "entries", Reason: fmt.Sprintf("supera el máximo de %d entradas", limits.MaxWordlistEntries)})
+	}
+	if len(invalid) > 0 {
+		p := newProblem(CodeInvalidInput, "El wordlist contiene entradas inválidas")
+		p.InvalidParams = invalid
+		return nil, &p
+	}
+	return entries, nil
+}
+
+// GET /wordlists -> wordlists del tenant, sin las entradas
+func handleListWordlists(w http.ResponseWriter, r *http.Request) {
+	lists, err := wordlistStore.List(r.Context(), tenantFrom(r.Context()))
+	if err != nil {
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+		return
+	}
+	slices.SortFunc(lists, func(a, b Wordlist) int { return strings.Compare(a.Name, b.Name) })
+	for i := range lists {
+		lists[i].Entries = nil
+	}
+	writeJSON(w, http.StatusOK, map[string][]Wordlist{"wordlists": append([]Wordlist{}, lists...)})
+}
+
+// GET /wordlists/{name} -> el wordlist con sus entradas
+func handleGetWordlist(w http.ResponseWriter, r *http.Request) {
+	wl, ok, err := wordlistStore.Get(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "name"))
+	switch {
+	case err != nil:
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+	case !ok:
+		writeProblem(w, r, newProblem(CodeNotFound, "Wordlist inexistente"))
+	default:
+		writeJSON(w, http.StatusOK, wl)
+	}
+}
+
+// PUT /wordlists/{name} -> crea o reemplaza el wordlist (administrador del tenant)
+func handlePutWordlist(limits LimitsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, tenant, name := r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "name")
+		if !wordlistNamePattern.MatchString(name) {
+			p := newProblem(CodeInvalidInput, "Nombre de wordlist inválido")
+			p.InvalidParams = []InvalidParam{{Name: "name", Reason: fmt.Sprintf("debe cumplir %s", wordlistNamePattern)}}
+			writeProblem(w, r, p)
+			return
+		}
+		entries, problem := readWordlistEntries(w, r, limits)
+		if problem != nil {
+			writeProblem(w, r, *problem)
+			return
+		}
+
+		lists, err := wordlistStore.List(ctx, tenant)
+		if err != nil {
+			writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+			return
+		}
+		exists := slices.ContainsFunc(lists, func(wl Wordlist) bool { return wl.Name == name })
+		if !exists && len(lists) >= maxWordlistsPerTenant {
+			writeProblem(w, r, newProblem(CodeConflict, fmt.Sprintf("El tenant ya tiene el máximo de %d wordlists", maxWordlistsPerTenant)))
+			return
+		}
+
+		wl := Wordlist{Name: name, Count: len(entries), UpdatedAt: time.Now().UTC(), Entries: entries}
+		if err := wordlistStore.Put(ctx, tenant, wl); err != nil {
+			writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+			return
+		}
+		loggerFrom(ctx).Info("wordlist updated", slog.String("wordlist", name), slog.Int("entries", len(entries)))
+		status := http.StatusOK
+		if !exists {
+			status = http.StatusCreated
+		}
+		wl.Entries = nil
+		writeJSON(w, status, wl)
+	}
+}
+
+// DELETE /wordlists/{name} -> 204 (administrador del tenant)
+func handleDeleteWordlist(w http.ResponseWriter, r *http.Request) {
+	ok, err := wordlistStore.Delete(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "name"))
+	switch {
+	case err != nil:
+		writeProblem(w, r, newProblem(CodeQueueUnavailable, err.Error()))
+	case !ok:
+		writeProblem(w, r, newProblem(CodeNotFound, "Wordlist inexistente"))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Workflows simples: un job puede declarar depends_on con otros jobs. Queda
+// en estado waiting hasta que todos terminan; si completan, se encola con
+// sus resultados en inputs, y si alguno falla o se cancela, falla con
+// DEPENDENCY_FAILED sin procesarse.
+
+// maxDependencies limita depends_on por job.
+const maxDependencies = 16
+
+// JobInput es el resultado de una dependencia que recibe el job.
+type JobInput struct {
+	JobID  string       `json:"job_id"`
+	Key    string       `json:"key"`
+	Result *APIResponse `json:"result"`
+}
+
+// submitDependentJob crea el job en espera y lo anota en cada dependencia.
+// Sin url, el job procesa la misma URL que la primera dependencia.
+func submitDependentJob(ctx context.Context, item OCRRequest, deps []string) (Job, error) {
+	slices.Sort(deps)
+	deps = slices.Compact(deps)
+	if len(deps) > maxDependencies {
+		return Job{}, &codedError{CodeInvalidInput, fmt.Errorf("depends_on admite hasta %d jobs", maxDependencies)}
+	}
+	for _, id := range deps {
+		dep, ok, err := tenantJob(ctx, id)
+		if err != nil {
+			return Job{}, &codedError{CodeQueueUnavailable, err}
+		}
+		if !ok {
+			return Job{}, &codedError{CodeInvalidInput, fmt.Errorf("depends_on: el job %s no existe o venció", id)}
+		}
+		if item.URL == "" {
+			item.URL = dep.Item.URL
+		}
+	}
+
+	job := newJob(ctx, item, "")
+	job.DependsOn = deps
+	job.setStatus(jobWaiting, "esperando "+strings.Join(deps, ", "))
+	if err := jobStore.Put(ctx, job); err != nil {
+		return Job{}, &codedError{CodeQueueUnavailable, err}
+	}
+
+	// La dependencia que termina después de anotarse libera al job; si ya
+	// había terminado, lo libera releaseDependent al final.
+	for _, id := range deps {
+		_, err := jobStore.Update(ctx, id, func(d *Job) error {
+			if !d.finished() {
+				d.Dependents = append(d.Dependents, job.ID)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errJobNotFound) {
+			return Job{}, &codedError{CodeQueueUnavailable, err}
+		}
+	}
+	return releaseDependent(ctx, job.ID)
+}
+
+// releaseDependents intenta liberar los jobs que esperan a parent.
+func releaseDependents(ctx context.Context, parent Job) {
+	for _, id := range parent.Dependents {
+		if _, err := releaseDependent(ctx, id); err != nil {
+			slog.Error("dependent job not released", "job_id", id, "parent_id", parent.ID, "error", err)
+		}
+	}
+}
+
+// releaseDependent encola el job si todas sus dependencias completaron, o
+// lo marca fallido si alguna falló. Si sigue esperando no hace nada. Es
+// idempotente: varias dependencias que terminan a la vez lo liberan una vez.
+func releaseDependent(ctx context.Context, id string) (Job, error) {
+	job, ok, err := jobStore.Get(ctx, id)
+	if err != nil {
+		return Job{}, &codedError{CodeQueueUnavailable, err}
+	}
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	if job.Status != jobWaiting {
+		return job, nil
+	}
+
+	var inputs []JobInput
+	failure := ""
+	for _, depID := range job.DependsOn {
+		dep, ok, err := jobStore.Get(ctx, depID)
+		if err != nil {
+			return Job{}, &codedError{CodeQueueUnavailable, err}
+		}
+		switch {
+		case !ok:
+			failure = fmt.Sprintf("la dependencia %s venció", depID)
+		case dep.Status == jobFailed || dep.Status == jobCancelled:
+			failure = fmt.Sprintf("la dependencia %s terminó %s", depID, dep.Status)
+		case !dep.succeeded():
+			return job, nil // sigue esperando
+		default:
+			inputs = append(inputs, JobInput{JobID: dep.ID, Key: dep.Item.Key, Result: dep.Result})
+		}
+		if failure != "" {
+			break
+		}
+	}
+
+	var msg QueueMessage
+	released := false
+	job, err = jobStore.Update(ctx, id, func(j *Job) error {
+		if j.Status != jobWaiting {
+			return nil
+		}
+		if failure != "" {
+			j.Result = errorResponse(j.Item.Key, CodeDependencyFailed, failure).withMetadata(j.Item.Metadata)
+			j.setStatus(jobFailed, "")
+			return nil
+		}
+		j.Inputs = inputs
+		msg = j.schedule(time.Now().UTC())
+		reason := "dependencias completadas"
+		if r := j.deferredReason(); r != "" {
+			reason += "; " + r
+		}
+		j.setStatus(jobQueued, reason)
+		released = true
+		return nil
+	})
+	if err != nil {
+		return Job{}, &codedError{CodeQueueUnavailable, err}
+	}
+	if failure != "" && job.Status == jobFailed {
+		releaseDependents(ctx, job)
+	}
+	if !released {
+		return job, nil
+	}
+	if err := enqueueJob(ctx, job, msg); err != nil {
+		job, _ = jobStore.Update(ctx, id, func(j *Job) error {
+			j.Result = errorResponse(j.Item.Key, CodeQueueUnavailable, err.Error()).withMetadata(j.Item.Metadata)
+			j.setStatus(jobFailed, "")
+			return nil
+		})
+		releaseDependents(ctx, job)
+		return job, err
+	}
+	return job, nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Endpoints XML para consumidores que no pueden hablar JSON. El schema es
+// el mismo que el de /ocr y /ocr/batch: cada campo JSON es un elemento con
+// el mismo nombre y cada elemento de un array es un hijo <item> (en los
+// problems, <i> como en el apéndice A del RFC 7807). El request se traduce
+// a JSON y pasa por el handler de siempre, así que presets, validaciones y
+// errores son los mismos. También aceptan el payload dentro de un envelope
+// SOAP 1.1.
+
+const (
+	soapEnvelopeNS = "http://schemas.xmlsoap.org/soap/envelope/"
+	problemXMLNS   = "urn:ietf:rfc:7807"
+)
+
+// xmlBatchRequest es el envelope de /ocr/batch, con el preset que resuelve
+// validateBatchInput.
+type xmlBatchRequest struct {
+	BatchOCRRequest
+	Preset string `json:"preset,omitempty"`
+}
+
+// xmlNode es un elemento XML genérico, que se interpreta con el tipo de Go
+// del campo JSON equivalente.
+type xmlNode struct {
+	XMLName xml.Name
+	Content string    `xml:",chardata"`
+	Nodes   []xmlNode `xml:",any"`
+}
+
+// handleXMLCompat traduce el request XML a JSON según reqType, llama a next
+// y responde el resultado como XML con la raíz root.
+func handleXMLCompat(next http.Handler, reqType reflect.Type, root string, limits LimitsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var doc xmlNode
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+		if err == nil {
+			err = xml.Unmarshal(body, &doc)
+		}
+		soap := doc.XMLName.Space == soapEnvelopeNS && doc.XMLName.Local == "Envelope"
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr):
+			writeXMLProblem(w, r, soap, newProblem(CodePayloadTooLarge, fmt.Sprintf("El body supera el máximo de %d bytes", limits.MaxBodyBytes)))
+			return
+		case err != nil:
+			writeXMLProblem(w, r, soap, newProblem(CodeInvalidInput, "XML inválido: "+err.Error()))
+			return
+		}
+
+		payload := doc
+		if soap {
+			var ok bool
+			if payload, ok = soapPayload(doc); !ok {
+				writeXMLProblem(w, r, soap, newProblem(CodeInvalidInput, "El envelope SOAP no tiene un elemento en Body"))
+				return
+			}
+		}
+		var invalid []InvalidParam
+		value := xmlValue(payload, reqType, "", &invalid)
+		if len(invalid) > 0 {
+			p := newProblem(CodeInvalidInput, "La request contiene campos inválidos")
+			p.InvalidParams = invalid
+			writeXMLProblem(w, r, soap, p)
+			return
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			writeXMLProblem(w, r, soap, newProblem(CodeInternal, err.Error()))
+			return
+		}
+
+		inner := r.Clone(r.Context())
+		inner.Body = io.NopCloser(bytes.NewReader(data))
+		inner.ContentLength = int64(len(data))
+		inner.Header.Set("Content-Type", "application/json")
+		inner.Header.Set("Accept", "application/json")
+		inner.URL.RawQuery = ""
+		rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, inner)
+		writeXMLResponse(w, rec, root, soap)
+	}
+}
+
+// soapPayload devuelve el primer elemento del Body del envelope.
+func soapPayload(envelope xmlNode) (xmlNode, bool) {
+	for _, n := range envelope.Nodes {
+		if n.XMLName.Space == soapEnvelopeNS && n.XMLName.Local == "Body" && len(n.Nodes) > 0 {
+			return n.Nodes[0], true
+		}
+	}
+	return xmlNode{}, false
+}
+
+// xmlValue convierte el elemento al valor JSON del tipo t, con las mismas
+// reglas que encoding/json para los nombres de los campos. Los elementos
+// desconocidos y los valores que no son del tipo se agregan a invalid con
+// el nombre del campo JSON (items[2].url).
+func xmlValue(n xmlNode, t reflect.Type, path string, invalid *[]InvalidParam) any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	text := strings.TrimSpace(n.Content)
+	bad := func(reason string) any {
+		*invalid = append(*invalid, InvalidParam{Name: path, Reason: reason})
+		return nil
+	}
+	switch {
+	case t == timeType:
+		return text
+	case t.Kind() == reflect.Struct:
+		fields := jsonFields(t)
+		obj := map[string]any{}
+		for _, child := range n.Nodes {
+			name := child.XMLName.Local
+			childPath := name
+			if path != "" {
+				childPath = path + "." + name
+			}
+			ft, ok := fields[name]
+			if !ok {
+				*invalid = append(*invalid, InvalidParam{Name: childPath, Reason: "campo desconocido"})
+				continue
+			}
+			obj[name] = xmlValue(child, ft, childPath, invalid)
+		}
+		return obj
+	case t.Kind() == reflect.Slice:
+		items := []any{}
+		for i, child := range n.Nodes {
+			items = append(items, xmlValue(child, t.Elem(), fmt.Sprintf("%s[%d]", path, i), invalid))
+		}
+		return items
+	case t.Kind() == reflect.Bool:
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return bad("debe ser true o false")
+		}
+		return v
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if _, err := strconv.ParseInt(text, 10, 64); err != nil {
+			return bad("debe ser un número entero")
+		}
+		return json.Number(text)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return bad("debe ser un número")
+		}
+		return json.Number(text)
+	}
+	// Los strings conservan los espacios (page_separator)
+	return n.Content
+}
+
+// jsonFields devuelve los campos de t por su nombre JSON, con los de los
+// structs embebidos al mismo nivel.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for k, v := range jsonFields(f.Type) {
+				if _, ok := fields[k]; !ok {
+					fields[k] = v
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// writeXMLProblem responde el problem como problem+xml (o SOAP Fault).
+func writeXMLProblem(w http.ResponseWriter, r *http.Request, soap bool, p Problem) {
+	rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	writeProblem(rec, r, p)
+	writeXMLResponse(w, rec, "", soap)
+}
+
+// writeXMLResponse traduce la respuesta JSON del handler a XML. Los
+// problems van como application/problem+xml o, con SOAP, en un Fault.
+func writeXMLResponse(w http.ResponseWriter, rec *bufferedResponse, root string, soap bool) {
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.Header().Del("Content-Length")
+	contentType := rec.header.Get("Content-Type")
+	isProblem := strings.HasPrefix(contentType, "application/problem+json")
+	if !isProblem && !strings.HasPrefix(contentType, "application/json") {
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+		return
+	}
+
+	start, item := xml.StartElement{Name: xml.Name{Local: root}}, "item"
+	if isProblem {
+		start = xml.StartElement{Name: xml.Name{Local: "problem"}, Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: problemXMLNS}}}
+		item = "i"
+	}
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	enc := xml.NewEncoder(&out)
+	payload := func() error { return writeXMLValue(enc, rec.body.Bytes(), start, item) }
+	status, mediaType := rec.status, "application/xml; charset=utf-8"
+	var err error
+	switch {
+	case soap:
+		status, err = writeSOAP(enc, rec, isProblem, payload)
+		mediaType = "text/xml; charset=utf-8"
+	case isProblem:
+		err = payload()
+		mediaType = "application/problem+xml"
+	default:
+		err = payload()
+	}
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err != nil {
+		// El handler respondió JSON que no se pudo leer: se devuelve tal cual
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	w.Write(out.Bytes())
+}
+
+// writeSOAP escribe el envelope SOAP 1.1 con el payload en el Body. Un
+// problem va en el detail de un Fault, con status 500 como pide SOAP 1.1;
+// faultcode es Client si el problem es un 4xx. Devuelve el status HTTP.
+func writeSOAP(enc *xml.Encoder, rec *bufferedResponse, isProblem bool, payload func() error) (int, error) {
+	envelope := xml.StartElement{Name: xml.Name{Local: "soap:Envelope"}, Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns:soap"}, Value: soapEnvelopeNS}}}
+	body := xml.StartElement{Name: xml.Name{Local: "soap:Body"}}
+	fault := xml.StartElement{Name: xml.Name{Local: "soap:Fault"}}
+	detail := xml.StartElement{Name: xml.Name{Local: "detail"}}
+	status := rec.status
+	err := errors.Join(enc.EncodeToken(envelope), enc.EncodeToken(body))
+	if isProblem {
+		var p Problem
+		json.Unmarshal(rec.body.Bytes(), &p)
+		code, message := "soap:Server", p.Detail
+		if p.Status < 500 {
+			code = "soap:Client"
+		}
+		if message == "" {
+			message = p.Title
+		}
+		err = errors.Join(err,
+			enc.EncodeToken(fault),
+			enc.EncodeElement(code, xml.StartElement{Name: xml.Name{Local: "faultcode"}}),
+			enc.EncodeElement(message, xml.StartElement{Name: xml.Name{Local: "faultstring"}}),
+			enc.EncodeToken(detail), payload(), enc.EncodeToken(detail.End()),
+			enc.EncodeToken(fault.End()))
+		status = http.StatusInternalServerError
+	} else {
+		err = errors.Join(err, payload())
+	}
+	return status, errors.Join(err, enc.EncodeToken(body.End()), enc.EncodeToken(envelope.End()))
+}
+
+// writeXMLValue escribe el JSON como el elemento start: los objetos son
+// elementos con un hijo por campo, los arrays un hijo item por elemento y
+// los null se omiten.
+func writeXMLValue(enc *xml.Encoder, data []byte, start xml.StartElement, item string) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return encodeXMLValue(enc, dec, start, item)
+}
+
+func encodeXMLValue(enc *xml.Encoder, dec *json.Decoder, start xml.StartElement, item string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	switch {
+	case tok == nil:
+		return nil
+	case !ok:
+		return enc.EncodeElement(fmt.Sprint(tok), start)
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for dec.More() {
+		child := xml.StartElement{Name: xml.Name{Local: item}}
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			child = xmlElementFor(key.(string))
+		}
+		if err := encodeXMLValue(enc, dec, child, item); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// xmlElementFor devuelve el elemento de un campo JSON. Las claves que no
+// son nombres XML válidos (los campos de una plantilla pueden tener
+// espacios) van como <entry name="...">.
+func xmlElementFor(key string) xml.StartElement {
+	valid := key != "" && !strings.HasPrefix(strings.ToLower(key), "xml")
+	for i, c := range key {
+		switch {
+		case c == '_' || unicode.IsLetter(c):
+		case i > 0 && (c == '-' || c == '.' || unicode.IsDigit(c)):
+		default:
+			valid = false
+		}
+	}
+	if valid {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: key}}}
+}